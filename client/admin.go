@@ -0,0 +1,582 @@
+// client/admin.go
+// Administrative commands.
+
+package client
+
+import (
+	"sort"
+	"time"
+)
+
+// User information.
+type UserInfo struct {
+	Username     string
+	Clearance    int
+	PasswordHash []byte
+	TOTPEnabled  bool
+}
+
+// A listener, in addition to the main TLS listener.
+type Listener struct {
+	Name           string            `bson:"-"`
+	Network        string            `bson:"network"`
+	Address        string            `bson:"address"`
+	Limit          time.Duration     `bson:"limit"`
+	MaxLimitEvents int               `bson:"maxLimitEvents"`
+	IPAllowList    []string          `bson:"ipAllowList"`
+	IPDenyList     []string          `bson:"ipDenyList"`
+	PeerUsers      map[string]string `bson:"peerUsers"`
+}
+
+// All server settings.
+type Settings struct {
+	Host                     string              `bson:"host"`
+	Port                     int                 `bson:"port"`
+	Drives                   []string            `bson:"drives"`
+	DriveFiles               map[string]string   `bson:"driveFiles"`
+	NumWorkers               int                 `bson:"numWorkers"`
+	MainCronInterval         time.Duration       `bson:"mainCronInterval"`
+	SessionCronInterval      time.Duration       `bson:"sessionCronInterval"`
+	NetworkTimeout           time.Duration       `bson:"networkTimeout"`
+	ShutdownDeadline         time.Duration       `bson:"shutdownDeadline"`
+	SessionFile              string              `bson:"sessionFile"`
+	SessionIdleTimeout       time.Duration       `bson:"sessionIdleTimeout"`
+	SessionMaxLifetime       time.Duration       `bson:"sessionMaxLifetime"`
+	SessionBinding           string              `bson:"sessionBinding"`
+	SessionBindingIPv4Prefix int                 `bson:"sessionBindingIPv4Prefix"`
+	SessionBindingIPv6Prefix int                 `bson:"sessionBindingIPv6Prefix"`
+	AuditFile                string              `bson:"auditFile"`
+	AuditHashChain           bool                `bson:"auditHashChain"`
+	MetricsAddress           string              `bson:"metricsAddress"`
+	Verbose                  bool                `bson:"verbose"`
+	LogToFile                bool                `bson:"logToFile"`
+	LogJSON                  bool                `bson:"logJSON"`
+	LogLevel                 string              `bson:"logLevel"`
+	LogFile                  string              `bson:"logFile"`
+	Limit                    time.Duration       `bson:"limit"`
+	MaxLimitEvents           int                 `bson:"maxLimitEvents"`
+	UserRequests             int                 `bson:"userRequests"`
+	UserRequestInterval      time.Duration       `bson:"userRequestInterval"`
+	UserUploadRate           int64               `bson:"userUploadRate"`
+	UserDownloadRate         int64               `bson:"userDownloadRate"`
+	UploadRate               int64               `bson:"uploadRate"`
+	DownloadRate             int64               `bson:"downloadRate"`
+	IPAllowList              []string            `bson:"ipAllowList"`
+	IPDenyList               []string            `bson:"ipDenyList"`
+	TrustedProxies           []string            `bson:"trustedProxies"`
+	MaxConnections           int                 `bson:"maxConnections"`
+	MaxConnectionsPerIP      int                 `bson:"maxConnectionsPerIP"`
+	OpenConnections          int                 `bson:"openConnections"`
+	MaxChunkSize             int                 `bson:"maxChunkSize"`
+	MemoryBudget             int64               `bson:"memoryBudget"`
+	MemoryBudgetUsed         int64               `bson:"memoryBudgetUsed"`
+	MaxUploadSize            int64               `bson:"maxUploadSize"`
+	TOTPRequiredClearance    int                 `bson:"totpRequiredClearance"`
+	UserLockoutThreshold     int                 `bson:"userLockoutThreshold"`
+	IPLockoutThreshold       int                 `bson:"ipLockoutThreshold"`
+	LockoutDuration          time.Duration       `bson:"lockoutDuration"`
+	LockoutMaxDuration       time.Duration       `bson:"lockoutMaxDuration"`
+	PasswordMinLength        int                 `bson:"passwordMinLength"`
+	PasswordRequireUpper     bool                `bson:"passwordRequireUpper"`
+	PasswordRequireLower     bool                `bson:"passwordRequireLower"`
+	PasswordRequireDigit     bool                `bson:"passwordRequireDigit"`
+	PasswordRequireSymbol    bool                `bson:"passwordRequireSymbol"`
+	PasswordDenyListFile     string              `bson:"passwordDenyListFile"`
+	PasswordHash             string              `bson:"passwordHash"`
+	BcryptCost               int                 `bson:"bcryptCost"`
+	Argon2Time               int                 `bson:"argon2Time"`
+	Argon2Memory             int                 `bson:"argon2Memory"`
+	Argon2Threads            int                 `bson:"argon2Threads"`
+	ClientCAFile             string              `bson:"clientCAFile"`
+	ClientAuth               string              `bson:"clientAuth"`
+	CertUsers                map[string]string   `bson:"certUsers"`
+	LDAPMode                 string              `bson:"ldapMode"`
+	LDAPAutoProvision        bool                `bson:"ldapAutoProvision"`
+	LDAPURL                  string              `bson:"ldapURL"`
+	LDAPStartTLS             bool                `bson:"ldapStartTLS"`
+	LDAPInsecureSkipVerify   bool                `bson:"ldapInsecureSkipVerify"`
+	LDAPBindDN               string              `bson:"ldapBindDN"`
+	LDAPGroupBaseDN          string              `bson:"ldapGroupBaseDN"`
+	LDAPGroupFilter          string              `bson:"ldapGroupFilter"`
+	LDAPGroups               map[string]int      `bson:"ldapGroups"`
+	LDAPDefaultClearance     int                 `bson:"ldapDefaultClearance"`
+	Listeners                map[string]Listener `bson:"listeners"`
+}
+
+// LDAP settings.
+type LDAPSettings struct {
+	Mode               string
+	AutoProvision      bool
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	GroupBaseDN        string
+	GroupFilter        string
+	GroupClearances    map[string]int
+	DefaultClearance   int
+}
+
+// Locked users and IP addresses, with the time that they are locked until.
+type LockedAccounts struct {
+	Users map[string]time.Time `bson:"users"`
+	IPs   map[string]time.Time `bson:"ips"`
+}
+
+// Password policy settings.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	DenyListFile  string
+}
+
+// Password hashing parameters.
+type HashParams struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    int
+	Argon2Memory  int
+	Argon2Threads int
+}
+
+// Audit log filters. A zero limit uses the server's default, and a negative
+// limit returns every record.
+type AuditLogFilter struct {
+	Limit   int
+	User    string
+	Command string
+	Drive   string
+}
+
+// An audit log record. The time is a Unix time.
+type AuditRecord struct {
+	Time     int64
+	User     string
+	AuthType string `bson:"authType"`
+	IP       string
+	Command  string
+	Drive    string
+	Paths    []string
+	Code     int
+	BytesIn  int64 `bson:"bytesIn"`
+	BytesOut int64 `bson:"bytesOut"`
+	Duration time.Duration
+}
+
+// The result of verifying the audit log hash chain.
+type AuditVerification struct {
+	Valid   bool
+	Records int
+}
+
+// Per-user limits. Zero values are not limited.
+type UserLimits struct {
+	Requests     int           `bson:"requests"`
+	Interval     time.Duration `bson:"interval"`
+	UploadRate   int64         `bson:"uploadRate"`
+	DownloadRate int64         `bson:"downloadRate"`
+}
+
+// Memory usage statistics.
+type MemoryUsage struct {
+	Alloc uint64
+	Total uint64
+	Sys   uint64
+}
+
+// Get all usernames.
+func (c *Client) GetAllUsers(a Auth, timeout time.Duration) ([]string, error) {
+	result := struct {
+		Users []string
+	}{}
+	err := c.call(a, "getallusers", map[string]interface{}{}, &result, timeout)
+	return result.Users, err
+}
+
+// Get information about users.
+func (c *Client) GetUserInformation(a Auth, users []string, timeout time.Duration) ([]UserInfo, error) {
+	result := struct {
+		Info []UserInfo
+	}{}
+	err := c.call(a, "getuserinformation", map[string]interface{}{"users": users}, &result, timeout)
+	return result.Info, err
+}
+
+// Set the clearances of users.
+func (c *Client) SetUserClearance(a Auth, users []string, clearances []int, timeout time.Duration) error {
+	return c.call(a, "setuserclearance", map[string]interface{}{"users": users, "clearances": clearances}, nil, timeout)
+}
+
+// Set the passwords of users, optionally revoking their sessions.
+func (c *Client) SetUserPassword(a Auth, users, passwords []string, revokeSessions bool, timeout time.Duration) error {
+	return c.call(a, "setuserpassword", map[string]interface{}{"users": users, "passwords": passwords, "revokeSessions": revokeSessions}, nil, timeout)
+}
+
+// Create users.
+func (c *Client) CreateUsers(a Auth, users, passwords []string, clearances []int, timeout time.Duration) error {
+	return c.call(a, "createusers", map[string]interface{}{"users": users, "passwords": passwords, "clearances": clearances}, nil, timeout)
+}
+
+// Reset the two-factor authentication of users.
+func (c *Client) ResetUserTOTP(a Auth, users []string, timeout time.Duration) error {
+	return c.call(a, "resetusertotp", map[string]interface{}{"users": users}, nil, timeout)
+}
+
+// Delete users.
+func (c *Client) DeleteUsers(a Auth, users []string, timeout time.Duration) error {
+	return c.call(a, "deleteusers", map[string]interface{}{"users": users}, nil, timeout)
+}
+
+// Get all session IDs.
+func (c *Client) GetAllSessions(a Auth, timeout time.Duration) ([][]byte, error) {
+	result := struct {
+		IDs [][]byte `bson:"ids"`
+	}{}
+	err := c.call(a, "getallsessions", map[string]interface{}{}, &result, timeout)
+	return result.IDs, err
+}
+
+// Get the session IDs of a user.
+func (c *Client) GetAllUserSessions(a Auth, user string, timeout time.Duration) ([][]byte, error) {
+	result := struct {
+		IDs [][]byte `bson:"ids"`
+	}{}
+	err := c.call(a, "getallusersessions", map[string]interface{}{"user": user}, &result, timeout)
+	return result.IDs, err
+}
+
+// Get information about sessions.
+func (c *Client) GetSessionInfo(a Auth, ids [][]byte, timeout time.Duration) ([]SessionInfo, error) {
+	result := struct {
+		Sessions []SessionInfo
+	}{}
+	err := c.call(a, "getsessioninfo", map[string]interface{}{"ids": ids}, &result, timeout)
+	return result.Sessions, err
+}
+
+// Expire all sessions.
+func (c *Client) ExpireAllSessions(a Auth, timeout time.Duration) error {
+	return c.call(a, "expireallsessions", map[string]interface{}{}, nil, timeout)
+}
+
+// Expire sessions.
+func (c *Client) ExpireSessions(a Auth, ids [][]byte, timeout time.Duration) error {
+	return c.call(a, "expiresessions", map[string]interface{}{"ids": ids}, nil, timeout)
+}
+
+// Get all server settings.
+func (c *Client) GetAllSettings(a Auth, timeout time.Duration) (Settings, error) {
+	settings := Settings{}
+	if err := c.call(a, "getallsettings", map[string]interface{}{}, &settings, timeout); err != nil {
+		return settings, err
+	}
+	for name, l := range settings.Listeners {
+		l.Name = name
+		settings.Listeners[name] = l
+	}
+	return settings, nil
+}
+
+// Set the host and port.
+func (c *Client) SetHostAndPort(a Auth, host string, port int, timeout time.Duration) error {
+	return c.call(a, "sethostandport", map[string]interface{}{"host": host, "port": port}, nil, timeout)
+}
+
+// Add a drive, given the path of its drive file.
+func (c *Client) AddDrive(a Auth, name, path string, timeout time.Duration) error {
+	return c.call(a, "adddrive", map[string]interface{}{"name": name, "path": path}, nil, timeout)
+}
+
+// Rename a drive.
+func (c *Client) RenameDrive(a Auth, drive, newName string, timeout time.Duration) error {
+	return c.call(a, "renamedrive", map[string]interface{}{"drive": drive, "newName": newName}, nil, timeout)
+}
+
+// Remove a drive.
+func (c *Client) RemoveDrive(a Auth, drive string, timeout time.Duration) error {
+	return c.call(a, "removedrive", map[string]interface{}{"drive": drive}, nil, timeout)
+}
+
+// Set the number of workers.
+func (c *Client) SetNumWorkers(a Auth, numWorkers int, timeout time.Duration) error {
+	return c.call(a, "setnumworkers", map[string]interface{}{"numWorkers": numWorkers}, nil, timeout)
+}
+
+// Set the main and session cron intervals.
+func (c *Client) SetCronIntervals(a Auth, mainInterval, sessionInterval time.Duration, timeout time.Duration) error {
+	return c.call(a, "setcronintervals", map[string]interface{}{"mainInterval": int64(mainInterval), "sessionInterval": int64(sessionInterval)}, nil, timeout)
+}
+
+// Set the network timeout interval.
+func (c *Client) SetTimeoutInterval(a Auth, interval time.Duration, timeout time.Duration) error {
+	return c.call(a, "settimeoutinterval", map[string]interface{}{"timeout": int64(interval)}, nil, timeout)
+}
+
+// Set the logging settings.
+func (c *Client) SetLoggingSettings(a Auth, verbose, logToFile, logJSON bool, logLevel, logPath string, timeout time.Duration) error {
+	return c.call(a, "setloggingsettings", map[string]interface{}{"verbose": verbose, "logToFile": logToFile, "logJSON": logJSON, "logLevel": logLevel, "logPath": logPath}, nil, timeout)
+}
+
+// Set the rate limit.
+func (c *Client) SetRateLimit(a Auth, limit time.Duration, maxLimitEvents int, timeout time.Duration) error {
+	return c.call(a, "setratelimit", map[string]interface{}{"limit": int64(limit), "maxLimitEvents": maxLimitEvents}, nil, timeout)
+}
+
+// Set the clearance at which two-factor authentication is required.
+func (c *Client) SetTOTPRequiredClearance(a Auth, clearance int, timeout time.Duration) error {
+	return c.call(a, "settotprequiredclearance", map[string]interface{}{"clearance": clearance}, nil, timeout)
+}
+
+// Set the client CA file and client certificate authentication mode.
+func (c *Client) SetClientAuth(a Auth, clientCAFile, clientAuth string, timeout time.Duration) error {
+	return c.call(a, "setclientauth", map[string]interface{}{"clientCAFile": clientCAFile, "clientAuth": clientAuth}, nil, timeout)
+}
+
+// Map certificate identities to users.
+func (c *Client) AddCertUsers(a Auth, identities, users []string, timeout time.Duration) error {
+	return c.call(a, "addcertusers", map[string]interface{}{"identities": identities, "users": users}, nil, timeout)
+}
+
+// Remove certificate identity mappings.
+func (c *Client) RemoveCertUsers(a Auth, identities []string, timeout time.Duration) error {
+	return c.call(a, "removecertusers", map[string]interface{}{"identities": identities}, nil, timeout)
+}
+
+// Set the LDAP settings.
+func (c *Client) SetLDAP(a Auth, settings LDAPSettings, timeout time.Duration) error {
+	groups := make([]string, 0, len(settings.GroupClearances))
+	for group := range settings.GroupClearances {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	clearances := make([]int, len(groups))
+	for i := range groups {
+		clearances[i] = settings.GroupClearances[groups[i]]
+	}
+	return c.call(a, "setldap", map[string]interface{}{
+		"mode":               settings.Mode,
+		"autoProvision":      settings.AutoProvision,
+		"url":                settings.URL,
+		"bindDN":             settings.BindDN,
+		"groupBaseDN":        settings.GroupBaseDN,
+		"groupFilter":        settings.GroupFilter,
+		"startTLS":           settings.StartTLS,
+		"insecureSkipVerify": settings.InsecureSkipVerify,
+		"groups":             groups,
+		"clearances":         clearances,
+		"defaultClearance":   settings.DefaultClearance,
+	}, nil, timeout)
+}
+
+// Set the lockout thresholds and durations.
+func (c *Client) SetLockout(a Auth, userThreshold, ipThreshold int, duration, maxDuration time.Duration, timeout time.Duration) error {
+	return c.call(a, "setlockout", map[string]interface{}{"userThreshold": userThreshold, "ipThreshold": ipThreshold, "duration": int64(duration), "maxDuration": int64(maxDuration)}, nil, timeout)
+}
+
+// Get the locked users and IP addresses.
+func (c *Client) GetLockedAccounts(a Auth, timeout time.Duration) (LockedAccounts, error) {
+	locked := LockedAccounts{}
+	err := c.call(a, "getlockedaccounts", map[string]interface{}{}, &locked, timeout)
+	return locked, err
+}
+
+// Unlock users and IP addresses. Either may be nil.
+func (c *Client) UnlockAccounts(a Auth, users, ips []string, timeout time.Duration) error {
+	params := map[string]interface{}{}
+	if users != nil {
+		params["users"] = users
+	}
+	if ips != nil {
+		params["ips"] = ips
+	}
+	return c.call(a, "unlockaccounts", params, nil, timeout)
+}
+
+// Set the password policy.
+func (c *Client) SetPasswordPolicy(a Auth, policy PasswordPolicy, timeout time.Duration) error {
+	return c.call(a, "setpasswordpolicy", map[string]interface{}{
+		"minLength":     policy.MinLength,
+		"requireUpper":  policy.RequireUpper,
+		"requireLower":  policy.RequireLower,
+		"requireDigit":  policy.RequireDigit,
+		"requireSymbol": policy.RequireSymbol,
+		"denyListFile":  policy.DenyListFile,
+	}, nil, timeout)
+}
+
+// Set the password hashing parameters.
+func (c *Client) SetPasswordHashing(a Auth, params HashParams, timeout time.Duration) error {
+	return c.call(a, "setpasswordhashing", map[string]interface{}{
+		"algorithm":     params.Algorithm,
+		"bcryptCost":    params.BcryptCost,
+		"argon2Time":    params.Argon2Time,
+		"argon2Memory":  params.Argon2Memory,
+		"argon2Threads": params.Argon2Threads,
+	}, nil, timeout)
+}
+
+// Set the session binding mode. Zero prefix lengths keep the current
+// lengths.
+func (c *Client) SetSessionBinding(a Auth, mode string, ipv4Prefix, ipv6Prefix int, timeout time.Duration) error {
+	params := map[string]interface{}{"mode": mode}
+	if ipv4Prefix != 0 {
+		params["ipv4Prefix"] = ipv4Prefix
+	}
+	if ipv6Prefix != 0 {
+		params["ipv6Prefix"] = ipv6Prefix
+	}
+	return c.call(a, "setsessionbinding", params, nil, timeout)
+}
+
+// Get the most recent audit log records.
+func (c *Client) GetAuditLog(a Auth, filter AuditLogFilter, timeout time.Duration) ([]AuditRecord, error) {
+	params := map[string]interface{}{}
+	if filter.Limit > 0 {
+		params["limit"] = filter.Limit
+	} else if filter.Limit < 0 {
+		params["limit"] = 0
+	}
+	if filter.User != "" {
+		params["user"] = filter.User
+	}
+	if filter.Command != "" {
+		params["command"] = filter.Command
+	}
+	if filter.Drive != "" {
+		params["drive"] = filter.Drive
+	}
+	result := struct {
+		Records []AuditRecord
+	}{}
+	err := c.call(a, "getauditlog", params, &result, timeout)
+	return result.Records, err
+}
+
+// Verify the audit log hash chain.
+func (c *Client) VerifyAuditLog(a Auth, timeout time.Duration) (AuditVerification, error) {
+	result := AuditVerification{}
+	err := c.call(a, "verifyauditlog", map[string]interface{}{}, &result, timeout)
+	return result, err
+}
+
+// Get the parameters for per-user limits.
+func userLimitParams(limits UserLimits) map[string]interface{} {
+	return map[string]interface{}{
+		"requests":     limits.Requests,
+		"interval":     int64(limits.Interval),
+		"uploadRate":   int(limits.UploadRate),
+		"downloadRate": int(limits.DownloadRate),
+	}
+}
+
+// Set the default per-user limits.
+func (c *Client) SetUserLimits(a Auth, limits UserLimits, timeout time.Duration) error {
+	return c.call(a, "setuserlimits", userLimitParams(limits), nil, timeout)
+}
+
+// Override the per-user limits for a user.
+func (c *Client) SetUserLimitOverride(a Auth, user string, limits UserLimits, timeout time.Duration) error {
+	params := userLimitParams(limits)
+	params["user"] = user
+	return c.call(a, "setuserlimitoverride", params, nil, timeout)
+}
+
+// Remove the per-user limit overrides for users.
+func (c *Client) RemoveUserLimitOverrides(a Auth, users []string, timeout time.Duration) error {
+	return c.call(a, "removeuserlimitoverrides", map[string]interface{}{"users": users}, nil, timeout)
+}
+
+// Get the per-user limit overrides.
+func (c *Client) GetUserLimitOverrides(a Auth, timeout time.Duration) (map[string]UserLimits, error) {
+	result := struct {
+		Overrides map[string]UserLimits
+	}{}
+	err := c.call(a, "getuserlimitoverrides", map[string]interface{}{}, &result, timeout)
+	return result.Overrides, err
+}
+
+// Set the server-wide bandwidth limits, in bytes per second.
+func (c *Client) SetBandwidthLimits(a Auth, uploadRate, downloadRate int64, timeout time.Duration) error {
+	return c.call(a, "setbandwidthlimits", map[string]interface{}{"uploadRate": int(uploadRate), "downloadRate": int(downloadRate)}, nil, timeout)
+}
+
+// Set the IP allow and deny lists.
+func (c *Client) SetIPFilter(a Auth, allow, deny []string, timeout time.Duration) error {
+	return c.call(a, "setipfilter", map[string]interface{}{"allow": allow, "deny": deny}, nil, timeout)
+}
+
+// Set the trusted proxies.
+func (c *Client) SetTrustedProxies(a Auth, proxies []string, timeout time.Duration) error {
+	return c.call(a, "settrustedproxies", map[string]interface{}{"proxies": proxies}, nil, timeout)
+}
+
+// Set the connection limits.
+func (c *Client) SetConnectionLimits(a Auth, maxConnections, maxConnectionsPerIP int, timeout time.Duration) error {
+	return c.call(a, "setconnectionlimits", map[string]interface{}{"maxConnections": maxConnections, "maxConnectionsPerIP": maxConnectionsPerIP}, nil, timeout)
+}
+
+// Set the maximum chunk size, the transfer memory budget and the maximum
+// upload size.
+func (c *Client) SetTransferLimits(a Auth, maxChunkSize int, memoryBudget, maxUploadSize int64, timeout time.Duration) error {
+	return c.call(a, "settransferlimits", map[string]interface{}{"maxChunkSize": maxChunkSize, "memoryBudget": memoryBudget, "maxUploadSize": maxUploadSize}, nil, timeout)
+}
+
+// Add or replace a listener. The rate limit is only set if either of its
+// values is not zero.
+func (c *Client) AddListener(a Auth, l Listener, timeout time.Duration) error {
+	params := map[string]interface{}{"name": l.Name, "network": l.Network, "address": l.Address}
+	if l.Limit != 0 || l.MaxLimitEvents != 0 {
+		params["limit"] = int64(l.Limit)
+		params["maxLimitEvents"] = l.MaxLimitEvents
+	}
+	if l.IPAllowList != nil {
+		params["ipAllowList"] = l.IPAllowList
+	}
+	if l.IPDenyList != nil {
+		params["ipDenyList"] = l.IPDenyList
+	}
+	if l.PeerUsers != nil {
+		osUsers := make([]string, 0, len(l.PeerUsers))
+		for osUser := range l.PeerUsers {
+			osUsers = append(osUsers, osUser)
+		}
+		sort.Strings(osUsers)
+		users := make([]string, len(osUsers))
+		for i := range osUsers {
+			users[i] = l.PeerUsers[osUsers[i]]
+		}
+		params["osUsers"] = osUsers
+		params["users"] = users
+	}
+	return c.call(a, "addlistener", params, nil, timeout)
+}
+
+// Remove listeners.
+func (c *Client) RemoveListeners(a Auth, names []string, timeout time.Duration) error {
+	return c.call(a, "removelisteners", map[string]interface{}{"names": names}, nil, timeout)
+}
+
+// Shut down the server. A negative deadline keeps the configured shutdown
+// deadline.
+func (c *Client) Shutdown(a Auth, deadline time.Duration, timeout time.Duration) error {
+	params := map[string]interface{}{}
+	if deadline >= 0 {
+		params["deadline"] = int64(deadline)
+	}
+	return c.call(a, "shutdown", params, nil, timeout)
+}
+
+// Reload the server configuration.
+func (c *Client) Reload(a Auth, timeout time.Duration) error {
+	return c.call(a, "reload", map[string]interface{}{}, nil, timeout)
+}
+
+// Get the server's memory usage.
+func (c *Client) GetMemoryUsage(a Auth, timeout time.Duration) (MemoryUsage, error) {
+	usage := MemoryUsage{}
+	err := c.call(a, "getmemoryusage", map[string]interface{}{}, &usage, timeout)
+	return usage, err
+}
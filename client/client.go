@@ -7,11 +7,15 @@ package client
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
-	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
+	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/cubeflix/lily/connection"
@@ -24,6 +28,7 @@ var ErrInvalidSessionID = errors.New("lily.client: Invalid session ID")
 var ErrInvalidProtocol = errors.New("lily.client: Invalid protocol")
 var ErrInvalidChunkSize = errors.New("lily.client: Invalid chunk size")
 var ErrInvalidSliceLength = errors.New("lily.client: Invalid length of slices")
+var ErrInvalidCAFile = errors.New("lily.client: Invalid CA file")
 
 const DefaultChunkSize = 4096
 
@@ -37,6 +42,16 @@ type Client struct {
 
 	insecureSkipVerify bool
 	useCerts           bool
+
+	// The certificate authorities to trust. If nil, the system roots are
+	// trusted.
+	rootCAs *x509.CertPool
+
+	// The chunk compression algorithm for requests.
+	compression string
+
+	// If chunks carry checksums.
+	checksums bool
 }
 
 // Create a client.
@@ -51,6 +66,35 @@ func NewClient(host string, port int, certFile, keyFile string, insecureSkipVeri
 	}
 }
 
+// Trust the certificate authorities in a PEM file, such as a CA created by
+// the server's cert command, instead of the system roots.
+func (c *Client) SetCAFile(caFile string) error {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return ErrInvalidCAFile
+	}
+	c.rootCAs = pool
+	return nil
+}
+
+// Send a checksum with each chunk, and verify the checksums of received
+// chunks. This uses the checksum protocol version, which older servers reject.
+func (c *Client) SetChecksums(checksums bool) {
+	c.checksums = checksums
+}
+
+// Get the protocol version for requests.
+func (c *Client) version() string {
+	if c.checksums {
+		return network.CHECKSUM_PROTOCOL_VERSION
+	}
+	return network.PROTOCOL_VERSION
+}
+
 // Perform a non-chunk request.
 func (c *Client) MakeNonChunkRequest(r Request) (Response, error) {
 	conn, err := c.MakeConnection(c.insecureSkipVerify)
@@ -90,28 +134,13 @@ func (c *Client) UploadFiles(a Auth, files, uploadPaths []string, settings []acc
 	if settings != nil && len(files) != len(settings) {
 		return Response{}, ErrInvalidSliceLength
 	}
-	if chunkSize < 0 || chunkSize > 1000000 {
+	if chunkSize <= 0 || chunkSize > 1000000 {
 		return Response{}, ErrInvalidChunkSize
 	}
-	filedata := make([]splitfileinfo, len(files))
 	for i := range files {
-		stat, err := os.Stat(files[i])
-		if err != nil {
+		if _, err := os.Stat(files[i]); err != nil {
 			return Response{}, err
 		}
-		chunkSizes := make([]int, int(math.Ceil(float64(stat.Size())/float64(chunkSize))))
-		remainingSize := int(stat.Size())
-		chunkN := 0
-		for {
-			chunkSize := int(math.Min(float64(remainingSize), float64(chunkSize)))
-			chunkSizes[chunkN] = chunkSize
-			remainingSize -= chunkSize
-			chunkN += 1
-			if remainingSize == 0 {
-				break
-			}
-		}
-		filedata[i] = splitfileinfo{Path: files[i], UploadPath: uploadPaths[i], ChunkSizes: chunkSizes}
 	}
 
 	// Create the files.
@@ -129,8 +158,40 @@ func (c *Client) UploadFiles(a Auth, files, uploadPaths []string, settings []acc
 		return resp, nil
 	}
 
+	// Write the files.
+	return c.WriteFiles(a, files, uploadPaths, drive, chunkSize, timeout)
+}
+
+// Write local files to existing files on the server, replacing their
+// contents.
+func (c *Client) WriteFiles(a Auth, files, uploadPaths []string, drive string, chunkSize int, timeout time.Duration) (Response, error) {
+	// Stat the files.
+	if len(files) != len(uploadPaths) {
+		return Response{}, ErrInvalidSliceLength
+	}
+	if chunkSize <= 0 || chunkSize > 1000000 {
+		return Response{}, ErrInvalidChunkSize
+	}
+	filedata := make([]splitfileinfo, len(files))
+	for i := range files {
+		stat, err := os.Stat(files[i])
+		if err != nil {
+			return Response{}, err
+		}
+		chunkSizes := make([]int, int(math.Ceil(float64(stat.Size())/float64(chunkSize))))
+		remainingSize := int(stat.Size())
+		chunkN := 0
+		for remainingSize > 0 {
+			chunkSize := int(math.Min(float64(remainingSize), float64(chunkSize)))
+			chunkSizes[chunkN] = chunkSize
+			remainingSize -= chunkSize
+			chunkN += 1
+		}
+		filedata[i] = splitfileinfo{Path: files[i], UploadPath: uploadPaths[i], ChunkSizes: chunkSizes}
+	}
+
 	// Make the request.
-	conn, err := c.MakeConnection(true)
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
 	if err != nil {
 		return Response{}, err
 	}
@@ -149,7 +210,7 @@ func (c *Client) UploadFiles(a Auth, files, uploadPaths []string, settings []acc
 	for i := range filedata {
 		chunkInfo = append(chunkInfo, network.ChunkInfo{Name: filedata[i].UploadPath, NumChunks: len(filedata[i].ChunkSizes)})
 	}
-	ch := network.NewChunkHandler(stream)
+	ch := c.newChunkHandler(stream)
 	ch.WriteChunkResponseInfo(chunkInfo, timeout, false)
 	for i := range filedata {
 		file, err := os.Open(filedata[i].Path)
@@ -159,12 +220,14 @@ func (c *Client) UploadFiles(a Auth, files, uploadPaths []string, settings []acc
 		for j := range filedata[i].ChunkSizes {
 			ch.WriteChunkInfo(filedata[i].UploadPath, filedata[i].ChunkSizes[j], timeout)
 			buf := make([]byte, filedata[i].ChunkSizes[j])
-			_, err := file.Read(buf)
+			_, err := io.ReadFull(file, buf)
 			if err != nil {
+				file.Close()
 				return Response{}, err
 			}
 			ch.WriteChunk(&buf, timeout)
 		}
+		file.Close()
 	}
 	ch.WriteFooter(timeout)
 
@@ -195,7 +258,7 @@ func (c *Client) DownloadFiles(a Auth, files, downloadPaths []string, drive stri
 	}
 
 	// Make the request.
-	conn, err := c.MakeConnection(true)
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
 	if err != nil {
 		return Response{}, err
 	}
@@ -212,7 +275,7 @@ func (c *Client) DownloadFiles(a Auth, files, downloadPaths []string, drive stri
 	}
 
 	// Receive the chunks.
-	ch := network.NewChunkHandler(stream)
+	ch := c.newChunkHandler(stream)
 
 	// Receive the chunk info.
 	chunkInfo, err := ch.GetChunkRequestInfo(timeout)
@@ -229,18 +292,24 @@ func (c *Client) DownloadFiles(a Auth, files, downloadPaths []string, drive stri
 		for n := 0; n < chunkInfo[i].NumChunks; n++ {
 			_, size, err := ch.GetChunkInfo(timeout)
 			if err != nil {
+				file.Close()
 				return Response{}, err
 			}
 			buf := make([]byte, size)
 			err = ch.GetChunk(&buf, timeout)
 			if err != nil {
+				file.Close()
 				return Response{}, err
 			}
 			_, err = file.Write(buf)
 			if err != nil {
+				file.Close()
 				return Response{}, err
 			}
 		}
+		if err := file.Close(); err != nil {
+			return Response{}, err
+		}
 	}
 	if ch.GetFooter(timeout) != nil {
 		return Response{}, err
@@ -263,13 +332,15 @@ func (c *Client) MakeConnection(insecureSkipVerify bool) (*tls.Conn, error) {
 		config = &tls.Config{
 			Certificates:       []tls.Certificate{cert},
 			InsecureSkipVerify: insecureSkipVerify,
+			RootCAs:            c.rootCAs,
 		}
 	} else {
 		config = &tls.Config{
 			InsecureSkipVerify: insecureSkipVerify,
+			RootCAs:            c.rootCAs,
 		}
 	}
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:%d", c.host, c.port), config)
+	conn, err := tls.Dial("tcp", net.JoinHostPort(c.host, strconv.Itoa(c.port)), config)
 	if err != nil {
 		return nil, err
 	}
@@ -278,6 +349,14 @@ func (c *Client) MakeConnection(insecureSkipVerify bool) (*tls.Conn, error) {
 
 // Make a request.
 func (c *Client) SendRequestData(conn *tls.Conn, r Request, timeout time.Duration, sendEmptyChunks bool) (network.DataStream, error) {
+	if c.compression != network.CompressionNone {
+		// Choose the compression with the reserved argument.
+		params := map[string]interface{}{"compression": c.compression}
+		for k, v := range r.params {
+			params[k] = v
+		}
+		r.params = params
+	}
 	data, err := r.MarshalBinary()
 	if err != nil {
 		return nil, err
@@ -287,7 +366,7 @@ func (c *Client) SendRequestData(conn *tls.Conn, r Request, timeout time.Duratio
 	stream := network.DataStream(network.NewTLSStream(conn))
 	header := []byte("LILY")
 	header = append(header, length...)
-	header = append(header, []byte(network.PROTOCOL_VERSION)...)
+	header = append(header, []byte(c.version())...)
 	if _, err := stream.Write(&header, timeout); err != nil {
 		return nil, err
 	}
@@ -295,7 +374,7 @@ func (c *Client) SendRequestData(conn *tls.Conn, r Request, timeout time.Duratio
 		return nil, err
 	}
 	if sendEmptyChunks {
-		ch := network.NewChunkHandler(stream)
+		ch := c.newChunkHandler(stream)
 		ch.WriteChunkResponseInfo([]network.ChunkInfo{}, timeout, false)
 		ch.WriteFooter(timeout)
 	}
@@ -310,7 +389,9 @@ func (c *Client) ReceiveHeader(stream network.DataStream, timeout time.Duration)
 		return err
 	}
 
-	if string(header) != "LILY"+network.PROTOCOL_VERSION {
+	// Errors such as an invalid protocol version are sent with the base
+	// version.
+	if string(header[:4]) != "LILY" || (string(header[4:]) != c.version() && string(header[4:]) != network.PROTOCOL_VERSION) {
 		return ErrInvalidProtocol
 	}
 
@@ -388,7 +469,7 @@ func (c *Client) ReceiveResponse(stream network.DataStream, timeout time.Duratio
 
 // Ignore all chunk response data.
 func (c *Client) ReceiveIgnoreChunkData(stream network.DataStream, timeout time.Duration) error {
-	ch := network.NewChunkHandler(stream)
+	ch := c.newChunkHandler(stream)
 
 	// Receive the chunk info.
 	chunkInfo, err := ch.GetChunkRequestInfo(timeout)
@@ -484,6 +565,27 @@ func (a *SessionAuth) MarshalBinary() ([]byte, error) {
 	return data, nil
 }
 
+// Certificate authentication. The client must be created with useCerts set,
+// and the certificate must be mapped to the username on the server.
+type CertAuth struct {
+	username string
+}
+
+func NewCertAuth(username string) *CertAuth {
+	return &CertAuth{
+		username: username,
+	}
+}
+
+func (a *CertAuth) MarshalBinary() ([]byte, error) {
+	data := []byte("C")
+	length := make([]byte, 2)
+	binary.LittleEndian.PutUint16(length, uint16(len(a.username)))
+	data = append(data, length...)
+	data = append(data, []byte(a.username+"END")...)
+	return data, nil
+}
+
 // Request struct.
 type Request struct {
 	auth        Auth
@@ -0,0 +1,52 @@
+// client/compression.go
+// Chunk compression for requests.
+
+package client
+
+import (
+	"time"
+
+	"github.com/cubeflix/lily/network"
+)
+
+// Set the chunk compression algorithm for requests. The server must support
+// the algorithm, so it is usually chosen with NegotiateCompression.
+func (c *Client) SetCompression(compression string) error {
+	if !network.ValidCompression(compression) {
+		return network.ErrUnsupportedCompression
+	}
+	c.compression = compression
+	return nil
+}
+
+// Use the first of the preferred compression algorithms which the server
+// supports, or no compression if there are none. Returns the algorithm.
+func (c *Client) NegotiateCompression(preferred []string, timeout time.Duration) (string, error) {
+	c.compression = network.CompressionNone
+	info, err := c.Info(timeout)
+	if err != nil {
+		return "", err
+	}
+	supported := info.Compression
+	for _, compression := range preferred {
+		if !network.ValidCompression(compression) {
+			continue
+		}
+		for i := range supported {
+			if supported[i] == compression {
+				c.compression = compression
+				return compression, nil
+			}
+		}
+	}
+	return network.CompressionNone, nil
+}
+
+// Create a chunk handler for a request stream, with the compression and
+// protocol version.
+func (c *Client) newChunkHandler(stream network.DataStream) *network.ChunkHandler {
+	ch := network.NewChunkHandler(stream)
+	ch.SetCompression(c.compression)
+	ch.SetVersion(c.version())
+	return ch
+}
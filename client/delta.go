@@ -0,0 +1,194 @@
+// client/delta.go
+// Delta transfers of changed files.
+
+package client
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cubeflix/lily/delta"
+	"github.com/cubeflix/lily/network"
+)
+
+// The smallest file which sync patches with a delta, when deltas are enabled.
+// Smaller files are rewritten, since a delta needs an extra request.
+const DeltaMinSize = 1 << 20
+
+// The largest chunk size, and the largest number of chunks in a stream.
+const maxChunkSize = 1000000
+const maxNumChunks = 65535
+
+// Get the block signature of a file on a drive. If the block size is zero,
+// the server chooses it. The signature is nil if the response code is not
+// zero.
+func (c *Client) GetSignature(a Auth, file, drive string, blockSize int, timeout time.Duration) (*delta.Signature, Response, error) {
+	// Make the request.
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
+	if err != nil {
+		return nil, Response{}, err
+	}
+	defer conn.Close()
+	stream, err := c.SendRequestData(conn, *NewRequest(a, "getsignature", map[string]interface{}{"path": file, "drive": drive, "blockSize": blockSize}, timeout), timeout, true)
+	if err != nil {
+		return nil, Response{}, err
+	}
+	if err := c.ReceiveHeader(stream, timeout); err != nil {
+		return nil, Response{}, err
+	}
+
+	// Receive the signature entries.
+	ch := c.newChunkHandler(stream)
+	chunkInfo, err := ch.GetChunkRequestInfo(timeout)
+	if err != nil {
+		return nil, Response{}, err
+	}
+	data := []byte{}
+	for i := range chunkInfo {
+		for n := 0; n < chunkInfo[i].NumChunks; n++ {
+			_, size, err := ch.GetChunkInfo(timeout)
+			if err != nil {
+				return nil, Response{}, err
+			}
+			if uint64(len(data))+size > delta.MaxBlocks*delta.EntrySize {
+				return nil, Response{}, delta.ErrInvalidSignature
+			}
+			buf := make([]byte, size)
+			if err := ch.GetChunk(&buf, timeout); err != nil {
+				return nil, Response{}, err
+			}
+			data = append(data, buf...)
+		}
+	}
+	if err := ch.GetFooter(timeout); err != nil {
+		return nil, Response{}, err
+	}
+	response, err := c.ReceiveResponse(stream, timeout)
+	if err != nil || response.Code != 0 {
+		return nil, response, err
+	}
+
+	// Unmarshal the signature.
+	signature, err := delta.UnmarshalSignature(int(toInt64(response.Data["blockSize"])), toInt64(response.Data["size"]), data)
+	if err != nil {
+		return nil, response, err
+	}
+	return signature, response, nil
+}
+
+// Patch an existing file on the server so it matches a local file, sending
+// only the parts of the local file which are not in the remote file. The
+// chunk size is increased if the delta would need too many chunks.
+func (c *Client) PatchFile(a Auth, localFile, file, drive string, chunkSize int, timeout time.Duration) (Response, error) {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return Response{}, ErrInvalidChunkSize
+	}
+
+	// Get the signature of the remote file.
+	signature, resp, err := c.GetSignature(a, file, drive, 0, timeout)
+	if err != nil || resp.Code != 0 {
+		return resp, err
+	}
+
+	// Calculate the delta and the hash of the local file.
+	f, err := os.Open(localFile)
+	if err != nil {
+		return Response{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Response{}, err
+	}
+	hasher := sha256.New()
+	ops, err := delta.Diff(signature, io.TeeReader(f, hasher))
+	if err != nil {
+		return Response{}, err
+	}
+	size := delta.EncodedSize(ops)
+	if size > int64(chunkSize)*maxNumChunks {
+		chunkSize = int((size + maxNumChunks - 1) / maxNumChunks)
+		if chunkSize > maxChunkSize {
+			return Response{}, ErrInvalidChunkSize
+		}
+	}
+	numChunks := int((size + int64(chunkSize) - 1) / int64(chunkSize))
+
+	// Make the request.
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	stream, err := c.SendRequestData(conn, *NewRequest(a, "patchfile", map[string]interface{}{"path": file, "drive": drive, "hash": hasher.Sum(nil), "size": info.Size()}, timeout), timeout, false)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Write the delta.
+	ch := c.newChunkHandler(stream)
+	if err := ch.WriteChunkResponseInfo([]network.ChunkInfo{{Name: file, NumChunks: numChunks}}, timeout, false); err != nil {
+		return Response{}, err
+	}
+	w := &chunkWriter{name: file, handler: ch, timeout: timeout, buf: make([]byte, 0, chunkSize)}
+	if err := delta.WriteDelta(w, ops, f); err != nil {
+		return Response{}, err
+	}
+	if err := w.flush(); err != nil {
+		return Response{}, err
+	}
+	if err := ch.WriteFooter(timeout); err != nil {
+		return Response{}, err
+	}
+
+	// Receive the response.
+	if err := c.ReceiveHeader(stream, timeout); err != nil {
+		return Response{}, err
+	}
+	if err := c.ReceiveIgnoreChunkData(stream, timeout); err != nil {
+		return Response{}, err
+	}
+	return c.ReceiveResponse(stream, timeout)
+}
+
+// Writes data as chunks of a fixed size.
+type chunkWriter struct {
+	name    string
+	handler *network.ChunkHandler
+	timeout time.Duration
+	buf     []byte
+}
+
+// Write data, writing each chunk once it is full.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Write the buffered data as a chunk.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.handler.WriteChunkInfo(w.name, len(w.buf), w.timeout); err != nil {
+		return err
+	}
+	if err := w.handler.WriteChunk(&w.buf, w.timeout); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
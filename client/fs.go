@@ -0,0 +1,335 @@
+// client/fs.go
+// Filesystem commands.
+
+package client
+
+import (
+	"io"
+	"time"
+
+	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/security/access"
+)
+
+// A directory list entry. The size and hash are only set for files.
+type ListDirEntry struct {
+	Name         string
+	IsFile       bool
+	LastEditTime int64
+	LastEditor   string
+	Size         int64
+	Hash         []byte
+}
+
+// The status of a path.
+type PathStatus struct {
+	Exists       bool
+	Name         string
+	IsFile       bool
+	LastEditTime int64
+	LastEditor   string
+	Size         int64
+	Hash         []byte
+}
+
+// Create directories. If the settings are nil, the directories inherit the
+// settings of their parents.
+func (c *Client) CreateDirs(a Auth, paths []string, settings []access.BSONAccessSettings, drive string, timeout time.Duration) error {
+	params := map[string]interface{}{"paths": paths, "drive": drive}
+	if settings != nil {
+		params["settings"] = settings
+	}
+	return c.call(a, "createdirs", params, nil, timeout)
+}
+
+// Create a tree of directories under a parent directory. If the settings are
+// nil, the directories inherit the settings of their parents.
+func (c *Client) CreateDirTree(a Auth, parent string, paths []string, settings []access.BSONAccessSettings, parentSettings *access.BSONAccessSettings, drive string, timeout time.Duration) error {
+	params := map[string]interface{}{"parent": parent, "paths": paths, "drive": drive}
+	if settings != nil {
+		params["settings"] = settings
+	}
+	if parentSettings != nil {
+		params["parentSettings"] = *parentSettings
+	}
+	return c.call(a, "createdirtree", params, nil, timeout)
+}
+
+// List a directory.
+func (c *Client) ListDir(a Auth, path, drive string, timeout time.Duration) ([]ListDirEntry, error) {
+	result := struct {
+		List []ListDirEntry
+	}{}
+	err := c.call(a, "listdir", map[string]interface{}{"path": path, "drive": drive}, &result, timeout)
+	return result.List, err
+}
+
+// Rename directories.
+func (c *Client) RenameDirs(a Auth, paths, newNames []string, drive string, timeout time.Duration) error {
+	return c.call(a, "renamedirs", map[string]interface{}{"paths": paths, "newNames": newNames, "drive": drive}, nil, timeout)
+}
+
+// Move directories.
+func (c *Client) MoveDirs(a Auth, paths, dests []string, drive string, timeout time.Duration) error {
+	return c.call(a, "movedirs", map[string]interface{}{"paths": paths, "dests": dests, "drive": drive}, nil, timeout)
+}
+
+// Delete directories.
+func (c *Client) DeleteDirs(a Auth, paths []string, drive string, timeout time.Duration) error {
+	return c.call(a, "deletedirs", map[string]interface{}{"paths": paths, "drive": drive}, nil, timeout)
+}
+
+// Create empty files. If the settings are nil, the files inherit the settings
+// of their parents.
+func (c *Client) CreateFiles(a Auth, paths []string, settings []access.BSONAccessSettings, drive string, timeout time.Duration) error {
+	params := map[string]interface{}{"paths": paths, "drive": drive}
+	if settings != nil {
+		params["settings"] = settings
+	}
+	return c.call(a, "createfiles", params, nil, timeout)
+}
+
+// Read a range of a file into a writer. An end of -1 reads to the end of the
+// file.
+func (c *Client) ReadFile(a Auth, file, drive string, w io.Writer, start, end int64, chunkSize int, timeout time.Duration) error {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return ErrInvalidChunkSize
+	}
+	return checkResponse(c.readFile(a, file, drive, w, start, end, chunkSize, timeout))
+}
+
+// Write data from a reader to a file, starting at an offset. If clear is set,
+// the file is cleared first.
+func (c *Client) WriteFile(a Auth, file, drive string, r io.Reader, start, size int64, clear bool, chunkSize int, timeout time.Duration) error {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return ErrInvalidChunkSize
+	}
+	params := map[string]interface{}{"paths": []string{file}, "drive": drive, "start": []int64{start}, "clear": []bool{clear}}
+	return checkResponse(c.writeChunks(a, "writefiles", params, file, r, size, chunkSize, timeout))
+}
+
+// Rename files.
+func (c *Client) RenameFiles(a Auth, paths, newNames []string, drive string, timeout time.Duration) error {
+	return c.call(a, "renamefiles", map[string]interface{}{"paths": paths, "newNames": newNames, "drive": drive}, nil, timeout)
+}
+
+// Move files.
+func (c *Client) MoveFiles(a Auth, paths, dests []string, drive string, timeout time.Duration) error {
+	return c.call(a, "movefiles", map[string]interface{}{"paths": paths, "dests": dests, "drive": drive}, nil, timeout)
+}
+
+// Delete files.
+func (c *Client) DeleteFiles(a Auth, paths []string, drive string, timeout time.Duration) error {
+	return c.call(a, "deletefiles", map[string]interface{}{"paths": paths, "drive": drive}, nil, timeout)
+}
+
+// Get the status of paths. Paths which do not exist are not an error.
+func (c *Client) Stat(a Auth, paths []string, drive string, timeout time.Duration) (map[string]PathStatus, error) {
+	result := struct {
+		Stat map[string]PathStatus
+	}{}
+	err := c.call(a, "stat", map[string]interface{}{"paths": paths, "drive": drive}, &result, timeout)
+	return result.Stat, err
+}
+
+// Recalculate the hashes of files.
+func (c *Client) RehashFiles(a Auth, paths []string, drive string, timeout time.Duration) error {
+	return c.call(a, "rehashfiles", map[string]interface{}{"paths": paths, "drive": drive}, nil, timeout)
+}
+
+// Verify the stored hashes of files, returning whether each one matches.
+func (c *Client) VerifyHashes(a Auth, paths []string, drive string, timeout time.Duration) (map[string]bool, error) {
+	result := struct {
+		Results map[string]bool
+	}{}
+	err := c.call(a, "verifyhashes", map[string]interface{}{"paths": paths, "drive": drive}, &result, timeout)
+	return result.Results, err
+}
+
+// Begin a ranged upload to replace a file of a size. Returns the upload ID.
+func (c *Client) BeginUpload(a Auth, path, drive string, size int64, timeout time.Duration) (string, error) {
+	result := struct {
+		ID string `bson:"id"`
+	}{}
+	err := c.call(a, "beginupload", map[string]interface{}{"path": path, "drive": drive, "size": size}, &result, timeout)
+	return result.ID, err
+}
+
+// Write a range of an upload from a reader.
+func (c *Client) WriteRange(a Auth, file, drive, id string, r io.Reader, start, size int64, chunkSize int, timeout time.Duration) error {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return ErrInvalidChunkSize
+	}
+	params := map[string]interface{}{"drive": drive, "id": id, "start": start}
+	return checkResponse(c.writeChunks(a, "writerange", params, file, r, size, chunkSize, timeout))
+}
+
+// Commit an upload, replacing the file. If the hash is not nil, the server
+// checks it against the SHA-256 hash of the new file.
+func (c *Client) CommitUpload(a Auth, id string, hash []byte, drive string, timeout time.Duration) error {
+	params := map[string]interface{}{"drive": drive, "id": id}
+	if hash != nil {
+		params["hash"] = hash
+	}
+	return c.call(a, "commitupload", params, nil, timeout)
+}
+
+// Abort an upload.
+func (c *Client) AbortUpload(a Auth, id, drive string, timeout time.Duration) error {
+	return c.call(a, "abortupload", map[string]interface{}{"drive": drive, "id": id}, nil, timeout)
+}
+
+// Get the access settings of a path.
+func (c *Client) GetPathSettings(a Auth, path, drive string, timeout time.Duration) (access.BSONAccessSettings, error) {
+	result := struct {
+		Settings access.BSONAccessSettings
+	}{}
+	err := c.call(a, "getpathsettings", map[string]interface{}{"path": path, "drive": drive}, &result, timeout)
+	return result.Settings, err
+}
+
+// Set the access settings of a path.
+func (c *Client) SetPathSettings(a Auth, path, drive string, settings access.BSONAccessSettings, timeout time.Duration) error {
+	return c.call(a, "setpathsettings", map[string]interface{}{"path": path, "drive": drive, "settings": settings}, nil, timeout)
+}
+
+// Set the access and modify clearances of a path.
+func (c *Client) SetPathClearances(a Auth, path, drive string, accessClearance, modifyClearance int, timeout time.Duration) error {
+	return c.call(a, "setpathclearances", map[string]interface{}{"path": path, "drive": drive, "access": accessClearance, "modify": modifyClearance}, nil, timeout)
+}
+
+// Add users to the access whitelist of a path.
+func (c *Client) AddToPathAccessWhitelist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "addtopathaccesswhitelist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Remove users from the access whitelist of a path.
+func (c *Client) RemoveFromPathAccessWhitelist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "removefrompathaccesswhitelist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Add users to the modify whitelist of a path.
+func (c *Client) AddToPathModifyWhitelist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "addtopathmodifywhitelist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Remove users from the modify whitelist of a path.
+func (c *Client) RemoveFromPathModifyWhitelist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "removefrompathmodifywhitelist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Add users to the access blacklist of a path.
+func (c *Client) AddToPathAccessBlacklist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "addtopathaccessblacklist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Remove users from the access blacklist of a path.
+func (c *Client) RemoveFromPathAccessBlacklist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "removefrompathaccessblacklist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Add users to the modify blacklist of a path.
+func (c *Client) AddToPathModifyBlacklist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "addtopathmodifyblacklist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Remove users from the modify blacklist of a path.
+func (c *Client) RemoveFromPathModifyBlacklist(a Auth, path, drive string, users []string, timeout time.Duration) error {
+	return c.call(a, "removefrompathmodifyblacklist", map[string]interface{}{"path": path, "drive": drive, "users": users}, nil, timeout)
+}
+
+// Read a range of a file into a writer, returning the response.
+func (c *Client) readFile(a Auth, file, drive string, w io.Writer, start, end int64, chunkSize int, timeout time.Duration) (Response, error) {
+	// Make the request.
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	stream, err := c.SendRequestData(conn, *NewRequest(a, "readfiles", map[string]interface{}{"paths": []string{file}, "drive": drive, "start": []int64{start}, "end": []int64{end}, "chunkSize": int64(chunkSize)}, timeout), timeout, true)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := c.ReceiveHeader(stream, timeout); err != nil {
+		return Response{}, err
+	}
+
+	// Receive the chunks.
+	ch := c.newChunkHandler(stream)
+	chunkInfo, err := ch.GetChunkRequestInfo(timeout)
+	if err != nil {
+		return Response{}, err
+	}
+	current := start
+	for i := range chunkInfo {
+		for n := 0; n < chunkInfo[i].NumChunks; n++ {
+			_, size, err := ch.GetChunkInfo(timeout)
+			if err != nil {
+				return Response{}, err
+			}
+			if end >= 0 && size > uint64(end-current) {
+				return Response{}, ErrInvalidProtocol
+			}
+			buf := make([]byte, size)
+			if err := ch.GetChunk(&buf, timeout); err != nil {
+				return Response{}, err
+			}
+			if _, err := w.Write(buf); err != nil {
+				return Response{}, err
+			}
+			current += int64(size)
+		}
+	}
+	if err := ch.GetFooter(timeout); err != nil {
+		return Response{}, err
+	}
+	resp, err := c.ReceiveResponse(stream, timeout)
+	if err != nil || resp.Code != 0 {
+		return resp, err
+	}
+	if end >= 0 && current != end {
+		return resp, ErrInvalidProtocol
+	}
+	return resp, nil
+}
+
+// Send a chunk request which writes size bytes from a reader to a file,
+// returning the response.
+func (c *Client) writeChunks(a Auth, command string, params map[string]interface{}, file string, r io.Reader, size int64, chunkSize int, timeout time.Duration) (Response, error) {
+	// Make the request.
+	conn, err := c.MakeConnection(c.insecureSkipVerify)
+	if err != nil {
+		return Response{}, err
+	}
+	defer conn.Close()
+	stream, err := c.SendRequestData(conn, *NewRequest(a, command, params, timeout), timeout, false)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Write the data.
+	ch := c.newChunkHandler(stream)
+	numChunks := int((size + int64(chunkSize) - 1) / int64(chunkSize))
+	if err := ch.WriteChunkResponseInfo([]network.ChunkInfo{{Name: file, NumChunks: numChunks}}, timeout, false); err != nil {
+		return Response{}, err
+	}
+	w := &chunkWriter{name: file, handler: ch, timeout: timeout, buf: make([]byte, 0, chunkSize)}
+	if _, err := io.CopyN(w, r, size); err != nil {
+		return Response{}, err
+	}
+	if err := w.flush(); err != nil {
+		return Response{}, err
+	}
+	if err := ch.WriteFooter(timeout); err != nil {
+		return Response{}, err
+	}
+
+	// Receive the response.
+	if err := c.ReceiveHeader(stream, timeout); err != nil {
+		return Response{}, err
+	}
+	if err := c.ReceiveIgnoreChunkData(stream, timeout); err != nil {
+		return Response{}, err
+	}
+	return c.ReceiveResponse(stream, timeout)
+}
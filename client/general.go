@@ -0,0 +1,86 @@
+// client/general.go
+// General commands.
+
+package client
+
+import (
+	"time"
+)
+
+// Server information.
+type ServerInfo struct {
+	Name                         string        `bson:"name"`
+	Version                      string        `bson:"version"`
+	Drives                       []string      `bson:"drives"`
+	DefaultSessionExpiration     time.Duration `bson:"defaultSessionExpiration"`
+	AllowChangeSessionExpiration bool          `bson:"allowChangeSessionExpiration"`
+	AllowNonExpiringSessions     bool          `bson:"allowNonExpiringSessions"`
+	SessionIdleTimeout           time.Duration `bson:"sessionIdleTimeout"`
+	SessionMaxLifetime           time.Duration `bson:"sessionMaxLifetime"`
+	PerUserSessionLimit          int           `bson:"perUserSessionLimit"`
+	Timeout                      time.Duration `bson:"timeout"`
+	Limit                        time.Duration `bson:"limit"`
+	MaxLimitEvents               int           `bson:"maxLimitEvents"`
+	TOTPRequiredClearance        int           `bson:"totpRequiredClearance"`
+	Compression                  []string      `bson:"compression"`
+}
+
+// Login options.
+type LoginOptions struct {
+	// The session expiration time, if SetExpireAfter is set. Otherwise, the
+	// server's default is used. Zero means that the session never expires.
+	ExpireAfter    time.Duration
+	SetExpireAfter bool
+
+	// The client label for the session.
+	Label string
+
+	// The second factor, if the user has enabled two-factor authentication.
+	TOTP         string
+	RecoveryCode string
+}
+
+// Ping the server.
+func (c *Client) Ping(timeout time.Duration) error {
+	return c.call(NewNullAuth(), "ping", map[string]interface{}{}, nil, timeout)
+}
+
+// Get the server information.
+func (c *Client) Info(timeout time.Duration) (ServerInfo, error) {
+	info := ServerInfo{}
+	err := c.call(NewNullAuth(), "info", map[string]interface{}{}, &info, timeout)
+	return info, err
+}
+
+// Log in, returning the new session ID. The authentication is usually user
+// or certificate authentication.
+func (c *Client) Login(a Auth, opts LoginOptions, timeout time.Duration) ([]byte, error) {
+	params := map[string]interface{}{}
+	if opts.SetExpireAfter {
+		params["expireAfter"] = int64(opts.ExpireAfter)
+	}
+	if opts.Label != "" {
+		params["label"] = opts.Label
+	}
+	if opts.TOTP != "" {
+		params["totp"] = opts.TOTP
+	}
+	if opts.RecoveryCode != "" {
+		params["recoveryCode"] = opts.RecoveryCode
+	}
+	result := struct {
+		ID []byte `bson:"id"`
+	}{}
+	if err := c.call(a, "login", params, &result, timeout); err != nil {
+		return nil, err
+	}
+	if len(result.ID) == 0 {
+		return nil, ErrInvalidSessionID
+	}
+	return result.ID, nil
+}
+
+// Log out of a session.
+func (c *Client) Logout(a Auth, timeout time.Duration) error {
+	return c.call(a, "logout", map[string]interface{}{}, nil, timeout)
+}
@@ -0,0 +1,181 @@
+// client/parallel.go
+// Parallel transfers of single files over several connections.
+
+package client
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// The largest range transferred over one connection, for a chunk size.
+func maxRangeSize(chunkSize int) int64 {
+	return int64(chunkSize) * maxNumChunks
+}
+
+// Split a file into at least the given number of ranges, none of which are
+// larger than the largest range.
+func splitRanges(size int64, streams int, maxSize int64) [][2]int64 {
+	if streams < 1 {
+		streams = 1
+	}
+	rangeSize := (size + int64(streams) - 1) / int64(streams)
+	if rangeSize > maxSize {
+		rangeSize = maxSize
+	}
+	ranges := [][2]int64{}
+	for start := int64(0); start < size; start += rangeSize {
+		end := start + rangeSize
+		if end > size {
+			end = size
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+	return ranges
+}
+
+// Transfer the ranges with a number of concurrent streams. Returns the first
+// failed response or error.
+func transferRanges(ranges [][2]int64, streams int, transfer func(start, end int64) (Response, error)) (Response, error) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failedResp Response
+	var failedErr error
+	next := make(chan [2]int64)
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range next {
+				resp, err := transfer(r[0], r[1])
+				if err != nil || resp.Code != 0 {
+					lock.Lock()
+					if failedErr == nil && failedResp.Code == 0 {
+						failedResp, failedErr = resp, err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, r := range ranges {
+		lock.Lock()
+		failed := failedErr != nil || failedResp.Code != 0
+		lock.Unlock()
+		if failed {
+			break
+		}
+		next <- r
+	}
+	close(next)
+	wg.Wait()
+	return failedResp, failedErr
+}
+
+// Upload a local file to replace an existing file on a drive, writing ranges
+// of the file concurrently over a number of connections. The server replaces
+// the file once every range has arrived and the hash of the new file matches.
+func (c *Client) ParallelUpload(a Auth, localFile, file, drive string, chunkSize, streams int, timeout time.Duration) (Response, error) {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return Response{}, ErrInvalidChunkSize
+	}
+
+	// Get the size and hash of the local file.
+	f, err := os.Open(localFile)
+	if err != nil {
+		return Response{}, err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Begin the upload.
+	resp, err := c.MakeNonChunkRequest(*NewRequest(a, "beginupload", map[string]interface{}{"path": file, "drive": drive, "size": size}, timeout))
+	if err != nil || resp.Code != 0 {
+		return resp, err
+	}
+	id, _ := resp.Data["id"].(string)
+
+	// Write the ranges.
+	resp, err = transferRanges(splitRanges(size, streams, maxRangeSize(chunkSize)), streams, func(start, end int64) (Response, error) {
+		return c.writeRange(a, f, file, drive, id, start, end, chunkSize, timeout)
+	})
+	if err != nil || resp.Code != 0 {
+		c.MakeNonChunkRequest(*NewRequest(a, "abortupload", map[string]interface{}{"drive": drive, "id": id}, timeout))
+		return resp, err
+	}
+
+	// Commit the upload.
+	return c.MakeNonChunkRequest(*NewRequest(a, "commitupload", map[string]interface{}{"drive": drive, "id": id, "hash": hasher.Sum(nil)}, timeout))
+}
+
+// Write a range of an upload.
+func (c *Client) writeRange(a Auth, f *os.File, file, drive, id string, start, end int64, chunkSize int, timeout time.Duration) (Response, error) {
+	params := map[string]interface{}{"drive": drive, "id": id, "start": start}
+	return c.writeChunks(a, "writerange", params, file, io.NewSectionReader(f, start, end-start), end-start, chunkSize, timeout)
+}
+
+// Download a file from a drive, reading ranges of the file concurrently over
+// a number of connections.
+func (c *Client) ParallelDownload(a Auth, file, localFile, drive string, chunkSize, streams int, timeout time.Duration) (Response, error) {
+	if chunkSize <= 0 || chunkSize > maxChunkSize {
+		return Response{}, ErrInvalidChunkSize
+	}
+
+	// Get the size of the file.
+	resp, err := c.MakeNonChunkRequest(*NewRequest(a, "stat", map[string]interface{}{"drive": drive, "paths": []string{file}}, timeout))
+	if err != nil || resp.Code != 0 {
+		return resp, err
+	}
+	stats, _ := resp.Data["stat"].(map[string]interface{})
+	stat, _ := stats[file].(map[string]interface{})
+	size := toInt64(stat["size"])
+
+	// Files which are empty or missing have no ranges, so they are downloaded
+	// normally.
+	if exists, _ := stat["exists"].(bool); !exists || size == 0 {
+		return c.DownloadFiles(a, []string{file}, []string{localFile}, drive, timeout)
+	}
+
+	// Create the local file.
+	f, err := os.Create(localFile)
+	if err != nil {
+		return Response{}, err
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return Response{}, err
+	}
+
+	// Read the ranges.
+	resp, err = transferRanges(splitRanges(size, streams, maxRangeSize(chunkSize)), streams, func(start, end int64) (Response, error) {
+		return c.readRange(a, f, file, drive, start, end, chunkSize, timeout)
+	})
+	if err != nil || resp.Code != 0 {
+		return resp, err
+	}
+	return resp, f.Close()
+}
+
+// Read a range of a file into the local file.
+func (c *Client) readRange(a Auth, f *os.File, file, drive string, start, end int64, chunkSize int, timeout time.Duration) (Response, error) {
+	return c.readFile(a, file, drive, &offsetWriter{f: f, offset: start}, start, end, chunkSize, timeout)
+}
+
+// Writes data sequentially to a file, starting at an offset.
+type offsetWriter struct {
+	f      *os.File
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,29 @@
+// client/parallel_test.go
+// Testing for client/parallel.go.
+
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test splitting files into ranges.
+func TestSplitRanges(t *testing.T) {
+	for _, test := range []struct {
+		size     int64
+		streams  int
+		maxSize  int64
+		expected [][2]int64
+	}{
+		{0, 4, 100, [][2]int64{}},
+		{10, 4, 100, [][2]int64{{0, 3}, {3, 6}, {6, 9}, {9, 10}}},
+		{10, 0, 100, [][2]int64{{0, 10}}},
+		{2, 4, 100, [][2]int64{{0, 1}, {1, 2}}},
+		{250, 2, 100, [][2]int64{{0, 100}, {100, 200}, {200, 250}}},
+	} {
+		if ranges := splitRanges(test.size, test.streams, test.maxSize); !reflect.DeepEqual(ranges, test.expected) {
+			t.Fatal(test.size, test.streams, ranges)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// client/response.go
+// Response errors and decoding of response data.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrRequestFailed = errors.New("lily.client: Request failed")
+
+// Errors for the response codes. See RESPONSE_CODES.md.
+var ErrInvalidCommand = errors.New("lily.client: Invalid command")
+var ErrUnhandledError = errors.New("lily.client: Unhandled command error")
+var ErrInvalidRequest = errors.New("lily.client: Invalid request")
+var ErrConnection = errors.New("lily.client: Connection timed out or connection error")
+var ErrInvalidProtocolVersion = errors.New("lily.client: Invalid protocol version")
+var ErrInvalidAuth = errors.New("lily.client: Invalid or expired authentication")
+var ErrRateLimit = errors.New("lily.client: Rate limit reached")
+var ErrOutOfMemory = errors.New("lily.client: Out of memory")
+var ErrSessionIDGeneration = errors.New("lily.client: Could not generate a unique session ID")
+var ErrInvalidExpiration = errors.New("lily.client: Invalid expiration time")
+var ErrSessionLimit = errors.New("lily.client: Per-user session limit reached")
+var ErrInvalidParameters = errors.New("lily.client: Invalid parameters")
+var ErrDriveNotFound = errors.New("lily.client: Drive does not exist")
+var ErrInvalidAccessSettings = errors.New("lily.client: Invalid access settings")
+var ErrFSArgument = errors.New("lily.client: FS argument error")
+var ErrInsufficientClearance = errors.New("lily.client: Insufficient clearance")
+var ErrUnknownFSError = errors.New("lily.client: Unknown FS error")
+var ErrInvalidClearances = errors.New("lily.client: Invalid access and modify clearances")
+var ErrUsernameExists = errors.New("lily.client: Username already exists")
+var ErrUsernameNotFound = errors.New("lily.client: Username not found")
+var ErrPasswordHash = errors.New("lily.client: Failed to hash password")
+var ErrSessionNotFound = errors.New("lily.client: Session not found")
+var ErrInvalidDriveFile = errors.New("lily.client: Invalid drive file")
+var ErrInvalidNumWorkers = errors.New("lily.client: Invalid number of workers")
+var ErrInvalidTimeout = errors.New("lily.client: Invalid timeout interval")
+var ErrInvalidLogLevel = errors.New("lily.client: Invalid log level")
+var ErrDriveExists = errors.New("lily.client: Drive already exists")
+var ErrInvalidTOTP = errors.New("lily.client: Invalid or missing two-factor authentication code")
+var ErrTOTPEnrollmentRequired = errors.New("lily.client: Two-factor authentication enrollment required")
+var ErrTOTPEnabled = errors.New("lily.client: Two-factor authentication already enabled")
+var ErrAccountLocked = errors.New("lily.client: Account temporarily locked")
+var ErrNotLocked = errors.New("lily.client: Account or address not locked")
+var ErrPasswordPolicy = errors.New("lily.client: Password does not meet the password policy")
+var ErrAuditNotEnabled = errors.New("lily.client: Audit log not enabled")
+var ErrShuttingDown = errors.New("lily.client: Server is shutting down")
+var ErrReloadFailed = errors.New("lily.client: Failed to reload server")
+var ErrUnsupportedCompression = errors.New("lily.client: Unsupported compression")
+var ErrChecksumMismatch = errors.New("lily.client: Chunk checksum mismatch")
+
+// The errors for each response code.
+var responseErrors = map[int]error{
+	1:  ErrInvalidCommand,
+	2:  ErrUnhandledError,
+	3:  ErrInvalidRequest,
+	4:  ErrConnection,
+	5:  ErrInvalidProtocolVersion,
+	6:  ErrInvalidAuth,
+	7:  ErrRateLimit,
+	8:  ErrOutOfMemory,
+	9:  ErrSessionIDGeneration,
+	10: ErrInvalidExpiration,
+	11: ErrSessionLimit,
+	12: ErrInvalidParameters,
+	13: ErrDriveNotFound,
+	14: ErrInvalidAccessSettings,
+	15: ErrFSArgument,
+	16: ErrInsufficientClearance,
+	17: ErrUnknownFSError,
+	18: ErrInvalidChunkSize,
+	19: ErrInvalidClearances,
+	20: ErrUsernameExists,
+	21: ErrUsernameNotFound,
+	22: ErrPasswordHash,
+	23: ErrSessionNotFound,
+	24: ErrInvalidDriveFile,
+	25: ErrInvalidNumWorkers,
+	26: ErrInvalidTimeout,
+	27: ErrInvalidLogLevel,
+	28: ErrDriveExists,
+	29: ErrInvalidTOTP,
+	30: ErrTOTPEnrollmentRequired,
+	31: ErrTOTPEnabled,
+	32: ErrAccountLocked,
+	33: ErrNotLocked,
+	34: ErrPasswordPolicy,
+	35: ErrAuditNotEnabled,
+	36: ErrShuttingDown,
+	37: ErrReloadFailed,
+	38: ErrUnsupportedCompression,
+	39: ErrChecksumMismatch,
+}
+
+// An error response from the server. It matches the error for its code and
+// ErrRequestFailed with errors.Is.
+type ResponseError struct {
+	Code   int
+	String string
+	Data   map[string]interface{}
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.String, e.Code)
+}
+
+// Get the error for the response code, or nil if the code is unknown.
+func (e *ResponseError) Unwrap() error {
+	return responseErrors[e.Code]
+}
+
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrRequestFailed
+}
+
+// Get the error for the response, or nil if the response code is zero.
+func (r Response) Err() error {
+	if r.Code == 0 {
+		return nil
+	}
+	return &ResponseError{Code: r.Code, String: r.String, Data: r.Data}
+}
+
+// Check a response, returning an error if the response code is not zero.
+func checkResponse(resp Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+// Make a request, and return an error if the response code is not zero.
+func (c *Client) makeRequest(a Auth, command string, params map[string]interface{}, timeout time.Duration) (Response, error) {
+	resp, err := c.MakeNonChunkRequest(*NewRequest(a, command, params, timeout))
+	if err != nil {
+		return resp, err
+	}
+	return resp, checkResponse(resp, nil)
+}
+
+// Make a request, and decode the response data into the result, if it is
+// not nil.
+func (c *Client) call(a Auth, command string, params map[string]interface{}, result interface{}, timeout time.Duration) error {
+	resp, err := c.makeRequest(a, command, params, timeout)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	return decodeData(resp.Data, result)
+}
+
+// Decode response data into a struct, by re-encoding it as BSON.
+func decodeData(data map[string]interface{}, result interface{}) error {
+	encoded, err := bson.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidResponse, err)
+	}
+	if err := bson.Unmarshal(encoded, result); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidResponse, err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// client/response_test.go
+// Testing for client/response.go.
+
+package client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/commands"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The client methods for each command.
+var commandMethods = map[string]string{
+	"ping":                          "Ping",
+	"info":                          "Info",
+	"login":                         "Login",
+	"logout":                        "Logout",
+	"getallusers":                   "GetAllUsers",
+	"getuserinformation":            "GetUserInformation",
+	"setuserclearance":              "SetUserClearance",
+	"setuserpassword":               "SetUserPassword",
+	"createusers":                   "CreateUsers",
+	"deleteusers":                   "DeleteUsers",
+	"getallsessions":                "GetAllSessions",
+	"getallusersessions":            "GetAllUserSessions",
+	"getsessioninfo":                "GetSessionInfo",
+	"expireallsessions":             "ExpireAllSessions",
+	"expiresessions":                "ExpireSessions",
+	"getallsettings":                "GetAllSettings",
+	"sethostandport":                "SetHostAndPort",
+	"adddrive":                      "AddDrive",
+	"renamedrive":                   "RenameDrive",
+	"removedrive":                   "RemoveDrive",
+	"setnumworkers":                 "SetNumWorkers",
+	"setcronintervals":              "SetCronIntervals",
+	"settimeoutinterval":            "SetTimeoutInterval",
+	"setloggingsettings":            "SetLoggingSettings",
+	"setratelimit":                  "SetRateLimit",
+	"shutdown":                      "Shutdown",
+	"reload":                        "Reload",
+	"getmemoryusage":                "GetMemoryUsage",
+	"setpassword":                   "SetPassword",
+	"setuptotp":                     "SetupTOTP",
+	"enabletotp":                    "EnableTOTP",
+	"disabletotp":                   "DisableTOTP",
+	"resetusertotp":                 "ResetUserTOTP",
+	"settotprequiredclearance":      "SetTOTPRequiredClearance",
+	"setclientauth":                 "SetClientAuth",
+	"addcertusers":                  "AddCertUsers",
+	"removecertusers":               "RemoveCertUsers",
+	"setldap":                       "SetLDAP",
+	"setlockout":                    "SetLockout",
+	"getlockedaccounts":             "GetLockedAccounts",
+	"unlockaccounts":                "UnlockAccounts",
+	"listmysessions":                "ListMySessions",
+	"revokemysessions":              "RevokeMySessions",
+	"setsessionbinding":             "SetSessionBinding",
+	"getauditlog":                   "GetAuditLog",
+	"verifyauditlog":                "VerifyAuditLog",
+	"setuserlimits":                 "SetUserLimits",
+	"setuserlimitoverride":          "SetUserLimitOverride",
+	"removeuserlimitoverrides":      "RemoveUserLimitOverrides",
+	"getuserlimitoverrides":         "GetUserLimitOverrides",
+	"setbandwidthlimits":            "SetBandwidthLimits",
+	"setipfilter":                   "SetIPFilter",
+	"settrustedproxies":             "SetTrustedProxies",
+	"setconnectionlimits":           "SetConnectionLimits",
+	"settransferlimits":             "SetTransferLimits",
+	"addlistener":                   "AddListener",
+	"removelisteners":               "RemoveListeners",
+	"setpasswordpolicy":             "SetPasswordPolicy",
+	"setpasswordhashing":            "SetPasswordHashing",
+	"reauthenticate":                "Reauthenticate",
+	"setexpirationtime":             "SetExpirationTime",
+	"createdirs":                    "CreateDirs",
+	"createdirtree":                 "CreateDirTree",
+	"listdir":                       "ListDir",
+	"renamedirs":                    "RenameDirs",
+	"movedirs":                      "MoveDirs",
+	"deletedirs":                    "DeleteDirs",
+	"createfiles":                   "CreateFiles",
+	"readfiles":                     "ReadFile",
+	"writefiles":                    "WriteFile",
+	"renamefiles":                   "RenameFiles",
+	"movefiles":                     "MoveFiles",
+	"deletefiles":                   "DeleteFiles",
+	"stat":                          "Stat",
+	"rehashfiles":                   "RehashFiles",
+	"verifyhashes":                  "VerifyHashes",
+	"getsignature":                  "GetSignature",
+	"patchfile":                     "PatchFile",
+	"beginupload":                   "BeginUpload",
+	"writerange":                    "WriteRange",
+	"commitupload":                  "CommitUpload",
+	"abortupload":                   "AbortUpload",
+	"getpathsettings":               "GetPathSettings",
+	"setpathsettings":               "SetPathSettings",
+	"setpathclearances":             "SetPathClearances",
+	"addtopathaccesswhitelist":      "AddToPathAccessWhitelist",
+	"removefrompathaccesswhitelist": "RemoveFromPathAccessWhitelist",
+	"addtopathmodifywhitelist":      "AddToPathModifyWhitelist",
+	"removefrompathmodifywhitelist": "RemoveFromPathModifyWhitelist",
+	"addtopathaccessblacklist":      "AddToPathAccessBlacklist",
+	"removefrompathaccessblacklist": "RemoveFromPathAccessBlacklist",
+	"addtopathmodifyblacklist":      "AddToPathModifyBlacklist",
+	"removefrompathmodifyblacklist": "RemoveFromPathModifyBlacklist",
+}
+
+// Test that every command has a client method.
+func TestCommandMethods(t *testing.T) {
+	clientType := reflect.TypeOf(&Client{})
+	for name := range commands.COMMANDS {
+		method, ok := commandMethods[name]
+		if !ok {
+			t.Fatal("no method for command", name)
+		}
+		if _, ok := clientType.MethodByName(method); !ok {
+			t.Fatal("missing method", method)
+		}
+	}
+	if len(commandMethods) != len(commands.COMMANDS) {
+		t.Fatal(len(commandMethods), len(commands.COMMANDS))
+	}
+}
+
+// Test response errors.
+func TestResponseErrors(t *testing.T) {
+	if (Response{Code: 0, String: "ok"}).Err() != nil {
+		t.Fatal("error for code zero")
+	}
+	for code, expected := range responseErrors {
+		err := Response{Code: code, String: "message"}.Err()
+		if !errors.Is(err, expected) || !errors.Is(err, ErrRequestFailed) {
+			t.Fatal(code, err)
+		}
+		if code != 13 && errors.Is(err, ErrDriveNotFound) {
+			t.Fatal(code, err)
+		}
+	}
+
+	// Errors for unknown codes only match ErrRequestFailed.
+	err := checkResponse(Response{Code: 1000, String: "unknown"}, nil)
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) || respErr.Code != 1000 || errors.Unwrap(err) != nil || !errors.Is(err, ErrRequestFailed) {
+		t.Fatal(err)
+	}
+	if err.Error() != "unknown (code 1000)" {
+		t.Fatal(err.Error())
+	}
+}
+
+// Encode response data as the server does, and decode it as the client does.
+func receiveData(t *testing.T, data map[string]interface{}) map[string]interface{} {
+	encoded, err := bson.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := map[string]interface{}{}
+	if err := bson.Unmarshal(encoded, &received); err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+// Test decoding response data.
+func TestDecodeData(t *testing.T) {
+	// Decode the server info.
+	info := ServerInfo{}
+	err := decodeData(receiveData(t, map[string]interface{}{
+		"name":                     "lily",
+		"drives":                   []string{"a", "b"},
+		"defaultSessionExpiration": time.Hour,
+		"perUserSessionLimit":      5,
+		"compression":              []string{"gzip"},
+	}), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "lily" || !reflect.DeepEqual(info.Drives, []string{"a", "b"}) || info.DefaultSessionExpiration != time.Hour ||
+		info.PerUserSessionLimit != 5 || !reflect.DeepEqual(info.Compression, []string{"gzip"}) {
+		t.Fatal(info)
+	}
+
+	// Decode nested structs and maps.
+	type pathStatus struct {
+		Exists bool
+		IsFile bool
+		Size   int64
+		Hash   []byte
+	}
+	result := struct {
+		Stat map[string]PathStatus
+	}{}
+	err = decodeData(receiveData(t, map[string]interface{}{
+		"stat": map[string]pathStatus{"file": {Exists: true, IsFile: true, Size: 3, Hash: []byte{1, 2}}, "none": {}},
+	}), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Stat, map[string]PathStatus{"file": {Exists: true, IsFile: true, Size: 3, Hash: []byte{1, 2}}, "none": {Hash: []byte{}}}) {
+		t.Fatal(result.Stat)
+	}
+}
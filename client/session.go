@@ -0,0 +1,34 @@
+// client/session.go
+// Session commands.
+
+package client
+
+import (
+	"time"
+)
+
+// Session information. Sessions listed by ListMySessions are identified by
+// the hash of their ID instead of the ID itself.
+type SessionInfo struct {
+	ID          []byte
+	Username    string
+	ExpireAfter time.Duration
+	ExpireAt    int64
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+	CreatedAt   int64
+	LastUsed    int64
+	IP          string
+	Label       string
+}
+
+// Check that a session is still valid.
+func (c *Client) Reauthenticate(a Auth, timeout time.Duration) error {
+	return c.call(a, "reauthenticate", map[string]interface{}{}, nil, timeout)
+}
+
+// Set the expiration time of the current session. Zero means that the session
+// never expires.
+func (c *Client) SetExpirationTime(a Auth, expiration time.Duration, timeout time.Duration) error {
+	return c.call(a, "setexpirationtime", map[string]interface{}{"sessionExpiration": int64(expiration)}, nil, timeout)
+}
@@ -0,0 +1,551 @@
+// client/sync.go
+// Directory sync between the local filesystem and a drive.
+
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSyncConflict = errors.New("lily.client: File and directory conflict")
+var ErrSyncRootNotFound = errors.New("lily.client: Sync source does not exist")
+var ErrInvalidResponse = errors.New("lily.client: Invalid response")
+
+// Sync directions.
+type SyncDirection int
+
+const (
+	// Upload the local tree to the drive.
+	SyncUpload SyncDirection = iota
+
+	// Download the drive tree to the local filesystem.
+	SyncDownload
+)
+
+// Sync operations.
+type SyncOp string
+
+const (
+	SyncOpDelete   SyncOp = "delete"
+	SyncOpMkdir    SyncOp = "mkdir"
+	SyncOpUpload   SyncOp = "upload"
+	SyncOpDownload SyncOp = "download"
+)
+
+// Sync options.
+type SyncOptions struct {
+	// The direction to sync in.
+	Direction SyncDirection
+
+	// If files and directories in the destination that are not in the source
+	// should be deleted.
+	Delete bool
+
+	// Patterns of paths to include and exclude, matched with path.Match
+	// against the path relative to the sync root and against the name. If
+	// there are include patterns, only files matching them are synced.
+	// Excluded directories are skipped entirely.
+	Include []string
+	Exclude []string
+
+	// If the hashes of files should be compared even when their sizes match
+	// and the source is not newer than the destination.
+	Checksum bool
+
+	// If the actions should only be planned and not performed.
+	DryRun bool
+
+	// If existing files of at least DeltaMinSize bytes should be patched with
+	// a delta of the changes, rather than rewritten.
+	Delta bool
+
+	// The number of files to transfer at once.
+	Parallel int
+
+	// The upload chunk size and the request timeout.
+	ChunkSize int
+	Timeout   time.Duration
+}
+
+// A file or directory in a synced tree.
+type SyncEntry struct {
+	IsDir   bool
+	Size    int64
+	ModTime int64
+
+	// The SHA-256 hash. Only known for remote files, since local files are
+	// hashed when they need to be compared.
+	Hash []byte
+}
+
+// A sync action.
+type SyncAction struct {
+	Op     SyncOp
+	Path   string
+	IsDir  bool
+	Size   int64
+	Reason string
+
+	// The error from performing the action.
+	Err error
+
+	// If an uploaded file replaces an existing file.
+	replace bool
+}
+
+// Sync a local directory and a directory on a drive, so that the destination
+// matches the source. Only files which differ in size, or which are newer in
+// the source and have a different hash, are transferred. Returns the planned
+// actions, with the errors of any failed transfers.
+func (c *Client) Sync(a Auth, localDir, drive, remoteDir string, opts SyncOptions) ([]SyncAction, error) {
+	// Check the options.
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	remoteDir = strings.Trim(path.Clean("/"+remoteDir), "/")
+
+	// Get the trees.
+	local, localExists, err := localSyncTree(localDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	remote, remoteExists, err := c.remoteSyncTree(a, drive, remoteDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if (opts.Direction == SyncUpload && !localExists) || (opts.Direction == SyncDownload && !remoteExists) {
+		return nil, ErrSyncRootNotFound
+	}
+
+	// Plan the actions.
+	var actions []SyncAction
+	if opts.Direction == SyncUpload {
+		actions, err = planSync(local, remote, opts, func(rel string) ([]byte, error) {
+			return hashFile(filepath.Join(localDir, filepath.FromSlash(rel)))
+		})
+	} else {
+		actions, err = planSync(remote, local, opts, func(rel string) ([]byte, error) {
+			return hashFile(filepath.Join(localDir, filepath.FromSlash(rel)))
+		})
+	}
+	if err != nil || opts.DryRun {
+		return actions, err
+	}
+
+	// Create the destination root.
+	if opts.Direction == SyncUpload && !remoteExists {
+		if err := c.createRemoteDirs(a, drive, remoteDir, opts.Timeout); err != nil {
+			return actions, err
+		}
+	} else if opts.Direction == SyncDownload && !localExists {
+		if err := os.MkdirAll(localDir, 0755); err != nil {
+			return actions, err
+		}
+	}
+
+	// Delete and create directories in order, then transfer the files in
+	// parallel.
+	transfers := []int{}
+	for i := range actions {
+		remotePath := path.Join(remoteDir, actions[i].Path)
+		localPath := filepath.Join(localDir, filepath.FromSlash(actions[i].Path))
+		switch {
+		case actions[i].Op == SyncOpDelete && opts.Direction == SyncUpload:
+			command := "deletefiles"
+			if actions[i].IsDir {
+				command = "deletedirs"
+			}
+			err = c.syncRequest(a, command, map[string]interface{}{"drive": drive, "paths": []string{remotePath}}, opts.Timeout)
+		case actions[i].Op == SyncOpDelete:
+			err = os.RemoveAll(localPath)
+		case actions[i].Op == SyncOpMkdir && opts.Direction == SyncUpload:
+			err = c.syncRequest(a, "createdirs", map[string]interface{}{"drive": drive, "paths": []string{remotePath}}, opts.Timeout)
+		case actions[i].Op == SyncOpMkdir:
+			err = os.Mkdir(localPath, 0755)
+		default:
+			transfers = append(transfers, i)
+		}
+		if err != nil {
+			actions[i].Err = err
+			return actions, err
+		}
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				remotePath := path.Join(remoteDir, actions[i].Path)
+				localPath := filepath.Join(localDir, filepath.FromSlash(actions[i].Path))
+				if actions[i].Op == SyncOpUpload {
+					actions[i].Err = c.syncUpload(a, localPath, drive, remotePath, actions[i].replace, opts)
+				} else {
+					actions[i].Err = c.syncDownload(a, remotePath, drive, localPath, opts)
+				}
+			}
+		}()
+	}
+	for _, i := range transfers {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	// Return the first error.
+	for _, i := range transfers {
+		if actions[i].Err != nil {
+			return actions, actions[i].Err
+		}
+	}
+	return actions, nil
+}
+
+// Plan the actions to make the destination tree match the source tree. The
+// actions are ordered as deletions, then directories to create from the top
+// down, then transfers.
+func planSync(src, dst map[string]SyncEntry, opts SyncOptions, localHash func(rel string) ([]byte, error)) ([]SyncAction, error) {
+	transferOp := SyncOpUpload
+	if opts.Direction == SyncDownload {
+		transferOp = SyncOpDownload
+	}
+
+	// Delete the destination paths which conflict with the source, or which
+	// are not in the source.
+	deletes := []SyncAction{}
+	deletedDirs := []string{}
+	for _, rel := range sortedPaths(dst) {
+		if underAny(rel, deletedDirs) {
+			continue
+		}
+		s, inSrc := src[rel]
+		d := dst[rel]
+		reason := "extraneous"
+		if inSrc {
+			if s.IsDir == d.IsDir {
+				continue
+			}
+			if !opts.Delete {
+				return nil, fmt.Errorf("%w: %s", ErrSyncConflict, rel)
+			}
+			reason = "conflict"
+		} else if !opts.Delete || (len(opts.Include) != 0 && (d.IsDir || !matchAny(opts.Include, rel))) {
+			// Only delete included files if there are include patterns.
+			continue
+		}
+		deletes = append(deletes, SyncAction{Op: SyncOpDelete, Path: rel, IsDir: d.IsDir, Size: d.Size, Reason: reason})
+		if d.IsDir {
+			deletedDirs = append(deletedDirs, rel)
+		}
+	}
+
+	// Transfer the files which are new or have changed.
+	transfers := []SyncAction{}
+	neededDirs := map[string]SyncEntry{}
+	for _, rel := range sortedPaths(src) {
+		s := src[rel]
+		if s.IsDir {
+			// Create all directories, unless only some files are included.
+			if len(opts.Include) == 0 {
+				neededDirs[rel] = s
+			}
+			continue
+		}
+		d, inDst := dst[rel]
+		if inDst && d.IsDir {
+			inDst = false
+		}
+		reason, err := compareSyncEntries(rel, s, d, inDst, opts, localHash)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			continue
+		}
+		transfers = append(transfers, SyncAction{Op: transferOp, Path: rel, Size: s.Size, Reason: reason, replace: inDst})
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			neededDirs[dir] = SyncEntry{IsDir: true}
+		}
+	}
+
+	// Create the missing directories.
+	mkdirs := []SyncAction{}
+	for _, rel := range sortedPaths(neededDirs) {
+		if d, ok := dst[rel]; ok && d.IsDir {
+			continue
+		}
+		mkdirs = append(mkdirs, SyncAction{Op: SyncOpMkdir, Path: rel, IsDir: true, Reason: "new"})
+	}
+
+	// Return.
+	return append(append(deletes, mkdirs...), transfers...), nil
+}
+
+// Compare a source file with a destination file, and return the reason to
+// transfer it, or an empty string if they are the same.
+func compareSyncEntries(rel string, s, d SyncEntry, inDst bool, opts SyncOptions, localHash func(rel string) ([]byte, error)) (string, error) {
+	if !inDst {
+		return "new", nil
+	}
+	if s.Size != d.Size {
+		return "size", nil
+	}
+	if !opts.Checksum && s.ModTime <= d.ModTime {
+		return "", nil
+	}
+
+	// Compare the hashes. Only the remote hash is known, so the local file
+	// is hashed. Files without a stored hash are always transferred.
+	remoteHash := d.Hash
+	if opts.Direction == SyncDownload {
+		remoteHash = s.Hash
+	}
+	if len(remoteHash) == 0 {
+		return "hash", nil
+	}
+	hash, err := localHash(rel)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(hash, remoteHash) {
+		return "hash", nil
+	}
+	return "", nil
+}
+
+// Get the local tree, relative to the root. Returns false if the root does not
+// exist.
+func localSyncTree(root string, opts SyncOptions) (map[string]SyncEntry, bool, error) {
+	tree := map[string]SyncEntry{}
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return tree, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, ErrSyncConflict
+	}
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchAny(opts.Exclude, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			tree[rel] = SyncEntry{IsDir: true, ModTime: info.ModTime().Unix()}
+		} else if info.Mode().IsRegular() && (len(opts.Include) == 0 || matchAny(opts.Include, rel)) {
+			// Skip symbolic links and other special files.
+			tree[rel] = SyncEntry{Size: info.Size(), ModTime: info.ModTime().Unix()}
+		}
+		return nil
+	})
+	return tree, true, err
+}
+
+// Get the remote tree, relative to the root. Returns false if the root does
+// not exist.
+func (c *Client) remoteSyncTree(a Auth, drive, root string, opts SyncOptions) (map[string]SyncEntry, bool, error) {
+	tree := map[string]SyncEntry{}
+
+	// Check that the root is a directory.
+	if root != "" {
+		resp, err := c.makeRequest(a, "stat", map[string]interface{}{"drive": drive, "paths": []string{root}}, opts.Timeout)
+		if err != nil {
+			return nil, false, err
+		}
+		stats, _ := resp.Data["stat"].(map[string]interface{})
+		stat, _ := stats[root].(map[string]interface{})
+		if exists, _ := stat["exists"].(bool); !exists {
+			return tree, false, nil
+		}
+		if isFile, _ := stat["isfile"].(bool); isFile {
+			return nil, false, ErrSyncConflict
+		}
+	}
+
+	// List the directories, breadth first.
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		resp, err := c.makeRequest(a, "listdir", map[string]interface{}{"drive": drive, "path": path.Join(root, dir)}, opts.Timeout)
+		if err != nil {
+			return nil, false, err
+		}
+		list, ok := resp.Data["list"].([]interface{})
+		if !ok && resp.Data["list"] != nil {
+			return nil, false, ErrInvalidResponse
+		}
+		for i := range list {
+			item, ok := list[i].(map[string]interface{})
+			if !ok {
+				return nil, false, ErrInvalidResponse
+			}
+			name, _ := item["name"].(string)
+			rel := path.Join(dir, name)
+			isFile, _ := item["isfile"].(bool)
+			if matchAny(opts.Exclude, rel) {
+				continue
+			}
+			if !isFile {
+				tree[rel] = SyncEntry{IsDir: true, ModTime: toInt64(item["lastedittime"])}
+				dirs = append(dirs, rel)
+			} else if len(opts.Include) == 0 || matchAny(opts.Include, rel) {
+				hash, _ := item["hash"].([]byte)
+				tree[rel] = SyncEntry{Size: toInt64(item["size"]), ModTime: toInt64(item["lastedittime"]), Hash: hash}
+			}
+		}
+	}
+
+	// Return.
+	return tree, true, nil
+}
+
+// Upload a file, replacing or patching the existing file if there is one.
+func (c *Client) syncUpload(a Auth, localPath, drive, remotePath string, replace bool, opts SyncOptions) error {
+	if replace && opts.Delta {
+		if info, err := os.Stat(localPath); err == nil && info.Size() >= DeltaMinSize {
+			return checkResponse(c.PatchFile(a, localPath, remotePath, drive, opts.ChunkSize, opts.Timeout))
+		}
+	}
+	if replace {
+		return checkResponse(c.WriteFiles(a, []string{localPath}, []string{remotePath}, drive, opts.ChunkSize, opts.Timeout))
+	}
+	return checkResponse(c.UploadFiles(a, []string{localPath}, []string{remotePath}, nil, drive, opts.ChunkSize, opts.Timeout))
+}
+
+// Download a file to a temporary file, and replace the local file with it
+// once it is complete.
+func (c *Client) syncDownload(a Auth, remotePath, drive, localPath string, opts SyncOptions) error {
+	tempPath := filepath.Join(filepath.Dir(localPath), "."+filepath.Base(localPath)+".lilysync")
+	if err := checkResponse(c.DownloadFiles(a, []string{remotePath}, []string{tempPath}, drive, opts.Timeout)); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, localPath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// Create a remote directory and its missing parents.
+func (c *Client) createRemoteDirs(a Auth, drive, dir string, timeout time.Duration) error {
+	parts := strings.Split(dir, "/")
+	for i := range parts {
+		current := strings.Join(parts[:i+1], "/")
+		resp, err := c.makeRequest(a, "stat", map[string]interface{}{"drive": drive, "paths": []string{current}}, timeout)
+		if err != nil {
+			return err
+		}
+		stats, _ := resp.Data["stat"].(map[string]interface{})
+		stat, _ := stats[current].(map[string]interface{})
+		if exists, _ := stat["exists"].(bool); exists {
+			continue
+		}
+		if err := c.syncRequest(a, "createdirs", map[string]interface{}{"drive": drive, "paths": []string{current}}, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make a request, ignoring the response data.
+func (c *Client) syncRequest(a Auth, command string, params map[string]interface{}, timeout time.Duration) error {
+	_, err := c.makeRequest(a, command, params, timeout)
+	return err
+}
+
+// Calculate the SHA-256 hash of a file.
+func hashFile(name string) ([]byte, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Check if a relative path or its name matches any of the patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a path is under any of the directories.
+func underAny(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the sorted paths of a map.
+func sortedPaths(m map[string]SyncEntry) []string {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Convert a BSON number to an int64.
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,144 @@
+// client/sync_test.go
+// Testing for client/sync.go.
+
+package client
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Get the operations and paths of actions.
+func actionList(actions []SyncAction) []string {
+	list := []string{}
+	for i := range actions {
+		list = append(list, string(actions[i].Op)+" "+actions[i].Path+" "+actions[i].Reason)
+	}
+	return list
+}
+
+// Test planning an upload.
+func TestPlanSyncUpload(t *testing.T) {
+	hash := sha256.Sum256([]byte("same"))
+	local := map[string]SyncEntry{
+		"a":       {IsDir: true, ModTime: 10},
+		"a/new":   {Size: 3, ModTime: 10},
+		"a/b":     {IsDir: true, ModTime: 10},
+		"a/b/c":   {Size: 4, ModTime: 10},
+		"size":    {Size: 5, ModTime: 10},
+		"old":     {Size: 4, ModTime: 10},
+		"touched": {Size: 4, ModTime: 30},
+		"changed": {Size: 4, ModTime: 30},
+	}
+	remote := map[string]SyncEntry{
+		"a":       {IsDir: true, ModTime: 20},
+		"size":    {Size: 6, ModTime: 20},
+		"old":     {Size: 4, ModTime: 20, Hash: []byte("other")},
+		"touched": {Size: 4, ModTime: 20, Hash: hash[:]},
+		"changed": {Size: 4, ModTime: 20, Hash: []byte("other")},
+		"extra":   {Size: 1, ModTime: 20},
+		"x":       {IsDir: true, ModTime: 20},
+		"x/y":     {Size: 1, ModTime: 20},
+	}
+	localHash := func(rel string) ([]byte, error) {
+		return hash[:], nil
+	}
+
+	actions, err := planSync(local, remote, SyncOptions{Direction: SyncUpload}, localHash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{
+		"mkdir a/b new",
+		"upload a/b/c new",
+		"upload a/new new",
+		"upload changed hash",
+		"upload size size",
+	}
+	if !reflect.DeepEqual(actionList(actions), expected) {
+		t.Fatal(actionList(actions))
+	}
+	for i := range actions {
+		if actions[i].Path == "changed" && !actions[i].replace {
+			t.Fail()
+		}
+	}
+
+	// Delete the extraneous paths, without deleting the contents of deleted
+	// directories.
+	actions, err = planSync(local, remote, SyncOptions{Direction: SyncUpload, Delete: true}, localHash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(actionList(actions)[:2], []string{"delete extra extraneous", "delete x extraneous"}) || len(actions) != 7 {
+		t.Fatal(actionList(actions))
+	}
+
+	// Compare the hashes of all files.
+	actions, err = planSync(local, remote, SyncOptions{Direction: SyncUpload, Checksum: true}, localHash)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(actions) != 6 || actions[3].Path != "changed" || actions[4].Path != "old" {
+		t.Fatal(actionList(actions))
+	}
+}
+
+// Test planning a download, with a conflict between a file and a directory.
+func TestPlanSyncDownload(t *testing.T) {
+	remote := map[string]SyncEntry{
+		"a":   {IsDir: true, ModTime: 10},
+		"a/b": {Size: 1, ModTime: 10},
+	}
+	local := map[string]SyncEntry{
+		"a": {Size: 1, ModTime: 20},
+	}
+	_, err := planSync(remote, local, SyncOptions{Direction: SyncDownload}, nil)
+	if !errors.Is(err, ErrSyncConflict) {
+		t.Fatal(err)
+	}
+	actions, err := planSync(remote, local, SyncOptions{Direction: SyncDownload, Delete: true}, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{"delete a conflict", "mkdir a new", "download a/b new"}
+	if !reflect.DeepEqual(actionList(actions), expected) {
+		t.Fatal(actionList(actions))
+	}
+}
+
+// Test the local tree, with include and exclude patterns.
+func TestLocalSyncTree(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.txt", "b.log", "skip/c.txt", "d/e.txt", "d/f.log"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	tree, exists, err := localSyncTree(root, SyncOptions{Include: []string{"*.txt"}, Exclude: []string{"skip"}})
+	if err != nil || !exists {
+		t.Fatal(err)
+	}
+	expected := []string{"a.txt", "d", "d/e.txt"}
+	if !reflect.DeepEqual(sortedPaths(tree), expected) {
+		t.Fatal(sortedPaths(tree))
+	}
+	if tree["a.txt"].Size != 5 || !tree["d"].IsDir {
+		t.Fail()
+	}
+
+	// Check a missing root.
+	_, exists, err = localSyncTree(filepath.Join(root, "missing"), SyncOptions{})
+	if err != nil || exists {
+		t.Fail()
+	}
+}
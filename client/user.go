@@ -0,0 +1,68 @@
+// client/user.go
+// User commands.
+
+package client
+
+import (
+	"time"
+)
+
+// The current user's sessions. Current is the ID hash of the session which
+// made the request, if any.
+type MySessions struct {
+	Sessions []SessionInfo `bson:"sessions"`
+	Current  []byte        `bson:"current"`
+}
+
+// A new two-factor authentication secret, and its provisioning URI.
+type TOTPSetup struct {
+	Secret string `bson:"secret"`
+	URI    string `bson:"uri"`
+}
+
+// Set the current user's password, optionally revoking their other sessions.
+func (c *Client) SetPassword(a Auth, password string, revokeSessions bool, timeout time.Duration) error {
+	return c.call(a, "setpassword", map[string]interface{}{"password": password, "revokeSessions": revokeSessions}, nil, timeout)
+}
+
+// List the current user's sessions.
+func (c *Client) ListMySessions(a Auth, timeout time.Duration) (MySessions, error) {
+	sessions := MySessions{}
+	err := c.call(a, "listmysessions", map[string]interface{}{}, &sessions, timeout)
+	return sessions, err
+}
+
+// Revoke the current user's sessions, given their ID hashes.
+func (c *Client) RevokeMySessions(a Auth, ids [][]byte, timeout time.Duration) error {
+	return c.call(a, "revokemysessions", map[string]interface{}{"ids": ids}, nil, timeout)
+}
+
+// Generate a two-factor authentication secret for the current user. It is
+// enabled with EnableTOTP.
+func (c *Client) SetupTOTP(a Auth, timeout time.Duration) (TOTPSetup, error) {
+	setup := TOTPSetup{}
+	err := c.call(a, "setuptotp", map[string]interface{}{}, &setup, timeout)
+	return setup, err
+}
+
+// Enable two-factor authentication, given a code generated from the secret.
+// Returns the recovery codes.
+func (c *Client) EnableTOTP(a Auth, code string, timeout time.Duration) ([]string, error) {
+	result := struct {
+		RecoveryCodes []string `bson:"recoveryCodes"`
+	}{}
+	err := c.call(a, "enabletotp", map[string]interface{}{"totp": code}, &result, timeout)
+	return result.RecoveryCodes, err
+}
+
+// Disable two-factor authentication, given either a code or a recovery code.
+func (c *Client) DisableTOTP(a Auth, code, recoveryCode string, timeout time.Duration) error {
+	params := map[string]interface{}{}
+	if code != "" {
+		params["totp"] = code
+	}
+	if recoveryCode != "" {
+		params["recoveryCode"] = recoveryCode
+	}
+	return c.call(a, "disabletotp", params, nil, timeout)
+}
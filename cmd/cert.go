@@ -0,0 +1,179 @@
+// cmd/cert.go
+// Certificate commands.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cubeflix/lily/marshal"
+	"github.com/cubeflix/lily/security/certs"
+	"github.com/cubeflix/lily/server"
+	"github.com/cubeflix/lily/server/config"
+	"github.com/spf13/cobra"
+)
+
+// Get the absolute paths of a certificate and key in the certificate
+// directory.
+func certPaths(name string) (string, string, error) {
+	dir, err := filepath.Abs(certDir)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"), nil
+}
+
+// Create a certificate authority and a server certificate.
+func CertInit(cmd *cobra.Command, args []string) {
+	caCert, caKey, err := certPaths("ca")
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	serverCert, serverKey, err := certPaths("server")
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if _, err := os.Stat(caCert); err == nil {
+		fmt.Println("cert: certificate authority already exists")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(caCert), 0700); err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+
+	// Create the certificates.
+	ca, err := certs.NewCA(certName+" CA", certs.DefaultCAValidity)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	serverPair, err := ca.IssueServer(certName, certSANs, certValidity)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if err := ca.Save(caCert, caKey); err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if err := serverPair.Save(serverCert, serverKey); err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+
+	// Print the config file settings.
+	fmt.Println("[config]")
+	fmt.Println("clientCAFile:", caCert)
+	fmt.Println()
+	fmt.Println("[certs]")
+	fmt.Println("certFiles:", serverCert)
+	fmt.Println("keyFiles:", serverKey)
+}
+
+// Issue a client certificate.
+func CertIssueClient(cmd *cobra.Command, args []string) {
+	caCert, caKey, err := certPaths("ca")
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	clientCert, clientKey, err := certPaths(args[0])
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if _, err := os.Stat(clientCert); err == nil {
+		fmt.Println("cert: client certificate already exists")
+		return
+	}
+	ca, err := certs.LoadCA(caCert, caKey)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+
+	client, err := ca.IssueClient(args[0], certValidity)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if err := client.Save(clientCert, clientKey); err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	fmt.Println("cert:", clientCert)
+	fmt.Println("key:", clientKey)
+}
+
+// Replace the server certificate, and set it as the server's certificate.
+func CertRotate(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+
+	caCert, caKey, err := certPaths("ca")
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	serverCert, serverKey, err := certPaths("server")
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	ca, err := certs.LoadCA(caCert, caKey)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+
+	// Keep the name and SANs of the current certificate, unless they are
+	// given.
+	name, sans := certName, certSANs
+	if current, err := certs.Load(serverCert, serverKey); err == nil {
+		if !cmd.Flags().Changed("name") {
+			name = current.Cert.Subject.CommonName
+		}
+		if !cmd.Flags().Changed("san") {
+			sans = current.SANs()
+		}
+	}
+	serverPair, err := ca.IssueServer(name, sans, certValidity)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	if err := serverPair.Save(serverCert, serverKey); err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	s.Config().SetCertFilePairs([]config.CertFilePair{{Cert: serverCert, Key: serverKey}})
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("cert:", err.Error())
+		return
+	}
+	file.Close()
+}
@@ -0,0 +1,543 @@
+// cmd/client.go
+// Client commands.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	pathlib "path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cubeflix/lily/client"
+	"github.com/cubeflix/lily/network"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var ErrNotLoggedIn = errors.New("not logged in, use \"lily client login\" first")
+var ErrNoDrive = errors.New("no drive given, use drive:path or --drive")
+
+var clientHost string
+var clientPort int
+var clientCAFile string
+var clientCertFile string
+var clientKeyFile string
+var clientInsecure bool
+var clientSessionFile string
+var clientTimeout time.Duration
+var clientDrive string
+var clientJSON bool
+var clientCompress string
+var clientChecksum bool
+
+// The compression negotiated with each server, so it is only negotiated once
+// by the shell.
+var negotiatedCompression = map[string]string{}
+
+var loginCertAuth bool
+var loginLabel string
+var loginExpire time.Duration
+var loginTOTP string
+var loginRecoveryCode string
+var lsLong bool
+var putDelta bool
+var putStreams int
+var getStreams int
+var rmRecursive bool
+var mkdirParents bool
+var chmodAccess int
+var chmodModify int
+var callDurations []string
+var revokeSessions bool
+var shutdownDeadline time.Duration
+var syncDownload bool
+var syncDelete bool
+var syncInclude []string
+var syncExclude []string
+var syncChecksum bool
+var syncDryRun bool
+var syncParallel int
+var syncDelta bool
+
+// If the last client command failed. Commands exit with status 1 when they
+// fail, unless they are run by the shell.
+var clientFailed bool
+
+// The current drive and directory, which relative remote paths are resolved
+// against. The shell changes them with cd.
+var clientCwdDrive string
+var clientCwd = "/"
+
+// A cached login session, along with the connection settings it was created
+// with, so later commands do not need to repeat them.
+type clientSession struct {
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	CAFile    string `json:"caFile,omitempty"`
+	CertFile  string `json:"certFile,omitempty"`
+	KeyFile   string `json:"keyFile,omitempty"`
+	Insecure  bool   `json:"insecure,omitempty"`
+	Username  string `json:"username,omitempty"`
+	SessionID []byte `json:"sessionID,omitempty"`
+}
+
+// Get the session file path.
+func sessionFilePath() string {
+	if clientSessionFile != "" {
+		return clientSessionFile
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".lily-session"
+	}
+	return filepath.Join(home, ".lily", "session")
+}
+
+// Load the cached session, with the connection flags applied over it.
+func loadClientSession() clientSession {
+	s := clientSession{Host: "127.0.0.1", Port: 42069}
+	if data, err := ioutil.ReadFile(sessionFilePath()); err == nil {
+		json.Unmarshal(data, &s)
+	}
+	flags := ClientCmd.PersistentFlags()
+	if flags.Changed("host") {
+		s.Host = clientHost
+	}
+	if flags.Changed("port") {
+		s.Port = clientPort
+	}
+	if flags.Changed("ca") {
+		s.CAFile = clientCAFile
+	}
+	if flags.Changed("cert") {
+		s.CertFile = clientCertFile
+	}
+	if flags.Changed("key") {
+		s.KeyFile = clientKeyFile
+	}
+	if flags.Changed("insecure") {
+		s.Insecure = clientInsecure
+	}
+	return s
+}
+
+// Save the session. The file is only readable by its owner, since the session
+// ID is a credential.
+func saveClientSession(s clientSession) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	path := sessionFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Create a client from the session.
+func newClient(s clientSession) (*client.Client, error) {
+	useCerts := s.CertFile != "" && s.KeyFile != ""
+	c := client.NewClient(s.Host, s.Port, s.CertFile, s.KeyFile, s.Insecure, useCerts)
+	if s.CAFile != "" {
+		if err := c.SetCAFile(s.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	c.SetChecksums(clientChecksum)
+
+	// Negotiate the compression.
+	if clientCompress == "" {
+		return c, nil
+	}
+	preferred := []string{clientCompress}
+	if clientCompress == "auto" {
+		preferred = network.Compressions
+	} else if !network.ValidCompression(clientCompress) {
+		return nil, network.ErrUnsupportedCompression
+	}
+	key := net.JoinHostPort(s.Host, strconv.Itoa(s.Port)) + " " + clientCompress
+	compression, ok := negotiatedCompression[key]
+	if !ok {
+		var err error
+		compression, err = c.NegotiateCompression(preferred, clientTimeout)
+		if err != nil {
+			return nil, err
+		}
+		negotiatedCompression[key] = compression
+	}
+	if err := c.SetCompression(compression); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get a client and the session authentication.
+func sessionClient() (*client.Client, client.Auth, error) {
+	s := loadClientSession()
+	if s.Username == "" || len(s.SessionID) == 0 {
+		return nil, nil, ErrNotLoggedIn
+	}
+	c, err := newClient(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, client.NewSessionAuth(s.Username, s.SessionID), nil
+}
+
+// Check the response code of a response.
+func checkResponse(resp client.Response, err error) (client.Response, error) {
+	if err != nil {
+		return resp, err
+	}
+	return resp, resp.Err()
+}
+
+// Create a request without authentication.
+func nullRequest(command string) *client.Request {
+	return client.NewRequest(client.NewNullAuth(), command, map[string]interface{}{}, clientTimeout)
+}
+
+// Make a request with the cached session.
+func clientRequest(command string, params map[string]interface{}) (client.Response, error) {
+	c, auth, err := sessionClient()
+	if err != nil {
+		return client.Response{}, err
+	}
+	return checkResponse(c.MakeNonChunkRequest(*client.NewRequest(auth, command, params, clientTimeout)))
+}
+
+// Print a client error, as JSON if JSON output is enabled.
+func clientFail(err error) {
+	clientFailed = true
+	if clientJSON {
+		out := map[string]interface{}{"error": err.Error()}
+		var respErr *client.ResponseError
+		if errors.As(err, &respErr) {
+			out = map[string]interface{}{"error": respErr.String, "code": respErr.Code}
+		}
+		printJSON(out)
+		return
+	}
+	fmt.Println("client:", err.Error())
+}
+
+// Print a value as JSON.
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Println("client:", err.Error())
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// Print a result, as JSON if JSON output is enabled, or in a readable form.
+func clientOutput(v interface{}, human func()) {
+	if clientJSON {
+		printJSON(v)
+		return
+	}
+	human()
+}
+
+// Read a password, without echoing it if stdin is a terminal.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Resolve a remote path, given as drive:path or as a path relative to the
+// current directory. Returns the drive and the path from the drive root.
+func remotePath(arg string) (string, string, error) {
+	drive, path := clientCwdDrive, arg
+	if i := strings.Index(arg, ":"); i >= 0 {
+		drive, path = arg[:i], arg[i+1:]
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if drive == "" {
+		drive = clientDrive
+	}
+	if drive == "" {
+		return "", "", ErrNoDrive
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = pathlib.Join(clientCwd, path)
+	}
+	return drive, strings.TrimPrefix(pathlib.Clean(path), "/"), nil
+}
+
+// Format a Unix timestamp.
+func formatTime(v interface{}) string {
+	t, ok := v.(int64)
+	if !ok {
+		if i, ok := v.(int); ok {
+			t = int64(i)
+		}
+	}
+	if t <= 0 {
+		return "-"
+	}
+	return time.Unix(t, 0).Format("2006-01-02 15:04:05")
+}
+
+// Get a list of strings from a response value.
+func responseStrings(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for i := range list {
+		if s, ok := list[i].(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Get a list of session IDs from a response value, as UUID strings.
+func responseUUIDs(v interface{}) []string {
+	list, _ := v.([]interface{})
+	out := make([]string, 0, len(list))
+	for i := range list {
+		data, _ := list[i].([]byte)
+		if id, err := uuid.FromBytes(data); err == nil {
+			out = append(out, id.String())
+		}
+	}
+	return out
+}
+
+// Convert response data for JSON output. Byte slices, such as session IDs,
+// are converted to UUID strings where possible, and hex otherwise.
+func jsonData(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		for k := range v {
+			out[k] = jsonData(v[k])
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = jsonData(v[i])
+		}
+		return out
+	case []byte:
+		if id, err := uuid.FromBytes(v); err == nil {
+			return id.String()
+		}
+		return fmt.Sprintf("%x", v)
+	default:
+		return v
+	}
+}
+
+// Print response data as sorted key-value pairs.
+func printData(data map[string]interface{}) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %v\n", k, jsonData(data[k]))
+	}
+}
+
+// Exit with status 1 if the command failed, unless the shell is running.
+func clientExit(cmd *cobra.Command, args []string) {
+	if clientFailed && !shellRunning {
+		os.Exit(1)
+	}
+}
+
+// Log in and cache the session.
+func ClientLogin(cmd *cobra.Command, args []string) {
+	s := loadClientSession()
+	c, err := newClient(s)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+
+	// Get the authentication.
+	username := s.Username
+	if len(args) == 1 {
+		username = args[0]
+	}
+	if username == "" {
+		clientFail(errors.New("no username given"))
+		return
+	}
+	var auth client.Auth
+	if loginCertAuth {
+		auth = client.NewCertAuth(username)
+	} else {
+		password, err := readPassword("Password: ")
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		auth = client.NewUserAuth(username, password)
+	}
+
+	// Log in.
+	params := map[string]interface{}{"label": loginLabel}
+	if cmd.Flags().Changed("expire") {
+		params["expireAfter"] = int64(loginExpire)
+	}
+	if loginTOTP != "" {
+		params["totp"] = loginTOTP
+	}
+	if loginRecoveryCode != "" {
+		params["recoveryCode"] = loginRecoveryCode
+	}
+	resp, err := checkResponse(c.MakeNonChunkRequest(*client.NewRequest(auth, "login", params, clientTimeout)))
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	id, ok := resp.Data["id"].([]byte)
+	if !ok {
+		clientFail(client.ErrInvalidSessionID)
+		return
+	}
+	s.Username, s.SessionID = username, id
+	if err := saveClientSession(s); err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(map[string]interface{}{"username": username}, func() {
+		fmt.Println("Logged in as " + username + ".")
+	})
+}
+
+// Log out and remove the cached session.
+func ClientLogout(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("logout", map[string]interface{}{}); err != nil && err != ErrNotLoggedIn {
+		clientFail(err)
+	}
+
+	// Forget the session, but keep the connection settings.
+	s := loadClientSession()
+	s.Username, s.SessionID = "", nil
+	if err := saveClientSession(s); err != nil {
+		clientFail(err)
+	}
+}
+
+// Ping the server.
+func ClientPing(cmd *cobra.Command, args []string) {
+	c, err := newClient(loadClientSession())
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	start := time.Now()
+	if _, err := checkResponse(c.MakeNonChunkRequest(*nullRequest("ping"))); err != nil {
+		clientFail(err)
+		return
+	}
+	elapsed := time.Since(start)
+	clientOutput(map[string]interface{}{"time": elapsed.Seconds()}, func() {
+		fmt.Println("pong", elapsed.Round(time.Microsecond))
+	})
+}
+
+// Get the server information.
+func ClientInfo(cmd *cobra.Command, args []string) {
+	c, err := newClient(loadClientSession())
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	resp, err := checkResponse(c.MakeNonChunkRequest(*nullRequest("info")))
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(jsonData(resp.Data), func() {
+		printData(resp.Data)
+	})
+}
+
+// Call any command, with JSON parameters.
+func ClientCall(cmd *cobra.Command, args []string) {
+	params := map[string]interface{}{}
+	if len(args) == 2 {
+		if err := json.Unmarshal([]byte(args[1]), &params); err != nil {
+			clientFail(err)
+			return
+		}
+	}
+	params = callParams(params).(map[string]interface{})
+
+	// Convert the duration parameters.
+	for _, key := range callDurations {
+		str, ok := params[key].(string)
+		if !ok {
+			clientFail(fmt.Errorf("parameter %s must be a duration string", key))
+			return
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		params[key] = int64(d)
+	}
+	resp, err := clientRequest(args[0], params)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(jsonData(resp.Data), func() {
+		printData(resp.Data)
+	})
+}
+
+// Convert JSON numbers to ints, as the server expects.
+func callParams(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = callParams(v[k])
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = callParams(v[i])
+		}
+		return v
+	case float64:
+		if v == float64(int(v)) {
+			return int(v)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,132 @@
+// cmd/client_test.go
+// Testing for cmd/client.go.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Test resolving remote paths.
+func TestRemotePath(t *testing.T) {
+	defer func(drive, cwdDrive, cwd string) {
+		clientDrive, clientCwdDrive, clientCwd = drive, cwdDrive, cwd
+	}(clientDrive, clientCwdDrive, clientCwd)
+
+	tests := []struct {
+		drive, cwdDrive, cwd string
+		arg                  string
+		expectedDrive        string
+		expectedPath         string
+		err                  error
+	}{
+		{"", "", "/", "main:/foo/bar", "main", "foo/bar", nil},
+		{"", "", "/", "main:foo", "main", "foo", nil},
+		{"", "", "/", "main:", "main", "", nil},
+		{"", "", "/", "main:/foo/../bar/", "main", "bar", nil},
+		{"main", "", "/", "foo", "main", "foo", nil},
+		{"main", "", "/", "/foo", "main", "foo", nil},
+		{"main", "other", "/dir", "foo", "other", "dir/foo", nil},
+		{"main", "other", "/dir", "../foo", "other", "foo", nil},
+		{"main", "other", "/dir", ".", "other", "dir", nil},
+		{"main", "other", "/dir", "/foo", "other", "foo", nil},
+		{"main", "other", "/dir", "main:foo", "main", "foo", nil},
+		{"", "", "/", "foo", "", "", ErrNoDrive},
+	}
+	for _, test := range tests {
+		clientDrive, clientCwdDrive, clientCwd = test.drive, test.cwdDrive, test.cwd
+		drive, path, err := remotePath(test.arg)
+		if err != test.err || drive != test.expectedDrive || path != test.expectedPath {
+			t.Error(test.arg, drive, path, err)
+		}
+	}
+}
+
+// Test converting JSON parameters for the server.
+func TestCallParams(t *testing.T) {
+	params := callParams(map[string]interface{}{
+		"int":    float64(3),
+		"float":  1.5,
+		"string": "foo",
+		"list":   []interface{}{float64(1), 2.5, "bar"},
+		"map":    map[string]interface{}{"int": float64(-2)},
+	})
+	expected := map[string]interface{}{
+		"int":    3,
+		"float":  1.5,
+		"string": "foo",
+		"list":   []interface{}{1, 2.5, "bar"},
+		"map":    map[string]interface{}{"int": -2},
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Error(params)
+	}
+}
+
+// Test converting response data for JSON output.
+func TestJSONData(t *testing.T) {
+	id := uuid.New()
+	idBytes, _ := id.MarshalBinary()
+	data := jsonData(map[string]interface{}{
+		"id":    idBytes,
+		"hash":  []byte{1, 2, 255},
+		"list":  []interface{}{idBytes, 1},
+		"other": "foo",
+	})
+	expected := map[string]interface{}{
+		"id":    id.String(),
+		"hash":  "0102ff",
+		"list":  []interface{}{id.String(), 1},
+		"other": "foo",
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Error(data)
+	}
+}
+
+// Test getting lists from response values.
+func TestResponseLists(t *testing.T) {
+	id := uuid.New()
+	idBytes, _ := id.MarshalBinary()
+	tests := []struct {
+		value   interface{}
+		strings []string
+		uuids   []string
+	}{
+		{nil, []string{}, []string{}},
+		{"foo", []string{}, []string{}},
+		{[]interface{}{"foo", 1, "bar"}, []string{"foo", "bar"}, []string{}},
+		{[]interface{}{idBytes, []byte{1}, "foo"}, []string{"foo"}, []string{id.String()}},
+	}
+	for _, test := range tests {
+		if s := responseStrings(test.value); !reflect.DeepEqual(s, test.strings) {
+			t.Error(test.value, s)
+		}
+		if u := responseUUIDs(test.value); !reflect.DeepEqual(u, test.uuids) {
+			t.Error(test.value, u)
+		}
+	}
+}
+
+// Test formatting timestamps.
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected bool
+	}{
+		{int64(1700000000), true},
+		{1700000000, true},
+		{int64(0), false},
+		{-1, false},
+		{"foo", false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if s := formatTime(test.value); (s != "-") != test.expected {
+			t.Error(test.value, s)
+		}
+	}
+}
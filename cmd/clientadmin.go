@@ -0,0 +1,311 @@
+// cmd/clientadmin.go
+// Client user, session, drive and server commands.
+
+package cmd
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// Parse session IDs.
+func parseSessionIDs(args []string) ([][]byte, error) {
+	ids := make([][]byte, len(args))
+	for i := range args {
+		id, err := uuid.Parse(args[i])
+		if err != nil {
+			return nil, err
+		}
+		ids[i], _ = id.MarshalBinary()
+	}
+	return ids, nil
+}
+
+// Convert session information for output.
+func sessionOutput(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	out := make([]map[string]interface{}, 0, len(list))
+	for i := range list {
+		m, _ := list[i].(map[string]interface{})
+		out = append(out, map[string]interface{}{
+			"id":          jsonData(m["id"]),
+			"username":    m["username"],
+			"expireAt":    m["expireat"],
+			"idleTimeout": m["idletimeout"],
+			"maxLifetime": m["maxlifetime"],
+			"createdAt":   m["createdat"],
+			"lastUsed":    m["lastused"],
+			"ip":          m["ip"],
+			"label":       m["label"],
+		})
+	}
+	return out
+}
+
+// Print session information.
+func printSessions(sessions []map[string]interface{}) {
+	for _, s := range sessions {
+		fmt.Println(s["id"])
+		fmt.Println("	user:", s["username"])
+		fmt.Println("	created:", formatTime(s["createdAt"]))
+		fmt.Println("	last used:", formatTime(s["lastUsed"]))
+		fmt.Println("	expires:", formatTime(s["expireAt"]))
+		fmt.Println("	ip:", s["ip"])
+		fmt.Println("	label:", s["label"])
+	}
+}
+
+// List the users.
+func ClientUserList(cmd *cobra.Command, args []string) {
+	resp, err := clientRequest("getallusers", map[string]interface{}{})
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	users := responseStrings(resp.Data["users"])
+	clientOutput(users, func() {
+		for i := range users {
+			fmt.Println(users[i])
+		}
+	})
+}
+
+// Get information about users.
+func ClientUserInfo(cmd *cobra.Command, args []string) {
+	resp, err := clientRequest("getuserinformation", map[string]interface{}{"users": args})
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	list, _ := resp.Data["info"].([]interface{})
+	info := make([]map[string]interface{}, 0, len(list))
+	for i := range list {
+		m, _ := list[i].(map[string]interface{})
+		info = append(info, map[string]interface{}{
+			"username":    m["username"],
+			"clearance":   m["clearance"],
+			"totpEnabled": m["totpenabled"],
+		})
+	}
+	clientOutput(info, func() {
+		for _, u := range info {
+			fmt.Println(u["username"])
+			fmt.Println("	clearance:", u["clearance"])
+			fmt.Println("	totp enabled:", u["totpEnabled"])
+		}
+	})
+}
+
+// Create a user.
+func ClientUserCreate(cmd *cobra.Command, args []string) {
+	password, err := readPassword("New password: ")
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if _, err := clientRequest("createusers", map[string]interface{}{"users": []string{args[0]}, "passwords": []string{password}, "clearances": []int{clearance}}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Delete users.
+func ClientUserDelete(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("deleteusers", map[string]interface{}{"users": args}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Set a user's password, or the current user's password if no user is given.
+func ClientUserSetPassword(cmd *cobra.Command, args []string) {
+	password, err := readPassword("New password: ")
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if len(args) == 0 {
+		_, err = clientRequest("setpassword", map[string]interface{}{"password": password, "revokeSessions": revokeSessions})
+	} else {
+		_, err = clientRequest("setuserpassword", map[string]interface{}{"users": []string{args[0]}, "passwords": []string{password}, "revokeSessions": revokeSessions})
+	}
+	if err != nil {
+		clientFail(err)
+	}
+}
+
+// Set a user's clearance.
+func ClientUserSetClearance(cmd *cobra.Command, args []string) {
+	level, err := strconv.Atoi(args[1])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if _, err := clientRequest("setuserclearance", map[string]interface{}{"users": []string{args[0]}, "clearances": []int{level}}); err != nil {
+		clientFail(err)
+	}
+}
+
+// List all sessions, or a user's sessions.
+func ClientSessionList(cmd *cobra.Command, args []string) {
+	var ids []interface{}
+	if len(args) == 1 {
+		resp, err := clientRequest("getallusersessions", map[string]interface{}{"user": args[0]})
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		ids, _ = resp.Data["ids"].([]interface{})
+	} else {
+		resp, err := clientRequest("getallsessions", map[string]interface{}{})
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		ids, _ = resp.Data["ids"].([]interface{})
+	}
+	sessions := []map[string]interface{}{}
+	if len(ids) != 0 {
+		resp, err := clientRequest("getsessioninfo", map[string]interface{}{"ids": ids})
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		sessions = sessionOutput(resp.Data["sessions"])
+	}
+	clientOutput(sessions, func() {
+		printSessions(sessions)
+	})
+}
+
+// Expire sessions by ID.
+func ClientSessionExpire(cmd *cobra.Command, args []string) {
+	ids, err := parseSessionIDs(args)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if _, err := clientRequest("expiresessions", map[string]interface{}{"ids": ids}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Remove all expired sessions.
+func ClientSessionExpireAll(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("expireallsessions", map[string]interface{}{}); err != nil {
+		clientFail(err)
+	}
+}
+
+// List the current user's sessions.
+func ClientSessionMine(cmd *cobra.Command, args []string) {
+	resp, err := clientRequest("listmysessions", map[string]interface{}{})
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	sessions := sessionOutput(resp.Data["sessions"])
+	current, _ := resp.Data["current"].([]byte)
+	for _, s := range sessions {
+		// Session ID hashes are not UUIDs, so show them as hex.
+		id, _ := s["id"].(string)
+		s["current"] = id == hex.EncodeToString(current)
+	}
+	clientOutput(sessions, func() {
+		printSessions(sessions)
+	})
+}
+
+// Revoke the current user's sessions, by their ID hashes.
+func ClientSessionRevoke(cmd *cobra.Command, args []string) {
+	hashes := make([][]byte, len(args))
+	for i := range args {
+		hash, err := hex.DecodeString(args[i])
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		hashes[i] = hash
+	}
+	if _, err := clientRequest("revokemysessions", map[string]interface{}{"ids": hashes}); err != nil {
+		clientFail(err)
+	}
+}
+
+// List the drives.
+func ClientDriveList(cmd *cobra.Command, args []string) {
+	c, err := newClient(loadClientSession())
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	resp, err := checkResponse(c.MakeNonChunkRequest(*nullRequest("info")))
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	drives := responseStrings(resp.Data["drives"])
+	clientOutput(drives, func() {
+		for i := range drives {
+			fmt.Println(drives[i])
+		}
+	})
+}
+
+// Add a drive.
+func ClientDriveAdd(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("adddrive", map[string]interface{}{"name": args[0], "path": args[1]}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Rename a drive.
+func ClientDriveRename(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("renamedrive", map[string]interface{}{"drive": args[0], "newName": args[1]}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Remove a drive.
+func ClientDriveRemove(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("removedrive", map[string]interface{}{"drive": args[0]}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Get all the server settings.
+func ClientSettings(cmd *cobra.Command, args []string) {
+	resp, err := clientRequest("getallsettings", map[string]interface{}{})
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(jsonData(resp.Data), func() {
+		printData(resp.Data)
+	})
+}
+
+// Reload the server.
+func ClientReload(cmd *cobra.Command, args []string) {
+	if _, err := clientRequest("reload", map[string]interface{}{}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Shut down the server.
+func ClientShutdown(cmd *cobra.Command, args []string) {
+	params := map[string]interface{}{}
+	if cmd.Flags().Changed("deadline") {
+		if shutdownDeadline < 0 {
+			clientFail(errors.New("invalid deadline"))
+			return
+		}
+		params["deadline"] = int64(shutdownDeadline)
+	}
+	if _, err := clientRequest("shutdown", params); err != nil {
+		clientFail(err)
+	}
+}
@@ -0,0 +1,42 @@
+// cmd/clientadmin_test.go
+// Testing for cmd/clientadmin.go.
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Test parsing session IDs.
+func TestParseSessionIDs(t *testing.T) {
+	id := uuid.New()
+	ids, err := parseSessionIDs([]string{id.String(), id.String()})
+	if err != nil || len(ids) != 2 || !bytes.Equal(ids[0], id[:]) || !bytes.Equal(ids[1], id[:]) {
+		t.Fatal(ids, err)
+	}
+	if ids, err := parseSessionIDs([]string{}); err != nil || len(ids) != 0 {
+		t.Error(ids, err)
+	}
+	if _, err := parseSessionIDs([]string{id.String(), "foo"}); err == nil {
+		t.Error("invalid session ID parsed")
+	}
+}
+
+// Test converting session information for output.
+func TestSessionOutput(t *testing.T) {
+	id := uuid.New()
+	out := sessionOutput([]interface{}{
+		map[string]interface{}{"id": id[:], "username": "foo", "expireat": int64(10), "label": "cli"},
+		"invalid",
+	})
+	if len(out) != 2 || out[0]["id"] != id.String() || out[0]["username"] != "foo" ||
+		out[0]["expireAt"] != int64(10) || out[0]["label"] != "cli" || out[1]["username"] != nil {
+		t.Error(out)
+	}
+	if out := sessionOutput(nil); len(out) != 0 {
+		t.Error(out)
+	}
+}
@@ -0,0 +1,498 @@
+// cmd/clientfs.go
+// Client filesystem commands.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	pathlib "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cubeflix/lily/client"
+	"github.com/spf13/cobra"
+)
+
+// The status of a remote path.
+type remoteStatus struct {
+	Path         string `json:"path"`
+	Exists       bool   `json:"exists"`
+	IsFile       bool   `json:"isFile"`
+	LastEditTime int64  `json:"lastEditTime"`
+	LastEditor   string `json:"lastEditor"`
+}
+
+// A directory entry.
+type remoteEntry struct {
+	Name         string `json:"name"`
+	IsFile       bool   `json:"isFile"`
+	LastEditTime int64  `json:"lastEditTime"`
+	LastEditor   string `json:"lastEditor"`
+}
+
+// Get the status of a remote path.
+func statRemote(drive, path string) (remoteStatus, error) {
+	resp, err := clientRequest("stat", map[string]interface{}{"drive": drive, "paths": []string{path}})
+	if err != nil {
+		return remoteStatus{}, err
+	}
+	stats, _ := resp.Data["stat"].(map[string]interface{})
+	stat, _ := stats[path].(map[string]interface{})
+	s := remoteStatus{Path: "/" + path}
+	s.Exists, _ = stat["exists"].(bool)
+	s.IsFile, _ = stat["isfile"].(bool)
+	s.LastEditTime, _ = stat["lastedittime"].(int64)
+	s.LastEditor, _ = stat["lasteditor"].(string)
+	return s, nil
+}
+
+// List a remote directory, sorted by name.
+func listRemote(drive, path string) ([]remoteEntry, error) {
+	resp, err := clientRequest("listdir", map[string]interface{}{"drive": drive, "path": path})
+	if err != nil {
+		return nil, err
+	}
+	list, _ := resp.Data["list"].([]interface{})
+	entries := make([]remoteEntry, 0, len(list))
+	for i := range list {
+		m, _ := list[i].(map[string]interface{})
+		e := remoteEntry{}
+		e.Name, _ = m["name"].(string)
+		e.IsFile, _ = m["isfile"].(bool)
+		e.LastEditTime, _ = m["lastedittime"].(int64)
+		e.LastEditor, _ = m["lasteditor"].(string)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// List a directory.
+func ClientLs(cmd *cobra.Command, args []string) {
+	arg := ""
+	if len(args) == 1 {
+		arg = args[0]
+	}
+	drive, path, err := remotePath(arg)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	entries, err := listRemote(drive, path)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(entries, func() {
+		for _, e := range entries {
+			name := e.Name
+			if !e.IsFile {
+				name += "/"
+			}
+			if lsLong {
+				fmt.Printf("%s  %-16s  %s\n", formatTime(e.LastEditTime), e.LastEditor, name)
+			} else {
+				fmt.Println(name)
+			}
+		}
+	})
+}
+
+// Get the status of paths.
+func ClientStat(cmd *cobra.Command, args []string) {
+	stats := []remoteStatus{}
+	for i := range args {
+		drive, path, err := remotePath(args[i])
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		stat, err := statRemote(drive, path)
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		stats = append(stats, stat)
+	}
+	clientOutput(stats, func() {
+		for _, s := range stats {
+			kind := "directory"
+			if !s.Exists {
+				kind = "does not exist"
+			} else if s.IsFile {
+				kind = "file"
+			}
+			fmt.Println(s.Path+":", kind)
+			if s.Exists {
+				fmt.Println("	last edited:", formatTime(s.LastEditTime), "by", s.LastEditor)
+			}
+		}
+	})
+}
+
+// Download a file.
+func ClientGet(cmd *cobra.Command, args []string) {
+	drive, path, err := remotePath(args[0])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	local := pathlib.Base("/" + path)
+	if len(args) == 2 {
+		local = args[1]
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, pathlib.Base("/"+path))
+		}
+	}
+
+	c, auth, err := sessionClient()
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	var resp client.Response
+	if getStreams > 1 {
+		resp, err = c.ParallelDownload(auth, path, local, drive, client.DefaultChunkSize, getStreams, clientTimeout)
+	} else {
+		resp, err = c.DownloadFiles(auth, []string{path}, []string{local}, drive, clientTimeout)
+	}
+	if _, err := checkResponse(resp, err); err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(map[string]interface{}{"path": "/" + path, "local": local}, func() {
+		fmt.Println(drive+":/"+path, "->", local)
+	})
+}
+
+// Upload a file. Existing files are replaced.
+func ClientPut(cmd *cobra.Command, args []string) {
+	arg := pathlib.Base(filepath.ToSlash(args[0]))
+	if len(args) == 2 {
+		arg = args[1]
+	}
+	drive, path, err := remotePath(arg)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	stat, err := statRemote(drive, path)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if stat.Exists && !stat.IsFile {
+		path = strings.TrimPrefix(pathlib.Join(path, filepath.Base(args[0])), "/")
+		if stat, err = statRemote(drive, path); err != nil {
+			clientFail(err)
+			return
+		}
+	}
+
+	c, auth, err := sessionClient()
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	var resp client.Response
+	if stat.Exists && putDelta {
+		resp, err = c.PatchFile(auth, args[0], path, drive, client.DefaultChunkSize, clientTimeout)
+	} else if putStreams > 1 {
+		// Create the file, and then upload its ranges.
+		if !stat.Exists {
+			if _, err := clientRequest("createfiles", map[string]interface{}{"drive": drive, "paths": []string{path}}); err != nil {
+				clientFail(err)
+				return
+			}
+		}
+		resp, err = c.ParallelUpload(auth, args[0], path, drive, client.DefaultChunkSize, putStreams, clientTimeout)
+	} else if stat.Exists {
+		resp, err = c.WriteFiles(auth, []string{args[0]}, []string{path}, drive, client.DefaultChunkSize, clientTimeout)
+	} else {
+		resp, err = c.UploadFiles(auth, []string{args[0]}, []string{path}, nil, drive, client.DefaultChunkSize, clientTimeout)
+	}
+	if _, err := checkResponse(resp, err); err != nil {
+		clientFail(err)
+		return
+	}
+	clientOutput(map[string]interface{}{"path": "/" + path, "local": args[0]}, func() {
+		fmt.Println(args[0], "->", drive+":/"+path)
+	})
+}
+
+// Move or rename a file or directory. If the destination is a directory, the
+// source is moved into it.
+func ClientMv(cmd *cobra.Command, args []string) {
+	drive, src, err := remotePath(args[0])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	destDrive, dest, err := remotePath(args[1])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if destDrive != drive {
+		clientFail(errors.New("cannot move between drives"))
+		return
+	}
+	srcStat, err := statRemote(drive, src)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if !srcStat.Exists {
+		clientFail(errors.New("/" + src + " does not exist"))
+		return
+	}
+	destStat, err := statRemote(drive, dest)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if destStat.Exists && !destStat.IsFile {
+		dest = strings.TrimPrefix(pathlib.Join(dest, pathlib.Base("/"+src)), "/")
+	}
+
+	command := "movedirs"
+	if srcStat.IsFile {
+		command = "movefiles"
+	}
+	if _, err := clientRequest(command, map[string]interface{}{"drive": drive, "paths": []string{src}, "dests": []string{dest}}); err != nil {
+		clientFail(err)
+	}
+}
+
+// Remove files, or directories if recursive.
+func ClientRm(cmd *cobra.Command, args []string) {
+	for i := range args {
+		drive, path, err := remotePath(args[i])
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		stat, err := statRemote(drive, path)
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		if !stat.Exists {
+			clientFail(errors.New(stat.Path + " does not exist"))
+			return
+		}
+		command := "deletefiles"
+		if !stat.IsFile {
+			if !rmRecursive {
+				clientFail(errors.New(stat.Path + " is a directory, use -r to remove it"))
+				return
+			}
+			command = "deletedirs"
+		}
+		if _, err := clientRequest(command, map[string]interface{}{"drive": drive, "paths": []string{path}}); err != nil {
+			clientFail(err)
+			return
+		}
+	}
+}
+
+// Create directories, and their parents if needed.
+func ClientMkdir(cmd *cobra.Command, args []string) {
+	for i := range args {
+		drive, path, err := remotePath(args[i])
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		paths := []string{path}
+		if mkdirParents {
+			// Create each missing directory from the root down.
+			paths = []string{}
+			parts := strings.Split(path, "/")
+			for j := range parts {
+				dir := strings.Join(parts[:j+1], "/")
+				if len(paths) != 0 {
+					// The parent is missing, so this directory is too.
+					paths = append(paths, dir)
+					continue
+				}
+				stat, err := statRemote(drive, dir)
+				if err != nil {
+					clientFail(err)
+					return
+				}
+				if stat.Exists && stat.IsFile {
+					clientFail(errors.New(stat.Path + " is a file"))
+					return
+				}
+				if !stat.Exists {
+					paths = append(paths, dir)
+				}
+			}
+		}
+
+		// Create the directories one at a time, since each must exist before
+		// its children are created.
+		for j := range paths {
+			if _, err := clientRequest("createdirs", map[string]interface{}{"drive": drive, "paths": []string{paths[j]}}); err != nil {
+				clientFail(err)
+				return
+			}
+		}
+	}
+}
+
+// Get the access settings of a path.
+func ClientAccess(cmd *cobra.Command, args []string) {
+	drive, path, err := remotePath(args[0])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	resp, err := clientRequest("getpathsettings", map[string]interface{}{"drive": drive, "path": path})
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	settings, _ := resp.Data["settings"].(map[string]interface{})
+	out := map[string]interface{}{
+		"accessClearance": settings["accessclearance"],
+		"modifyClearance": settings["modifyclearance"],
+		"accessWhitelist": responseStrings(settings["accesswhitelist"]),
+		"modifyWhitelist": responseStrings(settings["modifywhitelist"]),
+		"accessBlacklist": responseStrings(settings["accessblacklist"]),
+		"modifyBlacklist": responseStrings(settings["modifyblacklist"]),
+	}
+	clientOutput(out, func() {
+		fmt.Println("access clearance:", out["accessClearance"])
+		fmt.Println("modify clearance:", out["modifyClearance"])
+		fmt.Println("access whitelist:", strings.Join(out["accessWhitelist"].([]string), ","))
+		fmt.Println("modify whitelist:", strings.Join(out["modifyWhitelist"].([]string), ","))
+		fmt.Println("access blacklist:", strings.Join(out["accessBlacklist"].([]string), ","))
+		fmt.Println("modify blacklist:", strings.Join(out["modifyBlacklist"].([]string), ","))
+	})
+}
+
+// Change the access settings of a path.
+func ClientChmod(cmd *cobra.Command, args []string) {
+	drive, path, err := remotePath(args[0])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+
+	// Set the clearances, keeping the current clearance if only one is given.
+	if cmd.Flags().Changed("access") || cmd.Flags().Changed("modify") {
+		accessClearance, modifyClearance := chmodAccess, chmodModify
+		if !cmd.Flags().Changed("access") || !cmd.Flags().Changed("modify") {
+			resp, err := clientRequest("getpathsettings", map[string]interface{}{"drive": drive, "path": path})
+			if err != nil {
+				clientFail(err)
+				return
+			}
+			settings, _ := resp.Data["settings"].(map[string]interface{})
+			if !cmd.Flags().Changed("access") {
+				accessClearance, _ = settings["accessclearance"].(int)
+			}
+			if !cmd.Flags().Changed("modify") {
+				modifyClearance, _ = settings["modifyclearance"].(int)
+			}
+		}
+		if _, err := clientRequest("setpathclearances", map[string]interface{}{"drive": drive, "path": path, "access": accessClearance, "modify": modifyClearance}); err != nil {
+			clientFail(err)
+			return
+		}
+	}
+
+	// Update the lists.
+	for flag, command := range map[string]string{
+		"add-access-whitelist":    "addtopathaccesswhitelist",
+		"remove-access-whitelist": "removefrompathaccesswhitelist",
+		"add-modify-whitelist":    "addtopathmodifywhitelist",
+		"remove-modify-whitelist": "removefrompathmodifywhitelist",
+		"add-access-blacklist":    "addtopathaccessblacklist",
+		"remove-access-blacklist": "removefrompathaccessblacklist",
+		"add-modify-blacklist":    "addtopathmodifyblacklist",
+		"remove-modify-blacklist": "removefrompathmodifyblacklist",
+	} {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		users, _ := cmd.Flags().GetStringSlice(flag)
+		if _, err := clientRequest(command, map[string]interface{}{"drive": drive, "path": path, "users": users}); err != nil {
+			clientFail(err)
+			return
+		}
+	}
+}
+
+// Sync a local directory with a remote directory.
+func ClientSync(cmd *cobra.Command, args []string) {
+	drive, path, err := remotePath(args[1])
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	c, auth, err := sessionClient()
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	opts := client.SyncOptions{
+		Direction: client.SyncUpload,
+		Delete:    syncDelete,
+		Include:   syncInclude,
+		Exclude:   syncExclude,
+		Checksum:  syncChecksum,
+		DryRun:    syncDryRun,
+		Delta:     syncDelta,
+		Parallel:  syncParallel,
+		ChunkSize: client.DefaultChunkSize,
+		Timeout:   clientTimeout,
+	}
+	if syncDownload {
+		opts.Direction = client.SyncDownload
+	}
+	actions, err := c.Sync(auth, args[0], drive, path, opts)
+
+	// Print the actions, even if some of them failed.
+	out := make([]map[string]interface{}, len(actions))
+	for i := range actions {
+		out[i] = map[string]interface{}{"op": actions[i].Op, "path": actions[i].Path, "isDir": actions[i].IsDir, "size": actions[i].Size, "reason": actions[i].Reason}
+		if actions[i].Err != nil {
+			out[i]["error"] = actions[i].Err.Error()
+		}
+	}
+	if clientJSON {
+		result := map[string]interface{}{"actions": out, "dryRun": syncDryRun}
+		if err != nil {
+			clientFailed = true
+			result["error"] = err.Error()
+		}
+		printJSON(result)
+		return
+	}
+	for i := range actions {
+		name := actions[i].Path
+		if actions[i].IsDir {
+			name += "/"
+		}
+		if actions[i].Err != nil {
+			fmt.Printf("%-8s  %s (%s): %s\n", actions[i].Op, name, actions[i].Reason, actions[i].Err.Error())
+		} else {
+			fmt.Printf("%-8s  %s (%s)\n", actions[i].Op, name, actions[i].Reason)
+		}
+	}
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if syncDryRun {
+		fmt.Println(len(actions), "actions planned, nothing changed.")
+	} else {
+		fmt.Println(len(actions), "actions done.")
+	}
+}
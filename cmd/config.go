@@ -15,6 +15,10 @@ import (
 	"github.com/cubeflix/lily/drive"
 	"github.com/cubeflix/lily/marshal"
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/audit"
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/ldapauth"
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server"
 	"github.com/cubeflix/lily/server/config"
 	"github.com/cubeflix/lily/user"
@@ -118,8 +122,174 @@ func ConfigInit(cmd *cobra.Command, args []string) {
 		fmt.Println("config:", err.Error())
 		return
 	}
+	c.SetSessionFile(configSec.Key("sessionFile").String())
+	c.SetAudit(configSec.Key("auditFile").String(), configSec.Key("auditHashChain").MustBool(false))
+	c.SetMetricsAddress(configSec.Key("metricsAddress").String())
+	err = c.SetShutdownDeadline(configSec.Key("shutdownDeadline").MustDuration(config.DefaultShutdownDeadline))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetUserLimits(userlimit.Limits{
+		Requests:     configSec.Key("userRequests").MustInt(0),
+		Interval:     configSec.Key("userRequestInterval").MustDuration(time.Minute),
+		UploadRate:   configSec.Key("userUploadRate").MustInt64(0),
+		DownloadRate: configSec.Key("userDownloadRate").MustInt64(0),
+	})
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetBandwidthLimits(configSec.Key("uploadRate").MustInt64(0), configSec.Key("downloadRate").MustInt64(0))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetIPFilter(configSec.Key("ipAllowList").Strings(","), configSec.Key("ipDenyList").Strings(","))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetTrustedProxies(configSec.Key("trustedProxies").Strings(","))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetConnectionLimits(configSec.Key("maxConnections").MustInt(0), configSec.Key("maxConnectionsPerIP").MustInt(0))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetTransferLimits(configSec.Key("maxChunkSize").MustInt(0), configSec.Key("memoryBudget").MustInt64(0),
+		configSec.Key("maxUploadSize").MustInt64(0))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	for _, listenerSec := range cfg.Section("listener").ChildSections() {
+		peerUsers, err := parsePeerUsers(listenerSec.Key("peerUsers").String())
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		err = c.SetListener(config.Listener{
+			Name:           strings.TrimPrefix(listenerSec.Name(), "listener."),
+			Network:        listenerSec.Key("network").MustString(config.ListenerTCP),
+			Address:        listenerSec.Key("address").String(),
+			Limit:          listenerSec.Key("limit").MustDuration(0),
+			MaxLimitEvents: listenerSec.Key("maxLimitEvents").MustInt(0),
+			IPAllowList:    listenerSec.Key("ipAllowList").Strings(","),
+			IPDenyList:     listenerSec.Key("ipDenyList").Strings(","),
+			PeerUsers:      peerUsers,
+		})
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	}
+	err = c.SetSessionTimeouts(configSec.Key("sessionIdleTimeout").MustDuration(0),
+		configSec.Key("sessionMaxLifetime").MustDuration(0))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	sessionBindingMode, err := config.ParseSessionBindingMode(configSec.Key("sessionBinding").MustString("none"))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetSessionBinding(sessionBindingMode,
+		configSec.Key("sessionBindingIPv4Prefix").MustInt(config.DefaultSessionBindingIPv4Prefix),
+		configSec.Key("sessionBindingIPv6Prefix").MustInt(config.DefaultSessionBindingIPv6Prefix))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetTOTPRequiredClearance(cfg.Section("config").Key("totpRequiredClearance").MustInt(0))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	passwordPolicy := auth.PasswordPolicy{
+		MinLength:     configSec.Key("passwordMinLength").MustInt(8),
+		RequireUpper:  configSec.Key("passwordRequireUpper").MustBool(false),
+		RequireLower:  configSec.Key("passwordRequireLower").MustBool(false),
+		RequireDigit:  configSec.Key("passwordRequireDigit").MustBool(false),
+		RequireSymbol: configSec.Key("passwordRequireSymbol").MustBool(false),
+		DenyListFile:  configSec.Key("passwordDenyListFile").String(),
+	}
+	err = c.SetPasswordPolicy(passwordPolicy)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	if err := setPasswordSetting(c, "passwordHash", configSec.Key("passwordHash").MustString("bcrypt")); err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	for _, name := range []string{"bcryptCost", "argon2Time", "argon2Memory", "argon2Threads"} {
+		if configSec.HasKey(name) {
+			if err := setPasswordSetting(c, name, configSec.Key(name).String()); err != nil {
+				fmt.Println("config:", err.Error())
+				return
+			}
+		}
+	}
+	err = c.SetLockout(configSec.Key("userLockoutThreshold").MustInt(5),
+		configSec.Key("ipLockoutThreshold").MustInt(20),
+		configSec.Key("lockoutDuration").MustDuration(time.Minute),
+		configSec.Key("lockoutMaxDuration").MustDuration(time.Hour))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	clientAuthMode, err := config.ParseClientAuthMode(configSec.Key("clientAuth").MustString("none"))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = c.SetClientAuth(configSec.Key("clientCAFile").String(), clientAuthMode)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	// Get LDAP settings.
+	ldapSec := cfg.Section("ldap")
+	ldapMode, err := config.ParseLDAPMode(ldapSec.Key("mode").MustString("disabled"))
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	ldapSettings := ldapauth.Settings{
+		URL:                ldapSec.Key("url").String(),
+		StartTLS:           ldapSec.Key("startTLS").MustBool(false),
+		InsecureSkipVerify: ldapSec.Key("insecureSkipVerify").MustBool(false),
+		BindDN:             ldapSec.Key("bindDN").String(),
+		GroupBaseDN:        ldapSec.Key("groupBaseDN").String(),
+		GroupFilter:        ldapSec.Key("groupFilter").String(),
+		GroupClearances:    map[string]int{},
+		DefaultClearance:   ldapSec.Key("defaultClearance").MustInt(0),
+	}
+	for _, key := range cfg.Section("ldapGroups").Keys() {
+		ldapSettings.GroupClearances[key.Name()] = key.MustInt(0)
+	}
+	err = c.SetLDAP(ldapMode, ldapSec.Key("autoProvision").MustBool(false), ldapSettings)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
 
-	uobj, err := user.NewUser(username, password, access.ClearanceLevelFive)
+	// Create the admin user with the password settings.
+	if err := c.LoadPasswordDenyList(); err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	if err := c.CheckPassword(password); err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	uobj, err := user.NewUserWithParams(username, password, access.ClearanceLevelFive, c.GetPasswordHashing())
 	if err != nil {
 		fmt.Println("config:", err.Error())
 		return
@@ -213,6 +383,158 @@ func ConfigSet(cmd *cobra.Command, args []string) {
 			return
 		}
 		s.Config().SetTimeout(timeout)
+	} else if name == "shutdownDeadline" {
+		deadline, err := time.ParseDuration(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetShutdownDeadline(deadline); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "userRequests" {
+		limits := s.Config().GetUserLimits()
+		requests, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		limits.Requests = requests
+		if err := s.Config().SetUserLimits(limits); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "userRequestInterval" {
+		limits := s.Config().GetUserLimits()
+		interval, err := time.ParseDuration(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		limits.Interval = interval
+		if err := s.Config().SetUserLimits(limits); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "userUploadRate" {
+		limits := s.Config().GetUserLimits()
+		rate, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		limits.UploadRate = rate
+		if err := s.Config().SetUserLimits(limits); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "userDownloadRate" {
+		limits := s.Config().GetUserLimits()
+		rate, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		limits.DownloadRate = rate
+		if err := s.Config().SetUserLimits(limits); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "uploadRate" {
+		_, downloadRate := s.Config().GetBandwidthLimits()
+		rate, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetBandwidthLimits(rate, downloadRate); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "downloadRate" {
+		uploadRate, _ := s.Config().GetBandwidthLimits()
+		rate, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetBandwidthLimits(uploadRate, rate); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "ipAllowList" {
+		_, ipDenyList := s.Config().GetIPFilter()
+		if err := s.Config().SetIPFilter(splitList(args[1]), ipDenyList); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "ipDenyList" {
+		ipAllowList, _ := s.Config().GetIPFilter()
+		if err := s.Config().SetIPFilter(ipAllowList, splitList(args[1])); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "trustedProxies" {
+		if err := s.Config().SetTrustedProxies(splitList(args[1])); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "maxConnections" {
+		_, maxConnectionsPerIP := s.Config().GetConnectionLimits()
+		maxConnections, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetConnectionLimits(maxConnections, maxConnectionsPerIP); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "maxConnectionsPerIP" {
+		maxConnections, _ := s.Config().GetConnectionLimits()
+		maxConnectionsPerIP, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetConnectionLimits(maxConnections, maxConnectionsPerIP); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "maxChunkSize" {
+		_, memoryBudget, maxUploadSize := s.Config().GetTransferLimits()
+		maxChunkSize, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetTransferLimits(maxChunkSize, memoryBudget, maxUploadSize); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "memoryBudget" {
+		maxChunkSize, _, maxUploadSize := s.Config().GetTransferLimits()
+		memoryBudget, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetTransferLimits(maxChunkSize, memoryBudget, maxUploadSize); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "maxUploadSize" {
+		maxChunkSize, memoryBudget, _ := s.Config().GetTransferLimits()
+		maxUploadSize, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetTransferLimits(maxChunkSize, memoryBudget, maxUploadSize); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
 	} else if name == "verbose" {
 		_, logToFile, logJSON, logLevel, logPath := s.Config().GetLogging()
 		verbose, err := strconv.ParseBool(args[1])
@@ -279,6 +601,64 @@ func ConfigSet(cmd *cobra.Command, args []string) {
 			return
 		}
 		s.Config().SetUserSessionLimit(perUserSessionLimit)
+	} else if name == "sessionFile" {
+		s.Config().SetSessionFile(args[1])
+	} else if name == "sessionIdleTimeout" || name == "sessionMaxLifetime" {
+		idleTimeout, maxLifetime := s.Config().GetSessionTimeouts()
+		value, err := time.ParseDuration(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if name == "sessionIdleTimeout" {
+			idleTimeout = value
+		} else {
+			maxLifetime = value
+		}
+		if err := s.Config().SetSessionTimeouts(idleTimeout, maxLifetime); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "metricsAddress" {
+		s.Config().SetMetricsAddress(args[1])
+	} else if name == "auditFile" {
+		_, auditHashChain := s.Config().GetAudit()
+		s.Config().SetAudit(args[1], auditHashChain)
+	} else if name == "auditHashChain" {
+		auditFile, _ := s.Config().GetAudit()
+		auditHashChain, err := strconv.ParseBool(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		s.Config().SetAudit(auditFile, auditHashChain)
+	} else if name == "sessionBinding" {
+		_, ipv4Prefix, ipv6Prefix := s.Config().GetSessionBinding()
+		mode, err := config.ParseSessionBindingMode(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetSessionBinding(mode, ipv4Prefix, ipv6Prefix); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "sessionBindingIPv4Prefix" || name == "sessionBindingIPv6Prefix" {
+		mode, ipv4Prefix, ipv6Prefix := s.Config().GetSessionBinding()
+		value, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if name == "sessionBindingIPv4Prefix" {
+			ipv4Prefix = value
+		} else {
+			ipv6Prefix = value
+		}
+		if err := s.Config().SetSessionBinding(mode, ipv4Prefix, ipv6Prefix); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
 	} else if name == "rateLimitInterval" {
 		_, maxLimitEvents := s.Config().GetRateLimit()
 		rateLimitInterval, err := time.ParseDuration(args[1])
@@ -295,6 +675,75 @@ func ConfigSet(cmd *cobra.Command, args []string) {
 			return
 		}
 		s.Config().SetRateLimit(rateLimitInterval, maxLimitEvents)
+	} else if name == "totpRequiredClearance" {
+		totpRequiredClearance, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetTOTPRequiredClearance(totpRequiredClearance); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "userLockoutThreshold" || name == "ipLockoutThreshold" {
+		userThreshold, ipThreshold, duration, maxDuration := s.Config().GetLockout()
+		threshold, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if name == "userLockoutThreshold" {
+			userThreshold = threshold
+		} else {
+			ipThreshold = threshold
+		}
+		if err := s.Config().SetLockout(userThreshold, ipThreshold, duration, maxDuration); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "lockoutDuration" || name == "lockoutMaxDuration" {
+		userThreshold, ipThreshold, duration, maxDuration := s.Config().GetLockout()
+		value, err := time.ParseDuration(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if name == "lockoutDuration" {
+			duration = value
+		} else {
+			maxDuration = value
+		}
+		if err := s.Config().SetLockout(userThreshold, ipThreshold, duration, maxDuration); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "clientCAFile" {
+		_, clientAuthMode := s.Config().GetClientAuth()
+		if err := s.Config().SetClientAuth(args[1], clientAuthMode); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if name == "clientAuth" {
+		clientCAFile, _ := s.Config().GetClientAuth()
+		clientAuthMode, err := config.ParseClientAuthMode(args[1])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		if err := s.Config().SetClientAuth(clientCAFile, clientAuthMode); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if strings.HasPrefix(name, "ldap") {
+		if err := setLDAPSetting(s.Config(), name, args[1]); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+	} else if strings.HasPrefix(name, "password") || strings.HasPrefix(name, "bcrypt") || strings.HasPrefix(name, "argon2") {
+		if err := setPasswordSetting(s.Config(), name, args[1]); err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
 	} else if name == "insecureSkipVerify" {
 		insecureSkipVerify, err := strconv.ParseBool(args[1])
 		if err != nil {
@@ -333,120 +782,590 @@ func ConfigSet(cmd *cobra.Command, args []string) {
 	file.Close()
 }
 
-// Config get command.
-func ConfigGet(cmd *cobra.Command, args []string) {
+// Config get command.
+func ConfigGet(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	name := args[0]
+	if name == "file" {
+		fmt.Println(s.Config().GetServerFile())
+	} else if name == "name" {
+		fmt.Println(s.Config().GetName())
+	} else if name == "host" {
+		host, _ := s.Config().GetHostAndPort()
+		fmt.Println(host)
+	} else if name == "port" {
+		_, port := s.Config().GetHostAndPort()
+		fmt.Println(port)
+	} else if name == "workers" {
+		fmt.Println(s.Config().GetNumWorkers())
+	} else if name == "backlog" {
+		fmt.Println(s.Config().GetBacklog())
+	} else if name == "cronInterval" {
+		cronInterval, _ := s.Config().GetCronIntervals()
+		fmt.Println(cronInterval)
+	} else if name == "sessionInterval" {
+		_, sessionInterval := s.Config().GetCronIntervals()
+		fmt.Println(sessionInterval)
+	} else if name == "timeout" {
+		fmt.Println(s.Config().GetTimeout())
+	} else if name == "shutdownDeadline" {
+		fmt.Println(s.Config().GetShutdownDeadline())
+	} else if name == "userRequests" {
+		fmt.Println(s.Config().GetUserLimits().Requests)
+	} else if name == "userRequestInterval" {
+		fmt.Println(s.Config().GetUserLimits().Interval)
+	} else if name == "userUploadRate" {
+		fmt.Println(s.Config().GetUserLimits().UploadRate)
+	} else if name == "userDownloadRate" {
+		fmt.Println(s.Config().GetUserLimits().DownloadRate)
+	} else if name == "uploadRate" {
+		uploadRate, _ := s.Config().GetBandwidthLimits()
+		fmt.Println(uploadRate)
+	} else if name == "downloadRate" {
+		_, downloadRate := s.Config().GetBandwidthLimits()
+		fmt.Println(downloadRate)
+	} else if name == "ipAllowList" {
+		ipAllowList, _ := s.Config().GetIPFilter()
+		fmt.Println(strings.Join(ipAllowList, ","))
+	} else if name == "ipDenyList" {
+		_, ipDenyList := s.Config().GetIPFilter()
+		fmt.Println(strings.Join(ipDenyList, ","))
+	} else if name == "trustedProxies" {
+		fmt.Println(strings.Join(s.Config().GetTrustedProxies(), ","))
+	} else if name == "maxConnections" {
+		maxConnections, _ := s.Config().GetConnectionLimits()
+		fmt.Println(maxConnections)
+	} else if name == "maxConnectionsPerIP" {
+		_, maxConnectionsPerIP := s.Config().GetConnectionLimits()
+		fmt.Println(maxConnectionsPerIP)
+	} else if name == "maxChunkSize" {
+		maxChunkSize, _, _ := s.Config().GetTransferLimits()
+		fmt.Println(maxChunkSize)
+	} else if name == "memoryBudget" {
+		_, memoryBudget, _ := s.Config().GetTransferLimits()
+		fmt.Println(memoryBudget)
+	} else if name == "maxUploadSize" {
+		_, _, maxUploadSize := s.Config().GetTransferLimits()
+		fmt.Println(maxUploadSize)
+	} else if name == "verbose" {
+		verbose, _, _, _, _ := s.Config().GetLogging()
+		fmt.Println(verbose)
+	} else if name == "logToFile" {
+		_, logToFile, _, _, _ := s.Config().GetLogging()
+		fmt.Println(logToFile)
+	} else if name == "logJSON" {
+		_, _, logJSON, _, _ := s.Config().GetLogging()
+		fmt.Println(logJSON)
+	} else if name == "logLevel" {
+		_, _, _, logLevel, _ := s.Config().GetLogging()
+		fmt.Println(logLevel)
+	} else if name == "logPath" {
+		_, _, _, _, logPath := s.Config().GetLogging()
+		fmt.Println(logPath)
+	} else if name == "defaultSessionExpiration" {
+		defaultSessionExpiration, _, _ := s.Config().GetSessionExpirationSettings()
+		fmt.Println(defaultSessionExpiration)
+	} else if name == "allowChangeSessionExpiration" {
+		_, allowChangeSessionExpiration, _ := s.Config().GetSessionExpirationSettings()
+		fmt.Println(allowChangeSessionExpiration)
+	} else if name == "allowNonExpiringSessions" {
+		_, _, allowNonExpiringSessions := s.Config().GetSessionExpirationSettings()
+		fmt.Println(allowNonExpiringSessions)
+	} else if name == "perUserSessionLimit" {
+		fmt.Println(s.Config().GetUserSessionLimit())
+	} else if name == "sessionFile" {
+		fmt.Println(s.Config().GetSessionFile())
+	} else if name == "sessionIdleTimeout" {
+		idleTimeout, _ := s.Config().GetSessionTimeouts()
+		fmt.Println(idleTimeout)
+	} else if name == "sessionMaxLifetime" {
+		_, maxLifetime := s.Config().GetSessionTimeouts()
+		fmt.Println(maxLifetime)
+	} else if name == "metricsAddress" {
+		fmt.Println(s.Config().GetMetricsAddress())
+	} else if name == "auditFile" {
+		auditFile, _ := s.Config().GetAudit()
+		fmt.Println(auditFile)
+	} else if name == "auditHashChain" {
+		_, auditHashChain := s.Config().GetAudit()
+		fmt.Println(auditHashChain)
+	} else if name == "sessionBinding" {
+		mode, _, _ := s.Config().GetSessionBinding()
+		fmt.Println(mode)
+	} else if name == "sessionBindingIPv4Prefix" {
+		_, ipv4Prefix, _ := s.Config().GetSessionBinding()
+		fmt.Println(ipv4Prefix)
+	} else if name == "sessionBindingIPv6Prefix" {
+		_, _, ipv6Prefix := s.Config().GetSessionBinding()
+		fmt.Println(ipv6Prefix)
+	} else if name == "rateLimitInterval" {
+		rateLimitInterval, _ := s.Config().GetRateLimit()
+		fmt.Println(rateLimitInterval)
+	} else if name == "maxLimitEvents" {
+		_, maxLimitEvents := s.Config().GetRateLimit()
+		fmt.Println(maxLimitEvents)
+	} else if name == "totpRequiredClearance" {
+		fmt.Println(s.Config().GetTOTPRequiredClearance())
+	} else if name == "userLockoutThreshold" {
+		userThreshold, _, _, _ := s.Config().GetLockout()
+		fmt.Println(userThreshold)
+	} else if name == "ipLockoutThreshold" {
+		_, ipThreshold, _, _ := s.Config().GetLockout()
+		fmt.Println(ipThreshold)
+	} else if name == "lockoutDuration" {
+		_, _, duration, _ := s.Config().GetLockout()
+		fmt.Println(duration)
+	} else if name == "lockoutMaxDuration" {
+		_, _, _, maxDuration := s.Config().GetLockout()
+		fmt.Println(maxDuration)
+	} else if name == "clientCAFile" {
+		clientCAFile, _ := s.Config().GetClientAuth()
+		fmt.Println(clientCAFile)
+	} else if name == "clientAuth" {
+		_, clientAuthMode := s.Config().GetClientAuth()
+		fmt.Println(clientAuthMode)
+	} else if strings.HasPrefix(name, "ldap") {
+		value, err := getLDAPSetting(s.Config(), name)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		fmt.Println(value)
+	} else if strings.HasPrefix(name, "password") || strings.HasPrefix(name, "bcrypt") || strings.HasPrefix(name, "argon2") {
+		value, err := getPasswordSetting(s.Config(), name)
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
+		fmt.Println(value)
+	} else if name == "insecureSkipVerify" {
+		fmt.Println(s.Config().GetTLSConfig().InsecureSkipVerify)
+	} else if name == "tlsServerName" {
+		fmt.Println(s.Config().GetTLSConfig().ServerName)
+	} else {
+		fmt.Println("config: invalid setting name")
+		return
+	}
+}
+
+// Config list command.
+func ConfigList(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	fmt.Println("server file:", s.Config().GetServerFile())
+	fmt.Println("name:", s.Config().GetName())
+	host, port := s.Config().GetHostAndPort()
+	fmt.Printf("addr: %s:%d\n", host, port)
+	fmt.Println("workers:", s.Config().GetNumWorkers())
+	fmt.Println("backlog:", s.Config().GetBacklog())
+	ci, si := s.Config().GetCronIntervals()
+	fmt.Println("cron interval:", ci)
+	fmt.Println("session interval:", si)
+	fmt.Println("timeout:", s.Config().GetTimeout())
+	fmt.Println("shutdown deadline:", s.Config().GetShutdownDeadline())
+	userLimits := s.Config().GetUserLimits()
+	fmt.Println("user requests:", userLimits.Requests)
+	fmt.Println("user request interval:", userLimits.Interval)
+	fmt.Println("user upload rate:", userLimits.UploadRate)
+	fmt.Println("user download rate:", userLimits.DownloadRate)
+	fmt.Println("user limit overrides:", len(s.Config().GetUserLimitOverrides()))
+	uploadRate, downloadRate := s.Config().GetBandwidthLimits()
+	fmt.Println("upload rate:", uploadRate)
+	fmt.Println("download rate:", downloadRate)
+	ipAllowList, ipDenyList := s.Config().GetIPFilter()
+	fmt.Println("IP allow list:", strings.Join(ipAllowList, ","))
+	fmt.Println("IP deny list:", strings.Join(ipDenyList, ","))
+	fmt.Println("trusted proxies:", strings.Join(s.Config().GetTrustedProxies(), ","))
+	maxConnections, maxConnectionsPerIP := s.Config().GetConnectionLimits()
+	fmt.Println("max connections:", maxConnections)
+	fmt.Println("max connections per IP:", maxConnectionsPerIP)
+	maxChunkSize, memoryBudget, maxUploadSize := s.Config().GetTransferLimits()
+	fmt.Println("max chunk size:", maxChunkSize)
+	fmt.Println("memory budget:", memoryBudget)
+	fmt.Println("max upload size:", maxUploadSize)
+	fmt.Println("listeners:")
+	for _, l := range s.Config().GetListeners() {
+		fmt.Println("	"+l.Name+":", l.Network, l.Address)
+		if l.MaxLimitEvents > 0 {
+			fmt.Println("		rate limit interval:", l.Limit)
+			fmt.Println("		max rate limit events:", l.MaxLimitEvents)
+		}
+		if l.Network == config.ListenerUnix {
+			for osUser := range l.PeerUsers {
+				fmt.Println("		peer user "+osUser+":", l.PeerUsers[osUser])
+			}
+		} else {
+			fmt.Println("		IP allow list:", strings.Join(l.IPAllowList, ","))
+			fmt.Println("		IP deny list:", strings.Join(l.IPDenyList, ","))
+		}
+	}
+	verbose, logToFile, logJSON, logLevel, logFile := s.Config().GetLogging()
+	fmt.Println("verbose:", verbose)
+	fmt.Println("log to file:", logToFile)
+	fmt.Println("log JSON:", logJSON)
+	fmt.Println("log level:", logLevel)
+	fmt.Println("log file:", logFile)
+	defaultSessionExpiration, allowChangeSessionExpiration, allowNonExpiringSessions := s.Config().GetSessionExpirationSettings()
+	fmt.Println("default session expiration:", defaultSessionExpiration)
+	fmt.Println("allow change session expiraiton:", allowChangeSessionExpiration)
+	fmt.Println("allow non expiring sessions:", allowNonExpiringSessions)
+	fmt.Println("per user session limit:", s.Config().GetUserSessionLimit())
+	fmt.Println("session file:", s.Config().GetSessionFile())
+	sessionIdleTimeout, sessionMaxLifetime := s.Config().GetSessionTimeouts()
+	fmt.Println("session idle timeout:", sessionIdleTimeout)
+	fmt.Println("session max lifetime:", sessionMaxLifetime)
+	sessionBindingMode, sessionBindingIPv4Prefix, sessionBindingIPv6Prefix := s.Config().GetSessionBinding()
+	fmt.Println("session binding:", sessionBindingMode)
+	fmt.Println("session binding ipv4 prefix:", sessionBindingIPv4Prefix)
+	fmt.Println("session binding ipv6 prefix:", sessionBindingIPv6Prefix)
+	auditFile, auditHashChain := s.Config().GetAudit()
+	fmt.Println("audit file:", auditFile)
+	fmt.Println("audit hash chain:", auditHashChain)
+	fmt.Println("metrics address:", s.Config().GetMetricsAddress())
+	limit, maxLimitEvents := s.Config().GetRateLimit()
+	fmt.Println("rate limit interval:", limit)
+	fmt.Println("max rate limit events:", maxLimitEvents)
+	fmt.Println("totp required clearance:", s.Config().GetTOTPRequiredClearance())
+	userLockoutThreshold, ipLockoutThreshold, lockoutDuration, lockoutMaxDuration := s.Config().GetLockout()
+	fmt.Println("user lockout threshold:", userLockoutThreshold)
+	fmt.Println("ip lockout threshold:", ipLockoutThreshold)
+	fmt.Println("lockout duration:", lockoutDuration)
+	fmt.Println("lockout max duration:", lockoutMaxDuration)
+	passwordPolicy := s.Config().GetPasswordPolicy()
+	fmt.Println("password min length:", passwordPolicy.MinLength)
+	fmt.Println("password require upper:", passwordPolicy.RequireUpper)
+	fmt.Println("password require lower:", passwordPolicy.RequireLower)
+	fmt.Println("password require digit:", passwordPolicy.RequireDigit)
+	fmt.Println("password require symbol:", passwordPolicy.RequireSymbol)
+	fmt.Println("password deny-list file:", passwordPolicy.DenyListFile)
+	hashParams := s.Config().GetPasswordHashing()
+	fmt.Println("password hash:", hashParams.Algorithm)
+	fmt.Println("bcrypt cost:", hashParams.BcryptCost)
+	fmt.Println("argon2 time:", hashParams.Argon2Time)
+	fmt.Println("argon2 memory:", hashParams.Argon2Memory)
+	fmt.Println("argon2 threads:", hashParams.Argon2Threads)
+	clientCAFile, clientAuthMode := s.Config().GetClientAuth()
+	fmt.Println("client CA file:", clientCAFile)
+	fmt.Println("client auth:", clientAuthMode)
+	fmt.Println("certificate users:")
+	certUsers := s.Config().GetCertUsers()
+	for identity := range certUsers {
+		fmt.Println("	"+identity+":", certUsers[identity])
+	}
+	ldapMode, ldapAutoProvision, ldapSettings := s.Config().GetLDAP()
+	fmt.Println("ldap mode:", ldapMode)
+	fmt.Println("ldap auto provision:", ldapAutoProvision)
+	fmt.Println("ldap url:", ldapSettings.URL)
+	fmt.Println("ldap start TLS:", ldapSettings.StartTLS)
+	fmt.Println("ldap insecure skip verify:", ldapSettings.InsecureSkipVerify)
+	fmt.Println("ldap bind DN:", ldapSettings.BindDN)
+	fmt.Println("ldap group base DN:", ldapSettings.GroupBaseDN)
+	fmt.Println("ldap group filter:", ldapSettings.GroupFilter)
+	fmt.Println("ldap default clearance:", ldapSettings.DefaultClearance)
+	fmt.Println("ldap groups:")
+	for group := range ldapSettings.GroupClearances {
+		fmt.Println("	"+group+":", ldapSettings.GroupClearances[group])
+	}
+	fmt.Println("certificates:")
+	certs := s.Config().GetCertFilePairs()
+	for i := range certs {
+		fmt.Println("	cert:", certs[i].Cert, "key:", certs[i].Key)
+	}
+}
+
+// Add a drive file.
+func ConfigAddDrive(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	if !filepath.IsAbs(args[1]) {
+		fmt.Println("config: drive path must be absolute")
+		return
+	}
+	err = s.Config().AddDriveFiles(map[string]string{args[0]: args[1]})
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = s.LoadDrives()
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	file.Close()
+}
+
+// Rename a drive.
+func ConfigRenameDrive(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	driveFiles := s.Config().GetDriveFiles()
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	driveFile, ok := driveFiles[args[0]]
+	if !ok {
+		fmt.Println("config: drive does not exist")
+		return
+	}
+
+	f, err := os.Open(driveFile)
+	if err != nil {
+		fmt.Println("drive:", err.Error())
+		return
+	}
+	d, err := drive.Unmarshal(f)
+	if err != nil {
+		f.Close()
+		fmt.Println("drive:", err.Error())
+		return
+	}
+	f.Close()
+
+	d.SetName(args[1])
+
+	f, err = os.Create(driveFile)
+	if err != nil {
+		fmt.Println("drive:", err.Error())
+		return
+	}
+	err = d.Marshal(f)
+	if err != nil {
+		fmt.Println("drive:", err.Error())
+		f.Close()
+		return
+	}
+	f.Close()
+
+	err = s.Config().RemoveDriveFiles([]string{args[0]})
+	if err != nil {
+		fmt.Println("drive:", err.Error())
+		return
+	}
+
+	err = s.Config().AddDriveFiles(map[string]string{args[1]: driveFile})
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	file.Close()
+}
+
+// Remove a drive file.
+func ConfigRemoveDrive(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	err = s.Config().RemoveDriveFiles([]string{args[0]})
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	file.Close()
+}
+
+// Add a user.
+func ConfigAddUser(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	c := access.Clearance(clearance)
+	err = c.Validate()
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	if err := s.Config().CheckPassword(args[1]); err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	uobj, err := user.NewUserWithParams(args[0], args[1], c, s.Config().GetPasswordHashing())
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	s.Users().SetUsersByName(map[string]*user.User{args[0]: uobj})
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	file.Close()
+}
+
+// Remove a user.
+func ConfigRemoveUser(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	err = s.Users().RemoveUsersByName([]string{args[0]})
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	file.Close()
+}
+
+// Reset a user's two-factor authentication.
+func ConfigResetUserTOTP(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
-	name := args[0]
-	if name == "file" {
-		fmt.Println(s.Config().GetServerFile())
-	} else if name == "name" {
-		fmt.Println(s.Config().GetName())
-	} else if name == "host" {
-		host, _ := s.Config().GetHostAndPort()
-		fmt.Println(host)
-	} else if name == "port" {
-		_, port := s.Config().GetHostAndPort()
-		fmt.Println(port)
-	} else if name == "workers" {
-		fmt.Println(s.Config().GetNumWorkers())
-	} else if name == "backlog" {
-		fmt.Println(s.Config().GetBacklog())
-	} else if name == "cronInterval" {
-		cronInterval, _ := s.Config().GetCronIntervals()
-		fmt.Println(cronInterval)
-	} else if name == "sessionInterval" {
-		_, sessionInterval := s.Config().GetCronIntervals()
-		fmt.Println(sessionInterval)
-	} else if name == "timeout" {
-		fmt.Println(s.Config().GetTimeout())
-	} else if name == "verbose" {
-		verbose, _, _, _, _ := s.Config().GetLogging()
-		fmt.Println(verbose)
-	} else if name == "logToFile" {
-		_, logToFile, _, _, _ := s.Config().GetLogging()
-		fmt.Println(logToFile)
-	} else if name == "logJSON" {
-		_, _, logJSON, _, _ := s.Config().GetLogging()
-		fmt.Println(logJSON)
-	} else if name == "logLevel" {
-		_, _, _, logLevel, _ := s.Config().GetLogging()
-		fmt.Println(logLevel)
-	} else if name == "logPath" {
-		_, _, _, _, logPath := s.Config().GetLogging()
-		fmt.Println(logPath)
-	} else if name == "defaultSessionExpiration" {
-		defaultSessionExpiration, _, _ := s.Config().GetSessionExpirationSettings()
-		fmt.Println(defaultSessionExpiration)
-	} else if name == "allowChangeSessionExpiration" {
-		_, allowChangeSessionExpiration, _ := s.Config().GetSessionExpirationSettings()
-		fmt.Println(allowChangeSessionExpiration)
-	} else if name == "allowNonExpiringSessions" {
-		_, _, allowNonExpiringSessions := s.Config().GetSessionExpirationSettings()
-		fmt.Println(allowNonExpiringSessions)
-	} else if name == "perUserSessionLimit" {
-		fmt.Println(s.Config().GetUserSessionLimit())
-	} else if name == "rateLimitInterval" {
-		rateLimitInterval, _ := s.Config().GetRateLimit()
-		fmt.Println(rateLimitInterval)
-	} else if name == "maxLimitEvents" {
-		_, maxLimitEvents := s.Config().GetRateLimit()
-		fmt.Println(maxLimitEvents)
-	} else if name == "insecureSkipVerify" {
-		fmt.Println(s.Config().GetTLSConfig().InsecureSkipVerify)
-	} else if name == "tlsServerName" {
-		fmt.Println(s.Config().GetTLSConfig().ServerName)
-	} else {
-		fmt.Println("config: invalid setting name")
+
+	users, err := s.Users().GetUsersByName([]string{args[0]})
+	if err != nil {
+		fmt.Println("config:", err.Error())
 		return
 	}
-}
+	users[0].ResetTOTP()
 
-// Config list command.
-func ConfigList(cmd *cobra.Command, args []string) {
-	// Load the server file.
-	s, err := server.LoadServerFromFile(serverFile)
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
 	if err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
-	fmt.Println("server file:", s.Config().GetServerFile())
-	fmt.Println("name:", s.Config().GetName())
-	host, port := s.Config().GetHostAndPort()
-	fmt.Printf("addr: %s:%d\n", host, port)
-	fmt.Println("workers:", s.Config().GetNumWorkers())
-	fmt.Println("backlog:", s.Config().GetBacklog())
-	ci, si := s.Config().GetCronIntervals()
-	fmt.Println("cron interval:", ci)
-	fmt.Println("session interval:", si)
-	fmt.Println("timeout:", s.Config().GetTimeout())
-	verbose, logToFile, logJSON, logLevel, logFile := s.Config().GetLogging()
-	fmt.Println("verbose:", verbose)
-	fmt.Println("log to file:", logToFile)
-	fmt.Println("log JSON:", logJSON)
-	fmt.Println("log level:", logLevel)
-	fmt.Println("log file:", logFile)
-	defaultSessionExpiration, allowChangeSessionExpiration, allowNonExpiringSessions := s.Config().GetSessionExpirationSettings()
-	fmt.Println("default session expiration:", defaultSessionExpiration)
-	fmt.Println("allow change session expiraiton:", allowChangeSessionExpiration)
-	fmt.Println("allow non expiring sessions:", allowNonExpiringSessions)
-	fmt.Println("per user session limit:", s.Config().GetUserSessionLimit())
-	limit, maxLimitEvents := s.Config().GetRateLimit()
-	fmt.Println("rate limit interval:", limit)
-	fmt.Println("max rate limit events:", maxLimitEvents)
-	fmt.Println("certificates:")
-	certs := s.Config().GetCertFilePairs()
-	for i := range certs {
-		fmt.Println("	cert:", certs[i].Cert, "key:", certs[i].Key)
+	err = marshal.MarshalConfig(s.Config(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
+	}
+	err = marshal.MarshalUserList(s.Users(), file)
+	if err != nil {
+		file.Close()
+		fmt.Println("config:", err.Error())
+		return
 	}
+	file.Close()
 }
 
-// Add a drive file.
-func ConfigAddDrive(cmd *cobra.Command, args []string) {
+// Map a client certificate identity to a user.
+func ConfigAddCertUser(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
@@ -454,17 +1373,11 @@ func ConfigAddDrive(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if !filepath.IsAbs(args[1]) {
-		fmt.Println("config: drive path must be absolute")
-		return
-	}
-	err = s.Config().AddDriveFiles(map[string]string{args[0]: args[1]})
-	if err != nil {
+	if _, err := s.Users().GetUsersByName([]string{args[1]}); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
-	err = s.LoadDrives()
-	if err != nil {
+	if err := s.Config().AddCertUsers(map[string]string{args[0]: args[1]}); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
@@ -490,8 +1403,8 @@ func ConfigAddDrive(cmd *cobra.Command, args []string) {
 	file.Close()
 }
 
-// Rename a drive.
-func ConfigRenameDrive(cmd *cobra.Command, args []string) {
+// Remove a client certificate identity.
+func ConfigRemoveCertUser(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
@@ -499,56 +1412,218 @@ func ConfigRenameDrive(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	driveFiles := s.Config().GetDriveFiles()
-	if err != nil {
+	if err := s.Config().RemoveCertUsers([]string{args[0]}); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
-	driveFile, ok := driveFiles[args[0]]
-	if !ok {
-		fmt.Println("config: drive does not exist")
+
+	// Save the server file.
+	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Println("config:", err.Error())
 		return
 	}
-
-	f, err := os.Open(driveFile)
+	err = marshal.MarshalConfig(s.Config(), file)
 	if err != nil {
-		fmt.Println("drive:", err.Error())
+		file.Close()
+		fmt.Println("config:", err.Error())
 		return
 	}
-	d, err := drive.Unmarshal(f)
+	err = marshal.MarshalUserList(s.Users(), file)
 	if err != nil {
-		f.Close()
-		fmt.Println("drive:", err.Error())
+		file.Close()
+		fmt.Println("config:", err.Error())
 		return
 	}
-	f.Close()
+	file.Close()
+}
 
-	d.SetName(args[1])
+// Split a comma-separated list. An empty value is an empty list.
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
 
-	f, err = os.Create(driveFile)
+// Parse a comma-separated list of OS users mapped to usernames, such as
+// "alice:foo,1000:bar".
+func parsePeerUsers(value string) (map[string]string, error) {
+	peerUsers := map[string]string{}
+	for _, item := range splitList(value) {
+		pair := strings.SplitN(item, ":", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("invalid peer user mapping")
+		}
+		peerUsers[pair[0]] = pair[1]
+	}
+	return peerUsers, nil
+}
+
+// Set an LDAP setting by name.
+func setLDAPSetting(c *config.Config, name, value string) error {
+	mode, autoProvision, settings := c.GetLDAP()
+	var err error
+	switch name {
+	case "ldapMode":
+		mode, err = config.ParseLDAPMode(value)
+	case "ldapAutoProvision":
+		autoProvision, err = strconv.ParseBool(value)
+	case "ldapURL":
+		settings.URL = value
+	case "ldapStartTLS":
+		settings.StartTLS, err = strconv.ParseBool(value)
+	case "ldapInsecureSkipVerify":
+		settings.InsecureSkipVerify, err = strconv.ParseBool(value)
+	case "ldapBindDN":
+		settings.BindDN = value
+	case "ldapGroupBaseDN":
+		settings.GroupBaseDN = value
+	case "ldapGroupFilter":
+		settings.GroupFilter = value
+	case "ldapDefaultClearance":
+		settings.DefaultClearance, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("invalid setting name")
+	}
 	if err != nil {
-		fmt.Println("drive:", err.Error())
-		return
+		return err
+	}
+	return c.SetLDAP(mode, autoProvision, settings)
+}
+
+// Set a password policy or hash setting by name.
+func setPasswordSetting(c *config.Config, name, value string) error {
+	policy := c.GetPasswordPolicy()
+	params := c.GetPasswordHashing()
+	var err error
+	var n uint64
+	switch name {
+	case "passwordMinLength":
+		policy.MinLength, err = strconv.Atoi(value)
+	case "passwordRequireUpper":
+		policy.RequireUpper, err = strconv.ParseBool(value)
+	case "passwordRequireLower":
+		policy.RequireLower, err = strconv.ParseBool(value)
+	case "passwordRequireDigit":
+		policy.RequireDigit, err = strconv.ParseBool(value)
+	case "passwordRequireSymbol":
+		policy.RequireSymbol, err = strconv.ParseBool(value)
+	case "passwordDenyListFile":
+		policy.DenyListFile = value
+	case "passwordHash":
+		params.Algorithm, err = auth.ParseAlgorithm(value)
+	case "bcryptCost":
+		params.BcryptCost, err = strconv.Atoi(value)
+	case "argon2Time":
+		n, err = strconv.ParseUint(value, 10, 32)
+		params.Argon2Time = uint32(n)
+	case "argon2Memory":
+		n, err = strconv.ParseUint(value, 10, 32)
+		params.Argon2Memory = uint32(n)
+	case "argon2Threads":
+		n, err = strconv.ParseUint(value, 10, 8)
+		params.Argon2Threads = uint8(n)
+	default:
+		return fmt.Errorf("invalid setting name")
 	}
-	err = d.Marshal(f)
 	if err != nil {
-		fmt.Println("drive:", err.Error())
-		f.Close()
-		return
+		return err
 	}
-	f.Close()
+	if err := c.SetPasswordPolicy(policy); err != nil {
+		return err
+	}
+	return c.SetPasswordHashing(params)
+}
 
-	err = s.Config().RemoveDriveFiles([]string{args[0]})
+// Get a password policy or hash setting by name.
+func getPasswordSetting(c *config.Config, name string) (interface{}, error) {
+	policy := c.GetPasswordPolicy()
+	params := c.GetPasswordHashing()
+	switch name {
+	case "passwordMinLength":
+		return policy.MinLength, nil
+	case "passwordRequireUpper":
+		return policy.RequireUpper, nil
+	case "passwordRequireLower":
+		return policy.RequireLower, nil
+	case "passwordRequireDigit":
+		return policy.RequireDigit, nil
+	case "passwordRequireSymbol":
+		return policy.RequireSymbol, nil
+	case "passwordDenyListFile":
+		return policy.DenyListFile, nil
+	case "passwordHash":
+		return params.Algorithm, nil
+	case "bcryptCost":
+		return params.BcryptCost, nil
+	case "argon2Time":
+		return params.Argon2Time, nil
+	case "argon2Memory":
+		return params.Argon2Memory, nil
+	case "argon2Threads":
+		return params.Argon2Threads, nil
+	default:
+		return nil, fmt.Errorf("invalid setting name")
+	}
+}
+
+// Get an LDAP setting by name.
+func getLDAPSetting(c *config.Config, name string) (interface{}, error) {
+	mode, autoProvision, settings := c.GetLDAP()
+	switch name {
+	case "ldapMode":
+		return mode, nil
+	case "ldapAutoProvision":
+		return autoProvision, nil
+	case "ldapURL":
+		return settings.URL, nil
+	case "ldapStartTLS":
+		return settings.StartTLS, nil
+	case "ldapInsecureSkipVerify":
+		return settings.InsecureSkipVerify, nil
+	case "ldapBindDN":
+		return settings.BindDN, nil
+	case "ldapGroupBaseDN":
+		return settings.GroupBaseDN, nil
+	case "ldapGroupFilter":
+		return settings.GroupFilter, nil
+	case "ldapDefaultClearance":
+		return settings.DefaultClearance, nil
+	default:
+		return nil, fmt.Errorf("invalid setting name")
+	}
+}
+
+// Map an LDAP group to a clearance level.
+func ConfigAddLDAPGroup(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
-		fmt.Println("drive:", err.Error())
+		fmt.Println("config:", err.Error())
 		return
 	}
 
-	err = s.Config().AddDriveFiles(map[string]string{args[1]: driveFile})
+	clearance, err := strconv.Atoi(args[1])
 	if err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
+	mode, autoProvision, settings := s.Config().GetLDAP()
+	groups := map[string]int{args[0]: clearance}
+	for group := range settings.GroupClearances {
+		if group != args[0] {
+			groups[group] = settings.GroupClearances[group]
+		}
+	}
+	settings.GroupClearances = groups
+	if err := s.Config().SetLDAP(mode, autoProvision, settings); err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
 
 	// Save the server file.
 	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
@@ -571,8 +1646,8 @@ func ConfigRenameDrive(cmd *cobra.Command, args []string) {
 	file.Close()
 }
 
-// Remove a drive file.
-func ConfigRemoveDrive(cmd *cobra.Command, args []string) {
+// Remove an LDAP group mapping.
+func ConfigRemoveLDAPGroup(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
@@ -580,8 +1655,19 @@ func ConfigRemoveDrive(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	err = s.Config().RemoveDriveFiles([]string{args[0]})
-	if err != nil {
+	mode, autoProvision, settings := s.Config().GetLDAP()
+	if _, ok := settings.GroupClearances[args[0]]; !ok {
+		fmt.Println("config: group does not exist")
+		return
+	}
+	groups := map[string]int{}
+	for group := range settings.GroupClearances {
+		if group != args[0] {
+			groups[group] = settings.GroupClearances[group]
+		}
+	}
+	settings.GroupClearances = groups
+	if err := s.Config().SetLDAP(mode, autoProvision, settings); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
@@ -607,8 +1693,8 @@ func ConfigRemoveDrive(cmd *cobra.Command, args []string) {
 	file.Close()
 }
 
-// Add a user.
-func ConfigAddUser(cmd *cobra.Command, args []string) {
+// Add or replace a listener.
+func ConfigAddListener(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
@@ -616,18 +1702,20 @@ func ConfigAddUser(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	c := access.Clearance(clearance)
-	err = c.Validate()
-	if err != nil {
-		fmt.Println("config:", err.Error())
-		return
+	peerUsers := map[string]string{}
+	if len(args) == 4 {
+		peerUsers, err = parsePeerUsers(args[3])
+		if err != nil {
+			fmt.Println("config:", err.Error())
+			return
+		}
 	}
-	uobj, err := user.NewUser(args[0], args[1], c)
-	if err != nil {
+	l, _ := s.Config().GetListener(args[0])
+	l.Name, l.Network, l.Address, l.PeerUsers = args[0], args[1], args[2], peerUsers
+	if err := s.Config().SetListener(l); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
-	s.Users().SetUsersByName(map[string]*user.User{args[0]: uobj})
 
 	// Save the server file.
 	file, err := os.OpenFile(serverFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
@@ -650,8 +1738,8 @@ func ConfigAddUser(cmd *cobra.Command, args []string) {
 	file.Close()
 }
 
-// Remove a user.
-func ConfigRemoveUser(cmd *cobra.Command, args []string) {
+// Remove a listener.
+func ConfigRemoveListener(cmd *cobra.Command, args []string) {
 	// Load the server file.
 	s, err := server.LoadServerFromFile(serverFile)
 	if err != nil {
@@ -659,8 +1747,7 @@ func ConfigRemoveUser(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	err = s.Users().RemoveUsersByName([]string{args[0]})
-	if err != nil {
+	if err := s.Config().RemoveListeners([]string{args[0]}); err != nil {
 		fmt.Println("config:", err.Error())
 		return
 	}
@@ -755,3 +1842,29 @@ func ConfigSetCerts(cmd *cobra.Command, args []string) {
 	}
 	file.Close()
 }
+
+// Verify the audit log.
+func ConfigVerifyAuditLog(cmd *cobra.Command, args []string) {
+	// Load the server file.
+	s, err := server.LoadServerFromFile(serverFile)
+	if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	auditFile, _ := s.Config().GetAudit()
+	if auditFile == "" {
+		fmt.Println("config: audit log not enabled")
+		return
+	}
+
+	// Verify the hash chain.
+	n, err := audit.VerifyFile(auditFile)
+	if err == audit.ErrBrokenChain {
+		fmt.Printf("config: audit log hash chain is broken after %d valid records\n", n)
+		return
+	} else if err != nil {
+		fmt.Println("config:", err.Error())
+		return
+	}
+	fmt.Printf("audit log is valid (%d records)\n", n)
+}
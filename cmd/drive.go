@@ -121,6 +121,10 @@ func fsFromDir(path, name string, isRoot bool, parent *fs.Directory, ac, mc acce
 		return nil, err
 	}
 	for _, f := range files {
+		// Skip the server's temporary files.
+		if f.Name() == drive.TempDirName {
+			continue
+		}
 		if f.IsDir() {
 			newSubdir, err := fsFromDir(filepath.Join(path, f.Name()), f.Name(), false, newDir, ac, mc, depth+1)
 			if err != nil {
@@ -238,8 +242,11 @@ func fixDir(path string, dir *fs.Directory, ac, mc access.Clearance, depth int)
 		return err
 	}
 
-	// Iterate over the OS files.
+	// Iterate over the OS files, skipping the server's temporary files.
 	for _, f := range files {
+		if f.Name() == drive.TempDirName {
+			continue
+		}
 		if f.IsDir() {
 			// Check that this directory exists in the drive.
 			subdirs, err := dir.GetSubdirsByName([]string{f.Name()})
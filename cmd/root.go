@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/cubeflix/lily/security/certs"
 	"github.com/cubeflix/lily/version"
 	"github.com/spf13/cobra"
 )
@@ -22,6 +25,11 @@ var driveFile string
 var accessClearance int
 var modifyClearance int
 
+var certDir string
+var certName string
+var certSANs []string
+var certValidity time.Duration
+
 // Base Lily command.
 var RootCmd = &cobra.Command{
 	Use:   "lily",
@@ -142,6 +150,69 @@ var ConfigRemoveUserCmd = &cobra.Command{
 	Run:   ConfigRemoveUser,
 }
 
+// Reset user two-factor authentication subcommand.
+var ConfigResetUserTOTPCmd = &cobra.Command{
+	Use:   "reset-user-totp <name>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Reset a user's two-factor authentication.",
+	Long:  `Remove a user's TOTP secret and recovery codes, disabling two-factor authentication.`,
+	Run:   ConfigResetUserTOTP,
+}
+
+// Add certificate user subcommand.
+var ConfigAddCertUserCmd = &cobra.Command{
+	Use:   "add-cert-user <identity> <username>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Map a client certificate to a user.",
+	Long:  `Map a client certificate subject, common name or SAN to a user, allowing certificate authentication. The identity is a type and a value: subject:<subject>, cn:<common name>, dns:<name>, email:<address>, uri:<uri> or ip:<address>.`,
+	Run:   ConfigAddCertUser,
+}
+
+// Remove certificate user subcommand.
+var ConfigRemoveCertUserCmd = &cobra.Command{
+	Use:   "remove-cert-user <identity>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Remove a client certificate mapping.",
+	Long:  `Remove a client certificate subject, common name or SAN mapping.`,
+	Run:   ConfigRemoveCertUser,
+}
+
+// Add LDAP group subcommand.
+var ConfigAddLDAPGroupCmd = &cobra.Command{
+	Use:   "add-ldap-group <group> <clearance>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Map an LDAP group to a clearance level.",
+	Long:  `Map an LDAP group DN or common name to a clearance level. Users receive the highest clearance of their groups on each login.`,
+	Run:   ConfigAddLDAPGroup,
+}
+
+// Remove LDAP group subcommand.
+var ConfigRemoveLDAPGroupCmd = &cobra.Command{
+	Use:   "remove-ldap-group <group>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Remove an LDAP group mapping.",
+	Long:  `Remove an LDAP group DN or common name mapping.`,
+	Run:   ConfigRemoveLDAPGroup,
+}
+
+// Add listener subcommand.
+var ConfigAddListenerCmd = &cobra.Command{
+	Use:   "add-listener <name> <network> <address> [peerUsers]",
+	Args:  cobra.MatchAll(cobra.RangeArgs(3, 4), cobra.OnlyValidArgs),
+	Short: "Add or replace a listener.",
+	Long:  `Add or replace an additional listener. The network is "tcp", with a host and port address, or "unix", with a socket path. Unix socket listeners can map local OS usernames or user IDs to users for peer authentication, given a comma-separated list of osuser:username pairs.`,
+	Run:   ConfigAddListener,
+}
+
+// Remove listener subcommand.
+var ConfigRemoveListenerCmd = &cobra.Command{
+	Use:   "remove-listener <name>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Remove a listener.",
+	Long:  `Remove an additional listener.`,
+	Run:   ConfigRemoveListener,
+}
+
 // Set certificates subcommand.
 var ConfigSetCertsCmd = &cobra.Command{
 	Use:   "set-certs <certFiles> <keyFiles>",
@@ -151,6 +222,46 @@ var ConfigSetCertsCmd = &cobra.Command{
 	Run:   ConfigSetCerts,
 }
 
+// Verify audit log subcommand.
+var ConfigVerifyAuditLogCmd = &cobra.Command{
+	Use:   "verify-audit-log",
+	Short: "Verify the audit log.",
+	Long:  `Verify the hash chain of the audit log, to detect changes to the file.`,
+	Run:   ConfigVerifyAuditLog,
+}
+
+// Cert command.
+var CertCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Create and issue certificates.",
+	Long:  `Create a local certificate authority, and issue server and client certificates from it.`,
+}
+
+// Cert init subcommand.
+var CertInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a certificate authority and server certificate.",
+	Long:  `Create a local certificate authority and a server certificate in the certificate directory, and print the config file settings to use them.`,
+	Run:   CertInit,
+}
+
+// Cert issue client subcommand.
+var CertIssueClientCmd = &cobra.Command{
+	Use:   "issue-client <name>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Issue a client certificate.",
+	Long:  `Issue a client certificate from the certificate authority, with the name as its common name. Map it to a user with "lily config add-cert-user cn:<name> <username>".`,
+	Run:   CertIssueClient,
+}
+
+// Cert rotate subcommand.
+var CertRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the server certificate.",
+	Long:  `Issue a new server certificate from the certificate authority, keeping the current name and SANs unless given, and set it as the server's certificate in the server file. Reload the server to use it.`,
+	Run:   CertRotate,
+}
+
 // Drive command.
 var DriveCmd = &cobra.Command{
 	Use:   "drive",
@@ -208,6 +319,330 @@ var DriveFixCmd = &cobra.Command{
 	Run:   DriveFix,
 }
 
+// Client command.
+var ClientCmd = &cobra.Command{
+	Use:               "client",
+	Short:             "Use a running Lily server.",
+	Long:              `Connect to a running Lily server. Log in with "lily client login" to cache a session, which the other client commands use. Remote paths are given as drive:path, or relative to the --drive flag.`,
+	PersistentPostRun: clientExit,
+}
+
+// Client login subcommand.
+var ClientLoginCmd = &cobra.Command{
+	Use:   "login [username]",
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Log in and cache the session.",
+	Long:  `Log in to the server and cache the session, along with the connection flags, in the session file. The username defaults to the cached username.`,
+	Run:   ClientLogin,
+}
+
+// Client logout subcommand.
+var ClientLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Log out and remove the cached session.",
+	Long:  `Log out of the server and remove the cached session. The connection settings are kept.`,
+	Run:   ClientLogout,
+}
+
+// Client ping subcommand.
+var ClientPingCmd = &cobra.Command{
+	Use:   "ping",
+	Short: "Ping the server.",
+	Long:  `Ping the server and print the round trip time.`,
+	Run:   ClientPing,
+}
+
+// Client info subcommand.
+var ClientInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Get the server information.",
+	Long:  `Get the server name, version and drives.`,
+	Run:   ClientInfo,
+}
+
+// Client ls subcommand.
+var ClientLsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	Short: "List a directory.",
+	Long:  `List the contents of a remote directory, or of the current directory.`,
+	Run:   ClientLs,
+}
+
+// Client stat subcommand.
+var ClientStatCmd = &cobra.Command{
+	Use:   "stat <path>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Get the status of paths.",
+	Long:  `Get whether remote paths exist, if they are files, and when and by whom they were last edited.`,
+	Run:   ClientStat,
+}
+
+// Client get subcommand.
+var ClientGetCmd = &cobra.Command{
+	Use:   "get <path> [local]",
+	Args:  cobra.MatchAll(cobra.RangeArgs(1, 2), cobra.OnlyValidArgs),
+	Short: "Download a file.",
+	Long:  `Download a remote file to a local path, or to the current directory. With --streams, ranges of the file are downloaded concurrently.`,
+	Run:   ClientGet,
+}
+
+// Client put subcommand.
+var ClientPutCmd = &cobra.Command{
+	Use:   "put <local> [path]",
+	Args:  cobra.MatchAll(cobra.RangeArgs(1, 2), cobra.OnlyValidArgs),
+	Short: "Upload a file.",
+	Long:  `Upload a local file to a remote path, or to the current directory. Existing files are replaced, or patched with only the changed blocks with --delta. With --streams, ranges of the file are uploaded concurrently, and the file is replaced once they have all arrived.`,
+	Run:   ClientPut,
+}
+
+// Client mv subcommand.
+var ClientMvCmd = &cobra.Command{
+	Use:   "mv <path> <dest>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Move a file or directory.",
+	Long:  `Move or rename a remote file or directory. If the destination is a directory, the path is moved into it.`,
+	Run:   ClientMv,
+}
+
+// Client rm subcommand.
+var ClientRmCmd = &cobra.Command{
+	Use:   "rm <path>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Remove files or directories.",
+	Long:  `Remove remote files, or directories with -r.`,
+	Run:   ClientRm,
+}
+
+// Client mkdir subcommand.
+var ClientMkdirCmd = &cobra.Command{
+	Use:   "mkdir <path>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Create directories.",
+	Long:  `Create remote directories, and their parents with -p.`,
+	Run:   ClientMkdir,
+}
+
+// Client access subcommand.
+var ClientAccessCmd = &cobra.Command{
+	Use:   "access <path>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Get the access settings of a path.",
+	Long:  `Get the clearances, whitelists and blacklists of a remote path.`,
+	Run:   ClientAccess,
+}
+
+// Client chmod subcommand.
+var ClientChmodCmd = &cobra.Command{
+	Use:   "chmod <path>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Change the access settings of a path.",
+	Long:  `Change the clearances of a remote path, and add or remove users from its whitelists and blacklists.`,
+	Run:   ClientChmod,
+}
+
+// Client sync subcommand.
+var ClientSyncCmd = &cobra.Command{
+	Use:   "sync <local> <path>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Sync a local directory with a remote directory.",
+	Long:  `Upload the files in a local directory which are new or differ from those in a remote directory, or download them with --download. Files are compared by size, then by SHA-256 hash if the source is newer. Include and exclude patterns match the relative path or the name of a file, such as "*.log" or "build/tmp".`,
+	Run:   ClientSync,
+}
+
+// Client call subcommand.
+var ClientCallCmd = &cobra.Command{
+	Use:   "call <command> [params]",
+	Args:  cobra.MatchAll(cobra.RangeArgs(1, 2), cobra.OnlyValidArgs),
+	Short: "Call any command.",
+	Long:  `Call any server command with the cached session, with the parameters as a JSON object. Parameters listed with --duration are parsed as durations, such as "1h".`,
+	Run:   ClientCall,
+}
+
+// Client shell subcommand.
+var ClientShellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start an interactive shell.",
+	Long:  `Start an interactive shell, which runs client commands with tab completion of remote paths. Use cd and pwd to change and print the current directory, and exit to quit.`,
+	Run:   ClientShell,
+}
+
+// Client user subcommand.
+var ClientUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage users.",
+	Long:  `Manage the server's users.`,
+}
+
+// Client user list subcommand.
+var ClientUserListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the users.",
+	Long:  `List the usernames of all users.`,
+	Run:   ClientUserList,
+}
+
+// Client user info subcommand.
+var ClientUserInfoCmd = &cobra.Command{
+	Use:   "info <username>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Get information about users.",
+	Long:  `Get the clearance and TOTP status of users.`,
+	Run:   ClientUserInfo,
+}
+
+// Client user create subcommand.
+var ClientUserCreateCmd = &cobra.Command{
+	Use:   "create <username>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Create a user.",
+	Long:  `Create a user, prompting for the password.`,
+	Run:   ClientUserCreate,
+}
+
+// Client user delete subcommand.
+var ClientUserDeleteCmd = &cobra.Command{
+	Use:   "delete <username>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Delete users.",
+	Long:  `Delete users.`,
+	Run:   ClientUserDelete,
+}
+
+// Client user set password subcommand.
+var ClientUserSetPasswordCmd = &cobra.Command{
+	Use:   "set-password [username]",
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Set a user's password.",
+	Long:  `Set a user's password, or your own password if no user is given, prompting for the password.`,
+	Run:   ClientUserSetPassword,
+}
+
+// Client user set clearance subcommand.
+var ClientUserSetClearanceCmd = &cobra.Command{
+	Use:   "set-clearance <username> <clearance>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Set a user's clearance.",
+	Long:  `Set a user's clearance level.`,
+	Run:   ClientUserSetClearance,
+}
+
+// Client session subcommand.
+var ClientSessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage sessions.",
+	Long:  `Manage the server's sessions, and your own sessions.`,
+}
+
+// Client session list subcommand.
+var ClientSessionListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Args:  cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	Short: "List sessions.",
+	Long:  `List all sessions, or a user's sessions.`,
+	Run:   ClientSessionList,
+}
+
+// Client session expire subcommand.
+var ClientSessionExpireCmd = &cobra.Command{
+	Use:   "expire <id>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Expire sessions.",
+	Long:  `Expire sessions by their IDs.`,
+	Run:   ClientSessionExpire,
+}
+
+// Client session expire all subcommand.
+var ClientSessionExpireAllCmd = &cobra.Command{
+	Use:   "expire-all",
+	Short: "Remove expired sessions.",
+	Long:  `Remove all expired sessions.`,
+	Run:   ClientSessionExpireAll,
+}
+
+// Client session mine subcommand.
+var ClientSessionMineCmd = &cobra.Command{
+	Use:   "mine",
+	Short: "List your sessions.",
+	Long:  `List your own sessions, by their ID hashes.`,
+	Run:   ClientSessionMine,
+}
+
+// Client session revoke subcommand.
+var ClientSessionRevokeCmd = &cobra.Command{
+	Use:   "revoke <hash>...",
+	Args:  cobra.MatchAll(cobra.MinimumNArgs(1), cobra.OnlyValidArgs),
+	Short: "Revoke your sessions.",
+	Long:  `Revoke your own sessions, by the ID hashes listed by "lily client session mine".`,
+	Run:   ClientSessionRevoke,
+}
+
+// Client drive subcommand.
+var ClientDriveCmd = &cobra.Command{
+	Use:   "drive",
+	Short: "Manage drives.",
+	Long:  `Manage the server's drives.`,
+}
+
+// Client drive list subcommand.
+var ClientDriveListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the drives.",
+	Long:  `List the names of all drives.`,
+	Run:   ClientDriveList,
+}
+
+// Client drive add subcommand.
+var ClientDriveAddCmd = &cobra.Command{
+	Use:   "add <name> <path>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Add a drive.",
+	Long:  `Add an initialized drive, by the path of its drive file on the server.`,
+	Run:   ClientDriveAdd,
+}
+
+// Client drive rename subcommand.
+var ClientDriveRenameCmd = &cobra.Command{
+	Use:   "rename <name> <new name>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Short: "Rename a drive.",
+	Long:  `Rename a drive.`,
+	Run:   ClientDriveRename,
+}
+
+// Client drive remove subcommand.
+var ClientDriveRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Short: "Remove a drive.",
+	Long:  `Remove a drive from the server. The drive's files are kept.`,
+	Run:   ClientDriveRemove,
+}
+
+// Client settings subcommand.
+var ClientSettingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Get the server settings.",
+	Long:  `Get all the server settings.`,
+	Run:   ClientSettings,
+}
+
+// Client reload subcommand.
+var ClientReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the server.",
+	Long:  `Reload the server's config file.`,
+	Run:   ClientReload,
+}
+
+// Client shutdown subcommand.
+var ClientShutdownCmd = &cobra.Command{
+	Use:   "shutdown",
+	Short: "Shut down the server.",
+	Long:  `Shut down the server, waiting up to the deadline for requests to finish.`,
+	Run:   ClientShutdown,
+}
+
 // Execute the root command.
 func Execute() {
 	// Execute the main command.
@@ -225,18 +660,68 @@ func init() {
 	ServeCmd.PersistentFlags().IntVarP(&port, "port", "p", 0, "The port to listen on (defaults to server file)")
 	ServeCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "If we should not log (defaults to false)")
 	ConfigCmd.PersistentFlags().StringVarP(&serverFile, "file", "f", ".server.lily", "The server file to use")
+	CertCmd.PersistentFlags().StringVarP(&certDir, "dir", "d", "certs", "The certificate directory")
+	CertCmd.PersistentFlags().DurationVar(&certValidity, "validity", certs.DefaultCertValidity, "The validity period of issued certificates")
+	CertInitCmd.PersistentFlags().StringVarP(&certName, "name", "n", "lily", "The server certificate common name")
+	CertInitCmd.PersistentFlags().StringSliceVar(&certSANs, "san", []string{"localhost", "127.0.0.1", "::1"}, "The server certificate DNS names and IP addresses")
+	CertRotateCmd.PersistentFlags().StringVarP(&serverFile, "file", "f", ".server.lily", "The server file to use")
+	CertRotateCmd.PersistentFlags().StringVarP(&certName, "name", "n", "lily", "The server certificate common name")
+	CertRotateCmd.PersistentFlags().StringSliceVar(&certSANs, "san", []string{"localhost", "127.0.0.1", "::1"}, "The server certificate DNS names and IP addresses")
 	DriveCmd.PersistentFlags().StringVarP(&driveFile, "file", "f", ".%name%.lilyd", "The drive file to use")
 	ConfigAddUserCmd.PersistentFlags().IntVarP(&clearance, "clearance", "c", 5, "The clearance level for the new user")
 	DriveInitCmd.PersistentFlags().IntVarP(&accessClearance, "access-clearance", "a", 1, "The access clearance level")
 	DriveInitCmd.PersistentFlags().IntVarP(&modifyClearance, "modify-clearance", "m", 1, "The modify clearance level")
 	DriveReimportCmd.PersistentFlags().IntVarP(&accessClearance, "access-clearance", "a", 1, "The access clearance level")
 	DriveReimportCmd.PersistentFlags().IntVarP(&modifyClearance, "modify-clearance", "m", 1, "The modify clearance level")
+	ClientCmd.PersistentFlags().StringVar(&clientHost, "host", "127.0.0.1", "The server host (defaults to the cached session)")
+	ClientCmd.PersistentFlags().IntVar(&clientPort, "port", 42069, "The server port (defaults to the cached session)")
+	ClientCmd.PersistentFlags().StringVar(&clientCAFile, "ca", "", "The CA certificate to verify the server with")
+	ClientCmd.PersistentFlags().StringVar(&clientCertFile, "cert", "", "The client certificate file")
+	ClientCmd.PersistentFlags().StringVar(&clientKeyFile, "key", "", "The client key file")
+	ClientCmd.PersistentFlags().BoolVar(&clientInsecure, "insecure", false, "If we should skip verifying the server certificate")
+	ClientCmd.PersistentFlags().StringVar(&clientSessionFile, "session-file", "", "The session file to use (defaults to ~/.lily/session)")
+	ClientCmd.PersistentFlags().DurationVar(&clientTimeout, "timeout", 10*time.Second, "The request timeout")
+	ClientCmd.PersistentFlags().StringVarP(&clientDrive, "drive", "d", "", "The drive for paths without a drive")
+	ClientCmd.PersistentFlags().BoolVar(&clientJSON, "json", false, "If we should print JSON output")
+	ClientCmd.PersistentFlags().StringVar(&clientCompress, "compress", "", "Compress file transfers with zstd or gzip, or auto for the best the server supports")
+	ClientCmd.PersistentFlags().BoolVar(&clientChecksum, "checksum", false, "Verify a checksum of each transferred chunk")
+	ClientLoginCmd.PersistentFlags().BoolVar(&loginCertAuth, "cert-auth", false, "If we should log in with the client certificate")
+	ClientLoginCmd.PersistentFlags().StringVar(&loginLabel, "label", "lily client", "The session label")
+	ClientLoginCmd.PersistentFlags().DurationVar(&loginExpire, "expire", 0, "The session lifetime (defaults to the server's)")
+	ClientLoginCmd.PersistentFlags().StringVar(&loginTOTP, "totp", "", "The TOTP code")
+	ClientLoginCmd.PersistentFlags().StringVar(&loginRecoveryCode, "recovery-code", "", "A TOTP recovery code")
+	ClientLsCmd.PersistentFlags().BoolVarP(&lsLong, "long", "l", false, "If we should show the last edit time and editor")
+	ClientPutCmd.PersistentFlags().BoolVar(&putDelta, "delta", false, "If we should send only the changes to an existing file")
+	ClientPutCmd.PersistentFlags().IntVar(&putStreams, "streams", 1, "The number of connections to upload ranges of the file over")
+	ClientGetCmd.PersistentFlags().IntVar(&getStreams, "streams", 1, "The number of connections to download ranges of the file over")
+	ClientRmCmd.PersistentFlags().BoolVarP(&rmRecursive, "recursive", "r", false, "If we should remove directories")
+	ClientMkdirCmd.PersistentFlags().BoolVarP(&mkdirParents, "parents", "p", false, "If we should create missing parent directories")
+	ClientChmodCmd.PersistentFlags().IntVarP(&chmodAccess, "access", "a", 1, "The access clearance level")
+	ClientChmodCmd.PersistentFlags().IntVarP(&chmodModify, "modify", "m", 1, "The modify clearance level")
+	for _, list := range []string{"access-whitelist", "modify-whitelist", "access-blacklist", "modify-blacklist"} {
+		ClientChmodCmd.PersistentFlags().StringSlice("add-"+list, nil, "Users to add to the "+strings.Replace(list, "-", " ", 1))
+		ClientChmodCmd.PersistentFlags().StringSlice("remove-"+list, nil, "Users to remove from the "+strings.Replace(list, "-", " ", 1))
+	}
+	ClientSyncCmd.PersistentFlags().BoolVar(&syncDownload, "download", false, "If we should download the remote directory instead")
+	ClientSyncCmd.PersistentFlags().BoolVar(&syncDelete, "delete", false, "If we should delete files in the destination that are not in the source")
+	ClientSyncCmd.PersistentFlags().StringArrayVar(&syncInclude, "include", nil, "Only sync files matching the pattern")
+	ClientSyncCmd.PersistentFlags().StringArrayVar(&syncExclude, "exclude", nil, "Skip paths matching the pattern")
+	ClientSyncCmd.PersistentFlags().BoolVarP(&syncChecksum, "checksum", "c", false, "If we should compare the hashes of all files")
+	ClientSyncCmd.PersistentFlags().BoolVarP(&syncDryRun, "dry-run", "n", false, "If we should only print the changes")
+	ClientSyncCmd.PersistentFlags().IntVarP(&syncParallel, "parallel", "j", 4, "The number of files to transfer at once")
+	ClientSyncCmd.PersistentFlags().BoolVar(&syncDelta, "delta", false, "If we should send only the changes to large existing files")
+	ClientCallCmd.PersistentFlags().StringSliceVar(&callDurations, "duration", nil, "Parameters to parse as durations")
+	ClientUserCreateCmd.PersistentFlags().IntVarP(&clearance, "clearance", "c", 5, "The clearance level for the new user")
+	ClientUserSetPasswordCmd.PersistentFlags().BoolVar(&revokeSessions, "revoke-sessions", false, "If we should revoke the user's other sessions")
+	ClientShutdownCmd.PersistentFlags().DurationVar(&shutdownDeadline, "deadline", 0, "How long to wait for requests to finish (defaults to the server's)")
 
 	// Add the commands.
 	RootCmd.AddCommand(VersionCmd)
 	RootCmd.AddCommand(ServeCmd)
 	RootCmd.AddCommand(ConfigCmd)
 	RootCmd.AddCommand(DriveCmd)
+	RootCmd.AddCommand(CertCmd)
+	RootCmd.AddCommand(ClientCmd)
 	ConfigCmd.AddCommand(ConfigInitCmd)
 	ConfigCmd.AddCommand(ConfigSetCmd)
 	ConfigCmd.AddCommand(ConfigGetCmd)
@@ -247,11 +732,59 @@ func init() {
 	ConfigCmd.AddCommand(ConfigListDriveCmd)
 	ConfigCmd.AddCommand(ConfigAddUserCmd)
 	ConfigCmd.AddCommand(ConfigRemoveUserCmd)
+	ConfigCmd.AddCommand(ConfigResetUserTOTPCmd)
+	ConfigCmd.AddCommand(ConfigAddCertUserCmd)
+	ConfigCmd.AddCommand(ConfigRemoveCertUserCmd)
+	ConfigCmd.AddCommand(ConfigAddLDAPGroupCmd)
+	ConfigCmd.AddCommand(ConfigRemoveLDAPGroupCmd)
+	ConfigCmd.AddCommand(ConfigAddListenerCmd)
+	ConfigCmd.AddCommand(ConfigRemoveListenerCmd)
 	ConfigCmd.AddCommand(ConfigSetCertsCmd)
+	ConfigCmd.AddCommand(ConfigVerifyAuditLogCmd)
+	CertCmd.AddCommand(CertInitCmd)
+	CertCmd.AddCommand(CertIssueClientCmd)
+	CertCmd.AddCommand(CertRotateCmd)
 	DriveCmd.AddCommand(DriveInitCmd)
 	DriveCmd.AddCommand(DriveSetPathCmd)
 	DriveCmd.AddCommand(DriveReimportCmd)
 	DriveCmd.AddCommand(DriveSettingsCmd)
 	DriveCmd.AddCommand(DriveListCmd)
 	DriveCmd.AddCommand(DriveFixCmd)
+	ClientCmd.AddCommand(ClientLoginCmd)
+	ClientCmd.AddCommand(ClientLogoutCmd)
+	ClientCmd.AddCommand(ClientPingCmd)
+	ClientCmd.AddCommand(ClientInfoCmd)
+	ClientCmd.AddCommand(ClientLsCmd)
+	ClientCmd.AddCommand(ClientStatCmd)
+	ClientCmd.AddCommand(ClientGetCmd)
+	ClientCmd.AddCommand(ClientPutCmd)
+	ClientCmd.AddCommand(ClientMvCmd)
+	ClientCmd.AddCommand(ClientRmCmd)
+	ClientCmd.AddCommand(ClientMkdirCmd)
+	ClientCmd.AddCommand(ClientAccessCmd)
+	ClientCmd.AddCommand(ClientChmodCmd)
+	ClientCmd.AddCommand(ClientSyncCmd)
+	ClientCmd.AddCommand(ClientCallCmd)
+	ClientCmd.AddCommand(ClientShellCmd)
+	ClientCmd.AddCommand(ClientUserCmd)
+	ClientCmd.AddCommand(ClientSessionCmd)
+	ClientCmd.AddCommand(ClientDriveCmd)
+	ClientCmd.AddCommand(ClientSettingsCmd)
+	ClientCmd.AddCommand(ClientReloadCmd)
+	ClientCmd.AddCommand(ClientShutdownCmd)
+	ClientUserCmd.AddCommand(ClientUserListCmd)
+	ClientUserCmd.AddCommand(ClientUserInfoCmd)
+	ClientUserCmd.AddCommand(ClientUserCreateCmd)
+	ClientUserCmd.AddCommand(ClientUserDeleteCmd)
+	ClientUserCmd.AddCommand(ClientUserSetPasswordCmd)
+	ClientUserCmd.AddCommand(ClientUserSetClearanceCmd)
+	ClientSessionCmd.AddCommand(ClientSessionListCmd)
+	ClientSessionCmd.AddCommand(ClientSessionExpireCmd)
+	ClientSessionCmd.AddCommand(ClientSessionExpireAllCmd)
+	ClientSessionCmd.AddCommand(ClientSessionMineCmd)
+	ClientSessionCmd.AddCommand(ClientSessionRevokeCmd)
+	ClientDriveCmd.AddCommand(ClientDriveListCmd)
+	ClientDriveCmd.AddCommand(ClientDriveAddCmd)
+	ClientDriveCmd.AddCommand(ClientDriveRenameCmd)
+	ClientDriveCmd.AddCommand(ClientDriveRemoveCmd)
 }
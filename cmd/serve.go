@@ -55,13 +55,19 @@ _  /____/ /  _  /___  /
 		return
 	}
 
-	// Catch exit signals.
+	// Catch exit signals. SIGHUP reloads the server instead. Reload errors
+	// are logged, and the server keeps its current settings.
 	signal.Notify(sigc,
 		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
-	<-sigc
+	for sig := range sigc {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		s.Reload()
+	}
 
 	// Stop the server and its workers.
 	s.FullyClose()
@@ -0,0 +1,325 @@
+// cmd/shell.go
+// Interactive client shell.
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// If the shell is running.
+var shellRunning bool
+
+// Commands handled by the shell itself.
+var shellCommands = []string{"cd", "pwd", "help", "exit", "quit"}
+
+// Run the interactive shell.
+func ClientShell(cmd *cobra.Command, args []string) {
+	if shellRunning {
+		clientFail(errors.New("the shell is already running"))
+		return
+	}
+	shellRunning = true
+	defer func() { shellRunning = false }()
+	clientCwdDrive, clientCwd = clientDrive, "/"
+
+	// Keep the flags given to the shell for every command.
+	shellFlags := map[string]string{}
+	ClientCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			shellFlags[f.Name] = f.Value.String()
+		}
+	})
+	RootCmd.SilenceErrors, RootCmd.SilenceUsage = true, true
+	defer func() { RootCmd.SilenceErrors, RootCmd.SilenceUsage = false, false }()
+	run := func(line string) bool {
+		return runShellLine(line, shellFlags)
+	}
+
+	// Read commands line by line if stdin is not a terminal, such as a script.
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !run(scanner.Text()) {
+				return
+			}
+		}
+		return
+	}
+
+	// Read commands from the terminal, with tab completion. The terminal is
+	// only in raw mode while a line is read, so commands can prompt for
+	// passwords and print normally.
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return completeShellLine(t, line, pos)
+	}
+	for {
+		t.SetPrompt("lily " + clientCwdDrive + ":" + clientCwd + "> ")
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			clientFail(err)
+			return
+		}
+		line, err := t.ReadLine()
+		term.Restore(fd, state)
+		if err == io.EOF {
+			fmt.Println()
+			return
+		} else if err != nil {
+			clientFail(err)
+			return
+		}
+		if !run(line) {
+			return
+		}
+	}
+}
+
+// Run a line in the shell. Returns false if the shell should exit.
+func runShellLine(line string, shellFlags map[string]string) bool {
+	args, err := splitShellLine(line)
+	if err != nil {
+		clientFail(err)
+		return true
+	}
+	if len(args) == 0 {
+		return true
+	}
+	clientFailed = false
+	switch args[0] {
+	case "exit", "quit":
+		return false
+	case "pwd":
+		fmt.Println(clientCwdDrive + ":" + clientCwd)
+		return true
+	case "cd":
+		if len(args) > 2 {
+			clientFail(errors.New("cd takes one path"))
+			return true
+		}
+		shellCd(args[1:])
+		return true
+	case "help":
+		args = append(args[1:], "--help")
+	default:
+		if sub, _, err := ClientCmd.Find(args); err != nil || sub == ClientCmd {
+			clientFail(fmt.Errorf("unknown command %q", args[0]))
+			return true
+		}
+	}
+
+	// Run the client command, with the shell's flags.
+	resetFlags(RootCmd)
+	for name, value := range shellFlags {
+		ClientCmd.PersistentFlags().Set(name, value)
+	}
+	RootCmd.SetArgs(append([]string{"client"}, args...))
+	if err := RootCmd.Execute(); err != nil {
+		clientFail(err)
+	}
+	return true
+}
+
+// Change the current directory.
+func shellCd(args []string) {
+	arg := "/"
+	if len(args) == 1 {
+		arg = args[0]
+	}
+	drive, path, err := remotePath(arg)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	stat, err := statRemote(drive, path)
+	if err != nil {
+		clientFail(err)
+		return
+	}
+	if !stat.Exists || stat.IsFile {
+		clientFail(errors.New(stat.Path + " is not a directory"))
+		return
+	}
+	clientCwdDrive, clientCwd = drive, stat.Path
+}
+
+// Reset the flags of a command and its subcommands to their defaults, since
+// the shell runs commands more than once.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(splitList(strings.Trim(f.DefValue, "[]")))
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// Split a shell line into arguments. Arguments can be quoted, and characters
+// can be escaped with a backslash.
+func splitShellLine(line string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg, escaped := false, false
+	var quote rune
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// Escape spaces and special characters in a completed argument.
+func escapeShellArg(arg string) string {
+	var b strings.Builder
+	for _, r := range arg {
+		if strings.ContainsRune(" \t\\\"'", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Complete the word before the cursor. The first word is completed from the
+// command names, and later words from the remote paths.
+func completeShellLine(t *term.Terminal, line string, pos int) (string, int, bool) {
+	// Find the start of the word, skipping escaped spaces.
+	start := 0
+	for i := 0; i < pos; i++ {
+		if line[i] == '\\' {
+			i++
+		} else if line[i] == ' ' {
+			start = i + 1
+		}
+	}
+	args, err := splitShellLine(line[:start])
+	if err != nil {
+		return "", 0, false
+	}
+	words, err := splitShellLine(line[start:pos])
+	if err != nil || len(words) > 1 {
+		return "", 0, false
+	}
+	word := ""
+	if len(words) == 1 {
+		word = words[0]
+	}
+
+	// Get the candidates.
+	var dir string
+	var candidates []string
+	if len(args) == 0 {
+		candidates = append(candidates, shellCommands...)
+		for _, sub := range ClientCmd.Commands() {
+			if !sub.Hidden {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+	} else {
+		// Split the word into the directory and the name being completed.
+		i := strings.LastIndexAny(word, "/:")
+		dir = word[:i+1]
+		listPath := dir
+		if listPath == "" {
+			listPath = "."
+		}
+		drive, path, err := remotePath(listPath)
+		if err != nil {
+			return "", 0, false
+		}
+		entries, err := listRemote(drive, path)
+		if err != nil {
+			return "", 0, false
+		}
+		for _, e := range entries {
+			if e.IsFile {
+				candidates = append(candidates, e.Name)
+			} else {
+				candidates = append(candidates, e.Name+"/")
+			}
+		}
+	}
+	prefix := word[len(dir):]
+	matches := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+
+	// Complete the longest common prefix of the matches, and show the matches
+	// if there is nothing more to complete.
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(matches) > 1 && common == prefix {
+		fmt.Fprintln(t, strings.Join(matches, "  "))
+		return "", 0, false
+	}
+	completed := escapeShellArg(dir + common)
+	if len(matches) == 1 && !strings.HasSuffix(common, "/") {
+		completed += " "
+	}
+	newLine := line[:start] + completed + line[pos:]
+	return newLine, start + len(completed), true
+}
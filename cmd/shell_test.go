@@ -0,0 +1,102 @@
+// cmd/shell_test.go
+// Testing for cmd/shell.go.
+
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+// Test splitting shell lines into arguments.
+func TestSplitShellLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+		valid    bool
+	}{
+		{"", []string{}, true},
+		{"   ", []string{}, true},
+		{"ls", []string{"ls"}, true},
+		{"  get  main:foo\tbar ", []string{"get", "main:foo", "bar"}, true},
+		{`put "my file" 'other file'`, []string{"put", "my file", "other file"}, true},
+		{`cd my\ dir`, []string{"cd", "my dir"}, true},
+		{`rm "a \"b\""`, []string{"rm", `a "b"`}, true},
+		{`rm 'a\b'`, []string{"rm", `a\b`}, true},
+		{`mkdir ""`, []string{"mkdir", ""}, true},
+		{`a"b"c`, []string{"abc"}, true},
+		{`ls "foo`, nil, false},
+		{`ls foo\`, nil, false},
+	}
+	for _, test := range tests {
+		args, err := splitShellLine(test.line)
+		if (err == nil) != test.valid || !reflect.DeepEqual(args, test.expected) {
+			t.Error(test.line, args, err)
+		}
+	}
+}
+
+// Test escaping completed arguments.
+func TestEscapeShellArg(t *testing.T) {
+	tests := []struct {
+		arg      string
+		expected string
+	}{
+		{"foo", "foo"},
+		{"my file", `my\ file`},
+		{`a"b'c\d`, `a\"b\'c\\d`},
+		{"tab\there", "tab\\\there"},
+	}
+	for _, test := range tests {
+		escaped := escapeShellArg(test.arg)
+		if escaped != test.expected {
+			t.Error(test.arg, escaped)
+		}
+
+		// Escaped arguments should split back into the argument.
+		if args, err := splitShellLine(escaped); err != nil || len(args) != 1 || args[0] != test.arg {
+			t.Error(test.arg, args, err)
+		}
+	}
+}
+
+// Test completing command names.
+func TestCompleteShellCommand(t *testing.T) {
+	var out bytes.Buffer
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), &out}, "")
+	tests := []struct {
+		line     string
+		pos      int
+		expected string
+		ok       bool
+	}{
+		{"pw", 2, "pwd ", true},
+		{"exi", 3, "exit ", true},
+		{"pwd", 3, "pwd ", true},
+		{"lo", 2, "log", true},
+		{"zzz", 3, "", false},
+		{"pw foo", 2, "pwd  foo", true},
+	}
+	for _, test := range tests {
+		line, pos, ok := completeShellLine(terminal, test.line, test.pos)
+		if ok != test.ok || line != test.expected {
+			t.Error(test.line, line, ok)
+		}
+		if ok && pos != len(line)-len(test.line)+test.pos {
+			t.Error(test.line, pos)
+		}
+	}
+
+	// Ambiguous words with nothing more to complete show the matches.
+	out.Reset()
+	if _, _, ok := completeShellLine(terminal, "log", 3); ok || !bytes.Contains(out.Bytes(), []byte("login  logout")) {
+		t.Error(out.String())
+	}
+}
@@ -6,13 +6,23 @@ package commands
 import (
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/cubeflix/lily/drive"
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/audit"
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/ldapauth"
+	"github.com/cubeflix/lily/security/userlimit"
+	"github.com/cubeflix/lily/server/config"
 	"github.com/cubeflix/lily/session"
 	"github.com/cubeflix/lily/user"
 )
 
+// The default number of audit records returned by the get audit log command.
+const DefaultAuditLogLimit = 100
+
 // Get all users command.
 func GetAllUsersCommand(c *Command) error {
 	userObj, _, err := authUserOrSession(c)
@@ -62,6 +72,7 @@ func GetUserInfoCommand(c *Command) error {
 			Username:     userObjs[i].GetUsername(),
 			Clearance:    int(userObjs[i].GetClearance()),
 			PasswordHash: userObjs[i].GetPasswordHash(),
+			TOTPEnabled:  userObjs[i].IsTOTPEnabled(),
 		}
 	}
 	c.Respond(0, "", map[string]interface{}{"info": userInfo})
@@ -144,6 +155,17 @@ func SetUserPasswordCommand(c *Command) error {
 		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
 		return nil
 	}
+	if !checkPasswords(c, passwords) {
+		return nil
+	}
+	revokeSessions := false
+	if _, ok := c.Params["revokeSessions"]; ok {
+		revokeSessions, err = getBool(c, "revokeSessions")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
 
 	// Get the users.
 	userObjs, err := c.Server.Users().GetUsersByName(users)
@@ -151,12 +173,16 @@ func SetUserPasswordCommand(c *Command) error {
 		c.Respond(21, "Username not found.", map[string]interface{}{})
 		return nil
 	}
+	hashParams := c.Server.Config().GetPasswordHashing()
 	for i := range userObjs {
-		err = userObjs[i].SetPassword(passwords[i])
+		err = userObjs[i].SetPassword(passwords[i], hashParams)
 		if err != nil {
 			c.Respond(22, "Failed to hash password.", map[string]interface{}{})
 			return nil
 		}
+		if revokeSessions {
+			revokeOtherSessions(c, users[i])
+		}
 	}
 	c.Server.Users().SetDirty(true)
 	c.Respond(0, "", map[string]interface{}{})
@@ -202,10 +228,14 @@ func CreateUsersCommand(c *Command) error {
 		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
 		return nil
 	}
+	if !checkPasswords(c, passwords) {
+		return nil
+	}
 
 	// Get the users.
+	hashParams := c.Server.Config().GetPasswordHashing()
 	for i := range users {
-		uobj, err := user.NewUser(users[i], passwords[i], access.Clearance(clearances[i]))
+		uobj, err := user.NewUserWithParams(users[i], passwords[i], access.Clearance(clearances[i]), hashParams)
 		if err != nil {
 			c.Respond(22, "Failed to hash password.", map[string]interface{}{})
 			return nil
@@ -217,6 +247,39 @@ func CreateUsersCommand(c *Command) error {
 	return nil
 }
 
+// Reset user two-factor authentication command.
+func ResetUserTOTPCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	users, err := getListOfStrings(c, "users")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the users.
+	userObjs, err := c.Server.Users().GetUsersByName(users)
+	if err != nil {
+		c.Respond(21, "Username not found.", map[string]interface{}{})
+		return nil
+	}
+	for i := range userObjs {
+		userObjs[i].ResetTOTP()
+	}
+	c.Server.Users().SetDirty(true)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
 // Delete users command.
 func DeleteUsersCommand(c *Command) error {
 	userObj, _, err := authUserOrSession(c)
@@ -334,12 +397,7 @@ func GetSessionInfoCommand(c *Command) error {
 		if err != nil {
 			return err
 		}
-		sessionInfo[i] = session.SessionInfo{
-			ID:          bytes,
-			Username:    sessionObjs[i].GetUsername(),
-			ExpireAfter: sessionObjs[i].GetExpireAfter(),
-			ExpireAt:    sessionObjs[i].GetExpireAt().Unix(),
-		}
+		sessionInfo[i] = sessionObjs[i].GetInfo(bytes)
 	}
 	c.Respond(0, "", map[string]interface{}{"sessions": sessionInfo})
 	return nil
@@ -408,22 +466,104 @@ func GetAllSettingsCommand(c *Command) error {
 	cronInterval, sessionInterval := c.Server.Config().GetCronIntervals()
 	verbose, logToFile, logJSON, logLevel, logFile := c.Server.Config().GetLogging()
 	limit, maxLimitEvents := c.Server.Config().GetRateLimit()
+	clientCAFile, clientAuthMode := c.Server.Config().GetClientAuth()
+	ldapMode, ldapAutoProvision, ldapSettings := c.Server.Config().GetLDAP()
+	userLockoutThreshold, ipLockoutThreshold, lockoutDuration, lockoutMaxDuration := c.Server.Config().GetLockout()
+	passwordPolicy := c.Server.Config().GetPasswordPolicy()
+	sessionIdleTimeout, sessionMaxLifetime := c.Server.Config().GetSessionTimeouts()
+	hashParams := c.Server.Config().GetPasswordHashing()
+	sessionBindingMode, sessionBindingIPv4Prefix, sessionBindingIPv6Prefix := c.Server.Config().GetSessionBinding()
+	auditFile, auditHashChain := c.Server.Config().GetAudit()
+	userLimits := c.Server.Config().GetUserLimits()
+	uploadRate, downloadRate := c.Server.Config().GetBandwidthLimits()
+	ipAllowList, ipDenyList := c.Server.Config().GetIPFilter()
+	maxConnections, maxConnectionsPerIP := c.Server.Config().GetConnectionLimits()
+	maxChunkSize, memoryBudget, maxUploadSize := c.Server.Config().GetTransferLimits()
+	_, memoryBudgetUsed := c.Server.MemoryBudget().Usage()
+	listeners := map[string]interface{}{}
+	for _, l := range c.Server.Config().GetListeners() {
+		listeners[l.Name] = map[string]interface{}{
+			"network":        l.Network,
+			"address":        l.Address,
+			"limit":          l.Limit,
+			"maxLimitEvents": l.MaxLimitEvents,
+			"ipAllowList":    l.IPAllowList,
+			"ipDenyList":     l.IPDenyList,
+			"peerUsers":      l.PeerUsers,
+		}
+	}
 	c.Respond(0, "", map[string]interface{}{
-		"host":                host,
-		"port":                port,
-		"drives":              c.Server.GetDriveNames(),
-		"driveFiles":          c.Server.Config().GetDriveFiles(),
-		"numWorkers":          c.Server.Config().GetNumWorkers(),
-		"mainCronInterval":    cronInterval,
-		"sessionCronInterval": sessionInterval,
-		"networkTimeout":      c.Server.Config().GetTimeout(),
-		"verbose":             verbose,
-		"logToFile":           logToFile,
-		"logJSON":             logJSON,
-		"logLevel":            logLevel,
-		"logFile":             logFile,
-		"limit":               limit,
-		"maxLimitEvents":      maxLimitEvents,
+		"host":                     host,
+		"port":                     port,
+		"drives":                   c.Server.GetDriveNames(),
+		"driveFiles":               c.Server.Config().GetDriveFiles(),
+		"numWorkers":               c.Server.Config().GetNumWorkers(),
+		"mainCronInterval":         cronInterval,
+		"sessionCronInterval":      sessionInterval,
+		"networkTimeout":           c.Server.Config().GetTimeout(),
+		"shutdownDeadline":         c.Server.Config().GetShutdownDeadline(),
+		"sessionFile":              c.Server.Config().GetSessionFile(),
+		"sessionIdleTimeout":       sessionIdleTimeout,
+		"sessionMaxLifetime":       sessionMaxLifetime,
+		"sessionBinding":           sessionBindingMode.String(),
+		"sessionBindingIPv4Prefix": sessionBindingIPv4Prefix,
+		"sessionBindingIPv6Prefix": sessionBindingIPv6Prefix,
+		"auditFile":                auditFile,
+		"auditHashChain":           auditHashChain,
+		"metricsAddress":           c.Server.Config().GetMetricsAddress(),
+		"verbose":                  verbose,
+		"logToFile":                logToFile,
+		"logJSON":                  logJSON,
+		"logLevel":                 logLevel,
+		"logFile":                  logFile,
+		"limit":                    limit,
+		"maxLimitEvents":           maxLimitEvents,
+		"userRequests":             userLimits.Requests,
+		"userRequestInterval":      userLimits.Interval,
+		"userUploadRate":           userLimits.UploadRate,
+		"userDownloadRate":         userLimits.DownloadRate,
+		"uploadRate":               uploadRate,
+		"downloadRate":             downloadRate,
+		"ipAllowList":              ipAllowList,
+		"ipDenyList":               ipDenyList,
+		"trustedProxies":           c.Server.Config().GetTrustedProxies(),
+		"maxConnections":           maxConnections,
+		"maxConnectionsPerIP":      maxConnectionsPerIP,
+		"openConnections":          c.Server.OpenConnections(),
+		"maxChunkSize":             maxChunkSize,
+		"memoryBudget":             memoryBudget,
+		"memoryBudgetUsed":         memoryBudgetUsed,
+		"maxUploadSize":            maxUploadSize,
+		"totpRequiredClearance":    c.Server.Config().GetTOTPRequiredClearance(),
+		"userLockoutThreshold":     userLockoutThreshold,
+		"ipLockoutThreshold":       ipLockoutThreshold,
+		"lockoutDuration":          lockoutDuration,
+		"lockoutMaxDuration":       lockoutMaxDuration,
+		"passwordMinLength":        passwordPolicy.MinLength,
+		"passwordRequireUpper":     passwordPolicy.RequireUpper,
+		"passwordRequireLower":     passwordPolicy.RequireLower,
+		"passwordRequireDigit":     passwordPolicy.RequireDigit,
+		"passwordRequireSymbol":    passwordPolicy.RequireSymbol,
+		"passwordDenyListFile":     passwordPolicy.DenyListFile,
+		"passwordHash":             hashParams.Algorithm.String(),
+		"bcryptCost":               hashParams.BcryptCost,
+		"argon2Time":               int(hashParams.Argon2Time),
+		"argon2Memory":             int(hashParams.Argon2Memory),
+		"argon2Threads":            int(hashParams.Argon2Threads),
+		"clientCAFile":             clientCAFile,
+		"clientAuth":               clientAuthMode.String(),
+		"certUsers":                c.Server.Config().GetCertUsers(),
+		"ldapMode":                 ldapMode.String(),
+		"ldapAutoProvision":        ldapAutoProvision,
+		"ldapURL":                  ldapSettings.URL,
+		"ldapStartTLS":             ldapSettings.StartTLS,
+		"ldapInsecureSkipVerify":   ldapSettings.InsecureSkipVerify,
+		"ldapBindDN":               ldapSettings.BindDN,
+		"ldapGroupBaseDN":          ldapSettings.GroupBaseDN,
+		"ldapGroupFilter":          ldapSettings.GroupFilter,
+		"ldapGroups":               ldapSettings.GroupClearances,
+		"ldapDefaultClearance":     ldapSettings.DefaultClearance,
+		"listeners":                listeners,
 	})
 	return nil
 }
@@ -761,8 +901,8 @@ func SetRateLimitCommand(c *Command) error {
 	return nil
 }
 
-// Shutdown command.
-func ShutdownCommand(c *Command) error {
+// Set TOTP required clearance command.
+func SetTOTPRequiredClearanceCommand(c *Command) error {
 	userObj, _, err := authUserOrSession(c)
 	if err != nil {
 		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
@@ -773,9 +913,1030 @@ func ShutdownCommand(c *Command) error {
 		return nil
 	}
 
-	c.Server.GetPublicStopChan() <- os.Interrupt
+	// Get the arguments.
+	clearance, err := getInt(c, "clearance")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	err = c.Server.Config().SetTOTPRequiredClearance(clearance)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
 
-	// Don't really need to respond, but we don't want a panic.
+// Set client certificate authentication command.
+func SetClientAuthCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	clientCAFile, err := getString(c, "clientCAFile")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	modeName, err := getString(c, "clientAuth")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	mode, err := config.ParseClientAuthMode(modeName)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetClientAuth(clientCAFile, mode)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Add certificate users command.
+func AddCertUsersCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	identities, err := getListOfStrings(c, "identities")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	usernames, err := getListOfStrings(c, "users")
+	if err != nil || len(usernames) != len(identities) {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	users := map[string]string{}
+	for i := range identities {
+		users[identities[i]] = usernames[i]
+	}
+
+	err = c.Server.Config().AddCertUsers(users)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Remove certificate users command.
+func RemoveCertUsersCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	identities, err := getListOfStrings(c, "identities")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().RemoveCertUsers(identities)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set LDAP authentication command.
+func SetLDAPCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	modeName, err := getString(c, "mode")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	mode, err := config.ParseLDAPMode(modeName)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	autoProvision, err := getBool(c, "autoProvision")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	settings := ldapauth.Settings{GroupClearances: map[string]int{}}
+	for name, str := range map[string]*string{"url": &settings.URL, "bindDN": &settings.BindDN,
+		"groupBaseDN": &settings.GroupBaseDN, "groupFilter": &settings.GroupFilter} {
+		*str, err = getString(c, name)
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	settings.StartTLS, err = getBool(c, "startTLS")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	settings.InsecureSkipVerify, err = getBool(c, "insecureSkipVerify")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	groups, err := getListOfStrings(c, "groups")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	clearances, err := getListOfInts(c, "clearances")
+	if err != nil || len(clearances) != len(groups) {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	for i := range groups {
+		settings.GroupClearances[groups[i]] = clearances[i]
+	}
+	settings.DefaultClearance, err = getInt(c, "defaultClearance")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetLDAP(mode, autoProvision, settings)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set lockout settings command.
+func SetLockoutCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	userThreshold, err := getInt(c, "userThreshold")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	ipThreshold, err := getInt(c, "ipThreshold")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	duration, err := getDuration(c, "duration")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	maxDuration, err := getDuration(c, "maxDuration")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetLockout(userThreshold, ipThreshold, duration, maxDuration)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Update the active trackers.
+	userPolicy, ipPolicy := c.Server.Config().GetLockoutPolicies()
+	c.Server.UserLockout().SetPolicy(userPolicy)
+	c.Server.IPLockout().SetPolicy(ipPolicy)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Get locked accounts command.
+func GetLockedAccountsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the locked users and IP addresses.
+	now := time.Now()
+	c.Respond(0, "", map[string]interface{}{
+		"users": c.Server.UserLockout().GetLocked(now),
+		"ips":   c.Server.IPLockout().GetLocked(now),
+	})
+	return nil
+}
+
+// Unlock accounts command.
+func UnlockAccountsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments. Either list may be omitted.
+	users, err := getListOfStrings(c, "users")
+	if err != nil {
+		if _, ok := c.Params["users"]; ok {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	ips, err := getListOfStrings(c, "ips")
+	if err != nil {
+		if _, ok := c.Params["ips"]; ok {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	// Unlock.
+	now := time.Now()
+	usersOK := c.Server.UserLockout().Unlock(users, now)
+	ipsOK := c.Server.IPLockout().Unlock(ips, now)
+	if !usersOK || !ipsOK {
+		c.Respond(33, "Account or address not locked.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set password policy command.
+func SetPasswordPolicyCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	policy := auth.PasswordPolicy{}
+	policy.MinLength, err = getInt(c, "minLength")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	for name, b := range map[string]*bool{"requireUpper": &policy.RequireUpper, "requireLower": &policy.RequireLower,
+		"requireDigit": &policy.RequireDigit, "requireSymbol": &policy.RequireSymbol} {
+		*b, err = getBool(c, name)
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	policy.DenyListFile, err = getString(c, "denyListFile")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Set the policy and load the deny-list, restoring the old policy if the
+	// deny-list cannot be loaded.
+	oldPolicy := c.Server.Config().GetPasswordPolicy()
+	if err := c.Server.Config().SetPasswordPolicy(policy); err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	if err := c.Server.Config().LoadPasswordDenyList(); err != nil {
+		c.Server.Config().SetPasswordPolicy(oldPolicy)
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set password hashing command.
+func SetPasswordHashingCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	algorithmName, err := getString(c, "algorithm")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	algorithm, err := auth.ParseAlgorithm(algorithmName)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	values := map[string]int{}
+	for _, name := range []string{"bcryptCost", "argon2Time", "argon2Memory", "argon2Threads"} {
+		values[name], err = getInt(c, name)
+		if err != nil || values[name] < 0 {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	if values["argon2Threads"] > 255 {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	params := auth.HashParams{
+		Algorithm:     algorithm,
+		BcryptCost:    values["bcryptCost"],
+		Argon2Time:    uint32(values["argon2Time"]),
+		Argon2Memory:  uint32(values["argon2Memory"]),
+		Argon2Threads: uint8(values["argon2Threads"]),
+	}
+
+	// Set the parameters. Existing hashes are upgraded when users log in.
+	if err := c.Server.Config().SetPasswordHashing(params); err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set session binding command.
+func SetSessionBindingCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments. The prefix lengths are optional.
+	modeName, err := getString(c, "mode")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	mode, err := config.ParseSessionBindingMode(modeName)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	_, ipv4Prefix, ipv6Prefix := c.Server.Config().GetSessionBinding()
+	if _, ok := c.Params["ipv4Prefix"]; ok {
+		ipv4Prefix, err = getInt(c, "ipv4Prefix")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	if _, ok := c.Params["ipv6Prefix"]; ok {
+		ipv6Prefix, err = getInt(c, "ipv6Prefix")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	err = c.Server.Config().SetSessionBinding(mode, ipv4Prefix, ipv6Prefix)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Get audit log command.
+func GetAuditLogCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+	if c.Server.Audit() == nil {
+		c.Respond(35, "Audit log not enabled.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments. All of them are optional.
+	limit := DefaultAuditLogLimit
+	if _, ok := c.Params["limit"]; ok {
+		limit, err = getInt(c, "limit")
+		if err != nil || limit < 0 {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	filters := map[string]string{}
+	for _, name := range []string{"user", "command", "drive"} {
+		if _, ok := c.Params[name]; ok {
+			filters[name], err = getString(c, name)
+			if err != nil {
+				c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+				return nil
+			}
+		}
+	}
+
+	// Get the records.
+	records := c.Server.Audit().Recent(limit, func(r audit.Record) bool {
+		if username, ok := filters["user"]; ok && r.User != username {
+			return false
+		}
+		if command, ok := filters["command"]; ok && !strings.EqualFold(r.Command, command) {
+			return false
+		}
+		if drive, ok := filters["drive"]; ok && r.Drive != drive {
+			return false
+		}
+		return true
+	})
+	list := make([]map[string]interface{}, len(records))
+	for i := range records {
+		list[i] = map[string]interface{}{
+			"time":     records[i].Time.Unix(),
+			"user":     records[i].User,
+			"authType": records[i].AuthType,
+			"ip":       records[i].IP,
+			"command":  records[i].Command,
+			"drive":    records[i].Drive,
+			"paths":    records[i].Paths,
+			"code":     records[i].Code,
+			"bytesIn":  int64(records[i].BytesIn),
+			"bytesOut": int64(records[i].BytesOut),
+			"duration": records[i].Duration,
+		}
+	}
+	c.Respond(0, "", map[string]interface{}{"records": list})
+	return nil
+}
+
+// Verify audit log command.
+func VerifyAuditLogCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+	if c.Server.Audit() == nil {
+		c.Respond(35, "Audit log not enabled.", map[string]interface{}{})
+		return nil
+	}
+
+	// Verify the hash chain.
+	n, err := c.Server.Audit().Verify()
+	if err == audit.ErrBrokenChain {
+		c.Respond(0, "", map[string]interface{}{"valid": false, "records": n})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	c.Respond(0, "", map[string]interface{}{"valid": true, "records": n})
+	return nil
+}
+
+// Get per-user limits from the command parameters.
+func getUserLimits(c *Command) (userlimit.Limits, error) {
+	requests, err := getInt(c, "requests")
+	if err != nil {
+		return userlimit.Limits{}, err
+	}
+	interval, err := getDuration(c, "interval")
+	if err != nil {
+		return userlimit.Limits{}, err
+	}
+	uploadRate, err := getInt(c, "uploadRate")
+	if err != nil {
+		return userlimit.Limits{}, err
+	}
+	downloadRate, err := getInt(c, "downloadRate")
+	if err != nil {
+		return userlimit.Limits{}, err
+	}
+	return userlimit.Limits{
+		Requests:     requests,
+		Interval:     interval,
+		UploadRate:   int64(uploadRate),
+		DownloadRate: int64(downloadRate),
+	}, nil
+}
+
+// Update the active per-user limiter from the config.
+func updateUserLimiter(c *Command) {
+	c.Server.UserLimiter().SetLimits(c.Server.Config().GetUserLimits(), c.Server.Config().GetUserLimitOverrides())
+}
+
+// Set user limits command.
+func SetUserLimitsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	limits, err := getUserLimits(c)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetUserLimits(limits)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	updateUserLimiter(c)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set user limit override command.
+func SetUserLimitOverrideCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	username, err := getString(c, "user")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	limits, err := getUserLimits(c)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetUserLimitOverride(username, limits)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	updateUserLimiter(c)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Remove user limit overrides command.
+func RemoveUserLimitOverridesCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	usernames, err := getListOfStrings(c, "users")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().RemoveUserLimitOverrides(usernames)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	updateUserLimiter(c)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Get user limit overrides command.
+func GetUserLimitOverridesCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the overrides.
+	overrides := map[string]interface{}{}
+	for username, limits := range c.Server.Config().GetUserLimitOverrides() {
+		overrides[username] = map[string]interface{}{
+			"requests":     limits.Requests,
+			"interval":     limits.Interval,
+			"uploadRate":   limits.UploadRate,
+			"downloadRate": limits.DownloadRate,
+		}
+	}
+	c.Respond(0, "", map[string]interface{}{"overrides": overrides})
+	return nil
+}
+
+// Set bandwidth limits command.
+func SetBandwidthLimitsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	uploadRate, err := getInt(c, "uploadRate")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	downloadRate, err := getInt(c, "downloadRate")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetBandwidthLimits(int64(uploadRate), int64(downloadRate))
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Update the active throttles.
+	upload, download := c.Server.BandwidthThrottles()
+	upload.SetRate(int64(uploadRate))
+	download.SetRate(int64(downloadRate))
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set IP filter command.
+func SetIPFilterCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	allow, err := getListOfStrings(c, "allow")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	deny, err := getListOfStrings(c, "deny")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetIPFilter(allow, deny)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set trusted proxies command.
+func SetTrustedProxiesCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	proxies, err := getListOfStrings(c, "proxies")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetTrustedProxies(proxies)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set connection limits command.
+func SetConnectionLimitsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	maxConnections, err := getInt(c, "maxConnections")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	maxConnectionsPerIP, err := getInt(c, "maxConnectionsPerIP")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetConnectionLimits(maxConnections, maxConnectionsPerIP)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set transfer limits command.
+func SetTransferLimitsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	maxChunkSize, err := getInt(c, "maxChunkSize")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	memoryBudget, err := getInt64(c, "memoryBudget")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	maxUploadSize, err := getInt64(c, "maxUploadSize")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().SetTransferLimits(maxChunkSize, memoryBudget, maxUploadSize)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Update the active memory budget.
+	c.Server.MemoryBudget().SetLimit(memoryBudget)
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Add listener command. Note that this will not update the active server
+// until it is restarted.
+func AddListenerCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	l := config.Listener{}
+	if l.Name, err = getString(c, "name"); err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	if l.Network, err = getString(c, "network"); err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	if l.Address, err = getString(c, "address"); err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the optional rate limit, IP lists and peer users.
+	if _, ok := c.Params["maxLimitEvents"]; ok {
+		if l.Limit, err = getDuration(c, "limit"); err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+		if l.MaxLimitEvents, err = getInt(c, "maxLimitEvents"); err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	if _, ok := c.Params["ipAllowList"]; ok {
+		if l.IPAllowList, err = getListOfStrings(c, "ipAllowList"); err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	if _, ok := c.Params["ipDenyList"]; ok {
+		if l.IPDenyList, err = getListOfStrings(c, "ipDenyList"); err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+	if _, ok := c.Params["osUsers"]; ok {
+		osUsers, err := getListOfStrings(c, "osUsers")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+		usernames, err := getListOfStrings(c, "users")
+		if err != nil || len(usernames) != len(osUsers) {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+		l.PeerUsers = map[string]string{}
+		for i := range osUsers {
+			l.PeerUsers[osUsers[i]] = usernames[i]
+		}
+	}
+
+	err = c.Server.Config().SetListener(l)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Remove listeners command. Note that this will not update the active server
+// until it is restarted.
+func RemoveListenersCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	names, err := getListOfStrings(c, "names")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	err = c.Server.Config().RemoveListeners(names)
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Shutdown command.
+func ShutdownCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the optional shutdown deadline.
+	if _, ok := c.Params["deadline"]; ok {
+		deadline, err := getDuration(c, "deadline")
+		if err != nil || deadline < 0 {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+		c.Server.SetShutdownDeadline(deadline)
+	}
+
+	// The server waits for in-flight commands, including this one, before
+	// stopping.
+	c.Server.GetPublicStopChan() <- os.Interrupt
+
+	// Don't really need to respond, but we don't want a panic.
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Reload command.
+func ReloadCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if !userObj.IsClearanceSufficient(access.ClearanceLevelFive) {
+		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{})
+		return nil
+	}
+
+	// Reload the server file and certificates.
+	if err := c.Server.Reload(); err != nil {
+		c.Respond(37, "Failed to reload server.", map[string]interface{}{"error": err.Error()})
+		return nil
+	}
 	c.Respond(0, "", map[string]interface{}{})
 	return nil
 }
@@ -7,10 +7,15 @@ package commands
 
 import (
 	"os"
+	"time"
 
 	"github.com/cubeflix/lily/drive"
+	"github.com/cubeflix/lily/metrics"
 	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/security/audit"
 	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/lockout"
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server/config"
 	sessionlist "github.com/cubeflix/lily/session/list"
 	userlist "github.com/cubeflix/lily/user/list"
@@ -30,7 +35,17 @@ type Server interface {
 	GetDrive(string) (*drive.Drive, bool)
 	SetDrive(string, *drive.Drive)
 	GetPublicStopChan() chan os.Signal
+	SetShutdownDeadline(time.Duration)
+	Reload() error
 	GetMemUsage() (uint64, uint64, uint64)
+	UserLockout() *lockout.Tracker
+	IPLockout() *lockout.Tracker
+	UserLimiter() *userlimit.Limiter
+	BandwidthThrottles() (*network.Throttle, *network.Throttle)
+	MemoryBudget() *network.MemoryBudget
+	OpenConnections() int
+	Audit() *audit.Log
+	Metrics() *metrics.ServerMetrics
 }
 
 // The basic command object.
@@ -42,6 +57,16 @@ type Command struct {
 	Params map[string]interface{}
 	Chunks *network.ChunkHandler
 
+	// The remote IP address of the client, if known.
+	IP string
+
+	// The fingerprint of the verified client certificate, if any.
+	CertFingerprint string
+
+	// If the user reached their request limit once their credentials were
+	// verified.
+	rateLimited bool
+
 	RespCode   int
 	RespString string
 	RespData   map[string]interface{}
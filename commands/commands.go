@@ -6,6 +6,9 @@ package commands
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/cubeflix/lily/user"
 )
 
 // Commands map object.
@@ -39,11 +42,62 @@ var COMMANDS = map[string]func(*Command) error{
 	"setloggingsettings": SetLoggingSettingsCommand,
 	"setratelimit":       SetRateLimitCommand,
 	"shutdown":           ShutdownCommand,
+	"reload":             ReloadCommand,
 	"getmemoryusage":     GetMemoryUsageCommand,
 
 	// User commands.
 	"setpassword": SetPasswordCommand,
 
+	// Two-factor authentication commands.
+	"setuptotp":                SetupTOTPCommand,
+	"enabletotp":               EnableTOTPCommand,
+	"disabletotp":              DisableTOTPCommand,
+	"resetusertotp":            ResetUserTOTPCommand,
+	"settotprequiredclearance": SetTOTPRequiredClearanceCommand,
+
+	// Client certificate authentication commands.
+	"setclientauth":   SetClientAuthCommand,
+	"addcertusers":    AddCertUsersCommand,
+	"removecertusers": RemoveCertUsersCommand,
+
+	// LDAP authentication commands.
+	"setldap": SetLDAPCommand,
+
+	// Account lockout commands.
+	"setlockout":        SetLockoutCommand,
+	"getlockedaccounts": GetLockedAccountsCommand,
+	"unlockaccounts":    UnlockAccountsCommand,
+
+	// Self-service session commands.
+	"listmysessions":   ListMySessionsCommand,
+	"revokemysessions": RevokeMySessionsCommand,
+
+	// Session binding commands.
+	"setsessionbinding": SetSessionBindingCommand,
+
+	// Audit log commands.
+	"getauditlog":    GetAuditLogCommand,
+	"verifyauditlog": VerifyAuditLogCommand,
+
+	// Per-user limit commands.
+	"setuserlimits":            SetUserLimitsCommand,
+	"setuserlimitoverride":     SetUserLimitOverrideCommand,
+	"removeuserlimitoverrides": RemoveUserLimitOverridesCommand,
+	"getuserlimitoverrides":    GetUserLimitOverridesCommand,
+	"setbandwidthlimits":       SetBandwidthLimitsCommand,
+
+	// Listener commands.
+	"setipfilter":         SetIPFilterCommand,
+	"settrustedproxies":   SetTrustedProxiesCommand,
+	"setconnectionlimits": SetConnectionLimitsCommand,
+	"settransferlimits":   SetTransferLimitsCommand,
+	"addlistener":         AddListenerCommand,
+	"removelisteners":     RemoveListenersCommand,
+
+	// Password policy commands.
+	"setpasswordpolicy":  SetPasswordPolicyCommand,
+	"setpasswordhashing": SetPasswordHashingCommand,
+
 	// Session commands.
 	"reauthenticate":    ReauthenticateCommand,
 	"setexpirationtime": SetExpirationTimeCommand,
@@ -65,6 +119,12 @@ var COMMANDS = map[string]func(*Command) error{
 	"stat":         StatCommand,
 	"rehashfiles":  RehashFilesCommand,
 	"verifyhashes": VerifyHashesCommand,
+	"getsignature": GetSignatureCommand,
+	"patchfile":    PatchFileCommand,
+	"beginupload":  BeginUploadCommand,
+	"writerange":   WriteRangeCommand,
+	"commitupload": CommitUploadCommand,
+	"abortupload":  AbortUploadCommand,
 
 	"getpathsettings":               GetSettingsCommand,
 	"setpathsettings":               SetSettingsCommand,
@@ -79,10 +139,22 @@ var COMMANDS = map[string]func(*Command) error{
 	"removefrompathmodifyblacklist": RemoveFromModifyBlacklistCommand,
 }
 
+// Commands which transfer chunks, and reserve memory from the memory budget.
+var TRANSFER_COMMANDS = map[string]bool{
+	"readfiles":    true,
+	"writefiles":   true,
+	"getsignature": true,
+	"patchfile":    true,
+	"writerange":   true,
+}
+
 // Execute a given command. We won't bother with timeouts here since the code
 // should function without ever locking up. If it does happen to freeze, then
 // something more serious is wrong.
 func ExecuteCommand(c *Command) {
+	// Audit the command and record its metrics once it has been handled.
+	defer auditCommand(c, time.Now())
+	defer observeCommand(c, time.Now())
 	defer func() {
 		if err := recover(); err != nil {
 			// Panicked.
@@ -98,6 +170,33 @@ func ExecuteCommand(c *Command) {
 		return
 	}
 
+	// Refuse password authentication for locked out users and addresses.
+	if c.Server != nil && c.Auth != nil {
+		if uauth, ok := (*c.Auth).(*user.UserAuth); ok {
+			username, _, _ := uauth.GetInfo()
+			if until, locked := lockedOut(c, username); locked {
+				LogAuthFailure(username, c.IP, "locked out")
+				c.Respond(32, "Account temporarily locked.", map[string]interface{}{"lockedUntil": until})
+				return
+			}
+		}
+
+		// Apply the per-user request and bandwidth limits.
+		if !applyUserLimits(c) {
+			c.Respond(7, "Rate limit reached. Please try again later.", map[string]interface{}{})
+			return
+		}
+
+		// Reserve memory for transfers, turning them away if the memory
+		// budget is used up.
+		reserved, ok := reserveTransferMemory(c)
+		if !ok {
+			c.Respond(8, "Out of memory. Please try again later.", map[string]interface{}{})
+			return
+		}
+		defer c.Server.MemoryBudget().Release(reserved)
+	}
+
 	// Execute the command function.
 	err := cmd(c)
 	if err != nil {
@@ -106,5 +205,12 @@ func ExecuteCommand(c *Command) {
 		return
 	}
 
+	// Commands fail authentication if the user reaches their request limit
+	// once their password is verified.
+	if c.rateLimited {
+		c.Respond(7, "Rate limit reached. Please try again later.", map[string]interface{}{})
+		return
+	}
+
 	// If we didn't have an error, we can assume that the response is valid.
 }
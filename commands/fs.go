@@ -4,7 +4,10 @@
 package commands
 
 import (
+	"github.com/cubeflix/lily/delta"
 	"github.com/cubeflix/lily/drive"
+	"github.com/cubeflix/lily/fs"
+	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/security/access"
 	"github.com/cubeflix/lily/user/namelist"
 )
@@ -12,9 +15,16 @@ import (
 // Handle an FS error.
 func handleFSError(c *Command, err error) error {
 	switch err {
-	case drive.ErrEmptyPath, drive.ErrNotAChildOf, drive.ErrAlreadyExists, drive.ErrInvalidDirectoryTree, drive.ErrInvalidName, drive.ErrInvalidLength, drive.ErrInvalidChunks, drive.ErrInvalidStartEnd:
+	case drive.ErrEmptyPath, drive.ErrNotAChildOf, drive.ErrAlreadyExists, drive.ErrInvalidDirectoryTree, drive.ErrInvalidName, drive.ErrInvalidLength, drive.ErrInvalidChunks, drive.ErrInvalidStartEnd,
+		drive.ErrHashMismatch, drive.ErrUploadNotFound, drive.ErrIncompleteUpload, drive.ErrUploadTooLarge, delta.ErrInvalidBlockSize, delta.ErrInvalidDelta, delta.ErrSizeMismatch, fs.ErrInvalidChunk:
 		c.Respond(15, "FS argument error.", map[string]interface{}{"error": err.Error()})
 		return nil
+	case network.ErrChecksumMismatch:
+		c.Respond(39, "Chunk checksum mismatch.", map[string]interface{}{"error": err.Error()})
+		return nil
+	case network.ErrChunkTooLarge:
+		c.Respond(18, "Invalid chunk size.", map[string]interface{}{"error": err.Error()})
+		return nil
 	case drive.ErrCannotAccess:
 		c.Respond(16, "Insufficient clearance for access/modify.", map[string]interface{}{"error": err.Error()})
 		return nil
@@ -398,7 +408,7 @@ func ReadFilesCommand(c *Command) error {
 	chunkSizeArg, ok := c.Params["chunkSize"]
 	if ok {
 		chunkSize = chunkSizeArg.(int64)
-		if chunkSize < 0 || chunkSize > 1000000 {
+		if chunkSize < 0 || chunkSize > int64(c.Server.Config().GetMaxChunkSize()) {
 			c.Respond(18, "Invalid chunk size.", map[string]interface{}{})
 			return nil
 		}
@@ -475,6 +485,279 @@ func WriteFilesCommand(c *Command) error {
 	return nil
 }
 
+// Get the block signature of a file using chunks.
+func GetSignatureCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	path, err := getString(c, "path")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	blockSize := 0
+	if _, ok := c.Params["blockSize"]; ok {
+		blockSize, err = getInt(c, "blockSize")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the signature.
+	blockSize, size, err := driveObj.GetSignature(path, blockSize, c.Chunks, c.Server.Config().GetTimeout(), userObj)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{"blockSize": blockSize, "size": size})
+	return nil
+}
+
+// Patch a file with a delta using chunks.
+func PatchFileCommand(c *Command) error {
+	userObj, username, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	path, err := getString(c, "path")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	hash, err := getBytes(c, "hash")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	size, err := getInt64(c, "size")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Patch the file.
+	_, _, maxUploadSize := c.Server.Config().GetTransferLimits()
+	err = driveObj.PatchFile(path, hash, size, maxUploadSize, c.Chunks, c.Server.Config().GetTimeout(), username, userObj)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Begin an upload which replaces a file, whose ranges can be written
+// concurrently.
+func BeginUploadCommand(c *Command) error {
+	userObj, username, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	path, err := getString(c, "path")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	size, err := getInt64(c, "size")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Begin the upload.
+	_, _, maxUploadSize := c.Server.Config().GetTransferLimits()
+	id, err := driveObj.BeginUpload(path, size, maxUploadSize, username, userObj)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{"id": id})
+	return nil
+}
+
+// Write a range of an upload.
+func WriteRangeCommand(c *Command) error {
+	_, username, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	id, err := getString(c, "id")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	start, err := getInt64(c, "start")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Write the range.
+	err = driveObj.WriteUploadRange(id, start, c.Chunks, c.Server.Config().GetTimeout(), username)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Commit an upload, replacing the file once every range has arrived.
+func CommitUploadCommand(c *Command) error {
+	userObj, username, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	id, err := getString(c, "id")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	var hash []byte
+	if _, ok := c.Params["hash"]; ok {
+		hash, err = getBytes(c, "hash")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Commit the upload.
+	err = driveObj.CommitUpload(id, hash, username, userObj)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Abort an upload.
+func AbortUploadCommand(c *Command) error {
+	_, username, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	drive, err := getString(c, "drive")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+	id, err := getString(c, "id")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the drive.
+	driveObj, ok := c.Server.GetDrive(drive)
+	if !ok {
+		c.Respond(13, "Drive does not exist.", map[string]interface{}{})
+		return nil
+	}
+
+	// Abort the upload.
+	err = driveObj.AbortUpload(id, username)
+	if err != nil {
+		handleFSError(c, err)
+		return nil
+	}
+
+	// Return.
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
 // Rename files.
 func RenameFilesCommand(c *Command) error {
 	userObj, username, err := authUserOrSession(c)
@@ -5,36 +5,353 @@ package commands
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/audit"
 	"github.com/cubeflix/lily/session"
 	"github.com/cubeflix/lily/user"
 	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
 )
 
 var ErrAuthFail = errors.New("lily.commands: Auth fail")
 var ErrParamFail = errors.New("lily.commands: Param fail")
 var ErrInvalidAccessSettings = errors.New("lily.commands: Invalid access settings")
 
-// Authenticate user or session. Returns a user object and the username.
+// Log a failed authentication attempt which does not count towards a lockout,
+// such as an expired session.
+func LogAuthFailure(username, ip, reason string) {
+	log.WithFields(log.Fields{
+		"user":   username,
+		"ip":     ip,
+		"reason": reason,
+	}).Warn("authentication failure")
+}
+
+// Record a failed authentication attempt for a user and IP address, and log
+// it. If the username or IP address is empty, it is not tracked.
+func RecordAuthFailure(s Server, username, ip, reason string) {
+	now := time.Now()
+	fields := log.Fields{
+		"user":   username,
+		"ip":     ip,
+		"reason": reason,
+	}
+	var userUntil, ipUntil time.Time
+	var userLocked, ipLocked bool
+	if username != "" {
+		fields["userFailures"], userUntil, userLocked = s.UserLockout().Fail(username, now)
+	}
+	if ip != "" {
+		fields["ipFailures"], ipUntil, ipLocked = s.IPLockout().Fail(ip, now)
+	}
+	log.WithFields(fields).Warn("authentication failure")
+
+	// Log any new lockouts.
+	if userLocked {
+		log.WithFields(log.Fields{
+			"user":  username,
+			"ip":    ip,
+			"until": userUntil,
+		}).Warn("user locked out")
+	}
+	if ipLocked {
+		log.WithFields(log.Fields{
+			"ip":    ip,
+			"until": ipUntil,
+		}).Warn("ip address locked out")
+	}
+}
+
+// Check if a user or the command's IP address is locked out. Returns the time
+// the lockout ends.
+func lockedOut(c *Command, username string) (time.Time, bool) {
+	now := time.Now()
+	if until, ok := c.Server.UserLockout().Locked(username, now); ok {
+		return until, true
+	}
+	if c.IP != "" {
+		if until, ok := c.Server.IPLockout().Locked(c.IP, now); ok {
+			return until, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Get the username of a command whose credentials were verified when the
+// request was received, which are sessions, certificates and peer
+// credentials. Passwords are only verified by the command's auth path, with
+// lockout applied. Returns false if the credentials have not been verified.
+func verifiedUsername(c *Command) (string, bool) {
+	switch a := (*c.Auth).(type) {
+	case *session.Session:
+		return a.GetUsername(), !a.ShouldExpire()
+	case *user.CertAuth:
+		username, _ := a.GetInfo()
+		return username, true
+	case *user.PeerAuth:
+		username, _ := a.GetInfo()
+		return username, true
+	default:
+		return "", false
+	}
+}
+
+// Apply the server bandwidth throttles to a command, and the per-user limits
+// if its credentials have already been verified. Returns false if the user has
+// reached their request limit. Other commands are limited per IP address when
+// their connection is accepted, until their auth path verifies a password.
+func applyUserLimits(c *Command) bool {
+	if c.Chunks != nil {
+		serverUpload, serverDownload := c.Server.BandwidthThrottles()
+		c.Chunks.SetThrottles([]*network.Throttle{serverUpload}, []*network.Throttle{serverDownload})
+	}
+	if username, ok := verifiedUsername(c); ok {
+		return limitUser(c, username)
+	}
+	return true
+}
+
+// Apply the per-user request limit and bandwidth throttles to a command whose
+// user has been verified. Returns false, and marks the command as rate
+// limited, if the user has reached their request limit.
+func limitUser(c *Command, username string) bool {
+	now := time.Now()
+	if !c.Server.UserLimiter().Take(username, now) {
+		log.WithFields(log.Fields{
+			"user":    username,
+			"ip":      c.IP,
+			"command": c.Name,
+		}).Warn("user rate limit reached")
+		if m := c.Server.Metrics(); m != nil {
+			m.UserRateLimitRejections.Inc()
+		}
+		c.rateLimited = true
+		return false
+	}
+	if c.Chunks != nil {
+		serverUpload, serverDownload := c.Server.BandwidthThrottles()
+		userUpload, userDownload := c.Server.UserLimiter().Throttles(username, now)
+		c.Chunks.SetThrottles([]*network.Throttle{serverUpload, userUpload}, []*network.Throttle{serverDownload, userDownload})
+	}
+	return true
+}
+
+// Reserve the memory for the buffers of a transfer command from the memory
+// budget. A transfer uses up to one chunk buffer, and another for compressed
+// chunks. Returns the number of bytes reserved, and false if the budget is
+// used up.
+func reserveTransferMemory(c *Command) (int64, bool) {
+	if !TRANSFER_COMMANDS[strings.ToLower(c.Name)] {
+		return 0, true
+	}
+	size := int64(c.Server.Config().GetMaxChunkSize())
+	if c.Chunks != nil && c.Chunks.Compression() != network.CompressionNone {
+		size *= 2
+	}
+	if !c.Server.MemoryBudget().Reserve(size) {
+		log.WithFields(log.Fields{
+			"ip":      c.IP,
+			"command": c.Name,
+		}).Warn("memory budget used up")
+		return 0, false
+	}
+	return size, true
+}
+
+// Record the metrics for a handled command. Unknown command names are
+// recorded as "unknown".
+func observeCommand(c *Command, start time.Time) {
+	if c.Server == nil || c.Server.Metrics() == nil {
+		return
+	}
+	m := c.Server.Metrics()
+	name := strings.ToLower(c.Name)
+	if _, ok := COMMANDS[name]; !ok {
+		name = "unknown"
+	}
+	m.Requests.Inc(name, strconv.Itoa(c.RespCode))
+	m.CommandDuration.Observe(time.Since(start).Seconds(), name)
+	if c.Chunks != nil {
+		bytesIn, bytesOut := c.Chunks.BytesTransferred()
+		m.ChunkBytes.Add(float64(bytesIn), "read")
+		m.ChunkBytes.Add(float64(bytesOut), "written")
+	}
+}
+
+// Write an audit record for a handled command. Only the drive and path
+// parameters are recorded.
+func auditCommand(c *Command, start time.Time) {
+	if c.Server == nil || c.Server.Audit() == nil {
+		return
+	}
+	r := audit.Record{
+		Time:     start,
+		IP:       c.IP,
+		Command:  c.Name,
+		Code:     c.RespCode,
+		Duration: time.Since(start),
+	}
+
+	// Get the user and authentication type.
+	if c.Auth != nil && *c.Auth != nil {
+		r.AuthType = (*c.Auth).Type()
+		if userAuth, ok := (*c.Auth).(*user.UserAuth); ok {
+			r.User, _, _ = userAuth.GetInfo()
+		} else if certAuth, ok := (*c.Auth).(*user.CertAuth); ok {
+			r.User, _ = certAuth.GetInfo()
+		} else if peerAuth, ok := (*c.Auth).(*user.PeerAuth); ok {
+			r.User, _ = peerAuth.GetInfo()
+		} else if sessionAuth, ok := (*c.Auth).(*session.Session); ok {
+			r.User = sessionAuth.GetUsername()
+		}
+	}
+
+	// Get the target drive and paths.
+	r.Drive, _ = getString(c, "drive")
+	if path, err := getString(c, "path"); err == nil {
+		r.Paths = append(r.Paths, path)
+	}
+	for _, name := range []string{"paths", "dests"} {
+		if paths, err := getListOfStrings(c, name); err == nil {
+			r.Paths = append(r.Paths, paths...)
+		}
+	}
+	if c.Chunks != nil {
+		r.BytesIn, r.BytesOut = c.Chunks.BytesTransferred()
+	}
+
+	// Write the record.
+	if err := c.Server.Audit().Write(r); err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("failed to write audit record")
+	}
+}
+
+// Get the ID hash of the session the command was authenticated with, if any.
+func currentSessionHash(c *Command) (session.IDHash, bool) {
+	sauth, ok := (*c.Auth).(*session.Session)
+	if !ok {
+		return session.IDHash{}, false
+	}
+	return session.HashID(sauth.GetID()), true
+}
+
+// Revoke all of a user's sessions, other than the session the command was
+// authenticated with.
+func revokeOtherSessions(c *Command, username string) {
+	keep := []session.IDHash{}
+	if current, ok := currentSessionHash(c); ok {
+		keep = append(keep, current)
+	}
+	c.Server.Sessions().RemoveUserSessions(username, keep)
+}
+
+// Check new passwords against the password policy. Responds and returns false
+// if a password is rejected.
+func checkPasswords(c *Command, passwords []string) bool {
+	for i := range passwords {
+		if err := c.Server.Config().CheckPassword(passwords[i]); err != nil {
+			c.Respond(34, "Password does not meet the password policy.", map[string]interface{}{"reason": err.Error()})
+			return false
+		}
+	}
+	return true
+}
+
+// Check if a user must provide a second factor, either because they are
+// enrolled in two-factor authentication or because the server requires it at
+// their clearance level. These users cannot use password authentication
+// directly, and must log in to receive a session instead.
+func requiresSecondFactor(c *Command, userObj *user.User) bool {
+	if userObj.IsTOTPEnabled() {
+		return true
+	}
+	required := c.Server.Config().GetTOTPRequiredClearance()
+	return required != 0 && userObj.IsClearanceSufficient(access.Clearance(required))
+}
+
+// Authenticate user, certificate, peer or session. Returns a user object and
+// the username. Users who require a second factor must authenticate with a
+// session, a client certificate or their peer credentials.
 func authUserOrSession(c *Command) (*user.User, string, error) {
+	userObj, username, err := authUserOrSessionAllowPassword(c)
+	if err != nil {
+		return nil, "", err
+	}
+	if (*c.Auth).Type() == "user" {
+		if requiresSecondFactor(c, userObj) {
+			LogAuthFailure(username, c.IP, "second factor required")
+			return nil, "", ErrAuthFail
+		}
+		c.Server.UserLockout().Succeed(username)
+	}
+	return userObj, username, nil
+}
+
+// Authenticate user or session for two-factor enrollment. Users who are
+// required to enroll but have not yet done so may use password authentication.
+func authEnrollment(c *Command) (*user.User, string, error) {
+	userObj, username, err := authUserOrSessionAllowPassword(c)
+	if err != nil {
+		return nil, "", err
+	}
+	if (*c.Auth).Type() == "user" {
+		if userObj.IsTOTPEnabled() {
+			LogAuthFailure(username, c.IP, "second factor required")
+			return nil, "", ErrAuthFail
+		}
+		c.Server.UserLockout().Succeed(username)
+	}
+	return userObj, username, nil
+}
+
+// Authenticate user, certificate, peer or session, allowing password
+// authentication for all users. Returns a user object and the username.
+func authUserOrSessionAllowPassword(c *Command) (*user.User, string, error) {
 	// Authenticate.
-	if (*c.Auth).Type() != "user" && (*c.Auth).Type() != "session" {
+	authType := (*c.Auth).Type()
+	if authType != "user" && authType != "cert" && authType != "peer" && authType != "session" {
 		// Invalid auth type.
+		LogAuthFailure("", c.IP, "invalid authentication type")
 		return nil, "", ErrAuthFail
 	}
 	if err := (*c.Auth).Authenticate(); err != nil {
 		// Authenticate.
+		if userAuth, ok := (*c.Auth).(*user.UserAuth); ok {
+			username, _, _ := userAuth.GetInfo()
+			RecordAuthFailure(c.Server, username, c.IP, "invalid password")
+		} else if sessionAuth, ok := (*c.Auth).(*session.Session); ok {
+			LogAuthFailure(sessionAuth.GetUsername(), c.IP, "invalid session")
+		}
 		return nil, "", ErrAuthFail
 	}
 
+	// Apply the per-user limits once a password has been verified.
+	if userAuth, ok := (*c.Auth).(*user.UserAuth); ok {
+		username, _, _ := userAuth.GetInfo()
+		if !limitUser(c, username) {
+			return nil, "", ErrAuthFail
+		}
+	}
+
 	// Get the user object.
 	var username string
 	var userObj *user.User
 	if userAuth, ok := (*c.Auth).(*user.UserAuth); ok {
 		// User auth object.
 		username, _, userObj = userAuth.GetInfo()
+	} else if certAuth, ok := (*c.Auth).(*user.CertAuth); ok {
+		// Certificate auth object.
+		username, userObj = certAuth.GetInfo()
+	} else if peerAuth, ok := (*c.Auth).(*user.PeerAuth); ok {
+		// Peer auth object.
+		username, userObj = peerAuth.GetInfo()
 	} else if sessionAuth, ok := (*c.Auth).(*session.Session); ok {
 		// Session auth object.
 		username = sessionAuth.GetUsername()
@@ -49,20 +366,44 @@ func authUserOrSession(c *Command) (*user.User, string, error) {
 	return userObj, username, nil
 }
 
+// Verify a second factor for a user, given either a TOTP code in the "totp"
+// parameter or a recovery code in the "recoveryCode" parameter. Failed codes
+// count towards the user's lockout.
+func verifySecondFactor(c *Command, userObj *user.User) bool {
+	if code, err := getString(c, "totp"); err == nil {
+		if !userObj.VerifyTOTP(code) {
+			RecordAuthFailure(c.Server, userObj.GetUsername(), c.IP, "invalid totp code")
+			return false
+		}
+		return true
+	}
+	if code, err := getString(c, "recoveryCode"); err == nil {
+		if !userObj.UseRecoveryCode(code) {
+			RecordAuthFailure(c.Server, userObj.GetUsername(), c.IP, "invalid recovery code")
+			return false
+		}
+		c.Server.Users().SetDirty(true)
+		return true
+	}
+	LogAuthFailure(userObj.GetUsername(), c.IP, "missing second factor")
+	return false
+}
+
 // Authenticate session. Returns a session object and the username.
 func authSession(c *Command) (*session.Session, string, error) {
 	// Authenticate.
 	if (*c.Auth).Type() != "session" {
 		// Invalid auth type.
+		LogAuthFailure("", c.IP, "invalid authentication type")
 		return nil, "", ErrAuthFail
 	}
-	if err := (*c.Auth).Authenticate(); err != nil {
+	s := (*c.Auth).(*session.Session)
+	if err := s.Authenticate(); err != nil {
 		// Authenticate.
+		LogAuthFailure(s.GetUsername(), c.IP, "invalid session")
 		return nil, "", ErrAuthFail
 	}
 
-	s := (*c.Auth).(*session.Session)
-
 	return s, s.GetUsername(), nil
 }
 
@@ -92,6 +433,22 @@ func getInt(c *Command, paramName string) (int, error) {
 	return str, nil
 }
 
+// Get an int64. Small values may be sent as ints.
+func getInt64(c *Command, paramName string) (int64, error) {
+	arg, ok := c.Params[paramName]
+	if !ok {
+		return 0, ErrParamFail
+	}
+	switch i := arg.(type) {
+	case int64:
+		return i, nil
+	case int:
+		return int64(i), nil
+	default:
+		return 0, ErrParamFail
+	}
+}
+
 // Get a time.Duration.
 func getDuration(c *Command, paramName string) (time.Duration, error) {
 	arg, ok := c.Params[paramName]
@@ -118,6 +475,19 @@ func getBool(c *Command, paramName string) (bool, error) {
 	return str, nil
 }
 
+// Get bytes.
+func getBytes(c *Command, paramName string) ([]byte, error) {
+	arg, ok := c.Params[paramName]
+	if !ok {
+		return nil, ErrParamFail
+	}
+	data, ok := arg.([]byte)
+	if !ok {
+		return nil, ErrParamFail
+	}
+	return data, nil
+}
+
 // Get a list of UUIDs.
 func getUUIDs(c *Command, paramName string) ([]uuid.UUID, error) {
 	arg, ok := c.Params[paramName]
@@ -144,6 +514,27 @@ func getUUIDs(c *Command, paramName string) ([]uuid.UUID, error) {
 	return uuids, nil
 }
 
+// Get a list of session ID hashes.
+func getSessionHashes(c *Command, paramName string) ([]session.IDHash, error) {
+	arg, ok := c.Params[paramName]
+	if !ok {
+		return nil, ErrParamFail
+	}
+	argInterface, ok := arg.([]interface{})
+	if !ok {
+		return nil, ErrParamFail
+	}
+	hashes := make([]session.IDHash, len(argInterface))
+	for i := range argInterface {
+		data, ok := argInterface[i].([]byte)
+		if !ok || len(data) != len(hashes[i]) {
+			return nil, ErrParamFail
+		}
+		copy(hashes[i][:], data)
+	}
+	return hashes, nil
+}
+
 // Get a list of strings.
 func getListOfStrings(c *Command, paramName string) ([]string, error) {
 	arg, ok := c.Params[paramName]
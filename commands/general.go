@@ -6,12 +6,16 @@ package commands
 import (
 	"time"
 
+	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/session"
 	"github.com/cubeflix/lily/user"
 	"github.com/cubeflix/lily/version"
 	"github.com/google/uuid"
 )
 
+// The maximum length of a session label, in bytes.
+const MaxSessionLabelLength = 256
+
 // Ping command.
 func PingCommand(c *Command) error {
 	// Respond.
@@ -25,6 +29,7 @@ func InfoCommand(c *Command) error {
 	cobj := c.Server.Config()
 	defaultSessionExpiration, allowChangeSessionExpiration, allowNonExpiringSessions := cobj.GetSessionExpirationSettings()
 	limit, maxEvents := cobj.GetRateLimit()
+	sessionIdleTimeout, sessionMaxLifetime := cobj.GetSessionTimeouts()
 	c.Respond(0, "", map[string]interface{}{
 		"name":                         cobj.GetName(),
 		"version":                      version.VERSION,
@@ -32,23 +37,62 @@ func InfoCommand(c *Command) error {
 		"defaultSessionExpiration":     defaultSessionExpiration,
 		"allowChangeSessionExpiration": allowChangeSessionExpiration,
 		"allowNonExpiringSessions":     allowNonExpiringSessions,
+		"sessionIdleTimeout":           sessionIdleTimeout,
+		"sessionMaxLifetime":           sessionMaxLifetime,
 		"perUserSessionLimit":          cobj.GetUserSessionLimit(),
 		"timeout":                      cobj.GetTimeout(),
 		"limit":                        limit,
 		"maxLimitEvents":               maxEvents,
+		"totpRequiredClearance":        cobj.GetTOTPRequiredClearance(),
+		"compression":                  network.Compressions,
 	})
 	return nil
 }
 
 // Login command
 func LoginCommand(c *Command) error {
-	// Authenticate.
-	uauth, ok := (*c.Auth).(*user.UserAuth)
-	if !ok {
-		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
-		return nil
-	}
-	if uauth.Authenticate() != nil {
+	// Authenticate. Users may log in with a password, a client certificate or
+	// their peer credentials on a Unix socket.
+	var username string
+	var userObj *user.User
+	if uauth, ok := (*c.Auth).(*user.UserAuth); ok {
+		if uauth.Authenticate() != nil {
+			username, _, _ = uauth.GetInfo()
+			RecordAuthFailure(c.Server, username, c.IP, "invalid password")
+			c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+			return nil
+		}
+		username, _, userObj = uauth.GetInfo()
+		if !limitUser(c, username) {
+			return nil
+		}
+
+		// Check the second factor.
+		if userObj.IsTOTPEnabled() {
+			if !verifySecondFactor(c, userObj) {
+				c.Respond(29, "Invalid or missing two-factor authentication code.", map[string]interface{}{})
+				return nil
+			}
+		} else if requiresSecondFactor(c, userObj) {
+			c.Respond(30, "Two-factor authentication enrollment required.", map[string]interface{}{})
+			return nil
+		}
+		c.Server.UserLockout().Succeed(username)
+
+		// Upgrade the password hash if it uses an older algorithm or cost.
+		if _, password, _ := uauth.GetInfo(); userObj.RehashPassword(password, c.Server.Config().GetPasswordHashing()) {
+			c.Server.Users().SetDirty(true)
+		}
+	} else if cauth, ok := (*c.Auth).(*user.CertAuth); ok {
+		if cauth.Authenticate() != nil {
+			c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+			return nil
+		}
+		username, userObj = cauth.GetInfo()
+	} else if pauth, ok := (*c.Auth).(*user.PeerAuth); ok {
+		username, userObj = pauth.GetInfo()
+	} else {
+		LogAuthFailure("", c.IP, "invalid authentication type")
 		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
 		return nil
 	}
@@ -78,9 +122,22 @@ func LoginCommand(c *Command) error {
 		return nil
 	}
 
+	// Get the optional client label.
+	label := ""
+	if _, ok := c.Params["label"]; ok {
+		label, err = getString(c, "label")
+		if err != nil || len(label) > MaxSessionLabelLength {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
 	// Create the new session.
-	username, _, _ := uauth.GetInfo()
 	sobj := session.NewSession(newUUID, username, expireAfter)
+	sobj.SetIP(c.IP)
+	sobj.SetCertFingerprint(c.CertFingerprint)
+	sobj.SetLabel(label)
+	sobj.SetTimeouts(c.Server.Config().GetSessionTimeouts())
 	if c.Server.Sessions().SetSessionsByID(map[uuid.UUID]*session.Session{newUUID: sobj}) != nil {
 		// Limit reached.
 		c.Respond(11, "Per-user session limit reached.", map[string]interface{}{})
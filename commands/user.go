@@ -3,6 +3,11 @@
 
 package commands
 
+import (
+	"github.com/cubeflix/lily/security/totp"
+	"github.com/cubeflix/lily/session"
+)
+
 func SetPasswordCommand(c *Command) error {
 	userObj, _, err := authUserOrSession(c)
 	if err != nil {
@@ -16,12 +21,175 @@ func SetPasswordCommand(c *Command) error {
 		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
 		return nil
 	}
+	if !checkPasswords(c, []string{password}) {
+		return nil
+	}
 
-	err = userObj.SetPassword(password)
+	revokeSessions := false
+	if _, ok := c.Params["revokeSessions"]; ok {
+		revokeSessions, err = getBool(c, "revokeSessions")
+		if err != nil {
+			c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	err = userObj.SetPassword(password, c.Server.Config().GetPasswordHashing())
 	if err != nil {
 		c.Respond(22, "Failed to hash password.", map[string]interface{}{})
 		return nil
 	}
 	c.Server.Users().SetDirty(true)
+	if revokeSessions {
+		revokeOtherSessions(c, userObj.GetUsername())
+	}
+	return nil
+}
+
+// List my sessions command.
+func ListMySessionsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the sessions. Sessions are identified by their ID hashes, so the
+	// session IDs themselves are never revealed.
+	sessions := c.Server.Sessions().GetUserSessionHashes(userObj.GetUsername())
+	sessionInfo := []session.SessionInfo{}
+	for hash := range sessions {
+		id := make([]byte, len(hash))
+		copy(id, hash[:])
+		sessionInfo = append(sessionInfo, sessions[hash].GetInfo(id))
+	}
+	var current []byte
+	if hash, ok := currentSessionHash(c); ok {
+		current = hash[:]
+	}
+	c.Respond(0, "", map[string]interface{}{"sessions": sessionInfo, "current": current})
+	return nil
+}
+
+// Revoke my sessions command.
+func RevokeMySessionsCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get the arguments.
+	hashes, err := getSessionHashes(c, "ids")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Check that the sessions belong to the user.
+	sessions := c.Server.Sessions().GetUserSessionHashes(userObj.GetUsername())
+	for i := range hashes {
+		if _, ok := sessions[hashes[i]]; !ok {
+			c.Respond(23, "Session not found.", map[string]interface{}{})
+			return nil
+		}
+	}
+
+	// Revoke the sessions.
+	err = c.Server.Sessions().RemoveSessionsByHash(hashes)
+	if err != nil {
+		c.Respond(23, "Session not found.", map[string]interface{}{})
+		return nil
+	}
+	c.Respond(0, "", map[string]interface{}{})
+	return nil
+}
+
+// Set up two-factor authentication. Generates a new TOTP secret, which must be
+// confirmed with the enable TOTP command before it is required on login.
+func SetupTOTPCommand(c *Command) error {
+	userObj, username, err := authEnrollment(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if userObj.IsTOTPEnabled() {
+		c.Respond(31, "Two-factor authentication already enabled.", map[string]interface{}{})
+		return nil
+	}
+
+	// Generate the secret.
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	userObj.SetTOTP(secret, false)
+	c.Server.Users().SetDirty(true)
+	c.Respond(0, "", map[string]interface{}{
+		"secret": totp.EncodeSecret(secret),
+		"uri":    totp.URI(c.Server.Config().GetName(), username, secret),
+	})
+	return nil
+}
+
+// Enable two-factor authentication, given a code generated from the secret
+// returned by the set up TOTP command. Returns a new set of recovery codes.
+func EnableTOTPCommand(c *Command) error {
+	userObj, _, err := authEnrollment(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+	if userObj.IsTOTPEnabled() {
+		c.Respond(31, "Two-factor authentication already enabled.", map[string]interface{}{})
+		return nil
+	}
+
+	// Get arguments.
+	code, err := getString(c, "totp")
+	if err != nil {
+		c.Respond(12, "Invalid parameters.", map[string]interface{}{})
+		return nil
+	}
+
+	// Verify the code.
+	secret, _ := userObj.GetTOTP()
+	if len(secret) == 0 || !userObj.VerifyTOTP(code) {
+		c.Respond(29, "Invalid or missing two-factor authentication code.", map[string]interface{}{})
+		return nil
+	}
+
+	// Generate the recovery codes and enable.
+	codes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := userObj.SetRecoveryCodesFromStrings(codes, c.Server.Config().GetPasswordHashing()); err != nil {
+		c.Respond(22, "Failed to hash password.", map[string]interface{}{})
+		return nil
+	}
+	userObj.SetTOTP(secret, true)
+	c.Server.Users().SetDirty(true)
+	c.Respond(0, "", map[string]interface{}{"recoveryCodes": codes})
+	return nil
+}
+
+// Disable two-factor authentication, given a TOTP or recovery code.
+func DisableTOTPCommand(c *Command) error {
+	userObj, _, err := authUserOrSession(c)
+	if err != nil {
+		c.Respond(6, "Invalid or expired authentication.", map[string]interface{}{})
+		return nil
+	}
+
+	// Verify the code.
+	if userObj.IsTOTPEnabled() && !verifySecondFactor(c, userObj) {
+		c.Respond(29, "Invalid or missing two-factor authentication code.", map[string]interface{}{})
+		return nil
+	}
+
+	userObj.ResetTOTP()
+	c.Server.Users().SetDirty(true)
+	c.Respond(0, "", map[string]interface{}{})
 	return nil
 }
@@ -8,15 +8,21 @@ package connection
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
+	"net"
 	"os"
 	"time"
 
 	"github.com/cubeflix/lily/commands"
 	"github.com/cubeflix/lily/drive"
+	"github.com/cubeflix/lily/metrics"
 	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/security/audit"
 	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/lockout"
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server/config"
 	"github.com/cubeflix/lily/user"
 	"github.com/google/uuid"
@@ -44,7 +50,17 @@ type Server interface {
 	GetDrive(string) (*drive.Drive, bool)
 	SetDrive(string, *drive.Drive)
 	GetPublicStopChan() chan os.Signal
+	SetShutdownDeadline(time.Duration)
+	Reload() error
 	GetMemUsage() (uint64, uint64, uint64)
+	UserLockout() *lockout.Tracker
+	IPLockout() *lockout.Tracker
+	UserLimiter() *userlimit.Limiter
+	BandwidthThrottles() (*network.Throttle, *network.Throttle)
+	MemoryBudget() *network.MemoryBudget
+	OpenConnections() int
+	Audit() *audit.Log
+	Metrics() *metrics.ServerMetrics
 }
 
 // Fixed DataStream.
@@ -80,6 +96,11 @@ func (t *FixedStream) Flush() {}
 
 var ErrInvalidProtocol = errors.New("lily.connection: Invalid protocol")
 var ErrInvalidSessionUsername = errors.New("lily.connection: Invalid session username")
+var ErrNoClientCertificate = errors.New("lily.connection: No verified client certificate")
+var ErrInvalidCertUsername = errors.New("lily.connection: Invalid certificate username")
+var ErrNoPeerCredentials = errors.New("lily.connection: No mapped peer credentials")
+var ErrInvalidPeerUsername = errors.New("lily.connection: Invalid peer username")
+var ErrSessionBindingMismatch = errors.New("lily.connection: Session used from an unbound address or certificate")
 
 // Receive a Lily-encoded string.
 func recvString(conn network.DataStream, timeout time.Duration) (string, error) {
@@ -121,15 +142,42 @@ type Connection struct {
 	Command     *commands.Command
 	conn        network.DataStream
 	requestData network.DataStream // The request data is held in a fixed stream.
+	clientCert  *x509.Certificate  // The verified client certificate, if any.
+	peerUser    string             // The username mapped from the peer credentials, if any.
+	ip          string             // The remote IP address, if known.
+	version     string             // The protocol version of the request.
 }
 
 func NewConnection(conn network.DataStream, fixedStream network.DataStream) *Connection {
 	return &Connection{
 		conn:        conn,
 		requestData: fixedStream,
+		version:     network.PROTOCOL_VERSION,
 	}
 }
 
+// Set the verified client certificate for certificate authentication.
+func (c *Connection) SetClientCertificate(cert *x509.Certificate) {
+	c.clientCert = cert
+}
+
+// Set the username mapped from the peer credentials of a Unix socket
+// connection, for peer authentication.
+func (c *Connection) SetPeerUsername(username string) {
+	c.peerUser = username
+}
+
+// Set the protocol version of the request. The request and response chunks
+// use the version.
+func (c *Connection) SetVersion(version string) {
+	c.version = version
+}
+
+// Set the remote IP address.
+func (c *Connection) SetRemoteIP(ip string) {
+	c.ip = ip
+}
+
 // Receive a request from the connection.
 func (c *Connection) ReceiveRequest(timeout time.Duration, s Server) error {
 	// Receive the authentication data.
@@ -174,7 +222,31 @@ func (c *Connection) ReceiveRequest(timeout time.Duration, s Server) error {
 	}
 
 	// Create the command.
-	c.Command = commands.NewCommand(s, name, &auth, *params, network.NewChunkHandler(c.conn))
+	chunks := network.NewChunkHandler(c.conn)
+	if err := chunks.SetVersion(c.version); err != nil {
+		return err
+	}
+	if s.Config() != nil {
+		chunks.SetMaxChunkSize(s.Config().GetMaxChunkSize())
+	}
+	c.Command = commands.NewCommand(s, name, &auth, *params, chunks)
+	c.Command.IP = c.ip
+	if c.clientCert != nil {
+		c.Command.CertFingerprint = config.CertFingerprint(c.clientCert)
+	}
+
+	// Compress the request and response chunks if the request chose an
+	// algorithm.
+	if arg, ok := c.Command.Params["compression"]; ok {
+		delete(c.Command.Params, "compression")
+		compression, ok := arg.(string)
+		if !ok {
+			return network.ErrUnsupportedCompression
+		}
+		if err := c.Command.Chunks.SetCompression(compression); err != nil {
+			return err
+		}
+	}
 
 	// Return.
 	return nil
@@ -214,7 +286,21 @@ func (c *Connection) ReceiveAuth(timeout time.Duration, s Server) (auth.Auth, er
 
 		// Get the user object from the server.
 		uobj, err := s.Users().GetUsersByName([]string{username})
+
+		// Use LDAP authentication, if enabled.
+		if verifier, mode, autoProvision := ldapVerifier(s); verifier != nil {
+			if err == userlist.ErrUserNotFound && autoProvision {
+				return user.NewExternalUserAuth(username, password, nil, verifier, false, syncUser(s)), nil
+			}
+			if err != nil {
+				commands.RecordAuthFailure(s, username, c.ip, "user not found")
+				return nil, err
+			}
+			return user.NewExternalUserAuth(username, password, uobj[0], verifier,
+				mode == config.LDAPFallback, syncUser(s)), nil
+		}
 		if err != nil {
+			commands.RecordAuthFailure(s, username, c.ip, "user not found")
 			return nil, err
 		}
 
@@ -254,12 +340,105 @@ func (c *Connection) ReceiveAuth(timeout time.Duration, s Server) (auth.Auth, er
 		// Get the session object and verify it.
 		sobj, err := s.Sessions().GetSessionsByID([]uuid.UUID{uuidObj})
 		if err != nil {
+			commands.LogAuthFailure(username, c.ip, "session not found")
 			return nil, err
 		}
 		if sobj[0].GetUsername() != username {
+			commands.LogAuthFailure(username, c.ip, "invalid session username")
 			return nil, ErrInvalidSessionUsername
 		}
+
+		// Check the session binding.
+		if s.Config() != nil {
+			fingerprint := ""
+			if c.clientCert != nil {
+				fingerprint = config.CertFingerprint(c.clientCert)
+			}
+			if !s.Config().CheckSessionBinding(sobj[0].GetIP(), c.ip, sobj[0].GetCertFingerprint(), fingerprint) {
+				log.WithFields(log.Fields{
+					"user":    username,
+					"ip":      c.ip,
+					"boundIP": sobj[0].GetIP(),
+				}).Warn("session used from unbound address or certificate")
+				return nil, ErrSessionBindingMismatch
+			}
+		}
 		return sobj[0], nil
+	} else if string(authType) == "C" {
+		// Certificate authentication.
+		// Receive the username.
+		username, err := recvString(c.requestData, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		// Receive the footer.
+		footer := make([]byte, 3)
+		_, err = c.requestData.Read(&footer, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if string(footer) != "END" {
+			return nil, network.ErrInvalidFooter
+		}
+
+		// Get the username for the certificate and verify it.
+		if c.clientCert == nil || s.Config() == nil {
+			commands.LogAuthFailure(username, c.ip, "no client certificate")
+			return nil, ErrNoClientCertificate
+		}
+		certUsername, ok := s.Config().GetCertUsername(c.clientCert)
+		if !ok || certUsername != username {
+			commands.LogAuthFailure(username, c.ip, "invalid certificate username")
+			return nil, ErrInvalidCertUsername
+		}
+
+		// Get the user object from the server.
+		uobj, err := s.Users().GetUsersByName([]string{username})
+		if err != nil {
+			commands.LogAuthFailure(username, c.ip, "user not found")
+			return nil, err
+		}
+
+		// Return the auth object.
+		return user.NewCertAuth(username, uobj[0]), nil
+	} else if string(authType) == "P" {
+		// Peer authentication.
+		// Receive the username.
+		username, err := recvString(c.requestData, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		// Receive the footer.
+		footer := make([]byte, 3)
+		_, err = c.requestData.Read(&footer, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if string(footer) != "END" {
+			return nil, network.ErrInvalidFooter
+		}
+
+		// Verify the username for the peer credentials.
+		if c.peerUser == "" {
+			commands.LogAuthFailure(username, c.ip, "no peer credentials")
+			return nil, ErrNoPeerCredentials
+		}
+		if c.peerUser != username {
+			commands.LogAuthFailure(username, c.ip, "invalid peer username")
+			return nil, ErrInvalidPeerUsername
+		}
+
+		// Get the user object from the server.
+		uobj, err := s.Users().GetUsersByName([]string{username})
+		if err != nil {
+			commands.LogAuthFailure(username, c.ip, "user not found")
+			return nil, err
+		}
+
+		// Return the auth object.
+		return user.NewPeerAuth(username, uobj[0]), nil
 	} else if string(authType) == "N" {
 		// Receive the footer.
 		footer := make([]byte, 3)
@@ -339,20 +518,18 @@ func (c *Connection) Respond(timeout time.Duration) error {
 // Respond with a connection error.
 func ConnectionError(s network.DataStream, timeout time.Duration, code int, str string, connErr error) {
 	// Receive the remaining data.
-	buf := make([]byte, 1024)
-	tcs, ok := s.(*network.TLSConnStream)
-	if !ok {
-		return
-	}
-	conn := tcs.Conn()
-	for {
-		conn.SetReadDeadline(time.Now().Add(timeout))
-		n, err := conn.Read(buf)
-		if err != nil {
-			break
-		}
-		if n < 1024 {
-			break
+	if ns, ok := s.(network.NetStream); ok {
+		buf := make([]byte, 1024)
+		conn := ns.NetConn()
+		for {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if n < 1024 {
+				break
+			}
 		}
 	}
 
@@ -427,21 +604,45 @@ func ConnectionError(s network.DataStream, timeout time.Duration, code int, str
 
 // Handle a TLS connection.
 func HandleConnection(conn *tls.Conn, timeout time.Duration, s Server) {
+	stream := network.DataStream(network.NewTLSStream(conn))
+	handleConnection(conn, stream, timeout, s, func(cobj *Connection) {
+		// The handshake is complete once the header has been read.
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			cobj.SetRemoteIP(addr.IP.String())
+		}
+		if chains := conn.ConnectionState().VerifiedChains; len(chains) > 0 {
+			cobj.SetClientCertificate(chains[0][0])
+		}
+	})
+}
+
+// Handle an unencrypted connection from a Unix socket. The peer username is
+// the username mapped from the peer credentials, or empty if the local user is
+// not mapped.
+func HandleLocalConnection(conn net.Conn, peerUsername string, timeout time.Duration, s Server) {
+	stream := network.DataStream(network.NewConnStream(conn))
+	handleConnection(conn, stream, timeout, s, func(cobj *Connection) {
+		cobj.SetPeerUsername(peerUsername)
+	})
+}
+
+// Handle a connection. The setup function sets the connection's transport
+// details once the request header has been received.
+func handleConnection(conn net.Conn, connStream network.DataStream, timeout time.Duration, s Server, setup func(*Connection)) {
 	defer conn.Close()
 
-	tlsStream := network.DataStream(network.NewTLSStream(conn))
 	// Accept the header.
 	header := make([]byte, 7)
-	if _, err := tlsStream.Read(&header, timeout); err != nil {
-		ConnectionError(tlsStream, timeout, 4, "Connection timed out or connection error.", err)
+	if _, err := connStream.Read(&header, timeout); err != nil {
+		ConnectionError(connStream, timeout, 4, "Connection timed out or connection error.", err)
 		return
 	}
 
 	// Get the length of the request.
 	request_length := binary.LittleEndian.Uint16(header[4:6])
 	request_data := make([]byte, request_length)
-	if _, err := tlsStream.Read(&request_data, timeout); err != nil {
-		ConnectionError(tlsStream, timeout, 4, "Connection timed out or connection error.", err)
+	if _, err := connStream.Read(&request_data, timeout); err != nil {
+		ConnectionError(connStream, timeout, 4, "Connection timed out or connection error.", err)
 		return
 	}
 
@@ -450,21 +651,28 @@ func HandleConnection(conn *tls.Conn, timeout time.Duration, s Server) {
 	stream := network.DataStream(&fixedStream)
 
 	// Check the protocol version.
-	if string(header[6]) != network.PROTOCOL_VERSION {
-		ConnectionError(tlsStream, timeout, 5, "Invalid protocol version.", nil)
+	version := string(header[6])
+	if !network.ValidProtocolVersion(version) {
+		ConnectionError(connStream, timeout, 5, "Invalid protocol version.", nil)
 		return
 	}
 
 	// Get the request.
-	cobj := NewConnection(tlsStream, stream)
+	cobj := NewConnection(connStream, stream)
+	cobj.SetVersion(version)
+	setup(cobj)
 	if err := cobj.ReceiveRequest(timeout, s); err != nil {
 		switch err {
 		case ErrInvalidProtocol:
-			ConnectionError(tlsStream, timeout, 3, "Invalid request.", err)
-		case ErrInvalidSessionUsername, userlist.ErrUserNotFound, ErrInvalidSessionUsername, sessionlist.ErrSessionNotFound:
-			ConnectionError(tlsStream, timeout, 6, "Invalid or expired authentication.", err)
+			ConnectionError(connStream, timeout, 3, "Invalid request.", err)
+		case ErrInvalidSessionUsername, userlist.ErrUserNotFound, sessionlist.ErrSessionNotFound,
+			ErrNoClientCertificate, ErrInvalidCertUsername, ErrNoPeerCredentials, ErrInvalidPeerUsername,
+			ErrSessionBindingMismatch:
+			ConnectionError(connStream, timeout, 6, "Invalid or expired authentication.", err)
+		case network.ErrUnsupportedCompression:
+			ConnectionError(connStream, timeout, 38, "Unsupported compression.", err)
 		default:
-			ConnectionError(tlsStream, timeout, 4, "Connection timed out or connection error.", err)
+			ConnectionError(connStream, timeout, 4, "Connection timed out or connection error.", err)
 		}
 		return
 	}
@@ -490,15 +698,16 @@ func HandleConnection(conn *tls.Conn, timeout time.Duration, s Server) {
 
 	// If we haven't responded with the header and chunk data yet, do that now.
 	if !cobj.Command.Chunks.DidWriteChunkData() {
-		ch := network.NewChunkHandler(tlsStream)
+		ch := network.NewChunkHandler(connStream)
+		ch.SetVersion(cobj.Command.Chunks.Version())
 		if err := ch.WriteChunkResponseInfo(nil, timeout, true); err != nil {
-			ConnectionError(tlsStream, timeout, 4, "Connection timed out or connection error.", err)
+			ConnectionError(connStream, timeout, 4, "Connection timed out or connection error.", err)
 			return
 		}
 	}
 	data := []byte("END")
-	if _, err := tlsStream.Write(&data, timeout); err != nil {
-		ConnectionError(tlsStream, timeout, 4, "Connection timed out or connection error.", err)
+	if _, err := connStream.Write(&data, timeout); err != nil {
+		ConnectionError(connStream, timeout, 4, "Connection timed out or connection error.", err)
 		return
 	}
 
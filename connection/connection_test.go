@@ -5,15 +5,24 @@ package connection_test
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/cubeflix/lily/client"
 	"github.com/cubeflix/lily/commands"
 	"github.com/cubeflix/lily/connection"
 	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server"
+	"github.com/cubeflix/lily/server/config"
 	"github.com/cubeflix/lily/session"
 	slist "github.com/cubeflix/lily/session/list"
 	"github.com/cubeflix/lily/user"
@@ -153,6 +162,323 @@ func TestConnectionSessionAuth(t *testing.T) {
 	}
 }
 
+// Test session binding with session authentication.
+func TestConnectionSessionBinding(t *testing.T) {
+	// Create a session bound to an address and certificate.
+	cert := &x509.Certificate{Raw: []byte("cert")}
+	suuid := uuid.New()
+	sobj := session.NewSession(suuid, "foo", time.Duration(0))
+	sobj.SetIP("192.0.2.10")
+	sobj.SetCertFingerprint(config.CertFingerprint(cert))
+	sessionlist := slist.NewSessionList(0, 100)
+	sessionlist.SetSessionsByID(map[uuid.UUID]*session.Session{suuid: sobj})
+
+	// Create the server object.
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1,
+		time.Minute, time.Minute, time.Second, false, false, false, config.LoggingLevelInfo,
+		"", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	serverobj := server.NewServer(sessionlist, ulist.NewUserList(), cobj)
+
+	// Create the authentication request data.
+	testInput := make([]byte, 0)
+	testInput = append(testInput, []byte("S")...)
+	testInput = append(testInput, []byte{3, 0}...)
+	testInput = append(testInput, []byte("foo")...)
+	testInput = append(testInput, suuid[:]...)
+	testInput = append(testInput, []byte("END")...)
+	ds := network.DataStream(&TestStream{testInput, []byte{}})
+
+	tests := []struct {
+		mode config.SessionBindingMode
+		ip   string
+		cert *x509.Certificate
+		ok   bool
+	}{
+		{config.SessionBindingNone, "198.51.100.1", nil, true},
+		{config.SessionBindingIP, "192.0.2.10", nil, true},
+		{config.SessionBindingIP, "192.0.2.11", nil, false},
+		{config.SessionBindingSubnet, "192.0.2.11", nil, true},
+		{config.SessionBindingSubnet, "198.51.100.1", nil, false},
+		{config.SessionBindingSubnet, "2001:db8::1", nil, false},
+		{config.SessionBindingCert, "198.51.100.1", cert, true},
+		{config.SessionBindingCert, "192.0.2.10", &x509.Certificate{Raw: []byte("other")}, false},
+		{config.SessionBindingCert, "192.0.2.10", nil, false},
+	}
+	for i := range tests {
+		if err := cobj.SetSessionBinding(tests[i].mode, 24, 64); err != nil {
+			t.Error(err.Error())
+			return
+		}
+		conn := connection.NewConnection(ds, connection.NewFixedStream(testInput))
+		conn.SetRemoteIP(tests[i].ip)
+		if tests[i].cert != nil {
+			conn.SetClientCertificate(tests[i].cert)
+		}
+		auth, err := conn.ReceiveAuth(time.Duration(0), serverobj)
+		if tests[i].ok && (err != nil || auth != sobj) {
+			t.Errorf("test %d: expected session, got %v", i, err)
+		} else if !tests[i].ok && err != connection.ErrSessionBindingMismatch {
+			t.Errorf("test %d: expected binding mismatch, got %v", i, err)
+		}
+	}
+}
+
+// Test auditing commands and recording their metrics.
+func TestConnectionAudit(t *testing.T) {
+	// Create a session.
+	suuid := uuid.New()
+	sobj := session.NewSession(suuid, "foo", time.Duration(0))
+	sessionlist := slist.NewSessionList(0, 100)
+	sessionlist.SetSessionsByID(map[uuid.UUID]*session.Session{suuid: sobj})
+
+	// Create the server object with an audit log.
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1,
+		time.Minute, time.Minute, time.Second, false, false, false, config.LoggingLevelInfo,
+		"", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	cobj.SetAudit(filepath.Join(t.TempDir(), "audit.log"), true)
+	serverobj := server.NewServer(sessionlist, ulist.NewUserList(), cobj)
+	if err := serverobj.InitAudit(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer serverobj.Audit().Close()
+
+	// Execute a command.
+	sauth := auth.Auth(sobj)
+	c := commands.NewCommand(serverobj, "ping", &sauth, map[string]interface{}{
+		"drive": "main", "paths": []interface{}{"a", "b"}}, nil)
+	c.IP = "192.0.2.10"
+	commands.ExecuteCommand(c)
+
+	// Check the audit record.
+	records := serverobj.Audit().Recent(0, nil)
+	if len(records) != 1 {
+		t.Fail()
+		return
+	}
+	r := records[0]
+	if r.User != "foo" || r.AuthType != "session" || r.IP != "192.0.2.10" || r.Command != "ping" ||
+		r.Drive != "main" || len(r.Paths) != 2 || r.Code != c.RespCode {
+		t.Fail()
+	}
+	if n, err := serverobj.Audit().Verify(); err != nil || n != 1 {
+		t.Fail()
+	}
+
+	// The command is also counted in the metrics.
+	if serverobj.Metrics().Requests.Get("ping", "0") != 1 {
+		t.Fail()
+	}
+}
+
+// Test that password users are only limited once their password has been
+// verified by the command, with lockout applied.
+func TestConnectionUserLimits(t *testing.T) {
+	// Create a user.
+	uobj, err := user.NewUser("foo", "bar", access.ClearanceLevelOne)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	userlist := ulist.NewUserList()
+	userlist.SetUsersByName(map[string]*user.User{"foo": uobj})
+
+	// Create the server object, allowing one request and one failed login.
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1,
+		time.Minute, time.Minute, time.Second, false, false, false, config.LoggingLevelInfo,
+		"", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := cobj.SetUserLimits(userlimit.Limits{Requests: 1, Interval: time.Minute}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := cobj.SetLockout(1, 0, time.Minute, time.Minute); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	serverobj := server.NewServer(slist.NewSessionList(0, 100), userlist, cobj)
+	execute := func(name, password string) int {
+		uauth := auth.Auth(user.NewUserAuth("foo", password, uobj))
+		c := commands.NewCommand(serverobj, name, &uauth, map[string]interface{}{}, nil)
+		commands.ExecuteCommand(c)
+		return c.RespCode
+	}
+
+	// Commands which don't check the password are not limited, and a wrong
+	// password doesn't count against the user.
+	if code := execute("ping", "wrong"); code != 0 {
+		t.Fatal(code)
+	}
+	if code := execute("ping", "bar"); code != 0 {
+		t.Fatal(code)
+	}
+
+	// Verified passwords use up the user's requests.
+	if code := execute("listmysessions", "bar"); code != 0 {
+		t.Fatal(code)
+	}
+	if code := execute("listmysessions", "bar"); code != 7 {
+		t.Fatal(code)
+	}
+
+	// Wrong passwords count towards the lockout.
+	if code := execute("listmysessions", "wrong"); code != 6 {
+		t.Fatal(code)
+	}
+	if code := execute("listmysessions", "bar"); code != 32 {
+		t.Fatal(code)
+	}
+}
+
+// Test a connection with certificate authentication.
+func TestConnectionCertAuth(t *testing.T) {
+	// Create a user.
+	uobj, err := user.NewUser("foo", "bar", access.ClearanceLevelOne)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Create the user list.
+	userlist := ulist.NewUserList()
+	userlist.SetUsersByName(map[string]*user.User{"foo": uobj})
+
+	// Create the config and map the certificate to the user.
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1,
+		time.Minute, time.Minute, time.Second, false, false, false, config.LoggingLevelInfo,
+		"", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := cobj.AddCertUsers(map[string]string{"dns:foo.example.com": "foo"}); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Create the server object.
+	sobj := server.NewServer(slist.NewSessionList(0, 100), userlist, cobj)
+
+	// Create the authentication request data.
+	testInput := make([]byte, 0)
+	testInput = append(testInput, []byte("C")...)
+	testInput = append(testInput, []byte{3, 0}...)
+	testInput = append(testInput, []byte("foo")...)
+	testInput = append(testInput, []byte("END")...)
+	ds := network.DataStream(&TestStream{testInput, []byte{}})
+
+	// Without a client certificate, authentication should fail.
+	conn := connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	if _, err := conn.ReceiveAuth(time.Duration(0), sobj); err != connection.ErrNoClientCertificate {
+		t.Fail()
+	}
+
+	// A certificate for a different identity should fail.
+	conn = connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	conn.SetClientCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "other"}})
+	if _, err := conn.ReceiveAuth(time.Duration(0), sobj); err != connection.ErrInvalidCertUsername {
+		t.Fail()
+	}
+
+	// The identity only matches its own type.
+	conn = connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	conn.SetClientCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: "foo.example.com"}})
+	if _, err := conn.ReceiveAuth(time.Duration(0), sobj); err != connection.ErrInvalidCertUsername {
+		t.Fail()
+	}
+
+	// Identities must have a type.
+	if err := cobj.AddCertUsers(map[string]string{"foo.example.com": "foo"}); err != config.ErrInvalidCertIdentity {
+		t.Fail()
+	}
+
+	// A mapped certificate should authenticate.
+	conn = connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	conn.SetClientCertificate(&x509.Certificate{DNSNames: []string{"foo.example.com"}})
+	auth, err := conn.ReceiveAuth(time.Duration(0), sobj)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	cauth, ok := auth.(*user.CertAuth)
+	if !ok {
+		t.Fail()
+		return
+	}
+	if cauth.Authenticate() != nil {
+		t.Fail()
+	}
+	if username, u := cauth.GetInfo(); username != "foo" || u != uobj {
+		t.Fail()
+	}
+}
+
+// Test a connection with peer authentication.
+func TestConnectionPeerAuth(t *testing.T) {
+	// Create a user.
+	uobj, err := user.NewUser("foo", "bar", access.ClearanceLevelOne)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Create the user list.
+	userlist := ulist.NewUserList()
+	userlist.SetUsersByName(map[string]*user.User{"foo": uobj})
+
+	// Create the server object.
+	sobj := server.NewServer(slist.NewSessionList(0, 100), userlist, nil)
+
+	// Create the authentication request data.
+	testInput := make([]byte, 0)
+	testInput = append(testInput, []byte("P")...)
+	testInput = append(testInput, []byte{3, 0}...)
+	testInput = append(testInput, []byte("foo")...)
+	testInput = append(testInput, []byte("END")...)
+	ds := network.DataStream(&TestStream{testInput, []byte{}})
+
+	// Without mapped peer credentials, authentication should fail.
+	conn := connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	if _, err := conn.ReceiveAuth(time.Duration(0), sobj); err != connection.ErrNoPeerCredentials {
+		t.Fail()
+	}
+
+	// Peer credentials for a different user should fail.
+	conn = connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	conn.SetPeerUsername("other")
+	if _, err := conn.ReceiveAuth(time.Duration(0), sobj); err != connection.ErrInvalidPeerUsername {
+		t.Fail()
+	}
+
+	// Mapped peer credentials should authenticate.
+	conn = connection.NewConnection(ds, connection.NewFixedStream(testInput))
+	conn.SetPeerUsername("foo")
+	auth, err := conn.ReceiveAuth(time.Duration(0), sobj)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	pauth, ok := auth.(*user.PeerAuth)
+	if !ok {
+		t.Fail()
+		return
+	}
+	if username, u := pauth.GetInfo(); username != "foo" || u != uobj || pauth.Authenticate() != nil {
+		t.Fail()
+	}
+}
+
 // Test a connection with a request.
 func TestConnectionRequest(t *testing.T) {
 	// Create a session.
@@ -269,3 +595,59 @@ func TestConnectionResponse(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test that a Unix socket client receives connection errors.
+func TestConnectionLocalError(t *testing.T) {
+	// Create the server object.
+	sobj := server.NewServer(slist.NewSessionList(0, 100), ulist.NewUserList(), nil)
+
+	// Listen on a Unix socket.
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "lily.sock"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer l.Close()
+	timeout := 100 * time.Millisecond
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		connection.HandleLocalConnection(conn, "", timeout, sobj)
+	}()
+
+	// Send a header with an invalid protocol version.
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer conn.Close()
+	stream := network.NewConnStream(conn)
+	header := []byte("LILY\x00\x009")
+	if _, err := stream.Write(&header, timeout); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	stream.Flush()
+
+	// The client should receive the error code.
+	c := client.NewClient("", 0, "", "", false, false)
+	if err := c.ReceiveHeader(stream, time.Second); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err := c.ReceiveIgnoreChunkData(stream, time.Second); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	resp, err := c.ReceiveResponse(stream, time.Second)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if resp.Code != 5 {
+		t.Errorf("expected code 5, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,88 @@
+// connection/ldap.go
+// LDAP authentication for server-side connections.
+
+package connection
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/ldapauth"
+	"github.com/cubeflix/lily/server/config"
+	"github.com/cubeflix/lily/user"
+	log "github.com/sirupsen/logrus"
+)
+
+// LDAP password verifier, which logs directory errors.
+type loggingVerifier struct {
+	authenticator *ldapauth.Authenticator
+}
+
+// Verify a password.
+func (v *loggingVerifier) VerifyPassword(username, password string) (access.Clearance, error) {
+	clearance, err := v.authenticator.VerifyPassword(username, password)
+	if err != nil && err != ldapauth.ErrInvalidCredentials && err != ldapauth.ErrNoClearance {
+		log.WithFields(log.Fields{
+			"user":  username,
+			"error": err.Error(),
+		}).Error("ldap authentication error")
+	}
+	return clearance, err
+}
+
+// Get the LDAP password verifier for the server. Returns nil if LDAP
+// authentication is disabled.
+func ldapVerifier(s Server) (user.PasswordVerifier, config.LDAPMode, bool) {
+	if s.Config() == nil {
+		return nil, config.LDAPDisabled, false
+	}
+	mode, autoProvision, settings := s.Config().GetLDAP()
+	if mode == config.LDAPDisabled {
+		return nil, mode, false
+	}
+	authenticator, err := ldapauth.NewAuthenticator(settings)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("invalid ldap settings")
+		return nil, mode, false
+	}
+	return &loggingVerifier{authenticator}, mode, autoProvision
+}
+
+// Get the function to update users after LDAP authentication. The user's
+// clearance is updated from the directory, and users who do not exist are
+// created with a random local password.
+func syncUser(s Server) user.ExternalSyncFunc {
+	return func(username string, clearance access.Clearance) (*user.User, error) {
+		// Update the existing user.
+		users, err := s.Users().GetUsersByName([]string{username})
+		if err == nil {
+			if users[0].GetClearance() != clearance {
+				users[0].SetClearance(clearance)
+				s.Users().SetDirty(true)
+			}
+			return users[0], nil
+		}
+
+		// Create the user.
+		password := make([]byte, 32)
+		if _, err := rand.Read(password); err != nil {
+			return nil, err
+		}
+		uobj, err := user.NewUserWithParams(username, hex.EncodeToString(password), clearance,
+			s.Config().GetPasswordHashing())
+		if err != nil {
+			return nil, err
+		}
+		s.Users().SetUsersByName(map[string]*user.User{username: uobj})
+		log.WithFields(log.Fields{
+			"user":      username,
+			"clearance": int(clearance),
+		}).Info("provisioned ldap user")
+
+		// Return.
+		return uobj, nil
+	}
+}
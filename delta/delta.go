@@ -0,0 +1,430 @@
+// delta/delta.go
+// Block signatures and deltas.
+
+// Package delta provides rsync-style block signatures, and deltas which
+// rebuild a new file from the blocks of an old copy and literal data.
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidBlockSize = errors.New("lily.delta: Invalid block size")
+var ErrInvalidSignature = errors.New("lily.delta: Invalid signature")
+var ErrInvalidDelta = errors.New("lily.delta: Invalid delta")
+var ErrSizeMismatch = errors.New("lily.delta: Delta does not match the size")
+
+// The smallest and largest block sizes.
+const MinBlockSize = 512
+const MaxBlockSize = 1 << 20
+
+// The largest number of blocks in a signature. Files with automatically
+// chosen block sizes have at most this many blocks up to 4 TB.
+const MaxBlocks = 1 << 22
+
+// The size of the strong hash of a block, which is a truncated SHA-256 hash,
+// and of a signature entry, which is the weak hash followed by the strong
+// hash.
+const StrongSize = 16
+const EntrySize = 4 + StrongSize
+
+// Delta ops.
+const (
+	opCopy    = 'C'
+	opLiteral = 'L'
+)
+
+// The encoded sizes of the ops.
+const copyHeaderSize = 17
+const literalHeaderSize = 9
+
+// The block signature of a file.
+type Signature struct {
+	BlockSize int
+	Size      int64
+
+	// The weak rolling hash and strong hash of each block. The last block
+	// may be shorter than the block size.
+	Weak   []uint32
+	Strong [][]byte
+}
+
+// A delta op. It either copies a range of the old file, or inserts a range of
+// literal data from the new file.
+type Op struct {
+	Copy   bool
+	Offset int64
+	Length int64
+}
+
+// Choose the block size for a file of a size. It is about the square root of
+// the size, so the signature and the number of ops stay small.
+func BlockSize(size int64) int {
+	blockSize := MinBlockSize
+	for blockSize < MaxBlockSize && int64(blockSize)*int64(blockSize) < size {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+// Check if a block size is valid.
+func ValidBlockSize(blockSize int) bool {
+	return blockSize >= MinBlockSize && blockSize <= MaxBlockSize
+}
+
+// Calculate the signature of a file.
+func NewSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if !ValidBlockSize(blockSize) {
+		return nil, ErrInvalidBlockSize
+	}
+	s := &Signature{BlockSize: blockSize, Weak: []uint32{}, Strong: [][]byte{}}
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			s.Weak = append(s.Weak, weakSum(block[:n]))
+			s.Strong = append(s.Strong, strongSum(block[:n]))
+			s.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return s, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Get the number of blocks in a file of a size.
+func NumBlocks(size int64, blockSize int) int64 {
+	return (size + int64(blockSize) - 1) / int64(blockSize)
+}
+
+// Marshal the signature entries.
+func (s *Signature) MarshalBinary() ([]byte, error) {
+	data := make([]byte, len(s.Weak)*EntrySize)
+	for i := range s.Weak {
+		binary.LittleEndian.PutUint32(data[i*EntrySize:], s.Weak[i])
+		copy(data[i*EntrySize+4:(i+1)*EntrySize], s.Strong[i])
+	}
+	return data, nil
+}
+
+// Unmarshal the signature entries of a file.
+func UnmarshalSignature(blockSize int, size int64, data []byte) (*Signature, error) {
+	if !ValidBlockSize(blockSize) || size < 0 {
+		return nil, ErrInvalidSignature
+	}
+	if int64(len(data)) != NumBlocks(size, blockSize)*EntrySize {
+		return nil, ErrInvalidSignature
+	}
+	s := &Signature{BlockSize: blockSize, Size: size, Weak: []uint32{}, Strong: [][]byte{}}
+	for i := 0; i < len(data); i += EntrySize {
+		s.Weak = append(s.Weak, binary.LittleEndian.Uint32(data[i:]))
+		s.Strong = append(s.Strong, data[i+4:i+EntrySize])
+	}
+	return s, nil
+}
+
+// Calculate the delta from the file with the signature to a new file. The
+// new file is read once, and the ops refer to the literal data by its offset
+// in the new file, so it is not kept in memory.
+func Diff(s *Signature, r io.Reader) ([]Op, error) {
+	blockSize := s.BlockSize
+	if !ValidBlockSize(blockSize) || int64(len(s.Weak)) != NumBlocks(s.Size, blockSize) || len(s.Strong) != len(s.Weak) {
+		return nil, ErrInvalidSignature
+	}
+
+	// Index the full blocks by their weak hash. The last block is only
+	// matched at the end of the new file.
+	fullBlocks := int(s.Size / int64(blockSize))
+	lastLength := int(s.Size % int64(blockSize))
+	table := map[uint32][]int{}
+	for i := 0; i < fullBlocks; i++ {
+		table[s.Weak[i]] = append(table[s.Weak[i]], i)
+	}
+
+	d := &differ{}
+	bufSize := 8 * blockSize
+	if bufSize < 1<<20 {
+		bufSize = 1 << 20
+	}
+	buf := make([]byte, 0, bufSize)
+
+	// The offset of the buffer in the new file, and the start of the window
+	// in the buffer.
+	var base int64
+	start := 0
+	eof := false
+	var literalStart int64
+
+	// Fill the buffer so it has a byte after the window, unless the file has
+	// ended.
+	fill := func() error {
+		if eof || len(buf)-start > blockSize {
+			return nil
+		}
+		n := copy(buf[:cap(buf)], buf[start:])
+		base += int64(start)
+		start = 0
+		buf = buf[:cap(buf)]
+		for n < len(buf) {
+			m, err := r.Read(buf[n:])
+			n += m
+			if err == io.EOF {
+				eof = true
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		buf = buf[:n]
+		return nil
+	}
+
+	// Slide the window over the new file, looking for blocks.
+	if err := fill(); err != nil {
+		return nil, err
+	}
+	var sum rollsum
+	if len(buf)-start >= blockSize {
+		sum.init(buf[start : start+blockSize])
+	}
+	for len(buf)-start >= blockSize {
+		if indexes, ok := table[sum.sum()]; ok {
+			strong := strongSum(buf[start : start+blockSize])
+			found := -1
+			for _, i := range indexes {
+				if bytes.Equal(strong, s.Strong[i]) {
+					found = i
+					break
+				}
+			}
+			if found != -1 {
+				// Copy the block, after the literal data before it.
+				d.literal(literalStart, base+int64(start)-literalStart)
+				d.copy(int64(found)*int64(blockSize), int64(blockSize))
+				start += blockSize
+				literalStart = base + int64(start)
+				if err := fill(); err != nil {
+					return nil, err
+				}
+				if len(buf)-start >= blockSize {
+					sum.init(buf[start : start+blockSize])
+				}
+				continue
+			}
+		}
+
+		// Roll the window forward by one byte.
+		if err := fill(); err != nil {
+			return nil, err
+		}
+		if len(buf)-start <= blockSize {
+			break
+		}
+		sum.roll(buf[start], buf[start+blockSize])
+		start++
+	}
+
+	// Match the last block at the end of the file.
+	end := base + int64(len(buf))
+	if lastLength > 0 && len(buf)-start >= lastLength {
+		tail := buf[len(buf)-lastLength:]
+		if weakSum(tail) == s.Weak[fullBlocks] && bytes.Equal(strongSum(tail), s.Strong[fullBlocks]) {
+			d.literal(literalStart, end-int64(lastLength)-literalStart)
+			d.copy(int64(fullBlocks)*int64(blockSize), int64(lastLength))
+			literalStart = end
+		}
+	}
+	d.literal(literalStart, end-literalStart)
+	return d.ops, nil
+}
+
+// Builds ops, merging adjacent ops.
+type differ struct {
+	ops []Op
+}
+
+// Add a copy op.
+func (d *differ) copy(offset, length int64) {
+	if n := len(d.ops); n > 0 && d.ops[n-1].Copy && d.ops[n-1].Offset+d.ops[n-1].Length == offset {
+		d.ops[n-1].Length += length
+		return
+	}
+	d.ops = append(d.ops, Op{Copy: true, Offset: offset, Length: length})
+}
+
+// Add a literal op.
+func (d *differ) literal(offset, length int64) {
+	if length <= 0 {
+		return
+	}
+	if n := len(d.ops); n > 0 && !d.ops[n-1].Copy && d.ops[n-1].Offset+d.ops[n-1].Length == offset {
+		d.ops[n-1].Length += length
+		return
+	}
+	d.ops = append(d.ops, Op{Offset: offset, Length: length})
+}
+
+// Get the size of the literal data in the ops.
+func LiteralSize(ops []Op) int64 {
+	var size int64
+	for i := range ops {
+		if !ops[i].Copy {
+			size += ops[i].Length
+		}
+	}
+	return size
+}
+
+// Get the size of the encoded delta.
+func EncodedSize(ops []Op) int64 {
+	var size int64
+	for i := range ops {
+		if ops[i].Copy {
+			size += copyHeaderSize
+		} else {
+			size += literalHeaderSize + ops[i].Length
+		}
+	}
+	return size
+}
+
+// Encode the delta, reading the literal data from the new file.
+func WriteDelta(w io.Writer, ops []Op, newFile io.ReaderAt) error {
+	header := make([]byte, copyHeaderSize)
+	for i := range ops {
+		if ops[i].Copy {
+			header[0] = opCopy
+			binary.LittleEndian.PutUint64(header[1:], uint64(ops[i].Offset))
+			binary.LittleEndian.PutUint64(header[9:], uint64(ops[i].Length))
+			if _, err := w.Write(header); err != nil {
+				return err
+			}
+			continue
+		}
+		header[0] = opLiteral
+		binary.LittleEndian.PutUint64(header[1:], uint64(ops[i].Length))
+		if _, err := w.Write(header[:literalHeaderSize]); err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.NewSectionReader(newFile, ops[i].Offset, ops[i].Length))
+		if err != nil {
+			return err
+		}
+		if n != ops[i].Length {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// Apply an encoded delta to the old file, writing the new file, which must be
+// the new size. Fails as soon as the delta writes past the new size.
+func Apply(old io.ReaderAt, oldSize int64, delta io.Reader, newSize int64, w io.Writer) error {
+	header := make([]byte, copyHeaderSize)
+	written := int64(0)
+	for {
+		// Read the op.
+		if _, err := io.ReadFull(delta, header[:1]); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch header[0] {
+		case opCopy:
+			if _, err := io.ReadFull(delta, header[1:copyHeaderSize]); err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrInvalidDelta
+			} else if err != nil {
+				return err
+			}
+			offset := int64(binary.LittleEndian.Uint64(header[1:]))
+			length := int64(binary.LittleEndian.Uint64(header[9:]))
+			if offset < 0 || length < 0 || offset > oldSize || length > oldSize-offset {
+				return ErrInvalidDelta
+			}
+			if length > newSize-written {
+				return ErrSizeMismatch
+			}
+			n, err := io.Copy(w, io.NewSectionReader(old, offset, length))
+			if err != nil {
+				return err
+			}
+			if n != length {
+				return ErrInvalidDelta
+			}
+			written += n
+		case opLiteral:
+			if _, err := io.ReadFull(delta, header[1:literalHeaderSize]); err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrInvalidDelta
+			} else if err != nil {
+				return err
+			}
+			length := int64(binary.LittleEndian.Uint64(header[1:]))
+			if length < 0 {
+				return ErrInvalidDelta
+			}
+			if length > newSize-written {
+				return ErrSizeMismatch
+			}
+			n, err := io.CopyN(w, delta, length)
+			if err == io.EOF || n != length {
+				return ErrInvalidDelta
+			} else if err != nil {
+				return err
+			}
+			written += n
+		default:
+			return ErrInvalidDelta
+		}
+	}
+
+	// Check that the whole file was written.
+	if written != newSize {
+		return ErrSizeMismatch
+	}
+	return nil
+}
+
+// The rolling hash of a window, as in rsync.
+type rollsum struct {
+	s1, s2 uint32
+	n      uint32
+}
+
+// Start the hash with a window.
+func (r *rollsum) init(block []byte) {
+	r.s1, r.s2, r.n = 0, 0, uint32(len(block))
+	for _, b := range block {
+		r.s1 += uint32(b)
+		r.s2 += r.s1
+	}
+}
+
+// Remove a byte from the start of the window and add a byte to the end.
+func (r *rollsum) roll(out, in byte) {
+	r.s1 += uint32(in) - uint32(out)
+	r.s2 += r.s1 - r.n*uint32(out)
+}
+
+// Get the hash.
+func (r *rollsum) sum() uint32 {
+	return r.s2<<16 | r.s1&0xffff
+}
+
+// Calculate the weak hash of a block.
+func weakSum(block []byte) uint32 {
+	var r rollsum
+	r.init(block)
+	return r.sum()
+}
+
+// Calculate the strong hash of a block.
+func strongSum(block []byte) []byte {
+	sum := sha256.Sum256(block)
+	return sum[:StrongSize]
+}
@@ -0,0 +1,136 @@
+// delta/delta_test.go
+// Testing for delta/delta.go.
+
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// Calculate and apply a delta, and check that it rebuilds the new file.
+// Returns the ops.
+func roundTrip(t *testing.T, old, new []byte, blockSize int) []Op {
+	t.Helper()
+	s, err := NewSignature(bytes.NewReader(old), blockSize)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Send the signature.
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s, err = UnmarshalSignature(s.BlockSize, s.Size, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ops, err := Diff(s, bytes.NewReader(new))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	encoded := &bytes.Buffer{}
+	if err := WriteDelta(encoded, ops, bytes.NewReader(new)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if int64(encoded.Len()) != EncodedSize(ops) {
+		t.Fatal("encoded size", encoded.Len(), EncodedSize(ops))
+	}
+	result := &bytes.Buffer{}
+	if err := Apply(bytes.NewReader(old), int64(len(old)), encoded, int64(len(new)), result); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(result.Bytes(), new) {
+		t.Fatal("rebuilt file does not match")
+	}
+	return ops
+}
+
+// Test deltas of changed files.
+func TestDiffApply(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	old := make([]byte, 3*1024*1024+123)
+	r.Read(old)
+
+	// An unchanged file is copied entirely.
+	ops := roundTrip(t, old, old, 4096)
+	if len(ops) != 1 || !ops[0].Copy || LiteralSize(ops) != 0 {
+		t.Fatal(ops)
+	}
+
+	// Change some bytes, insert some bytes and remove some bytes.
+	new := append([]byte{}, old...)
+	copy(new[100000:], []byte("changed"))
+	new = append(new[:2000000], append([]byte("inserted data"), new[2000000:]...)...)
+	new = append(new[:3000000], new[3000500:]...)
+	ops = roundTrip(t, old, new, 4096)
+	if LiteralSize(ops) > 3*4096 {
+		t.Fatal("too much literal data", LiteralSize(ops))
+	}
+
+	// Test empty and unrelated files.
+	roundTrip(t, []byte{}, new, 4096)
+	roundTrip(t, old, []byte{}, 4096)
+	other := make([]byte, 10000)
+	r.Read(other)
+	ops = roundTrip(t, old, other, 512)
+	if LiteralSize(ops) != int64(len(other)) {
+		t.Fatal(ops)
+	}
+
+	// Files shorter than a block.
+	roundTrip(t, []byte("short"), []byte("short"), 512)
+	roundTrip(t, []byte("short"), []byte("a bit longer"), 512)
+}
+
+// Test the rolling hash.
+func TestRollsum(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	var r rollsum
+	r.init(data[:16])
+	for i := 0; i+16 < len(data); i++ {
+		r.roll(data[i], data[i+16])
+		if r.sum() != weakSum(data[i+1:i+17]) {
+			t.Fatal("rolled hash does not match at", i)
+		}
+	}
+}
+
+// Test invalid deltas and signatures.
+func TestInvalid(t *testing.T) {
+	old := []byte("old data")
+	for _, delta := range [][]byte{
+		{'X'},
+		{opCopy, 0, 0},
+		{opCopy, 0, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 0, 0, 0, 0},
+		{opLiteral, 10, 0, 0, 0, 0, 0, 0, 0, 'a'},
+	} {
+		if err := Apply(bytes.NewReader(old), int64(len(old)), bytes.NewReader(delta), 100, &bytes.Buffer{}); err != ErrInvalidDelta {
+			t.Fatal(delta, err)
+		}
+	}
+
+	// Deltas must write exactly the new size, and fail before writing past it.
+	for _, delta := range [][]byte{
+		{},
+		{opCopy, 0, 0, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0},
+		{opLiteral, 0, 0, 0, 0, 0, 0, 0, 1},
+	} {
+		result := &bytes.Buffer{}
+		if err := Apply(bytes.NewReader(old), int64(len(old)), bytes.NewReader(delta), 4, result); err != ErrSizeMismatch || result.Len() != 0 {
+			t.Fatal(delta, err)
+		}
+	}
+	if _, err := UnmarshalSignature(512, 1000, make([]byte, EntrySize)); err != ErrInvalidSignature {
+		t.Fail()
+	}
+	if _, err := NewSignature(bytes.NewReader(old), 100); err != ErrInvalidBlockSize {
+		t.Fail()
+	}
+	if BlockSize(0) != MinBlockSize || BlockSize(20<<30) != 262144 || BlockSize(1<<50) != MaxBlockSize {
+		t.Fail()
+	}
+}
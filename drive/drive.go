@@ -40,6 +40,10 @@ type Drive struct {
 
 	// Root filesystem object.
 	fs *fs.Directory
+
+	// Uploads in progress, by ID.
+	uploads     map[string]*upload
+	uploadsLock sync.Mutex
 }
 
 var ErrPathNotFound = errors.New("lily.drive: Path not found")
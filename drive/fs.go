@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cubeflix/lily/delta"
 	"github.com/cubeflix/lily/fs"
 	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/security/access"
@@ -29,10 +30,21 @@ var ErrInvalidLength = errors.New("lily.drive: Invalid length of argument array"
 var ErrInvalidChunks = errors.New("lily.drive: Invalid chunks")
 var ErrInvalidStartEnd = errors.New("lily.drive: Invalid start and end values")
 var ErrCannotAccess = errors.New("lily.drive: Cannot access/modify")
+var ErrHashMismatch = errors.New("lily.drive: Hash mismatch")
 var EmptyShaHash = sha256.Sum256([]byte{})
 
 var IllegalNames = "\"*/:<>?\\|"
 
+// The directory in the root of the drive on the host which holds the server's
+// temporary files. The name is reserved, so it can't be used in the drive.
+const TempDirName = ".lilytemp"
+
+// The suffix of the copies of files being patched.
+const patchSuffix = ".lilypatch"
+
+// The number of signature entries in each chunk.
+const signatureChunkEntries = 4096
+
 // Path status.
 type PathStatus struct {
 	Exists       bool
@@ -40,6 +52,13 @@ type PathStatus struct {
 	IsFile       bool
 	LastEditTime int64
 	LastEditor   string
+	Size         int64
+	Hash         []byte
+}
+
+// Check that a name can be used for a file or directory.
+func validName(name string) bool {
+	return name != "" && name != TempDirName && !strings.ContainsAny(name, IllegalNames)
 }
 
 // Get the full host system path for a local path, given a drive.
@@ -47,6 +66,39 @@ func (d *Drive) getHostPath(path string) string {
 	return filepath.Join(d.path, path)
 }
 
+// Create a temporary file in the drive's temporary directory. The pattern is
+// used as in os.CreateTemp.
+func (d *Drive) createTempFile(pattern string) (*os.File, error) {
+	dir := filepath.Join(d.path, TempDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, pattern)
+}
+
+// Remove the copies of files left behind by uploads and patches which were in
+// progress when the server stopped. This must be called before any uploads or
+// patches begin.
+func (d *Drive) RemoveTempFiles() error {
+	dir := filepath.Join(d.path, TempDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && (strings.HasSuffix(name, uploadSuffix) || strings.HasSuffix(name, patchSuffix)) {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Create directories.
 func (d *Drive) CreateDirs(dirs []string, settings []*access.AccessSettings, useParentAccessSettings bool, username string, user *user.User) error {
 	var err error
@@ -86,7 +138,7 @@ func (d *Drive) CreateDirs(dirs []string, settings []*access.AccessSettings, use
 		parent.AcquireLock()
 
 		// Check that the name is valid.
-		if strings.ContainsAny(split[len(split)-1], IllegalNames) {
+		if !validName(split[len(split)-1]) {
 			parent.ReleaseLock()
 			return ErrInvalidName
 		}
@@ -190,6 +242,9 @@ func (d *Drive) CreateDirsTree(parent string, dirs []string, parentSettings *acc
 	if err != nil {
 		return err
 	}
+	if !validName(split[len(split)-1]) {
+		return ErrInvalidName
+	}
 	parentParent, err := d.GetDirectoryByPath(strings.Join(split[:len(split)-1], "/"))
 	if err != nil {
 		return err
@@ -278,7 +333,7 @@ func (d *Drive) CreateDirsTree(parent string, dirs []string, parentSettings *acc
 		}
 
 		// Check that the name is valid.
-		if strings.ContainsAny(split[len(split)-1], IllegalNames) {
+		if !validName(splitPath[len(splitPath)-1]) {
 			root.ReleaseLock()
 			return ErrInvalidName
 		}
@@ -360,8 +415,20 @@ func (d *Drive) ListDir(dir string, user *user.User) ([]fs.ListDirObj, error) {
 	}
 	dirobj.Lock.RUnlock()
 
-	// Return the listed directory.
-	return dirobj.ListDir(), nil
+	// List the directory, and get the sizes of the files from the host
+	// filesystem.
+	list := dirobj.ListDir()
+	for i := range list {
+		if !list[i].IsFile {
+			continue
+		}
+		if stat, err := os.Stat(d.getHostPath(filepath.Join(dir, list[i].Name))); err == nil {
+			list[i].Size = stat.Size()
+		}
+	}
+
+	// Return.
+	return list, nil
 }
 
 // Rename directories.
@@ -384,7 +451,7 @@ func (d *Drive) RenameDirs(dirs []string, newNames []string, username string, us
 		}
 
 		// Make sure that the name is correct.
-		if strings.ContainsAny(newNames[i], IllegalNames) || newNames[i] == "" {
+		if !validName(newNames[i]) {
 			return ErrInvalidName
 		}
 
@@ -511,7 +578,7 @@ func (d *Drive) MoveDirs(dirs, dests []string, username string, user *user.User)
 		}
 
 		// Make sure that the name is correct.
-		if strings.ContainsAny(split[len(split)-1], IllegalNames) || split[len(split)-1] == "" {
+		if !validName(split[len(split)-1]) {
 			return ErrInvalidName
 		}
 
@@ -780,7 +847,7 @@ func (d *Drive) CreateFiles(files []string, settings []*access.AccessSettings, u
 		}
 
 		// Check that the name is valid.
-		if strings.ContainsAny(split[len(split)-1], IllegalNames) {
+		if !validName(split[len(split)-1]) {
 			parent.ReleaseLock()
 			return ErrInvalidName
 		}
@@ -1041,6 +1108,227 @@ func (d *Drive) WriteFiles(files []string, start []int64, clear []bool, handler
 	return nil
 }
 
+// Get the block signature of a file, writing the packed signature entries as
+// chunks. Returns the block size and the size of the file.
+func (d *Drive) GetSignature(path string, blockSize int, handler *network.ChunkHandler, timeout time.Duration, user *user.User) (int, int64, error) {
+	// Check for an empty path.
+	clean, err := fs.CleanPath(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if clean == "" {
+		return 0, 0, ErrEmptyPath
+	}
+
+	// Get the file lock.
+	file, err := d.GetFileByPath(clean)
+	if err != nil {
+		return 0, 0, err
+	}
+	file.AcquireRLock()
+
+	// Check if we can access.
+	if !user.CanAccess(file.Settings) {
+		file.ReleaseRLock()
+		return 0, 0, ErrCannotAccess
+	}
+
+	// Get the block size.
+	f, err := os.Open(d.getHostPath(clean))
+	if err != nil {
+		file.ReleaseRLock()
+		return 0, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		file.ReleaseRLock()
+		f.Close()
+		return 0, 0, err
+	}
+	if blockSize == 0 {
+		blockSize = delta.BlockSize(info.Size())
+	}
+	if !delta.ValidBlockSize(blockSize) || delta.NumBlocks(info.Size(), blockSize) > delta.MaxBlocks {
+		file.ReleaseRLock()
+		f.Close()
+		return 0, 0, delta.ErrInvalidBlockSize
+	}
+
+	// Calculate the signature.
+	signature, err := delta.NewSignature(f, blockSize)
+	f.Close()
+	file.ReleaseRLock()
+	if err != nil {
+		return 0, 0, err
+	}
+	data, err := signature.MarshalBinary()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Write the signature entries.
+	chunkSize := signatureChunkEntries * delta.EntrySize
+	numChunks := (len(data) + chunkSize - 1) / chunkSize
+	if err := handler.WriteChunkResponseInfo([]network.ChunkInfo{{Name: path, NumChunks: numChunks}}, timeout, true); err != nil {
+		return 0, 0, err
+	}
+	for i := 0; i < numChunks; i++ {
+		chunk := data[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := handler.WriteChunkInfo(path, len(chunk), timeout); err != nil {
+			return 0, 0, err
+		}
+		if err := handler.WriteChunk(&chunk, timeout); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Return.
+	return blockSize, info.Size(), nil
+}
+
+// Patch a file with a delta from the chunk handler. The delta is applied to a
+// copy of the file, which replaces the file if its size and hash match. The
+// size can't be larger than the maximum size, if it is not zero, or the free
+// space on the drive.
+func (d *Drive) PatchFile(path string, hash []byte, size, maxSize int64, handler *network.ChunkHandler, timeout time.Duration, username string, user *user.User) error {
+	now := time.Now()
+
+	// Read the chunks from the handler.
+	chunks, err := handler.GetChunkRequestInfo(timeout)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the chunks are correct.
+	if len(chunks) != 1 || chunks[0].Name != path {
+		return ErrInvalidChunks
+	}
+	if len(hash) != sha256.Size {
+		return ErrInvalidLength
+	}
+	if size < 0 {
+		return ErrInvalidStartEnd
+	}
+	if maxSize > 0 && size > maxSize {
+		return ErrUploadTooLarge
+	}
+	if free, err := freeSpace(d.path); err == nil && size > free {
+		return ErrUploadTooLarge
+	}
+
+	// Check for an empty path.
+	clean, err := fs.CleanPath(path)
+	if err != nil {
+		return err
+	}
+	if clean == "" {
+		return ErrEmptyPath
+	}
+
+	// Check if we can modify the file.
+	file, err := d.GetFileByPath(clean)
+	if err != nil {
+		return err
+	}
+	file.AcquireRLock()
+	canModify := user.CanModify(file.Settings)
+	file.ReleaseRLock()
+	if !canModify {
+		return ErrCannotAccess
+	}
+
+	// Apply the delta to a copy of the file. The file isn't locked while the
+	// delta is received, since the hash check ensures the copy is correct even
+	// if the file changes.
+	hostPath := d.getHostPath(clean)
+	temp, err := d.createTempFile("*" + patchSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	if err := applyPatch(hostPath, hash, size, temp, fs.NewChunkReader(path, chunks[0].NumChunks, handler, timeout), handler, timeout); err != nil {
+		return err
+	}
+
+	// Get the file lock.
+	file, err = d.GetFileByPath(clean)
+	if err != nil {
+		return err
+	}
+	file.AcquireLock()
+
+	// Check if we can modify.
+	if !user.CanModify(file.Settings) {
+		file.ReleaseLock()
+		return ErrCannotAccess
+	}
+
+	// Replace the file.
+	info, err := os.Stat(hostPath)
+	if err == nil {
+		err = temp.Chmod(info.Mode())
+	}
+	if err == nil {
+		err = temp.Close()
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), hostPath)
+	}
+	file.ReleaseLock()
+	if err != nil {
+		return err
+	}
+
+	// Set the edit information for the file.
+	file.SetHash(hash)
+	file.SetLastEditTime(now)
+	file.SetLastEditor(username)
+	d.AcquireLock()
+	d.SetDirty(true)
+	d.ReleaseLock()
+
+	// Return.
+	return nil
+}
+
+// Apply a delta to the file on the host, writing the new file, which must be
+// the size and match the hash, to the copy.
+func applyPatch(hostPath string, hash []byte, size int64, temp *os.File, reader io.Reader, handler *network.ChunkHandler, timeout time.Duration) error {
+	// Open the old file.
+	old, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	info, err := old.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Apply the delta. If it fails, we read the rest of the delta so the
+	// response can be sent.
+	hasher := sha256.New()
+	if err := delta.Apply(old, info.Size(), reader, size, io.MultiWriter(temp, hasher)); err != nil {
+		if _, drainErr := io.Copy(io.Discard, reader); drainErr == nil {
+			handler.GetFooter(timeout)
+		}
+		return err
+	}
+	if err := handler.GetFooter(timeout); err != nil {
+		return err
+	}
+
+	// Check the hash.
+	if !bytes.Equal(hasher.Sum(nil), hash) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
 // Rename files.
 func (d *Drive) RenameFiles(files []string, newNames []string, username string, user *user.User) error {
 	var err error
@@ -1061,7 +1349,7 @@ func (d *Drive) RenameFiles(files []string, newNames []string, username string,
 		}
 
 		// Make sure that the name is correct.
-		if strings.ContainsAny(newNames[i], IllegalNames) || newNames[i] == "" {
+		if !validName(newNames[i]) {
 			return ErrInvalidName
 		}
 
@@ -1189,7 +1477,7 @@ func (d *Drive) MoveFiles(files, dests []string, username string, user *user.Use
 		}
 
 		// Make sure that the name is correct.
-		if strings.ContainsAny(split[len(split)-1], IllegalNames) || split[len(split)-1] == "" {
+		if !validName(split[len(split)-1]) {
 			return ErrInvalidName
 		}
 	}
@@ -1462,6 +1750,8 @@ func (d *Drive) Stat(paths []string, user *user.User) (map[string]PathStatus, er
 					IsFile:       listdir[j].IsFile,
 					LastEditTime: lastEditTime.Unix(),
 					LastEditor:   lastEditor,
+					Size:         listdir[j].Size,
+					Hash:         listdir[j].Hash,
 				}
 				found = true
 			}
@@ -4,11 +4,14 @@
 package drive
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/cubeflix/lily/delta"
 	"github.com/cubeflix/lily/fs"
 	"github.com/cubeflix/lily/network"
 	"github.com/cubeflix/lily/security/access"
@@ -915,7 +918,10 @@ func TestHashVerify(t *testing.T) {
 	data = []byte("hello")
 	c.WriteChunk(&data, time.Duration(0))
 	c.WriteFooter(time.Duration(0))
-	drive.WriteFiles([]string{"./a", "b"}, []int64{0, 0}, []bool{false}, c, time.Duration(0), "foo", u)
+	err = drive.WriteFiles([]string{"./a", "b"}, []int64{0, 0}, []bool{false, false}, c, time.Duration(0), "foo", u)
+	if err != nil {
+		t.Error(err.Error())
+	}
 
 	// Verify the hashes.
 	verify, err := drive.VerifyHashes([]string{"./a", "b"}, u)
@@ -932,6 +938,20 @@ func TestHashVerify(t *testing.T) {
 		t.Fail()
 	}
 
+	// Check the sizes and hashes in the status.
+	stat, err := drive.Stat([]string{"a", "b"}, u)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	hash := sha256.Sum256([]byte("hello world"))
+	if stat["a"].Size != 11 || !bytes.Equal(stat["a"].Hash, hash[:]) {
+		t.Fail()
+	}
+	hash = sha256.Sum256([]byte("hello"))
+	if stat["b"].Size != 5 || !bytes.Equal(stat["b"].Hash, hash[:]) {
+		t.Fail()
+	}
+
 	// Modify the files.
 	err = os.WriteFile(drive.getHostPath("a"), []byte("world"), 6666)
 	if err != nil {
@@ -963,3 +983,130 @@ func TestHashVerify(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test getting a signature and patching a file.
+func TestPatchFile(t *testing.T) {
+	u, err := user.NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	a, err := access.NewAccessSettings(access.ClearanceLevelOne, access.ClearanceLevelTwo)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	root, err := fs.NewDirectory("", true, &fs.Directory{}, a)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	tempdir := t.TempDir()
+	drive := NewDrive("foo", tempdir, root)
+	err = drive.CreateFiles([]string{"a"}, []*access.AccessSettings{}, true, "foo", u)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	old := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	err = os.WriteFile(drive.getHostPath("a"), old, 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Get the signature.
+	ts := &TestStream{
+		[]byte{},
+	}
+	ds := network.DataStream(ts)
+	c := network.NewChunkHandler(ds)
+	blockSize, size, err := drive.GetSignature("a", 0, c, time.Duration(0), u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if blockSize != delta.MinBlockSize || size != int64(len(old)) {
+		t.Fail()
+	}
+	header := make([]byte, 5)
+	ds.Read(&header, time.Duration(0))
+	chunks, err := c.GetChunkRequestInfo(time.Duration(0))
+	if err != nil || len(chunks) != 1 || chunks[0].NumChunks != 1 {
+		t.Fatal(chunks, err)
+	}
+	_, length, err := c.GetChunkInfo(time.Duration(0))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data := make([]byte, length)
+	if err := c.GetChunk(&data, time.Duration(0)); err != nil {
+		t.Fatal(err.Error())
+	}
+	signature, err := delta.UnmarshalSignature(blockSize, size, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Patch the file.
+	new := append(append([]byte("start"), old[:8000]...), old[9000:]...)
+	ops, err := delta.Diff(signature, bytes.NewReader(new))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	encoded := &bytes.Buffer{}
+	if err := delta.WriteDelta(encoded, ops, bytes.NewReader(new)); err != nil {
+		t.Fatal(err.Error())
+	}
+	writePatch := func() {
+		c.WriteChunkResponseInfo([]network.ChunkInfo{{Name: "a", NumChunks: 1}}, time.Duration(0), false)
+		c.WriteChunkInfo("a", encoded.Len(), time.Duration(0))
+		data := encoded.Bytes()
+		c.WriteChunk(&data, time.Duration(0))
+		c.WriteFooter(time.Duration(0))
+	}
+	writePatch()
+	hash := sha256.Sum256(new)
+	err = drive.PatchFile("a", hash[:], int64(len(new)), 0, c, time.Duration(0), "foo", u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err = os.ReadFile(drive.getHostPath("a"))
+	if err != nil || !bytes.Equal(data, new) {
+		t.Fatal("patched file does not match")
+	}
+	verify, err := drive.VerifyHashes([]string{"a"}, u)
+	if err != nil || !verify["a"] {
+		t.Fail()
+	}
+
+	// A wrong hash leaves the file unchanged.
+	err = os.WriteFile(drive.getHostPath("a"), old, 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	writePatch()
+	err = drive.PatchFile("a", make([]byte, sha256.Size), int64(len(new)), 0, c, time.Duration(0), "foo", u)
+	if err != ErrHashMismatch {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(drive.getHostPath("a"))
+	if err != nil || !bytes.Equal(data, old) {
+		t.Fail()
+	}
+
+	// The delta must write the size of the new file, which can't be larger
+	// than the maximum size.
+	writePatch()
+	err = drive.PatchFile("a", hash[:], int64(len(new))-1, 0, c, time.Duration(0), "foo", u)
+	if err != delta.ErrSizeMismatch {
+		t.Fatal(err)
+	}
+	writePatch()
+	err = drive.PatchFile("a", hash[:], int64(len(new)), int64(len(new))-1, c, time.Duration(0), "foo", u)
+	if err != ErrUploadTooLarge {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(drive.getHostPath("a"))
+	if err != nil || !bytes.Equal(data, old) {
+		t.Fail()
+	}
+	entries, err := os.ReadDir(filepath.Join(tempdir, TempDirName))
+	if err != nil || len(entries) != 0 {
+		t.Fatal("temporary file was not removed")
+	}
+}
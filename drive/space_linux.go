@@ -0,0 +1,18 @@
+// drive/space_linux.go
+// Free disk space on Linux.
+
+package drive
+
+import (
+	"syscall"
+)
+
+// Get the free space available to the server in the filesystem containing a
+// path, in bytes.
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,19 @@
+// drive/space_other.go
+// Free disk space on other platforms.
+
+//go:build !linux
+// +build !linux
+
+package drive
+
+import (
+	"errors"
+)
+
+var ErrFreeSpaceUnsupported = errors.New("lily.drive: Free space is not supported on this platform")
+
+// Get the free space available to the server in the filesystem containing a
+// path. Free space is only supported on Linux.
+func freeSpace(path string) (int64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -0,0 +1,323 @@
+// drive/upload.go
+// Uploads of files in ranges, which can be written concurrently.
+
+package drive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cubeflix/lily/fs"
+	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/user"
+	"github.com/google/uuid"
+)
+
+var ErrUploadNotFound = errors.New("lily.drive: Upload not found")
+var ErrIncompleteUpload = errors.New("lily.drive: Upload is missing ranges")
+var ErrUploadTooLarge = errors.New("lily.drive: Upload is too large")
+
+// Uploads which are not written to for this long are aborted by the cron
+// worker, or when the next upload begins.
+const UploadExpiry = time.Hour
+
+// The suffix of the copies of files being uploaded.
+const uploadSuffix = ".lilyupload"
+
+// An upload of a file in ranges. The ranges are written to a copy of the file,
+// which replaces the file once every range has arrived.
+type upload struct {
+	lock sync.Mutex
+
+	// The clean path of the file, and the user who began the upload.
+	path     string
+	username string
+
+	// The copy of the file and its size.
+	temp *os.File
+	size int64
+
+	// The ranges which have been written, the number of ranges being written,
+	// and when the upload was last written to.
+	ranges   [][2]int64
+	writers  int
+	lastUsed time.Time
+}
+
+// Check if the ranges cover the whole file.
+func (u *upload) complete() bool {
+	sort.Slice(u.ranges, func(i, j int) bool {
+		return u.ranges[i][0] < u.ranges[j][0]
+	})
+	covered := int64(0)
+	for _, r := range u.ranges {
+		if r[0] > covered {
+			return false
+		}
+		if r[1] > covered {
+			covered = r[1]
+		}
+	}
+	return covered >= u.size
+}
+
+// Close and remove the copy of the file.
+func (u *upload) remove() {
+	u.temp.Close()
+	os.Remove(u.temp.Name())
+}
+
+// Get an upload, checking that the user began it. The uploads lock must be
+// held.
+func (d *Drive) getUpload(id, username string) (*upload, error) {
+	u, ok := d.uploads[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	if u.username != username {
+		return nil, ErrCannotAccess
+	}
+	return u, nil
+}
+
+// Begin an upload which replaces an existing file with a file of a size. The
+// ranges of the new file are written with WriteUploadRange, and the file is
+// replaced by CommitUpload. The size can't be larger than the maximum size, if
+// it is not zero, or the free space left after the other uploads. Returns the
+// ID of the upload.
+func (d *Drive) BeginUpload(path string, size, maxSize int64, username string, user *user.User) (string, error) {
+	if size < 0 {
+		return "", ErrInvalidStartEnd
+	}
+	if maxSize > 0 && size > maxSize {
+		return "", ErrUploadTooLarge
+	}
+
+	// Check for an empty path.
+	clean, err := fs.CleanPath(path)
+	if err != nil {
+		return "", err
+	}
+	if clean == "" {
+		return "", ErrEmptyPath
+	}
+
+	// Check if we can modify the file.
+	file, err := d.GetFileByPath(clean)
+	if err != nil {
+		return "", err
+	}
+	file.AcquireRLock()
+	canModify := user.CanModify(file.Settings)
+	file.ReleaseRLock()
+	if !canModify {
+		return "", ErrCannotAccess
+	}
+
+	// Abort expired uploads, and check that the copy fits in the free space
+	// left after the other uploads.
+	d.uploadsLock.Lock()
+	defer d.uploadsLock.Unlock()
+	d.pruneUploads(time.Now())
+	if free, err := freeSpace(d.path); err == nil {
+		for _, u := range d.uploads {
+			free -= u.size
+		}
+		if size > free {
+			return "", ErrUploadTooLarge
+		}
+	}
+
+	// Create the copy of the file.
+	temp, err := d.createTempFile("*" + uploadSuffix)
+	if err != nil {
+		return "", err
+	}
+	if err := temp.Truncate(size); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return "", err
+	}
+
+	// Add the upload.
+	id := uuid.New().String()
+	if d.uploads == nil {
+		d.uploads = map[string]*upload{}
+	}
+	d.uploads[id] = &upload{
+		path:     clean,
+		username: username,
+		temp:     temp,
+		size:     size,
+		lastUsed: time.Now(),
+	}
+
+	// Return.
+	return id, nil
+}
+
+// Abort uploads which have not been written to since the upload expiry.
+func (d *Drive) PruneUploads(now time.Time) {
+	// Acquire the lock.
+	d.uploadsLock.Lock()
+	defer d.uploadsLock.Unlock()
+
+	d.pruneUploads(now)
+}
+
+// Abort expired uploads. The uploads lock must be held.
+func (d *Drive) pruneUploads(now time.Time) {
+	for id, u := range d.uploads {
+		u.lock.Lock()
+		if u.writers == 0 && now.Sub(u.lastUsed) > UploadExpiry {
+			delete(d.uploads, id)
+			u.remove()
+		}
+		u.lock.Unlock()
+	}
+}
+
+// Write a range of an upload, starting at a position, from a single chunk
+// stream. Ranges can be written concurrently.
+func (d *Drive) WriteUploadRange(id string, start int64, handler *network.ChunkHandler, timeout time.Duration, username string) error {
+	// Read the chunks from the handler.
+	chunks, err := handler.GetChunkRequestInfo(timeout)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the chunks are correct.
+	if len(chunks) != 1 {
+		return ErrInvalidChunks
+	}
+
+	// Get the upload. The upload can't be committed while it is being
+	// written.
+	d.uploadsLock.Lock()
+	u, err := d.getUpload(id, username)
+	if err != nil {
+		d.uploadsLock.Unlock()
+		return err
+	}
+	if start < 0 || start > u.size {
+		d.uploadsLock.Unlock()
+		return ErrInvalidStartEnd
+	}
+	u.lock.Lock()
+	u.writers++
+	u.lock.Unlock()
+	d.uploadsLock.Unlock()
+
+	// Write the range.
+	end, err := fs.WriteChunksAt(chunks[0].Name, u.temp, chunks[0].NumChunks, start, u.size, handler, timeout)
+	if err == nil {
+		err = handler.GetFooter(timeout)
+	}
+	u.lock.Lock()
+	u.writers--
+	u.lastUsed = time.Now()
+	if err == nil {
+		u.ranges = append(u.ranges, [2]int64{start, end})
+	}
+	u.lock.Unlock()
+
+	// Return.
+	return err
+}
+
+// Commit an upload once every range has arrived, replacing the file with the
+// new file. If the hash is not nil, the hash of the new file must match it.
+// If ranges are missing, the upload can be committed again once they are
+// written. Otherwise, the upload ends.
+func (d *Drive) CommitUpload(id string, hash []byte, username string, user *user.User) error {
+	now := time.Now()
+
+	// End the upload once every range has arrived.
+	d.uploadsLock.Lock()
+	u, err := d.getUpload(id, username)
+	if err != nil {
+		d.uploadsLock.Unlock()
+		return err
+	}
+	u.lock.Lock()
+	complete := u.writers == 0 && u.complete()
+	u.lock.Unlock()
+	if !complete {
+		d.uploadsLock.Unlock()
+		return ErrIncompleteUpload
+	}
+	delete(d.uploads, id)
+	d.uploadsLock.Unlock()
+	defer u.remove()
+
+	// Calculate the hash.
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(u.temp, 0, u.size)); err != nil {
+		return err
+	}
+	newHash := hasher.Sum(nil)
+	if hash != nil && !bytes.Equal(newHash, hash) {
+		return ErrHashMismatch
+	}
+
+	// Get the file lock.
+	file, err := d.GetFileByPath(u.path)
+	if err != nil {
+		return err
+	}
+	file.AcquireLock()
+
+	// Check if we can modify.
+	if !user.CanModify(file.Settings) {
+		file.ReleaseLock()
+		return ErrCannotAccess
+	}
+
+	// Replace the file.
+	hostPath := d.getHostPath(u.path)
+	info, err := os.Stat(hostPath)
+	if err == nil {
+		err = u.temp.Chmod(info.Mode())
+	}
+	if err == nil {
+		err = os.Rename(u.temp.Name(), hostPath)
+	}
+	file.ReleaseLock()
+	if err != nil {
+		return err
+	}
+
+	// Set the edit information for the file.
+	file.SetHash(newHash)
+	file.SetLastEditTime(now)
+	file.SetLastEditor(username)
+	d.AcquireLock()
+	d.SetDirty(true)
+	d.ReleaseLock()
+
+	// Return.
+	return nil
+}
+
+// Abort an upload, removing the copy of the file. Ranges which are still being
+// written fail, since the copy is closed.
+func (d *Drive) AbortUpload(id, username string) error {
+	d.uploadsLock.Lock()
+	u, err := d.getUpload(id, username)
+	if err != nil {
+		d.uploadsLock.Unlock()
+		return err
+	}
+	delete(d.uploads, id)
+	d.uploadsLock.Unlock()
+	u.remove()
+
+	// Return.
+	return nil
+}
@@ -0,0 +1,236 @@
+// drive/upload_test.go
+// Testing for drive/upload.go.
+
+package drive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/fs"
+	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/user"
+)
+
+// Write a range of an upload in chunks.
+func writeRange(d *Drive, id string, start int64, data []byte, chunkSize int) error {
+	c := network.NewChunkHandler(network.DataStream(&TestStream{[]byte{}}))
+	numChunks := (len(data) + chunkSize - 1) / chunkSize
+	c.WriteChunkResponseInfo([]network.ChunkInfo{{Name: "a", NumChunks: numChunks}}, time.Duration(0), false)
+	for i := 0; i < len(data); i += chunkSize {
+		chunk := data[i:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		chunk = append([]byte{}, chunk...)
+		c.WriteChunkInfo("a", len(chunk), time.Duration(0))
+		c.WriteChunk(&chunk, time.Duration(0))
+	}
+	c.WriteFooter(time.Duration(0))
+	return d.WriteUploadRange(id, start, c, time.Duration(0), "foo")
+}
+
+// Test uploading a file in ranges.
+func TestUpload(t *testing.T) {
+	u, err := user.NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	a, err := access.NewAccessSettings(access.ClearanceLevelOne, access.ClearanceLevelTwo)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	root, err := fs.NewDirectory("", true, &fs.Directory{}, a)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	tempdir := t.TempDir()
+	drive := NewDrive("foo", tempdir, root)
+	err = drive.CreateFiles([]string{"a"}, []*access.AccessSettings{}, true, "foo", u)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	err = os.WriteFile(drive.getHostPath("a"), []byte("old data"), 0644)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Write the ranges concurrently, leaving out the last range.
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	id, err := drive.BeginUpload("a", int64(len(data)), 0, "foo", u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	const rangeSize = 40000
+	var wg sync.WaitGroup
+	errs := make(chan error, len(data)/rangeSize+1)
+	for start := 0; start+rangeSize < len(data); start += rangeSize {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			errs <- writeRange(drive, id, int64(start), data[start:start+rangeSize], 4096)
+		}(start)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// The upload can't be committed until every range has arrived.
+	if err := drive.CommitUpload(id, nil, "foo", u); err != ErrIncompleteUpload {
+		t.Fatal(err)
+	}
+	current, _ := os.ReadFile(drive.getHostPath("a"))
+	if string(current) != "old data" {
+		t.Fatal("file changed before the upload was committed")
+	}
+	last := (len(data) - 1) / rangeSize * rangeSize
+	if err := writeRange(drive, id, int64(last), data[last:], 4096); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Ranges can't go past the end of the file, and only the user who began
+	// the upload can write it.
+	if err := writeRange(drive, id, int64(len(data)-10), data[:20], 4096); err != fs.ErrInvalidChunk {
+		t.Fatal(err)
+	}
+	if err := drive.CommitUpload(id, nil, "bar", u); err != ErrCannotAccess {
+		t.Fatal(err)
+	}
+
+	// Commit the upload.
+	hash := sha256.Sum256(data)
+	if err := drive.CommitUpload(id, hash[:], "foo", u); err != nil {
+		t.Fatal(err.Error())
+	}
+	current, err = os.ReadFile(drive.getHostPath("a"))
+	if err != nil || !bytes.Equal(current, data) {
+		t.Fatal("uploaded file does not match")
+	}
+	verify, err := drive.VerifyHashes([]string{"a"}, u)
+	if err != nil || !verify["a"] {
+		t.Fail()
+	}
+	if err := drive.CommitUpload(id, nil, "foo", u); err != ErrUploadNotFound {
+		t.Fatal(err)
+	}
+
+	// A wrong hash leaves the file unchanged.
+	id, err = drive.BeginUpload("a", 4, 0, "foo", u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := writeRange(drive, id, 0, []byte("new!"), 4096); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := drive.CommitUpload(id, hash[:], "foo", u); err != ErrHashMismatch {
+		t.Fatal(err)
+	}
+	current, _ = os.ReadFile(drive.getHostPath("a"))
+	if !bytes.Equal(current, data) {
+		t.Fatal("file changed by a failed upload")
+	}
+
+	// Aborted uploads are removed, along with their copies.
+	id, err = drive.BeginUpload("a", 4, 0, "foo", u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := drive.AbortUpload(id, "foo"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := drive.AbortUpload(id, "foo"); err != ErrUploadNotFound {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(tempdir, TempDirName))
+	if len(entries) != 0 {
+		t.Fatal(entries)
+	}
+}
+
+// Test limiting, expiring and cleaning up uploads.
+func TestUploadLimits(t *testing.T) {
+	u, err := user.NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	a, err := access.NewAccessSettings(access.ClearanceLevelOne, access.ClearanceLevelTwo)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	root, err := fs.NewDirectory("", true, &fs.Directory{}, a)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	tempdir := t.TempDir()
+	drive := NewDrive("foo", tempdir, root)
+	err = drive.CreateFiles([]string{"a"}, []*access.AccessSettings{}, true, "foo", u)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Uploads can't be larger than the maximum size or the free space.
+	if _, err := drive.BeginUpload("a", 11, 10, "foo", u); err != ErrUploadTooLarge {
+		t.Fatal(err)
+	}
+	if _, err := drive.BeginUpload("a", 10, 10, "foo", u); err != nil {
+		t.Fatal(err.Error())
+	}
+	if runtime.GOOS == "linux" {
+		if _, err := drive.BeginUpload("a", 1<<62, 0, "foo", u); err != ErrUploadTooLarge {
+			t.Fatal(err)
+		}
+	}
+
+	// Expired uploads are aborted.
+	id, err := drive.BeginUpload("a", 4, 0, "foo", u)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	drive.PruneUploads(time.Now())
+	if err := writeRange(drive, id, 0, []byte("new!"), 4096); err != nil {
+		t.Fatal(err.Error())
+	}
+	drive.PruneUploads(time.Now().Add(UploadExpiry + time.Minute))
+	if err := drive.AbortUpload(id, "foo"); err != ErrUploadNotFound {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(tempdir, TempDirName))
+	if len(entries) != 0 {
+		t.Fatal(entries)
+	}
+
+	// The temporary directory can't be used in the drive.
+	if err := drive.CreateFiles([]string{TempDirName}, []*access.AccessSettings{}, true, "foo", u); err != ErrInvalidName {
+		t.Fatal(err)
+	}
+
+	// Copies left behind by uploads and patches of a previous server are
+	// removed, but files in the drive are not.
+	if err := os.Mkdir(filepath.Join(tempdir, "dir"), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, name := range []string{".a.123.lilyupload", "dir/.b.456.lilyupload", TempDirName + "/123.lilyupload", TempDirName + "/456.lilypatch", TempDirName + "/b"} {
+		if err := os.WriteFile(filepath.Join(tempdir, name), []byte("old"), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := drive.RemoveTempFiles(); err != nil {
+		t.Fatal(err.Error())
+	}
+	for name, exists := range map[string]bool{"a": true, ".a.123.lilyupload": true, "dir/.b.456.lilyupload": true, TempDirName + "/123.lilyupload": false, TempDirName + "/456.lilypatch": false, TempDirName + "/b": true} {
+		if _, err := os.Stat(filepath.Join(tempdir, name)); (err == nil) != exists {
+			t.Error(name, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package fs
 
 import (
 	"errors"
+	"io"
 	"os"
 	"time"
 
@@ -23,7 +24,10 @@ func ReadFileChunks(name, path string, numChunks int, chunkSize, start, end int6
 	}
 
 	// Open the file.
-	file, _ := os.Open(path)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		// We close the file and if we encounter an error, we check if the
 		// standard error is nil, then return the close error. Else, we just
@@ -37,34 +41,23 @@ func ReadFileChunks(name, path string, numChunks int, chunkSize, start, end int6
 		}
 	}()
 
-	// Read in chunks.
-	_, _ = file.Seek(start, 0)
+	// Read in chunks. The chunks are written from the file directly when the
+	// connection allows it, and otherwise through pooled buffers.
 	current := start
 	for i := 0; i < numChunks; i++ {
-		// Read the chunk.
-		var d []byte
-		if current+chunkSize > end {
-			// Too much data.
-			d = make([]byte, end-current)
-		} else {
-			// Keep reading.
-			d = make([]byte, chunkSize)
-		}
-		if d == nil {
-			// Insufficient memory. Write the remaining chunks.
-			for j := 0; j < (numChunks - i); j++ {
-				handler.WriteChunkInfo(name, 0, timeout)
-				// d = make([]byte, 0)
-				// handler.WriteChunk(&d, timeout)
-			}
-			return ErrInsufficientMemory
+		size := chunkSize
+		if current+size > end {
+			size = end - current
 		}
-		size, _ := file.Read(d)
-		current += int64(size)
 
 		// Write the chunk.
-		handler.WriteChunkInfo(name, size, timeout)
-		handler.WriteChunk(&d, timeout)
+		if err := handler.WriteChunkInfo(name, int(size), timeout); err != nil {
+			return err
+		}
+		if err := handler.WriteChunkFrom(network.NewFileSection(file, current, size), int(size), timeout); err != nil {
+			return err
+		}
+		current += size
 	}
 
 	// Return.
@@ -101,36 +94,103 @@ func WriteFileChunks(name, path string, numChunks int, start int64, clear bool,
 	}()
 
 	// Write in chunks.
+	_, err = WriteChunksAt(name, file, numChunks, start, -1, handler, timeout)
+	return err
+}
+
+// Write chunks from a chunked handler at a position, which must not go past
+// the end, unless the end is -1. Returns the position after the chunks. Since
+// the chunks are written with WriteAt, chunks can be written to different
+// parts of a file concurrently.
+func WriteChunksAt(name string, w io.WriterAt, numChunks int, start, end int64, handler *network.ChunkHandler, timeout time.Duration) (int64, error) {
 	current := start
 	for i := 0; i < numChunks; i++ {
 		// Get the chunk info.
 		cName, suint64, err := handler.GetChunkInfo(timeout)
-		size := int(suint64)
 		if err != nil {
-			return err
+			return current, err
 		}
 		if name != cName {
-			return ErrInvalidChunk
+			return current, ErrInvalidChunk
 		}
-		d := make([]byte, size)
-		if d == nil {
-			return ErrInsufficientMemory
+		if end != -1 && suint64 > uint64(end-current) {
+			return current, ErrInvalidChunk
 		}
-
-		// Read the chunk data.
+		// Read the chunk data into a pooled buffer. The handler limits the
+		// size of the chunk.
+		buf := network.GetBuffer(int(suint64))
+		d := *buf
 		err = handler.GetChunk(&d, timeout)
 		if err != nil {
-			return err
+			network.PutBuffer(buf)
+			return current, err
 		}
 
 		// Write the chunk.
-		size, err = file.WriteAt(d, int64(current))
+		size, err := w.WriteAt(d, current)
+		network.PutBuffer(buf)
 		current += int64(size)
 		if err != nil {
-			return err
+			return current, err
 		}
 	}
 
 	// Return.
-	return nil
+	return current, nil
+}
+
+// A reader over the chunks of a chunked handler.
+type ChunkReader struct {
+	name      string
+	numChunks int
+	handler   *network.ChunkHandler
+	timeout   time.Duration
+	chunk     []byte
+	buf       *[]byte
+}
+
+// Create a new reader over the chunks with a name.
+func NewChunkReader(name string, numChunks int, handler *network.ChunkHandler, timeout time.Duration) *ChunkReader {
+	return &ChunkReader{
+		name:      name,
+		numChunks: numChunks,
+		handler:   handler,
+		timeout:   timeout,
+	}
+}
+
+// Read from the chunks.
+func (r *ChunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		// Return the last chunk's buffer to the pool.
+		if r.buf != nil {
+			network.PutBuffer(r.buf)
+			r.buf = nil
+		}
+		if r.numChunks == 0 {
+			return 0, io.EOF
+		}
+
+		// Get the next chunk.
+		cName, size, err := r.handler.GetChunkInfo(r.timeout)
+		if err != nil {
+			return 0, err
+		}
+		if r.name != cName {
+			return 0, ErrInvalidChunk
+		}
+		buf := network.GetBuffer(int(size))
+		chunk := *buf
+		if err := r.handler.GetChunk(&chunk, r.timeout); err != nil {
+			network.PutBuffer(buf)
+			return 0, err
+		}
+		r.chunk, r.buf = chunk, buf
+		r.numChunks--
+	}
+
+	// Read from the chunk.
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
 }
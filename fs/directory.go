@@ -55,6 +55,10 @@ type ListDirObj struct {
 	// Last edit time and last editor.
 	LastEditTime int64
 	LastEditor   string
+
+	// Size and SHA-256 hash, for files.
+	Size int64
+	Hash []byte
 }
 
 var ErrItemNotFound = errors.New("lily.fs.Directory: Item not found")
@@ -245,12 +249,14 @@ func (d *Directory) ListDir() []ListDirObj {
 		fileobj.AcquireRLock()
 		lastEditTime := fileobj.lastEdit
 		lastEditor := fileobj.lastEditor
+		hash := fileobj.hash
 		fileobj.ReleaseRLock()
 		keys[i] = ListDirObj{
 			Name:         k,
 			IsFile:       true,
 			LastEditTime: lastEditTime.Unix(),
 			LastEditor:   lastEditor,
+			Hash:         hash,
 		}
 		i++
 	}
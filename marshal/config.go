@@ -9,6 +9,8 @@ import (
 	"io"
 	"time"
 
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/ldapauth"
 	"github.com/cubeflix/lily/server/config"
 )
 
@@ -145,6 +147,203 @@ func MarshalConfig(c *config.Config, w io.Writer) error {
 		return err
 	}
 
+	// Marshal the two-factor authentication settings.
+	data = make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(c.GetTOTPRequiredClearance()))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// Marshal the lockout settings.
+	userLockoutThreshold, ipLockoutThreshold, lockoutDuration, lockoutMaxDuration := c.GetLockout()
+	binary.LittleEndian.PutUint32(data, uint32(userLockoutThreshold))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(data, uint32(ipLockoutThreshold))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	data = make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(lockoutDuration))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(data, uint64(lockoutMaxDuration))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	data = make([]byte, 4)
+
+	// Marshal the client certificate authentication settings.
+	clientCAFile, clientAuthMode := c.GetClientAuth()
+	err = MarshalString(clientCAFile, w)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(data, uint32(clientAuthMode))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = MarshalMapStringString(c.GetCertUsers(), w)
+	if err != nil {
+		return err
+	}
+
+	// Marshal the LDAP authentication settings.
+	ldapMode, ldapAutoProvision, ldapSettings := c.GetLDAP()
+	binary.LittleEndian.PutUint32(data, uint32(ldapMode))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = MarshalBool(ldapAutoProvision, w)
+	if err != nil {
+		return err
+	}
+	for _, str := range []string{ldapSettings.URL, ldapSettings.BindDN,
+		ldapSettings.GroupBaseDN, ldapSettings.GroupFilter} {
+		err = MarshalString(str, w)
+		if err != nil {
+			return err
+		}
+	}
+	err = MarshalBool(ldapSettings.StartTLS, w)
+	if err != nil {
+		return err
+	}
+	err = MarshalBool(ldapSettings.InsecureSkipVerify, w)
+	if err != nil {
+		return err
+	}
+	err = MarshalMapStringInt(ldapSettings.GroupClearances, w)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(data, uint32(ldapSettings.DefaultClearance))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// Marshal the password policy and hash settings.
+	passwordPolicy := c.GetPasswordPolicy()
+	binary.LittleEndian.PutUint32(data, uint32(passwordPolicy.MinLength))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	for _, b := range []bool{passwordPolicy.RequireUpper, passwordPolicy.RequireLower,
+		passwordPolicy.RequireDigit, passwordPolicy.RequireSymbol} {
+		err = MarshalBool(b, w)
+		if err != nil {
+			return err
+		}
+	}
+	err = MarshalString(passwordPolicy.DenyListFile, w)
+	if err != nil {
+		return err
+	}
+	hashParams := c.GetPasswordHashing()
+	for _, v := range []uint32{uint32(hashParams.Algorithm), uint32(hashParams.BcryptCost),
+		hashParams.Argon2Time, hashParams.Argon2Memory, uint32(hashParams.Argon2Threads)} {
+		binary.LittleEndian.PutUint32(data, v)
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	err = MarshalString(c.GetSessionFile(), w)
+	if err != nil {
+		return err
+	}
+	data = make([]byte, 8)
+	sessionIdleTimeout, sessionMaxLifetime := c.GetSessionTimeouts()
+	for _, d := range []time.Duration{sessionIdleTimeout, sessionMaxLifetime} {
+		binary.LittleEndian.PutUint64(data, uint64(d))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	data = make([]byte, 4)
+	sessionBindingMode, sessionBindingIPv4Prefix, sessionBindingIPv6Prefix := c.GetSessionBinding()
+	for _, v := range []int{int(sessionBindingMode), sessionBindingIPv4Prefix, sessionBindingIPv6Prefix} {
+		binary.LittleEndian.PutUint32(data, uint32(v))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	auditFile, auditHashChain := c.GetAudit()
+	err = MarshalString(auditFile, w)
+	if err != nil {
+		return err
+	}
+	err = MarshalBool(auditHashChain, w)
+	if err != nil {
+		return err
+	}
+	err = MarshalString(c.GetMetricsAddress(), w)
+	if err != nil {
+		return err
+	}
+	data = make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(c.GetShutdownDeadline()))
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	err = MarshalUserLimits(c.GetUserLimits(), w)
+	if err != nil {
+		return err
+	}
+	err = MarshalMapUserLimits(c.GetUserLimitOverrides(), w)
+	if err != nil {
+		return err
+	}
+	uploadRate, downloadRate := c.GetBandwidthLimits()
+	for _, v := range []int64{uploadRate, downloadRate} {
+		binary.LittleEndian.PutUint64(data, uint64(v))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	ipAllowList, ipDenyList := c.GetIPFilter()
+	for _, list := range [][]string{ipAllowList, ipDenyList, c.GetTrustedProxies()} {
+		err = MarshalStringSlice(list, w)
+		if err != nil {
+			return err
+		}
+	}
+	maxConnections, maxConnectionsPerIP := c.GetConnectionLimits()
+	for _, v := range []int{maxConnections, maxConnectionsPerIP} {
+		binary.LittleEndian.PutUint64(data, uint64(v))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	err = MarshalListeners(c.GetListeners(), w)
+	if err != nil {
+		return err
+	}
+	maxChunkSize, memoryBudget, maxUploadSize := c.GetTransferLimits()
+	for _, v := range []int64{int64(maxChunkSize), memoryBudget, maxUploadSize} {
+		binary.LittleEndian.PutUint64(data, uint64(v))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Return.
 	return nil
 }
@@ -259,13 +458,271 @@ func UnmarshalConfig(r io.Reader) (*config.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	data = make([]byte, 4)
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	totpRequiredClearance := binary.LittleEndian.Uint32(data)
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	userLockoutThreshold := binary.LittleEndian.Uint32(data)
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	ipLockoutThreshold := binary.LittleEndian.Uint32(data)
+	data = make([]byte, 8)
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	lockoutDuration := time.Duration(binary.LittleEndian.Uint64(data))
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	lockoutMaxDuration := time.Duration(binary.LittleEndian.Uint64(data))
+	data = make([]byte, 4)
+	clientCAFile, err := UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	clientAuthMode := binary.LittleEndian.Uint32(data)
+	certUsers, err := UnmarshalMapStringString(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	ldapMode := binary.LittleEndian.Uint32(data)
+	ldapAutoProvision, err := UnmarshalBool(r)
+	if err != nil {
+		return nil, err
+	}
+	ldapSettings := ldapauth.Settings{}
+	for _, str := range []*string{&ldapSettings.URL, &ldapSettings.BindDN,
+		&ldapSettings.GroupBaseDN, &ldapSettings.GroupFilter} {
+		*str, err = UnmarshalString(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ldapSettings.StartTLS, err = UnmarshalBool(r)
+	if err != nil {
+		return nil, err
+	}
+	ldapSettings.InsecureSkipVerify, err = UnmarshalBool(r)
+	if err != nil {
+		return nil, err
+	}
+	ldapSettings.GroupClearances, err = UnmarshalMapStringInt(r)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	ldapSettings.DefaultClearance = int(binary.LittleEndian.Uint32(data))
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy := auth.PasswordPolicy{MinLength: int(binary.LittleEndian.Uint32(data))}
+	for _, b := range []*bool{&passwordPolicy.RequireUpper, &passwordPolicy.RequireLower,
+		&passwordPolicy.RequireDigit, &passwordPolicy.RequireSymbol} {
+		*b, err = UnmarshalBool(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	passwordPolicy.DenyListFile, err = UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	hashValues := make([]uint32, 5)
+	for i := range hashValues {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		hashValues[i] = binary.LittleEndian.Uint32(data)
+	}
+	hashParams := auth.HashParams{
+		Algorithm:     auth.Algorithm(hashValues[0]),
+		BcryptCost:    int(hashValues[1]),
+		Argon2Time:    hashValues[2],
+		Argon2Memory:  hashValues[3],
+		Argon2Threads: uint8(hashValues[4]),
+	}
+	sessionFile, err := UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	data = make([]byte, 8)
+	sessionTimeouts := make([]time.Duration, 2)
+	for i := range sessionTimeouts {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		sessionTimeouts[i] = time.Duration(binary.LittleEndian.Uint64(data))
+	}
+	data = make([]byte, 4)
+	sessionBinding := make([]int, 3)
+	for i := range sessionBinding {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		sessionBinding[i] = int(binary.LittleEndian.Uint32(data))
+	}
+	auditFile, err := UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	auditHashChain, err := UnmarshalBool(r)
+	if err != nil {
+		return nil, err
+	}
+	metricsAddress, err := UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	data = make([]byte, 8)
+	_, err = r.Read(data)
+	if err != nil {
+		return nil, err
+	}
+	shutdownDeadline := time.Duration(binary.LittleEndian.Uint64(data))
+	userLimits, err := UnmarshalUserLimits(r)
+	if err != nil {
+		return nil, err
+	}
+	userLimitOverrides, err := UnmarshalMapUserLimits(r)
+	if err != nil {
+		return nil, err
+	}
+	bandwidthLimits := make([]int64, 2)
+	for i := range bandwidthLimits {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		bandwidthLimits[i] = int64(binary.LittleEndian.Uint64(data))
+	}
+	ipLists := make([][]string, 3)
+	for i := range ipLists {
+		ipLists[i], err = UnmarshalStringSlice(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	connectionLimits := make([]int, 2)
+	for i := range connectionLimits {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		connectionLimits[i] = int(binary.LittleEndian.Uint64(data))
+	}
+	listeners, err := UnmarshalListeners(r)
+	if err != nil {
+		return nil, err
+	}
+	transferLimits := make([]int64, 3)
+	for i := range transferLimits {
+		_, err = r.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		transferLimits[i] = int64(binary.LittleEndian.Uint64(data))
+	}
 
 	// Create the new config object.
-	return config.NewConfig("", name, host, int(port), driveFiles, int(numWorkers),
+	c, err := config.NewConfig("", name, host, int(port), driveFiles, int(numWorkers),
 		int(backlog), mainCronInterval, sessionCronInterval,
 		netTimeout, verbose, logToFile, logJSON, logLevel, logPath,
 		defaultSessionExpiration, allowChangeSessionExpiration,
 		allowNonExpiringSessions, int(perUserSessionLimit), limit, int(maxLimitEvents),
 		certFiles, &tls.Config{ServerName: serverName,
 			InsecureSkipVerify: InsecureSkipVerify})
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetTOTPRequiredClearance(int(totpRequiredClearance)); err != nil {
+		return nil, err
+	}
+	if err := c.SetLockout(int(userLockoutThreshold), int(ipLockoutThreshold), lockoutDuration, lockoutMaxDuration); err != nil {
+		return nil, err
+	}
+	if err := c.SetClientAuth(clientCAFile, config.ClientAuthMode(clientAuthMode)); err != nil {
+		return nil, err
+	}
+	if err := c.AddCertUsers(certUsers); err != nil {
+		return nil, err
+	}
+	if err := c.SetLDAP(config.LDAPMode(ldapMode), ldapAutoProvision, ldapSettings); err != nil {
+		return nil, err
+	}
+	if err := c.SetPasswordPolicy(passwordPolicy); err != nil {
+		return nil, err
+	}
+	if err := c.SetPasswordHashing(hashParams); err != nil {
+		return nil, err
+	}
+	c.SetSessionFile(sessionFile)
+	if err := c.SetSessionTimeouts(sessionTimeouts[0], sessionTimeouts[1]); err != nil {
+		return nil, err
+	}
+	if err := c.SetSessionBinding(config.SessionBindingMode(sessionBinding[0]), sessionBinding[1],
+		sessionBinding[2]); err != nil {
+		return nil, err
+	}
+	c.SetAudit(auditFile, auditHashChain)
+	c.SetMetricsAddress(metricsAddress)
+	if err := c.SetShutdownDeadline(shutdownDeadline); err != nil {
+		return nil, err
+	}
+	if err := c.SetUserLimits(userLimits); err != nil {
+		return nil, err
+	}
+	for username := range userLimitOverrides {
+		if err := c.SetUserLimitOverride(username, userLimitOverrides[username]); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.SetBandwidthLimits(bandwidthLimits[0], bandwidthLimits[1]); err != nil {
+		return nil, err
+	}
+	if err := c.SetIPFilter(ipLists[0], ipLists[1]); err != nil {
+		return nil, err
+	}
+	if err := c.SetTrustedProxies(ipLists[2]); err != nil {
+		return nil, err
+	}
+	if err := c.SetConnectionLimits(connectionLimits[0], connectionLimits[1]); err != nil {
+		return nil, err
+	}
+	for i := range listeners {
+		if err := c.SetListener(listeners[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.SetTransferLimits(int(transferLimits[0]), transferLimits[1], transferLimits[2]); err != nil {
+		return nil, err
+	}
+	c.SetDirty(false)
+
+	// Return.
+	return c, nil
 }
@@ -0,0 +1,191 @@
+// marshal/config_test.go
+// Testing for marshal/config.go.
+
+package marshal
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/ldapauth"
+	"github.com/cubeflix/lily/security/userlimit"
+	"github.com/cubeflix/lily/server/config"
+)
+
+// Test marshaling a config object.
+func TestMarshalConfig(t *testing.T) {
+	// Create the config object.
+	c, err := config.NewConfig("", "foo", "127.0.0.1", 42069, map[string]string{"bar": "/bar.lilyd"},
+		5, 5, time.Minute, time.Second, time.Second, true, false, false, config.LoggingLevelInfo,
+		"", time.Hour, true, false, 10, time.Second, 10, []config.CertFilePair{}, &tls.Config{})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if c.SetTOTPRequiredClearance(5) != nil {
+		t.Fail()
+	}
+	if c.SetLockout(5, 20, time.Minute, time.Hour) != nil {
+		t.Fail()
+	}
+	if c.SetClientAuth("/ca.pem", config.ClientAuthRequire) != nil {
+		t.Fail()
+	}
+	if c.AddCertUsers(map[string]string{"subject:CN=foo": "foo"}) != nil {
+		t.Fail()
+	}
+	ldapSettings := ldapauth.Settings{
+		URL:              "ldaps://localhost",
+		BindDN:           "uid=%s,dc=example",
+		GroupBaseDN:      "dc=example",
+		GroupFilter:      "(member=%s)",
+		GroupClearances:  map[string]int{"cn=admins,dc=example": 5},
+		DefaultClearance: 1,
+	}
+	if c.SetLDAP(config.LDAPFallback, true, ldapSettings) != nil {
+		t.Fail()
+	}
+	passwordPolicy := auth.PasswordPolicy{MinLength: 12, RequireUpper: true, RequireSymbol: true,
+		DenyListFile: "/denylist.txt"}
+	if c.SetPasswordPolicy(passwordPolicy) != nil {
+		t.Fail()
+	}
+	hashParams := auth.HashParams{Algorithm: auth.Argon2id, BcryptCost: 12, Argon2Time: 3,
+		Argon2Memory: 65536, Argon2Threads: 4}
+	if c.SetPasswordHashing(hashParams) != nil {
+		t.Fail()
+	}
+	c.SetSessionFile("/sessions.lily")
+	if c.SetSessionTimeouts(15*time.Minute, 12*time.Hour) != nil {
+		t.Fail()
+	}
+	if c.SetSessionBinding(config.SessionBindingSubnet, 16, 48) != nil {
+		t.Fail()
+	}
+	c.SetAudit("/audit.log", true)
+	c.SetMetricsAddress("127.0.0.1:9100")
+	if c.SetShutdownDeadline(time.Minute) != nil {
+		t.Fail()
+	}
+	if c.SetUserLimits(userlimit.Limits{Requests: 100, Interval: time.Minute, UploadRate: 1024}) != nil {
+		t.Fail()
+	}
+	if c.SetUserLimitOverride("foo", userlimit.Limits{DownloadRate: 2048}) != nil {
+		t.Fail()
+	}
+	if c.SetBandwidthLimits(4096, 8192) != nil {
+		t.Fail()
+	}
+	if c.SetIPFilter([]string{"10.0.0.0/8"}, []string{"10.0.0.1"}) != nil {
+		t.Fail()
+	}
+	if c.SetTrustedProxies([]string{"192.168.0.0/24"}) != nil {
+		t.Fail()
+	}
+	if c.SetConnectionLimits(100, 10) != nil {
+		t.Fail()
+	}
+	if c.SetTransferLimits(4000000, 1<<30, 1<<40) != nil {
+		t.Fail()
+	}
+	if c.SetListener(config.Listener{Name: "local", Network: config.ListenerUnix, Address: "/run/lily.sock",
+		PeerUsers: map[string]string{"backup": "foo"}}) != nil {
+		t.Fail()
+	}
+	if c.SetListener(config.Listener{Name: "ipv6", Network: config.ListenerTCP, Address: "[::1]:42069",
+		Limit: time.Second, MaxLimitEvents: 5, IPAllowList: []string{"::1"}, IPDenyList: []string{}}) != nil {
+		t.Fail()
+	}
+
+	// Marshal the config.
+	buf := bytes.NewBuffer([]byte{})
+	if err := MarshalConfig(c, buf); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Unmarshal the config.
+	cobj, err := UnmarshalConfig(buf)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Check the new config object.
+	if cobj.GetName() != "foo" || !reflect.DeepEqual(cobj.GetDriveFiles(), c.GetDriveFiles()) {
+		t.Fail()
+	}
+	if cobj.GetTOTPRequiredClearance() != 5 {
+		t.Fail()
+	}
+	if u, ip, d, m := cobj.GetLockout(); u != 5 || ip != 20 || d != time.Minute || m != time.Hour {
+		t.Fail()
+	}
+	if file, mode := cobj.GetClientAuth(); file != "/ca.pem" || mode != config.ClientAuthRequire {
+		t.Fail()
+	}
+	if !reflect.DeepEqual(cobj.GetCertUsers(), c.GetCertUsers()) {
+		t.Fail()
+	}
+	if mode, autoProvision, settings := cobj.GetLDAP(); mode != config.LDAPFallback || !autoProvision ||
+		!reflect.DeepEqual(settings, ldapSettings) {
+		t.Fail()
+	}
+	if cobj.GetPasswordPolicy() != passwordPolicy || cobj.GetPasswordHashing() != hashParams {
+		t.Fail()
+	}
+	if cobj.GetSessionFile() != "/sessions.lily" {
+		t.Fail()
+	}
+	if idle, max := cobj.GetSessionTimeouts(); idle != 15*time.Minute || max != 12*time.Hour {
+		t.Fail()
+	}
+	if mode, ipv4Prefix, ipv6Prefix := cobj.GetSessionBinding(); mode != config.SessionBindingSubnet ||
+		ipv4Prefix != 16 || ipv6Prefix != 48 {
+		t.Fail()
+	}
+	if auditFile, auditHashChain := cobj.GetAudit(); auditFile != "/audit.log" || !auditHashChain {
+		t.Fail()
+	}
+	if cobj.GetMetricsAddress() != "127.0.0.1:9100" || cobj.GetShutdownDeadline() != time.Minute {
+		t.Fail()
+	}
+	if cobj.GetUserLimits() != (userlimit.Limits{Requests: 100, Interval: time.Minute, UploadRate: 1024}) {
+		t.Fail()
+	}
+	if overrides := cobj.GetUserLimitOverrides(); len(overrides) != 1 || overrides["foo"].DownloadRate != 2048 {
+		t.Fail()
+	}
+	if uploadRate, downloadRate := cobj.GetBandwidthLimits(); uploadRate != 4096 || downloadRate != 8192 {
+		t.Fail()
+	}
+	if !cobj.IPAllowed(net.ParseIP("10.0.0.2")) || cobj.IPAllowed(net.ParseIP("10.0.0.1")) {
+		t.Fail()
+	}
+	if !cobj.IsTrustedProxy(net.ParseIP("192.168.0.1")) {
+		t.Fail()
+	}
+	if maxConnections, maxConnectionsPerIP := cobj.GetConnectionLimits(); maxConnections != 100 || maxConnectionsPerIP != 10 {
+		t.Fail()
+	}
+	if maxChunkSize, memoryBudget, maxUploadSize := cobj.GetTransferLimits(); maxChunkSize != 4000000 || memoryBudget != 1<<30 || maxUploadSize != 1<<40 {
+		t.Fail()
+	}
+	if !reflect.DeepEqual(cobj.GetListeners(), c.GetListeners()) {
+		t.Fail()
+	}
+	if username, ok := cobj.GetPeerUsername("local", "backup", "1000"); !ok || username != "foo" {
+		t.Fail()
+	}
+	if cobj.ListenerIPAllowed("ipv6", net.ParseIP("::2")) || !cobj.ListenerIPAllowed("ipv6", net.ParseIP("::1")) {
+		t.Fail()
+	}
+	if cobj.IsDirty() {
+		t.Fail()
+	}
+}
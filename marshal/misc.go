@@ -7,7 +7,9 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"time"
 
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server/config"
 )
 
@@ -229,3 +231,245 @@ func UnmarshalCertFilePair(r io.Reader) ([]config.CertFilePair, error) {
 	// Return.
 	return strings, nil
 }
+
+// Marshal a Lily-encoded map[string]int.
+func MarshalMapStringInt(m map[string]int, w io.Writer) error {
+	// Write the map length.
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(len(m)))
+	_, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// Write the keys and values.
+	for i := range m {
+		err = MarshalString(i, w)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(data, uint32(m[i]))
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Return.
+	return nil
+}
+
+// Unmarshal a Lily-encoded map[string]int.
+func UnmarshalMapStringInt(r io.Reader) (map[string]int, error) {
+	// Receive the map length.
+	data := make([]byte, 4)
+	_, err := r.Read(data)
+	if err != nil {
+		return map[string]int{}, err
+	}
+	length := binary.LittleEndian.Uint32(data)
+
+	// Get the keys and values.
+	m := make(map[string]int, length)
+	for i := 0; i < int(length); i++ {
+		key, err := UnmarshalString(r)
+		if err != nil {
+			return map[string]int{}, err
+		}
+		_, err = r.Read(data)
+		if err != nil {
+			return map[string]int{}, err
+		}
+		m[key] = int(int32(binary.LittleEndian.Uint32(data)))
+	}
+
+	// Return.
+	return m, nil
+}
+
+// Marshal per-user limits.
+func MarshalUserLimits(l userlimit.Limits, w io.Writer) error {
+	data := make([]byte, 8)
+	for _, v := range []uint64{uint64(l.Requests), uint64(l.Interval), uint64(l.UploadRate), uint64(l.DownloadRate)} {
+		binary.LittleEndian.PutUint64(data, v)
+		_, err := w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Return.
+	return nil
+}
+
+// Unmarshal per-user limits.
+func UnmarshalUserLimits(r io.Reader) (userlimit.Limits, error) {
+	data := make([]byte, 8)
+	values := make([]uint64, 4)
+	for i := range values {
+		_, err := r.Read(data)
+		if err != nil {
+			return userlimit.Limits{}, err
+		}
+		values[i] = binary.LittleEndian.Uint64(data)
+	}
+
+	// Return.
+	return userlimit.Limits{
+		Requests:     int(values[0]),
+		Interval:     time.Duration(values[1]),
+		UploadRate:   int64(values[2]),
+		DownloadRate: int64(values[3]),
+	}, nil
+}
+
+// Marshal a map of usernames to per-user limits.
+func MarshalMapUserLimits(m map[string]userlimit.Limits, w io.Writer) error {
+	// Write the map length.
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(len(m)))
+	_, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// Write the keys and values.
+	for i := range m {
+		err = MarshalString(i, w)
+		if err != nil {
+			return err
+		}
+		err = MarshalUserLimits(m[i], w)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Return.
+	return nil
+}
+
+// Unmarshal a map of usernames to per-user limits.
+func UnmarshalMapUserLimits(r io.Reader) (map[string]userlimit.Limits, error) {
+	// Receive the map length.
+	data := make([]byte, 4)
+	_, err := r.Read(data)
+	if err != nil {
+		return map[string]userlimit.Limits{}, err
+	}
+	length := binary.LittleEndian.Uint32(data)
+
+	// Get the keys and values.
+	m := make(map[string]userlimit.Limits, length)
+	for i := 0; i < int(length); i++ {
+		key, err := UnmarshalString(r)
+		if err != nil {
+			return map[string]userlimit.Limits{}, err
+		}
+		m[key], err = UnmarshalUserLimits(r)
+		if err != nil {
+			return map[string]userlimit.Limits{}, err
+		}
+	}
+
+	// Return.
+	return m, nil
+}
+
+// Marshal a list of listeners.
+func MarshalListeners(l []config.Listener, w io.Writer) error {
+	// Write the list length.
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(len(l)))
+	_, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+
+	// Write the listeners.
+	data = make([]byte, 8)
+	for i := range l {
+		for _, s := range []string{l[i].Name, l[i].Network, l[i].Address} {
+			err = MarshalString(s, w)
+			if err != nil {
+				return err
+			}
+		}
+		for _, v := range []uint64{uint64(l[i].Limit), uint64(l[i].MaxLimitEvents)} {
+			binary.LittleEndian.PutUint64(data, v)
+			_, err = w.Write(data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, s := range [][]string{l[i].IPAllowList, l[i].IPDenyList} {
+			err = MarshalStringSlice(s, w)
+			if err != nil {
+				return err
+			}
+		}
+		err = MarshalMapStringString(l[i].PeerUsers, w)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Return.
+	return nil
+}
+
+// Unmarshal a list of listeners.
+func UnmarshalListeners(r io.Reader) ([]config.Listener, error) {
+	// Receive the list length.
+	data := make([]byte, 4)
+	_, err := r.Read(data)
+	if err != nil {
+		return []config.Listener{}, err
+	}
+	length := binary.LittleEndian.Uint32(data)
+
+	// Get the listeners.
+	l := make([]config.Listener, length)
+	data = make([]byte, 8)
+	for i := range l {
+		strs := make([]string, 3)
+		for j := range strs {
+			strs[j], err = UnmarshalString(r)
+			if err != nil {
+				return []config.Listener{}, err
+			}
+		}
+		values := make([]uint64, 2)
+		for j := range values {
+			_, err = r.Read(data)
+			if err != nil {
+				return []config.Listener{}, err
+			}
+			values[j] = binary.LittleEndian.Uint64(data)
+		}
+		lists := make([][]string, 2)
+		for j := range lists {
+			lists[j], err = UnmarshalStringSlice(r)
+			if err != nil {
+				return []config.Listener{}, err
+			}
+		}
+		peerUsers, err := UnmarshalMapStringString(r)
+		if err != nil {
+			return []config.Listener{}, err
+		}
+		l[i] = config.Listener{
+			Name:           strs[0],
+			Network:        strs[1],
+			Address:        strs[2],
+			Limit:          time.Duration(values[0]),
+			MaxLimitEvents: int(values[1]),
+			IPAllowList:    lists[0],
+			IPDenyList:     lists[1],
+			PeerUsers:      peerUsers,
+		}
+	}
+
+	// Return.
+	return l, nil
+}
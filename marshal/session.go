@@ -0,0 +1,118 @@
+// marshal/session.go
+// Marshaling functions for session lists.
+
+package marshal
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/cubeflix/lily/session"
+	"github.com/cubeflix/lily/session/list"
+)
+
+// Marshal a session list. Session IDs are written as hashes.
+func MarshalSessionList(l *list.SessionList, w io.Writer) error {
+	// Get the sessions.
+	sessions := l.GetSessionHashes()
+
+	// Write the length of the list.
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(len(sessions)))
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	// Write the sessions.
+	data = make([]byte, 8)
+	for hash := range sessions {
+		if _, err := w.Write(hash[:]); err != nil {
+			return err
+		}
+		if err := MarshalString(sessions[hash].GetUsername(), w); err != nil {
+			return err
+		}
+		idleTimeout, maxLifetime := sessions[hash].GetTimeouts()
+		for _, d := range []time.Duration{sessions[hash].GetExpireAfter(), idleTimeout, maxLifetime} {
+			binary.LittleEndian.PutUint64(data, uint64(d))
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		for _, t := range []time.Time{sessions[hash].GetExpireAt(), sessions[hash].GetCreatedAt(),
+			sessions[hash].GetLastUsed()} {
+			binary.LittleEndian.PutUint64(data, uint64(t.UnixNano()))
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		if err := MarshalString(sessions[hash].GetIP(), w); err != nil {
+			return err
+		}
+		if err := MarshalString(sessions[hash].GetLabel(), w); err != nil {
+			return err
+		}
+		if err := MarshalString(sessions[hash].GetCertFingerprint(), w); err != nil {
+			return err
+		}
+	}
+
+	// Return.
+	return nil
+}
+
+// Unmarshal saved sessions by ID hash.
+func UnmarshalSessions(r io.Reader) (map[session.IDHash]*session.Session, error) {
+	// Get the length of the list.
+	data := make([]byte, 4)
+	if _, err := r.Read(data); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(data)
+
+	// Get each session.
+	sessions := map[session.IDHash]*session.Session{}
+	data = make([]byte, 8)
+	for i := 0; i < int(length); i++ {
+		var hash session.IDHash
+		if _, err := r.Read(hash[:]); err != nil {
+			return nil, err
+		}
+		username, err := UnmarshalString(r)
+		if err != nil {
+			return nil, err
+		}
+		durations := make([]time.Duration, 3)
+		for j := range durations {
+			if _, err := r.Read(data); err != nil {
+				return nil, err
+			}
+			durations[j] = time.Duration(binary.LittleEndian.Uint64(data))
+		}
+		times := make([]time.Time, 3)
+		for j := range times {
+			if _, err := r.Read(data); err != nil {
+				return nil, err
+			}
+			times[j] = time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+		}
+		ip, err := UnmarshalString(r)
+		if err != nil {
+			return nil, err
+		}
+		label, err := UnmarshalString(r)
+		if err != nil {
+			return nil, err
+		}
+		certFingerprint, err := UnmarshalString(r)
+		if err != nil {
+			return nil, err
+		}
+		sessions[hash] = session.RestoreSession(username, durations[0], times[0], durations[1], durations[2],
+			times[1], times[2], ip, label, certFingerprint)
+	}
+
+	// Return.
+	return sessions, nil
+}
@@ -0,0 +1,58 @@
+// marshal/session_test.go
+// Testing for marshal/session.go.
+
+package marshal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/session"
+	"github.com/cubeflix/lily/session/list"
+	"github.com/google/uuid"
+)
+
+// Test marshaling a session list.
+func TestMarshalSessionList(t *testing.T) {
+	// Create the session list.
+	lobj := list.NewSessionList(1, 100)
+	id := uuid.New()
+	sobj := session.NewSession(id, "foo", time.Hour)
+	sobj.SetIP("127.0.0.1")
+	sobj.SetLabel("laptop")
+	sobj.SetCertFingerprint("abcdef")
+	sobj.SetTimeouts(time.Minute, 2*time.Hour)
+	lobj.SetSessionsByID(map[uuid.UUID]*session.Session{id: sobj})
+
+	// Marshal the session list.
+	buf := bytes.NewBuffer([]byte{})
+	if err := MarshalSessionList(lobj, buf); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if bytes.Contains(buf.Bytes(), id[:]) {
+		t.Fail()
+	}
+
+	// Unmarshal the sessions.
+	sessions, err := UnmarshalSessions(buf)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	restored, ok := sessions[session.HashID(id)]
+	if !ok || len(sessions) != 1 {
+		t.Fail()
+		return
+	}
+	if restored.GetUsername() != "foo" || restored.GetExpireAfter() != time.Hour ||
+		!restored.GetExpireAt().Equal(sobj.GetExpireAt()) || !restored.GetCreatedAt().Equal(sobj.GetCreatedAt()) ||
+		restored.GetIP() != "127.0.0.1" || restored.GetLabel() != "laptop" ||
+		restored.GetCertFingerprint() != "abcdef" {
+		t.Fail()
+	}
+	if idle, max := restored.GetTimeouts(); idle != time.Minute || max != 2*time.Hour {
+		t.Fail()
+	}
+}
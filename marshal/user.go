@@ -9,6 +9,7 @@ import (
 	"io"
 
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/auth"
 	"github.com/cubeflix/lily/user"
 	"github.com/cubeflix/lily/user/list"
 )
@@ -23,12 +24,12 @@ func MarshalUser(u *user.User, w io.Writer) error {
 		return err
 	}
 
-	// Write the password hash.
+	// Write the password hash. Hashes vary in length between algorithms.
 	hash := u.GetPasswordHash()
-	if len(hash) != 60 {
+	if len(hash) == 0 {
 		return ErrInvalidHashLen
 	}
-	if _, err := w.Write(hash); err != nil {
+	if err := MarshalString(string(hash), w); err != nil {
 		return err
 	}
 
@@ -38,6 +39,23 @@ func MarshalUser(u *user.User, w io.Writer) error {
 		return err
 	}
 
+	// Write the two-factor authentication settings.
+	secret, enabled := u.GetTOTP()
+	if err := MarshalBool(enabled, w); err != nil {
+		return err
+	}
+	if err := MarshalString(string(secret), w); err != nil {
+		return err
+	}
+	codes := u.GetRecoveryCodes()
+	codeStrings := make([]string, len(codes))
+	for i := range codes {
+		codeStrings[i] = string(codes[i])
+	}
+	if err := MarshalStringSlice(codeStrings, w); err != nil {
+		return err
+	}
+
 	// Return.
 	return nil
 }
@@ -51,8 +69,8 @@ func UnmarshalUser(r io.Reader) (*user.User, error) {
 	}
 
 	// Get the password hash.
-	hash := make([]byte, 60)
-	if _, err := r.Read(hash); err != nil {
+	hash, err := UnmarshalString(r)
+	if err != nil {
 		return nil, err
 	}
 
@@ -66,12 +84,32 @@ func UnmarshalUser(r io.Reader) (*user.User, error) {
 		return nil, access.ErrInvalidClearanceError
 	}
 
-	// Create the new user object.
-	uobj, err := user.NewUser(username, "", clearance)
+	// Get the two-factor authentication settings.
+	enabled, err := UnmarshalBool(r)
 	if err != nil {
 		return nil, err
 	}
-	uobj.SetPasswordHash(hash)
+	secret, err := UnmarshalString(r)
+	if err != nil {
+		return nil, err
+	}
+	codeStrings, err := UnmarshalStringSlice(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the new user object.
+	uobj := user.NewUserFromHash(username, auth.PasswordHash(hash), clearance)
+	if secret != "" {
+		uobj.SetTOTP([]byte(secret), enabled)
+	}
+	if len(codeStrings) != 0 {
+		codes := make([]auth.PasswordHash, len(codeStrings))
+		for i := range codeStrings {
+			codes[i] = auth.PasswordHash(codeStrings[i])
+		}
+		uobj.SetRecoveryCodes(codes)
+	}
 
 	// Return.
 	return uobj, nil
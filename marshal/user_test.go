@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/auth"
 	"github.com/cubeflix/lily/user"
 	"github.com/cubeflix/lily/user/list"
 )
@@ -68,3 +69,62 @@ func TestMarshalUserList(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test marshaling a user object with two-factor authentication.
+func TestMarshalUserTOTP(t *testing.T) {
+	// Create the user object.
+	u, err := user.NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	u.SetTOTP([]byte("12345678901234567890"), true)
+	if err := u.SetRecoveryCodesFromStrings([]string{"aaaaa-bbbbb"}, auth.DefaultHashParams()); err != nil {
+		t.Error(err.Error())
+	}
+
+	// Marshal the user.
+	buf := bytes.NewBuffer([]byte{})
+	if err := MarshalUser(u, buf); err != nil {
+		t.Error(err.Error())
+	}
+
+	// Unmarshal the user.
+	uobj, err := UnmarshalUser(buf)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Check the new user object.
+	if !reflect.DeepEqual(uobj, u) {
+		t.Fail()
+	}
+}
+
+// Test marshaling a user with an Argon2id password hash.
+func TestMarshalUserArgon2id(t *testing.T) {
+	// Create the user object.
+	params := auth.DefaultHashParams()
+	params.Algorithm = auth.Argon2id
+	params.Argon2Memory = 64
+	hash, err := auth.NewPasswordHashWithParams("bar", params)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	u := user.NewUserFromHash("foo", hash, access.ClearanceLevelFive)
+
+	// Marshal and unmarshal the user.
+	buf := bytes.NewBuffer([]byte{})
+	if err := MarshalUser(u, buf); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	uobj, err := UnmarshalUser(buf)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !reflect.DeepEqual(uobj, u) || !uobj.ComparePassword("bar") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,364 @@
+// metrics/metrics.go
+// Metrics registry for Lily servers.
+
+// Package metrics provides a small registry of counters, gauges and
+// histograms, which can be written in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default histogram buckets for durations, in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A metric which can be written to the registry output.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Metrics registry.
+type Registry struct {
+	lock       sync.Mutex
+	collectors []collector
+}
+
+// Create a new registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:       sync.Mutex{},
+		collectors: []collector{},
+	}
+}
+
+// Add a collector to the registry.
+func (r *Registry) register(c collector) {
+	// Acquire the lock.
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Write all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	// Acquire the lock.
+	r.lock.Lock()
+	collectors := r.collectors
+	r.lock.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for i := range collectors {
+		collectors[i].write(bw)
+	}
+	return bw.Flush()
+}
+
+// Get an HTTP handler which serves the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
+
+// Write the metric header.
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// Format a float value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Format a set of labels, with an optional extra label.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	escape := strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+	pairs := make([]string, 0, len(names)+1)
+	for i := range names {
+		pairs = append(pairs, names[i]+"=\""+escape.Replace(values[i])+"\"")
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+"=\""+escape.Replace(extraValue)+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Get the label values for a key, in order.
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return []string{}
+	}
+	return strings.Split(key, "\xff")
+}
+
+// Get the sorted keys of a map.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter vector. Each set of label values has its own counter.
+type CounterVec struct {
+	lock   sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+}
+
+// Create and register a counter vector.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		lock:   sync.Mutex{},
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+	if len(labels) == 0 {
+		// Counters without labels are always written.
+		c.values[""] = 0
+	}
+	r.register(c)
+	return c
+}
+
+// Add to the counter for a set of label values. The value must not be
+// negative.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic("lily.metrics: Invalid number of label values")
+	}
+
+	// Acquire the lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.values[strings.Join(labelValues, "\xff")] += v
+}
+
+// Increment the counter for a set of label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Get the counter value for a set of label values.
+func (c *CounterVec) Get(labelValues ...string) float64 {
+	// Acquire the lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	// Acquire the lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := map[string]bool{}
+	for key := range c.values {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitKey(key, len(c.labels)), "", ""),
+			formatFloat(c.values[key]))
+	}
+}
+
+// Gauge.
+type Gauge struct {
+	lock  sync.Mutex
+	name  string
+	help  string
+	value float64
+}
+
+// Create and register a gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{
+		lock: sync.Mutex{},
+		name: name,
+		help: help,
+	}
+	r.register(g)
+	return g
+}
+
+// Add to the gauge. The value may be negative.
+func (g *Gauge) Add(v float64) {
+	// Acquire the lock.
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.value += v
+}
+
+// Set the gauge.
+func (g *Gauge) Set(v float64) {
+	// Acquire the lock.
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.value = v
+}
+
+// Get the gauge value.
+func (g *Gauge) Get() float64 {
+	// Acquire the lock.
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.value
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.Get()))
+}
+
+// Gauge whose value is read from a function when the metrics are written.
+type GaugeFunc struct {
+	name string
+	help string
+	f    func() float64
+}
+
+// Create and register a gauge function.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{
+		name: name,
+		help: help,
+		f:    f,
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// Histogram values for a set of label values.
+type histogramValues struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram vector. Each set of label values has its own histogram.
+type HistogramVec struct {
+	lock    sync.Mutex
+	name    string
+	help    string
+	buckets []float64
+	labels  []string
+	values  map[string]*histogramValues
+}
+
+// Create and register a histogram vector. The buckets are the upper bounds of
+// each bucket, in increasing order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		lock:    sync.Mutex{},
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		labels:  labels,
+		values:  map[string]*histogramValues{},
+	}
+	r.register(h)
+	return h
+}
+
+// Observe a value for a set of label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic("lily.metrics: Invalid number of label values")
+	}
+
+	// Acquire the lock.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	values, ok := h.values[key]
+	if !ok {
+		values = &histogramValues{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = values
+	}
+	for i := range h.buckets {
+		if v <= h.buckets[i] {
+			values.counts[i]++
+		}
+	}
+	values.count++
+	values.sum += v
+}
+
+// Get the number of observations and their sum for a set of label values.
+func (h *HistogramVec) Get(labelValues ...string) (uint64, float64) {
+	// Acquire the lock.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	values, ok := h.values[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0, 0
+	}
+	return values.count, values.sum
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	// Acquire the lock.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := map[string]bool{}
+	for key := range h.values {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		labelValues := splitKey(key, len(h.labels))
+		values := h.values[key]
+		for i := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				formatLabels(h.labels, labelValues, "le", formatFloat(h.buckets[i])), values.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, labelValues, "le", "+Inf"), values.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, labelValues, "", ""), formatFloat(values.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, labelValues, "", ""), values.count)
+	}
+}
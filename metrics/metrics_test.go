@@ -0,0 +1,78 @@
+// metrics/metrics_test.go
+// Testing for metrics/metrics.go.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test writing metrics in the text format.
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests.", "command", "code")
+	r.NewCounterVec("rejections_total", "Rejections.")
+	active := r.NewGauge("active", "Active.")
+	r.NewGaugeFunc("queue", "Queue.", func() float64 { return 3 })
+	duration := r.NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1}, "command")
+
+	requests.Inc("ping", "0")
+	requests.Inc("ping", "0")
+	requests.Inc("login", "6")
+	active.Add(2)
+	active.Add(-1)
+	duration.Observe(0.05, "ping")
+	duration.Observe(0.5, "ping")
+	duration.Observe(5, "ping")
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := r.WriteText(buf); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{command="login",code="6"} 1
+requests_total{command="ping",code="0"} 2
+# HELP rejections_total Rejections.
+# TYPE rejections_total counter
+rejections_total 0
+# HELP active Active.
+# TYPE active gauge
+active 1
+# HELP queue Queue.
+# TYPE queue gauge
+queue 3
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{command="ping",le="0.1"} 1
+duration_seconds_bucket{command="ping",le="1"} 2
+duration_seconds_bucket{command="ping",le="+Inf"} 3
+duration_seconds_sum{command="ping"} 5.55
+duration_seconds_count{command="ping"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+	if count, sum := duration.Get("ping"); count != 3 || sum != 5.55 {
+		t.Fail()
+	}
+}
+
+// Test escaping label values and serving metrics over HTTP.
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("requests_total", "Requests.", "command").Inc("a\"b\\c\nd")
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Header().Get("Content-Type") != ContentType {
+		t.Fail()
+	}
+	if !strings.Contains(w.Body.String(), `requests_total{command="a\"b\\c\nd"} 1`) {
+		t.Errorf("unexpected output:\n%s", w.Body.String())
+	}
+}
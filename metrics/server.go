@@ -0,0 +1,52 @@
+// metrics/server.go
+// Server metrics for Lily servers.
+
+package metrics
+
+// Server metrics. Gauges which read server state, such as queue lengths, are
+// registered by the server.
+type ServerMetrics struct {
+	Registry *Registry
+
+	// Requests by command and response code, and command latency.
+	Requests        *CounterVec
+	CommandDuration *HistogramVec
+
+	// Chunk data bytes read and written.
+	ChunkBytes *CounterVec
+
+	// Connections currently being handled, connections rejected by the rate
+	// limiter, requests rejected by the per-user rate limiter, and
+	// connections rejected by the listener.
+	ActiveConnections       *Gauge
+	RateLimitRejections     *CounterVec
+	UserRateLimitRejections *CounterVec
+	ConnectionRejections    *CounterVec
+
+	// Time taken to save the drives, server file and sessions.
+	CronSaveDuration *HistogramVec
+}
+
+// Create the server metrics in a new registry.
+func NewServerMetrics() *ServerMetrics {
+	r := NewRegistry()
+	return &ServerMetrics{
+		Registry: r,
+		Requests: r.NewCounterVec("lily_requests_total",
+			"Requests handled, by command and response code.", "command", "code"),
+		CommandDuration: r.NewHistogramVec("lily_command_duration_seconds",
+			"Command execution time, by command.", DefaultBuckets, "command"),
+		ChunkBytes: r.NewCounterVec("lily_chunk_bytes_total",
+			"Chunk data bytes transferred, by direction.", "direction"),
+		ActiveConnections: r.NewGauge("lily_active_connections",
+			"Connections currently being handled."),
+		RateLimitRejections: r.NewCounterVec("lily_rate_limit_rejections_total",
+			"Connections rejected by the rate limiter."),
+		UserRateLimitRejections: r.NewCounterVec("lily_user_rate_limit_rejections_total",
+			"Requests rejected by the per-user rate limiter."),
+		ConnectionRejections: r.NewCounterVec("lily_connection_rejections_total",
+			"Connections rejected by the listener, by reason.", "reason"),
+		CronSaveDuration: r.NewHistogramVec("lily_cron_save_duration_seconds",
+			"Time taken to save the drives, server file and sessions.", DefaultBuckets),
+	}
+}
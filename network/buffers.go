@@ -0,0 +1,123 @@
+// network/buffers.go
+// Pooled chunk buffers and the memory budget for transfers.
+
+package network
+
+import "sync"
+
+// The default largest chunk which the server receives.
+const DefaultMaxChunkSize = 1000000
+
+// Chunk buffers are pooled in power of two size classes, from 4 KB to 16 MB.
+// Larger buffers are not pooled.
+const minBufferClass = 12
+const maxBufferClass = 24
+
+var bufferPools [maxBufferClass - minBufferClass + 1]sync.Pool
+
+// Get the size class of a buffer size.
+func bufferClass(size int) int {
+	class := minBufferClass
+	for class <= maxBufferClass && 1<<class < size {
+		class++
+	}
+	return class
+}
+
+// Get a buffer of a size from the pool. Since streams may replace the slices
+// they read into, the buffer should be copied before it is read into, so the
+// original can be returned with PutBuffer.
+func GetBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class > maxBufferClass {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if buf, ok := bufferPools[class-minBufferClass].Get().(*[]byte); ok {
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make([]byte, size, 1<<class)
+	return &buf
+}
+
+// Return a buffer from GetBuffer to the pool.
+func PutBuffer(buf *[]byte) {
+	class := bufferClass(cap(*buf))
+	if class > maxBufferClass || 1<<class != cap(*buf) {
+		return
+	}
+	bufferPools[class-minBufferClass].Put(buf)
+}
+
+// Memory budget for transfers. Transfers reserve the memory for their buffers
+// before they begin, and are turned away if the budget is used up, instead of
+// running the server out of memory. A nil budget does not limit anything.
+type MemoryBudget struct {
+	lock  sync.Mutex
+	limit int64
+	used  int64
+}
+
+// Create a new memory budget of a number of bytes. If the limit is zero, the
+// budget does not limit anything.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		lock:  sync.Mutex{},
+		limit: limit,
+	}
+}
+
+// Get the limit and the number of bytes reserved.
+func (b *MemoryBudget) Usage() (int64, int64) {
+	if b == nil {
+		return 0, 0
+	}
+
+	// Acquire the lock.
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.limit, b.used
+}
+
+// Set the limit in bytes. Memory which is already reserved is kept, even if
+// it is over the new limit.
+func (b *MemoryBudget) SetLimit(limit int64) {
+	// Acquire the lock.
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.limit = limit
+}
+
+// Reserve bytes from the budget. Returns false if there are not enough bytes
+// left.
+func (b *MemoryBudget) Reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+
+	// Acquire the lock.
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.limit > 0 && b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// Release reserved bytes.
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil {
+		return
+	}
+
+	// Acquire the lock.
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.used -= n
+}
@@ -0,0 +1,66 @@
+// network/buffers_test.go
+// Testing for network/buffers.go.
+
+package network
+
+import (
+	"testing"
+)
+
+// Test getting buffers from the pool.
+func TestBuffers(t *testing.T) {
+	for _, size := range []int{0, 1, 4096, 4097, 1000000, 1 << 24} {
+		buf := GetBuffer(size)
+		if len(*buf) != size || cap(*buf) < size {
+			t.Fatal(size, len(*buf), cap(*buf))
+		}
+		PutBuffer(buf)
+	}
+
+	// Buffers larger than the largest size class are not pooled.
+	buf := GetBuffer(1<<24 + 1)
+	if len(*buf) != 1<<24+1 {
+		t.Fail()
+	}
+	PutBuffer(buf)
+
+	// Buffers which were resliced can be returned.
+	buf = GetBuffer(10000)
+	*buf = (*buf)[:10]
+	PutBuffer(buf)
+	if buf = GetBuffer(16384); len(*buf) != 16384 {
+		t.Fail()
+	}
+}
+
+// Test reserving memory from a memory budget.
+func TestMemoryBudget(t *testing.T) {
+	b := NewMemoryBudget(100)
+	if !b.Reserve(60) || b.Reserve(50) || !b.Reserve(40) {
+		t.Fatal("reserved past the limit")
+	}
+	if limit, used := b.Usage(); limit != 100 || used != 100 {
+		t.Fatal(limit, used)
+	}
+	b.Release(60)
+	if !b.Reserve(50) {
+		t.Fail()
+	}
+
+	// Lowering the limit keeps the reserved memory.
+	b.SetLimit(50)
+	if _, used := b.Usage(); used != 90 || b.Reserve(1) {
+		t.Fail()
+	}
+
+	// Zero and nil budgets do not limit anything.
+	b.SetLimit(0)
+	if !b.Reserve(1 << 40) {
+		t.Fail()
+	}
+	var nilBudget *MemoryBudget
+	if !nilBudget.Reserve(1 << 40) {
+		t.Fail()
+	}
+	nilBudget.Release(1 << 40)
+}
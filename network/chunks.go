@@ -6,6 +6,8 @@ package network
 import (
 	"encoding/binary"
 	"errors"
+	"hash/crc32"
+	"io"
 	"time"
 )
 
@@ -18,17 +20,47 @@ import (
 
 var ErrInvalidChunkName = errors.New("lily.network: Invalid chunk name")
 var ErrInvalidFooter = errors.New("lily.network: Footer data is invalid (possible data corruption")
+var ErrChecksumMismatch = errors.New("lily.network: Chunk checksum mismatch (possible data corruption)")
+var ErrChunkTooLarge = errors.New("lily.network: Chunk is larger than the maximum chunk size")
+
+// The CRC32C table for chunk checksums.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
 
 // ChunkHandler struct.
 type ChunkHandler struct {
 	// Store the DataStream object to read data from.
 	stream DataStream
 
+	// The protocol version, which decides if chunks carry checksums.
+	version string
+
+	// The largest chunk which can be received, or zero for no limit.
+	maxChunkSize uint64
+
 	// If we wrote the chunk data already.
 	wroteChunkData bool
 
 	// If we received the chunk data already.
 	receivedChunkData bool
+
+	// The number of chunk data bytes received and written.
+	bytesIn  uint64
+	bytesOut uint64
+
+	// Bandwidth throttles for chunk data received and written.
+	readThrottles  []*Throttle
+	writeThrottles []*Throttle
+
+	// The chunk compression algorithm.
+	compression string
+
+	// The info of the next chunk to write, which is written with the chunk
+	// when compressing or sending checksums, and the compressed length and
+	// checksum of the next chunk to receive.
+	pendingName      string
+	pendingLength    int
+	compressedLength uint64
+	checksum         uint32
 }
 
 // ChunkInfo struct.
@@ -42,6 +74,7 @@ type ChunkInfo struct {
 func NewChunkHandler(stream DataStream) *ChunkHandler {
 	return &ChunkHandler{
 		stream:            stream,
+		version:           PROTOCOL_VERSION,
 		wroteChunkData:    false,
 		receivedChunkData: false,
 	}
@@ -57,6 +90,105 @@ func (c *ChunkHandler) DidReceiveChunkData() bool {
 	return c.receivedChunkData
 }
 
+// Get the number of chunk data bytes received and written.
+func (c *ChunkHandler) BytesTransferred() (uint64, uint64) {
+	return c.bytesIn, c.bytesOut
+}
+
+// Set the bandwidth throttles for chunk data received and written. Nil
+// throttles are ignored.
+func (c *ChunkHandler) SetThrottles(read, write []*Throttle) {
+	c.readThrottles = read
+	c.writeThrottles = write
+}
+
+// Set the largest chunk which can be received. Zero means no limit.
+func (c *ChunkHandler) SetMaxChunkSize(size int) {
+	c.maxChunkSize = uint64(size)
+}
+
+// Set the chunk compression algorithm. Chunks are compressed only if they
+// shrink.
+func (c *ChunkHandler) SetCompression(compression string) error {
+	if !ValidCompression(compression) {
+		return ErrUnsupportedCompression
+	}
+	c.compression = compression
+	return nil
+}
+
+// Get the chunk compression algorithm.
+func (c *ChunkHandler) Compression() string {
+	return c.compression
+}
+
+// Set the protocol version. With CHECKSUM_PROTOCOL_VERSION, each chunk
+// carries a checksum, which is verified when it is received.
+func (c *ChunkHandler) SetVersion(version string) error {
+	if !ValidProtocolVersion(version) {
+		return ErrUnsupportedVersion
+	}
+	c.version = version
+	return nil
+}
+
+// Get the protocol version.
+func (c *ChunkHandler) Version() string {
+	return c.version
+}
+
+// Check if chunks carry checksums.
+func (c *ChunkHandler) checksums() bool {
+	return c.version == CHECKSUM_PROTOCOL_VERSION
+}
+
+// Read chunk data, in throttled pieces if there are read throttles.
+func (c *ChunkHandler) readData(data *[]byte, timeout time.Duration) (int, error) {
+	if len(c.readThrottles) == 0 {
+		return c.stream.Read(data, timeout)
+	}
+	read := 0
+	for read < len(*data) {
+		end := read + ThrottlePieceSize
+		if end > len(*data) {
+			end = len(*data)
+		}
+		piece := (*data)[read:end]
+		WaitThrottles(c.readThrottles, len(piece))
+		n, err := c.stream.Read(&piece, timeout)
+		if err != nil || n == 0 {
+			return read + n, err
+		}
+
+		// Streams may replace the slice instead of filling it.
+		read += copy((*data)[read:end], piece[:n])
+	}
+	return read, nil
+}
+
+// Write chunk data, in throttled pieces if there are write throttles.
+func (c *ChunkHandler) writeData(data *[]byte, timeout time.Duration) (int, error) {
+	if len(c.writeThrottles) == 0 {
+		return c.stream.Write(data, timeout)
+	}
+	written := 0
+	for written < len(*data) {
+		end := written + ThrottlePieceSize
+		if end > len(*data) {
+			end = len(*data)
+		}
+		piece := (*data)[written:end]
+		WaitThrottles(c.writeThrottles, len(piece))
+		n, err := c.stream.Write(&piece, timeout)
+		written += n
+		if err != nil || n == 0 {
+			return written, err
+		}
+		c.stream.Flush()
+	}
+	return written, nil
+}
+
 // Get the request chunk data, including the list of chunks and order. NOTE:
 // This function MUST be called before using the handler.
 func (c *ChunkHandler) GetChunkRequestInfo(timeout time.Duration) ([]ChunkInfo, error) {
@@ -138,6 +270,31 @@ func (c *ChunkHandler) GetChunkInfo(timeout time.Duration) (string, uint64, erro
 		return "", 0, err
 	}
 	chunkLength := binary.LittleEndian.Uint64(data)
+	if c.maxChunkSize != 0 && chunkLength > c.maxChunkSize {
+		return "", 0, ErrChunkTooLarge
+	}
+
+	// Get the compressed length of the chunk.
+	if c.compression != CompressionNone {
+		_, err = c.stream.Read(&data, timeout)
+		if err != nil {
+			return "", 0, err
+		}
+		c.compressedLength = binary.LittleEndian.Uint64(data)
+		if c.compressedLength > chunkLength {
+			return "", 0, ErrInvalidCompressedChunk
+		}
+	}
+
+	// Get the checksum of the chunk.
+	if c.checksums() {
+		data = make([]byte, 4)
+		_, err = c.stream.Read(&data, timeout)
+		if err != nil {
+			return "", 0, err
+		}
+		c.checksum = binary.LittleEndian.Uint32(data)
+	}
 
 	// Return.
 	return name, chunkLength, nil
@@ -145,15 +302,36 @@ func (c *ChunkHandler) GetChunkInfo(timeout time.Duration) (string, uint64, erro
 
 // Load the next chunk of data. Data should be the size of the chunk.
 func (c *ChunkHandler) GetChunk(data *[]byte, timeout time.Duration) error {
-	// Load the chunk.
-	_, err := c.stream.Read(data, timeout)
-	if err != nil {
-		return err
+	if c.compression != CompressionNone && c.compressedLength < uint64(len(*data)) {
+		// Load and decompress the chunk.
+		buf := GetBuffer(int(c.compressedLength))
+		defer PutBuffer(buf)
+		compressed := *buf
+		n, err := c.readData(&compressed, timeout)
+		if err != nil {
+			return err
+		}
+		c.bytesIn += uint64(n)
+		if err := decompressChunk(c.compression, compressed, *data); err != nil {
+			return err
+		}
+	} else {
+		// Load the chunk.
+		n, err := c.readData(data, timeout)
+		if err != nil {
+			return err
+		}
+		c.bytesIn += uint64(n)
+	}
+
+	// Verify the checksum.
+	if c.checksums() && crc32.Checksum(*data, checksumTable) != c.checksum {
+		return ErrChecksumMismatch
 	}
 
 	// Get the footer data.
 	footer := make([]byte, 3)
-	_, err = c.stream.Read(&footer, timeout)
+	_, err := c.stream.Read(&footer, timeout)
 	if err != nil {
 		return err
 	}
@@ -188,7 +366,7 @@ func (c *ChunkHandler) WriteChunkResponseInfo(chunks []ChunkInfo, timeout time.D
 
 	// Write the Lily header.
 	if writeHeader {
-		header := []byte("LILY" + PROTOCOL_VERSION)
+		header := []byte("LILY" + c.version)
 		_, err := c.stream.Write(&header, timeout)
 		if err != nil {
 			return err
@@ -241,6 +419,18 @@ func (c *ChunkHandler) WriteChunkResponseInfo(chunks []ChunkInfo, timeout time.D
 
 // Write info about the next chunk. Write the name and length.
 func (c *ChunkHandler) WriteChunkInfo(name string, length int, timeout time.Duration) error {
+	// With compression or checksums, the info is written with the chunk, once
+	// the compressed length and checksum are known.
+	if c.compression != CompressionNone || c.checksums() {
+		c.pendingName, c.pendingLength = name, length
+		return nil
+	}
+	return c.writeChunkInfo(name, nil, timeout, uint64(length))
+}
+
+// Write the name, lengths and checksum of the next chunk. The checksum is
+// omitted if it is nil.
+func (c *ChunkHandler) writeChunkInfo(name string, checksum []byte, timeout time.Duration, lengths ...uint64) error {
 	// Write the length of the name.
 	data := make([]byte, 2)
 	binary.LittleEndian.PutUint16(data, uint16(len(name)))
@@ -256,12 +446,22 @@ func (c *ChunkHandler) WriteChunkInfo(name string, length int, timeout time.Dura
 		return err
 	}
 
-	// Write the length of the chunk.
-	data = make([]byte, 8)
-	binary.LittleEndian.PutUint64(data, uint64(length))
-	_, err = c.stream.Write(&data, timeout)
-	if err != nil {
-		return err
+	// Write the lengths of the chunk.
+	for _, length := range lengths {
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, length)
+		_, err = c.stream.Write(&data, timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write the checksum of the chunk.
+	if checksum != nil {
+		_, err = c.stream.Write(&checksum, timeout)
+		if err != nil {
+			return err
+		}
 	}
 
 	c.stream.Flush()
@@ -272,12 +472,88 @@ func (c *ChunkHandler) WriteChunkInfo(name string, length int, timeout time.Dura
 
 // Write a chunk.
 func (c *ChunkHandler) WriteChunk(data *[]byte, timeout time.Duration) error {
+	if c.compression != CompressionNone || c.checksums() {
+		chunk := *data
+		if c.pendingLength < len(chunk) {
+			chunk = chunk[:c.pendingLength]
+		}
+		lengths := []uint64{uint64(len(chunk))}
+		send := chunk
+		if c.compression != CompressionNone {
+			// Compress the chunk, and send it uncompressed if it doesn't
+			// shrink.
+			buf := GetBuffer(len(chunk))
+			defer PutBuffer(buf)
+			compressed, err := compressChunk(c.compression, chunk, (*buf)[:0])
+			if err != nil {
+				return err
+			}
+			if len(compressed) < len(chunk) {
+				send = compressed
+			}
+			lengths = append(lengths, uint64(len(send)))
+		}
+		var checksum []byte
+		if c.checksums() {
+			checksum = make([]byte, 4)
+			binary.LittleEndian.PutUint32(checksum, crc32.Checksum(chunk, checksumTable))
+		}
+		if err := c.writeChunkInfo(c.pendingName, checksum, timeout, lengths...); err != nil {
+			return err
+		}
+		data = &send
+	}
+
 	// Load the chunk.
-	_, err := c.stream.Write(data, timeout)
+	n, err := c.writeData(data, timeout)
+	if err != nil {
+		return err
+	}
+	c.bytesOut += uint64(n)
+
+	footer := []byte("END")
+	_, err = c.stream.Write(&footer, timeout)
 	if err != nil {
 		return err
 	}
 
+	c.stream.Flush()
+
+	// Return.
+	return nil
+}
+
+// Write a chunk of a length from a reader. If the stream can read directly
+// from the reader, and the chunk is not compressed, checksummed or throttled,
+// the chunk is not copied into a buffer, so unencrypted connections can send
+// file sections with sendfile. File sections are sent whole, so they must be
+// the length of the chunk.
+func (c *ChunkHandler) WriteChunkFrom(r io.Reader, length int, timeout time.Duration) error {
+	rf, ok := c.stream.(DirectWriteStream)
+	if !ok || c.compression != CompressionNone || c.checksums() || throttled(c.writeThrottles) {
+		// Read the chunk into a buffer.
+		buf := GetBuffer(length)
+		defer PutBuffer(buf)
+		data := *buf
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		return c.WriteChunk(&data, timeout)
+	}
+
+	// Write the chunk directly.
+	if _, ok := r.(*FileSection); !ok {
+		r = io.LimitReader(r, int64(length))
+	}
+	n, err := rf.WriteFrom(r, timeout)
+	c.bytesOut += uint64(n)
+	if err != nil {
+		return err
+	}
+	if n != int64(length) {
+		return io.ErrUnexpectedEOF
+	}
+
 	footer := []byte("END")
 	_, err = c.stream.Write(&footer, timeout)
 	if err != nil {
@@ -5,6 +5,7 @@ package network
 
 import (
 	"bytes"
+	"io"
 	"testing"
 	"time"
 )
@@ -142,3 +143,227 @@ func TestWritingChunkedHandler(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test writing and reading compressed chunks.
+func TestCompressedChunks(t *testing.T) {
+	text := bytes.Repeat([]byte("time,level,message\n"), 1000)
+	short := []byte("bar")
+	small := bytes.Repeat([]byte("ab"), 300)
+	for _, compression := range Compressions {
+		ts := &TestStream{
+			[]byte{},
+			[]byte{},
+		}
+		c := NewChunkHandler(DataStream(ts))
+		if err := c.SetCompression(compression); err != nil {
+			t.Fatal(err.Error())
+		}
+		c.WriteChunkResponseInfo([]ChunkInfo{{"foo", 3}}, time.Duration(0), false)
+		for _, data := range [][]byte{text, short, small} {
+			data := append([]byte{}, data...)
+			c.WriteChunkInfo("foo", len(data), time.Duration(0))
+			if err := c.WriteChunk(&data, time.Duration(0)); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		c.WriteFooter(time.Duration(0))
+
+		// The text is compressed, but the short chunk is not.
+		if _, out := c.BytesTransferred(); out >= uint64(len(text)/10+len(small)) || !bytes.Contains(ts.output, []byte("barEND")) {
+			t.Fatal(compression, out)
+		}
+
+		// Read the chunks.
+		ts.data = ts.output
+		c = NewChunkHandler(DataStream(ts))
+		c.SetCompression(compression)
+		if _, err := c.GetChunkRequestInfo(time.Duration(0)); err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, expected := range [][]byte{text, short, small} {
+			name, length, err := c.GetChunkInfo(time.Duration(0))
+			if err != nil || name != "foo" || length != uint64(len(expected)) {
+				t.Fatal(name, length, err)
+			}
+			data := make([]byte, length)
+			if err := c.GetChunk(&data, time.Duration(0)); err != nil {
+				t.Fatal(err.Error())
+			}
+			if !bytes.Equal(data, expected) {
+				t.Fatal(compression, "chunk does not match")
+			}
+		}
+		if err := c.GetFooter(time.Duration(0)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if NewChunkHandler(nil).SetCompression("lz4") != ErrUnsupportedCompression {
+		t.Fail()
+	}
+}
+
+// Test chunks which decompress to the wrong size.
+func TestInvalidCompressedChunk(t *testing.T) {
+	for _, compression := range Compressions {
+		compressed, err := compressChunk(compression, bytes.Repeat([]byte("a"), 1000), nil)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, size := range []int{999, 1001} {
+			if decompressChunk(compression, compressed, make([]byte, size)) != ErrInvalidCompressedChunk {
+				t.Fatal(compression, size)
+			}
+		}
+		if decompressChunk(compression, []byte("garbage"), make([]byte, 1000)) != ErrInvalidCompressedChunk {
+			t.Fatal(compression)
+		}
+	}
+}
+
+// Test chunks with checksums, with and without compression.
+func TestChunkChecksums(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionZstd} {
+		ts := &TestStream{
+			[]byte{},
+			[]byte{},
+		}
+		c := NewChunkHandler(DataStream(ts))
+		c.SetCompression(compression)
+		if err := c.SetVersion(CHECKSUM_PROTOCOL_VERSION); err != nil {
+			t.Fatal(err.Error())
+		}
+		c.WriteChunkResponseInfo([]ChunkInfo{{"foo", 1}}, time.Duration(0), true)
+		data := bytes.Repeat([]byte("chunk data"), 100)
+		c.WriteChunkInfo("foo", len(data), time.Duration(0))
+		if err := c.WriteChunk(&data, time.Duration(0)); err != nil {
+			t.Fatal(err.Error())
+		}
+		c.WriteFooter(time.Duration(0))
+		if !bytes.HasPrefix(ts.output, []byte("LILY"+CHECKSUM_PROTOCOL_VERSION)) {
+			t.Fatal("invalid header")
+		}
+		output := ts.output[5:]
+
+		// Read the chunk, and then read it with a corrupted byte.
+		for _, corrupt := range []bool{false, true} {
+			ts.data = append([]byte{}, output...)
+			if corrupt {
+				ts.data[len(ts.data)-10] ^= 1
+			}
+			c = NewChunkHandler(DataStream(ts))
+			c.SetCompression(compression)
+			c.SetVersion(CHECKSUM_PROTOCOL_VERSION)
+			if _, err := c.GetChunkRequestInfo(time.Duration(0)); err != nil {
+				t.Fatal(err.Error())
+			}
+			_, length, err := c.GetChunkInfo(time.Duration(0))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			received := make([]byte, length)
+			err = c.GetChunk(&received, time.Duration(0))
+			if corrupt {
+				if err != ErrChecksumMismatch && err != ErrInvalidCompressedChunk {
+					t.Fatal(compression, err)
+				}
+			} else if err != nil || !bytes.Equal(received, data) {
+				t.Fatal(compression, err)
+			}
+		}
+	}
+	if NewChunkHandler(nil).SetVersion("9") != ErrUnsupportedVersion {
+		t.Fail()
+	}
+}
+
+// Testing DataStream which writes from readers directly.
+type DirectTestStream struct {
+	TestStream
+	direct int64
+}
+
+// Write to the testing DataStream from a reader.
+func (t *DirectTestStream) WriteFrom(r io.Reader, timeout time.Duration) (int64, error) {
+	buf := &bytes.Buffer{}
+	n, err := buf.ReadFrom(r)
+	t.output = append(t.output, buf.Bytes()...)
+	t.direct += n
+	return n, err
+}
+
+// Test writing chunks from readers.
+func TestWriteChunkFrom(t *testing.T) {
+	data := bytes.Repeat([]byte("chunk data"), 100)
+	for _, compression := range []string{CompressionNone, CompressionZstd} {
+		ts := &DirectTestStream{}
+		c := NewChunkHandler(DataStream(ts))
+		c.SetCompression(compression)
+		c.WriteChunkResponseInfo([]ChunkInfo{{"foo", 2}}, time.Duration(0), false)
+		r := bytes.NewReader(data)
+		for i := 0; i < 2; i++ {
+			c.WriteChunkInfo("foo", len(data)/2, time.Duration(0))
+			if err := c.WriteChunkFrom(r, len(data)/2, time.Duration(0)); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		c.WriteFooter(time.Duration(0))
+
+		// Only uncompressed chunks are written directly.
+		if (compression == CompressionNone) != (ts.direct == int64(len(data))) {
+			t.Fatal(compression, ts.direct)
+		}
+
+		// Read the chunks.
+		ts.data = ts.output
+		c = NewChunkHandler(DataStream(ts))
+		c.SetCompression(compression)
+		if _, err := c.GetChunkRequestInfo(time.Duration(0)); err != nil {
+			t.Fatal(err.Error())
+		}
+		received := []byte{}
+		for i := 0; i < 2; i++ {
+			_, length, err := c.GetChunkInfo(time.Duration(0))
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			chunk := make([]byte, length)
+			if err := c.GetChunk(&chunk, time.Duration(0)); err != nil {
+				t.Fatal(err.Error())
+			}
+			received = append(received, chunk...)
+		}
+		if !bytes.Equal(received, data) {
+			t.Fatal(compression)
+		}
+	}
+
+	// Readers which end early fail.
+	c := NewChunkHandler(DataStream(&DirectTestStream{}))
+	c.WriteChunkResponseInfo([]ChunkInfo{{"foo", 1}}, time.Duration(0), false)
+	c.WriteChunkInfo("foo", 10, time.Duration(0))
+	if err := c.WriteChunkFrom(bytes.NewReader([]byte("short")), 10, time.Duration(0)); err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+}
+
+// Test rejecting chunks larger than the maximum chunk size.
+func TestMaxChunkSize(t *testing.T) {
+	ts := &TestStream{}
+	c := NewChunkHandler(DataStream(ts))
+	c.WriteChunkResponseInfo([]ChunkInfo{{"foo", 1}}, time.Duration(0), false)
+	data := make([]byte, 2000)
+	c.WriteChunkInfo("foo", len(data), time.Duration(0))
+	c.WriteChunk(&data, time.Duration(0))
+	for _, max := range []int{1000, 2000} {
+		ts.data = append([]byte{}, ts.output...)
+		c = NewChunkHandler(DataStream(ts))
+		c.SetMaxChunkSize(max)
+		if _, err := c.GetChunkRequestInfo(time.Duration(0)); err != nil {
+			t.Fatal(err.Error())
+		}
+		_, _, err := c.GetChunkInfo(time.Duration(0))
+		if (max < len(data)) != (err == ErrChunkTooLarge) {
+			t.Fatal(max, err)
+		}
+	}
+}
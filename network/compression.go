@@ -0,0 +1,121 @@
+// network/compression.go
+// Chunk compression.
+
+package network
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnsupportedCompression = errors.New("lily.network: Unsupported compression")
+var ErrInvalidCompressedChunk = errors.New("lily.network: Invalid compressed chunk")
+
+// Chunk compression algorithms. Requests choose an algorithm with the
+// compression argument, and the chunks of both the request and the response
+// are compressed with it.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// The compression algorithms supported, in order of preference.
+var Compressions = []string{CompressionZstd, CompressionGzip}
+
+// Gzip writers are pooled, since they are large.
+var gzipWriters sync.Pool
+
+// The zstd encoder is safe for concurrent use, so it is shared.
+var zstdOnce sync.Once
+var zstdEncoder *zstd.Encoder
+
+// Check if a compression algorithm is supported.
+func ValidCompression(compression string) bool {
+	if compression == CompressionNone {
+		return true
+	}
+	for i := range Compressions {
+		if Compressions[i] == compression {
+			return true
+		}
+	}
+	return false
+}
+
+// Create the zstd encoder.
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+}
+
+// Compress a chunk, appending it to dst.
+func compressChunk(compression string, data, dst []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		buf := bytes.NewBuffer(dst)
+		w, _ := gzipWriters.Get().(*gzip.Writer)
+		if w == nil {
+			w = gzip.NewWriter(buf)
+		} else {
+			w.Reset(buf)
+		}
+		defer gzipWriters.Put(w)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		initZstd()
+		return zstdEncoder.EncodeAll(data, dst), nil
+	}
+	return nil, ErrUnsupportedCompression
+}
+
+// Decompress a chunk into data, which must be the size of the uncompressed
+// chunk. The chunk is decompressed as a stream, so it can't decompress to more
+// than the size of data.
+func decompressChunk(compression string, compressed, data []byte) error {
+	var r io.Reader
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return ErrInvalidCompressedChunk
+		}
+		r = gr
+	case CompressionZstd:
+		// Limit the window of the frame to the size of the chunk, rounded up
+		// to a power of two, and at least the smallest window.
+		maxWindow := uint64(zstd.MinWindowSize)
+		for maxWindow < uint64(len(data)) {
+			maxWindow *= 2
+		}
+		zr, err := zstd.NewReader(bytes.NewReader(compressed), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxWindow))
+		if err != nil {
+			return ErrInvalidCompressedChunk
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return ErrUnsupportedCompression
+	}
+	if _, err := io.ReadFull(r, data); err != nil {
+		return ErrInvalidCompressedChunk
+	}
+
+	// Check that there is no more data.
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		return ErrInvalidCompressedChunk
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ import (
 	"bufio"
 	"crypto/tls"
 	"errors"
+	"io"
+	"net"
 	"os"
 	"time"
 )
@@ -23,7 +25,17 @@ import (
 
 const PROTOCOL_VERSION = "0"
 
+// The protocol version with chunk checksums. It is the same as the base
+// protocol, except that each chunk carries a CRC32C checksum of its data.
+const CHECKSUM_PROTOCOL_VERSION = "1"
+
 var ErrTimedOut = errors.New("lily.network: Timed out")
+var ErrUnsupportedVersion = errors.New("lily.network: Unsupported protocol version")
+
+// Check if a protocol version is supported.
+func ValidProtocolVersion(version string) bool {
+	return version == PROTOCOL_VERSION || version == CHECKSUM_PROTOCOL_VERSION
+}
 
 // DataStream interface. Can represent a crypto/tls.Conn object.
 type DataStream interface {
@@ -32,25 +44,69 @@ type DataStream interface {
 	Flush()
 }
 
-// tls.Conn DataStream object.
-type TLSConnStream struct {
-	conn *tls.Conn
+// A DataStream which can write data directly from a reader, instead of the
+// data being copied into its buffer.
+type DirectWriteStream interface {
+	WriteFrom(io.Reader, time.Duration) (int64, error)
+}
+
+// A DataStream over a network connection.
+type NetStream interface {
+	DataStream
+	NetConn() net.Conn
+}
+
+// A connection which wraps another connection, such as to track it. Wrapped
+// connections are unwrapped so files can be sent to the socket directly.
+type WrappedConn interface {
+	Unwrap() net.Conn
+}
+
+// Get the connection underneath any wrapped connections.
+func unwrapConn(conn net.Conn) net.Conn {
+	for {
+		wrapped, ok := conn.(WrappedConn)
+		if !ok {
+			return conn
+		}
+		conn = wrapped.Unwrap()
+	}
+}
+
+// net.Conn DataStream object, for unencrypted local connections.
+type ConnStream struct {
+	conn net.Conn
 
 	reader *bufio.Reader
 	writer *bufio.Writer
 }
 
+// Create a new buffered connection stream.
+func NewConnStream(conn net.Conn) *ConnStream {
+	return &ConnStream{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+// tls.Conn DataStream object. Data is always written through the buffer, as
+// it must be encrypted.
+type TLSConnStream struct {
+	stream *ConnStream
+	conn   *tls.Conn
+}
+
 // Create a new buffered TLS connection stream.
 func NewTLSStream(conn *tls.Conn) *TLSConnStream {
 	return &TLSConnStream{
+		stream: NewConnStream(conn),
 		conn:   conn,
-		reader: bufio.NewReader(conn),
-		writer: bufio.NewWriter(conn),
 	}
 }
 
-// Wrappers for TLS.Conn functions.
-func (c *TLSConnStream) Read(b *[]byte, timeout time.Duration) (int, error) {
+// Wrappers for net.Conn functions.
+func (c *ConnStream) Read(b *[]byte, timeout time.Duration) (int, error) {
 	err := c.conn.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
 		return 0, err
@@ -72,7 +128,7 @@ func (c *TLSConnStream) Read(b *[]byte, timeout time.Duration) (int, error) {
 	return read, nil
 }
 
-func (c *TLSConnStream) Write(b *[]byte, timeout time.Duration) (int, error) {
+func (c *ConnStream) Write(b *[]byte, timeout time.Duration) (int, error) {
 	err := c.conn.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
 		return 0, err
@@ -94,10 +150,54 @@ func (c *TLSConnStream) Write(b *[]byte, timeout time.Duration) (int, error) {
 	return read, nil
 }
 
-func (c *TLSConnStream) Flush() {
+func (c *ConnStream) Flush() {
 	c.writer.Flush()
 }
 
-func (c *TLSConnStream) Conn() *tls.Conn {
+func (c *ConnStream) NetConn() net.Conn {
+	return c.conn
+}
+
+// Write data directly from a reader, after the buffered data. File sections
+// are sent with sendfile where the platform supports it.
+func (c *ConnStream) WriteFrom(r io.Reader, timeout time.Duration) (int64, error) {
+	err := c.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return 0, err
+	}
+	if section, ok := r.(*FileSection); ok {
+		if n, handled, err := sendFile(unwrapConn(c.conn), section); handled {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return n, ErrTimedOut
+			}
+			return n, err
+		}
+	}
+	buf := GetBuffer(ThrottlePieceSize)
+	defer PutBuffer(buf)
+	n, err := io.CopyBuffer(c.conn, r, *buf)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, ErrTimedOut
+	}
+	return n, err
+}
+
+// Wrappers for the buffered stream functions.
+func (c *TLSConnStream) Read(b *[]byte, timeout time.Duration) (int, error) {
+	return c.stream.Read(b, timeout)
+}
+
+func (c *TLSConnStream) Write(b *[]byte, timeout time.Duration) (int, error) {
+	return c.stream.Write(b, timeout)
+}
+
+func (c *TLSConnStream) Flush() {
+	c.stream.Flush()
+}
+
+func (c *TLSConnStream) NetConn() net.Conn {
 	return c.conn
 }
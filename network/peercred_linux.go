@@ -0,0 +1,30 @@
+// network/peercred_linux.go
+// Peer credentials for Unix socket connections on Linux.
+
+package network
+
+import (
+	"net"
+	"syscall"
+)
+
+// Get the user ID of the process connected to a Unix socket, using
+// SO_PEERCRED.
+func PeerUID(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,20 @@
+// network/peercred_other.go
+// Peer credentials for Unix socket connections on other platforms.
+
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrPeerCredentialsUnsupported = errors.New("lily.network: Peer credentials are not supported on this platform")
+
+// Get the user ID of the process connected to a Unix socket. Peer credentials
+// are only supported on Linux.
+func PeerUID(conn *net.UnixConn) (int, error) {
+	return 0, ErrPeerCredentialsUnsupported
+}
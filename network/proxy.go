@@ -0,0 +1,153 @@
+// network/proxy.go
+// PROXY protocol support for connections from load balancers.
+
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidProxyHeader = errors.New("lily.network: Invalid PROXY protocol header")
+
+// The PROXY protocol v2 signature.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The maximum length of a PROXY protocol v1 header, including the CRLF.
+const proxyV1MaxLength = 107
+
+// A connection from a load balancer, with the client's address.
+type ProxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+// Get the client's address.
+func (c *ProxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Get the connection from the load balancer.
+func (c *ProxiedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Read a PROXY protocol v1 or v2 header from a connection. Returns a
+// connection whose remote address is the client's address. If the header
+// does not carry a TCP address, such as for health checks, the load
+// balancer's address is kept. The header must be received before the
+// timeout. No data after the header is read.
+func ReadProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout != 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	// Both versions are at least as long as the v2 signature.
+	header := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	var remote net.Addr
+	var err error
+	if bytes.Equal(header, proxyV2Signature) {
+		remote, err = readProxyV2(conn)
+	} else if bytes.HasPrefix(header, []byte("PROXY ")) {
+		remote, err = readProxyV1(conn, header)
+	} else {
+		return nil, ErrInvalidProxyHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+
+	// Return.
+	return &ProxiedConn{Conn: conn, remote: remote}, nil
+}
+
+// Read the rest of a PROXY protocol v1 header.
+func readProxyV1(conn net.Conn, header []byte) (net.Addr, error) {
+	// Read up to the CRLF, one byte at a time so no data is buffered.
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n")) {
+		if len(header) >= proxyV1MaxLength {
+			return nil, ErrInvalidProxyHeader
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, err
+		}
+		header = append(header, b[0])
+	}
+
+	// Parse the header.
+	fields := strings.Split(string(header[:len(header)-2]), " ")
+	if len(fields) < 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrInvalidProxyHeader
+	}
+	if (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// Read the rest of a PROXY protocol v2 header.
+func readProxyV2(conn net.Conn) (net.Addr, error) {
+	// Read the version, command, family and length.
+	info := make([]byte, 4)
+	if _, err := io.ReadFull(conn, info); err != nil {
+		return nil, err
+	}
+	if info[0]>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+	data := make([]byte, binary.BigEndian.Uint16(info[2:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	// Local connections, such as health checks, keep the load balancer's
+	// address.
+	switch info[0] & 0xf {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// Get the source address. Only TCP over IPv4 and IPv6 carry an address.
+	switch info[1] {
+	case 0x11:
+		if len(data) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}, nil
+	case 0x21:
+		if len(data) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,96 @@
+// network/proxy_test.go
+// Testing for network/proxy.go.
+
+package network
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Send a PROXY protocol header followed by data, and read the header.
+func readTestProxyHeader(t *testing.T, header []byte) (net.Addr, error) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go client.Write(append(header, []byte("foo")...))
+	conn, err := ReadProxyHeader(server, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data after the header should not be consumed.
+	data := make([]byte, 3)
+	if _, err := io.ReadFull(conn, data); err != nil || string(data) != "foo" {
+		t.Error("data after the header was consumed")
+	}
+	return conn.RemoteAddr(), nil
+}
+
+// Test reading PROXY protocol v1 headers.
+func TestProxyV1(t *testing.T) {
+	addr, err := readTestProxyHeader(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if addr.String() != "192.0.2.1:56324" {
+		t.Error(addr.String())
+	}
+	addr, err = readTestProxyHeader(t, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1234 443\r\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if addr.String() != "[2001:db8::1]:1234" {
+		t.Error(addr.String())
+	}
+
+	// Unknown connections keep the load balancer's address.
+	addr, err = readTestProxyHeader(t, []byte("PROXY UNKNOWN\r\n"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if addr.Network() != "pipe" {
+		t.Fail()
+	}
+
+	// Invalid headers are rejected.
+	if _, err := readTestProxyHeader(t, []byte("PROXY TCP4 2001:db8::1 192.0.2.1 1 2\r\n")); err != ErrInvalidProxyHeader {
+		t.Fail()
+	}
+	if _, err := readTestProxyHeader(t, []byte("GET / HTTP/1.1\r\n")); err != ErrInvalidProxyHeader {
+		t.Fail()
+	}
+}
+
+// Test reading PROXY protocol v2 headers.
+func TestProxyV2(t *testing.T) {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 0, 2, 1, 198, 51, 100, 1)
+	header = append(header, 0xdc, 0x04, 0x01, 0xbb)
+	addr, err := readTestProxyHeader(t, header)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if addr.String() != "192.0.2.1:56324" {
+		t.Error(addr.String())
+	}
+
+	// Local connections keep the load balancer's address.
+	header = append(append([]byte{}, proxyV2Signature...), 0x20, 0, 0, 0)
+	addr, err = readTestProxyHeader(t, header)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if addr.Network() != "pipe" {
+		t.Fail()
+	}
+
+	// Invalid versions are rejected.
+	header = append(append([]byte{}, proxyV2Signature...), 0x11, 0, 0, 0)
+	if _, err := readTestProxyHeader(t, header); err != ErrInvalidProxyHeader {
+		t.Fail()
+	}
+}
@@ -0,0 +1,26 @@
+// network/sendfile.go
+// Sections of files which can be sent to connections directly.
+
+package network
+
+import (
+	"io"
+	"os"
+)
+
+// A section of a file. File sections written to unencrypted connections are
+// sent with sendfile where the platform supports it.
+type FileSection struct {
+	*io.SectionReader
+	file   *os.File
+	offset int64
+}
+
+// Create a file section, starting at an offset with a length.
+func NewFileSection(file *os.File, offset, length int64) *FileSection {
+	return &FileSection{
+		SectionReader: io.NewSectionReader(file, offset, length),
+		file:          file,
+		offset:        offset,
+	}
+}
@@ -0,0 +1,71 @@
+// network/sendfile_linux.go
+// Sending file sections with sendfile on Linux.
+
+package network
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+// The most data sent by a single sendfile call.
+const maxSendfileSize = 4 << 20
+
+// Send the rest of a file section to a TCP or Unix socket connection with
+// sendfile. Returns false if the connection is not a socket, in which case
+// nothing is sent.
+func sendFile(conn net.Conn, section *FileSection) (int64, bool, error) {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return 0, false, nil
+	}
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	// Send from the current position in the section, waiting for the socket
+	// to be writable when its buffer is full.
+	pos, err := section.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	offset := section.offset + pos
+	remaining := section.Size() - pos
+	written := int64(0)
+	var sendErr error
+	err = raw.Write(func(fd uintptr) bool {
+		for remaining > 0 {
+			size := remaining
+			if size > maxSendfileSize {
+				size = maxSendfileSize
+			}
+			n, err := syscall.Sendfile(int(fd), int(section.file.Fd()), &offset, int(size))
+			if n > 0 {
+				written += int64(n)
+				remaining -= int64(n)
+			}
+			if err == syscall.EAGAIN {
+				return false
+			} else if err == syscall.EINTR {
+				continue
+			} else if err != nil {
+				sendErr = err
+				return true
+			} else if n == 0 {
+				// The file ended early.
+				return true
+			}
+		}
+		return true
+	})
+	section.Seek(written, io.SeekCurrent)
+	if sendErr != nil {
+		err = sendErr
+	}
+
+	// Return.
+	return written, true, err
+}
@@ -0,0 +1,17 @@
+// network/sendfile_other.go
+// Sending file sections on other platforms.
+
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"net"
+)
+
+// Send the rest of a file section to a connection. Sendfile is only used on
+// Linux, so this always returns false, and nothing is sent.
+func sendFile(conn net.Conn, section *FileSection) (int64, bool, error) {
+	return 0, false, nil
+}
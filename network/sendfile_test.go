@@ -0,0 +1,111 @@
+// network/sendfile_test.go
+// Testing for network/sendfile.go.
+
+package network
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A connection wrapper for testing.
+type testWrappedConn struct {
+	net.Conn
+}
+
+// Get the wrapped connection.
+func (c *testWrappedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Test that plain streams write directly and TLS streams write through their
+// buffer.
+func TestDirectWriteStreams(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	if _, ok := DataStream(NewConnStream(server)).(DirectWriteStream); !ok {
+		t.Error("plain stream does not write directly")
+	}
+	if _, ok := DataStream(NewTLSStream(tls.Server(server, &tls.Config{}))).(DirectWriteStream); ok {
+		t.Error("TLS stream writes directly")
+	}
+}
+
+// Test sending file sections to wrapped Unix socket connections.
+func TestWriteFileSection(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer file.Close()
+
+	// Connect over a Unix socket.
+	listener, err := net.Listen("unix", filepath.Join(dir, "sock"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+	client, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer conn.Close()
+	wrapped := &testWrappedConn{&testWrappedConn{conn}}
+	if unwrapConn(wrapped) != conn {
+		t.Fatal("connection not unwrapped")
+	}
+
+	// Send a section larger than the socket buffer, after some buffered data.
+	received := make(chan []byte)
+	go func() {
+		buf := make([]byte, 3+500000)
+		io.ReadFull(client, buf)
+		received <- buf
+	}()
+	stream := NewConnStream(wrapped)
+	header := []byte("abc")
+	stream.Write(&header, time.Second)
+	section := NewFileSection(file, 123, 500000)
+	n, err := stream.WriteFrom(section, time.Second)
+	if err != nil || n != 500000 {
+		t.Fatal(n, err)
+	}
+	if buf := <-received; !bytes.Equal(buf[:3], header) || !bytes.Equal(buf[3:], data[123:123+500000]) {
+		t.Fatal("sent data does not match")
+	}
+
+	// Sendfile is used on Linux, and stops at the end of the file.
+	section = NewFileSection(file, int64(len(data))-10, 20)
+	go io.ReadFull(client, make([]byte, 10))
+	n, handled, err := sendFile(unwrapConn(wrapped), section)
+	if handled != (runtime.GOOS == "linux") {
+		t.Fatal(handled)
+	}
+	if handled && (err != nil || n != 10) {
+		t.Fatal(n, err)
+	}
+
+	// Other connections are not handled.
+	if _, handled, _ := sendFile(&testWrappedConn{conn}, section); handled {
+		t.Error("wrapped connection handled")
+	}
+}
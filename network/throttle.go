@@ -0,0 +1,115 @@
+// network/throttle.go
+// Bandwidth throttling for chunk data.
+
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// The size of each piece of throttled chunk data. Chunks are read and written
+// in pieces, so throttled transfers are spread out instead of being sent in
+// bursts.
+const ThrottlePieceSize = 32 * 1024
+
+// Bandwidth throttle. The throttle is a token bucket of bytes, which refills
+// at the rate and holds up to one second of data. A nil throttle does not
+// limit anything.
+type Throttle struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// Create a new throttle with a rate in bytes per second. If the rate is zero,
+// the throttle does not limit anything.
+func NewThrottle(rate int64) *Throttle {
+	return &Throttle{
+		lock:   sync.Mutex{},
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Get the rate in bytes per second.
+func (t *Throttle) GetRate() int64 {
+	if t == nil {
+		return 0
+	}
+
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.rate
+}
+
+// Set the rate in bytes per second. If the rate is zero, the throttle does not
+// limit anything.
+func (t *Throttle) SetRate(rate int64) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.rate = rate
+	if t.tokens > float64(rate) {
+		t.tokens = float64(rate)
+	}
+}
+
+// Take bytes from the throttle. Returns how long to wait before using them.
+// The bucket may go into debt, so concurrent transfers share the rate.
+func (t *Throttle) take(n int, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.rate <= 0 {
+		return 0
+	}
+
+	// Refill the bucket.
+	t.tokens += now.Sub(t.last).Seconds() * float64(t.rate)
+	if t.tokens > float64(t.rate) {
+		t.tokens = float64(t.rate)
+	}
+	t.last = now
+
+	// Take the bytes.
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / float64(t.rate) * float64(time.Second))
+}
+
+// Wait until n bytes may be transferred through all of the throttles.
+func WaitThrottles(throttles []*Throttle, n int) {
+	now := time.Now()
+	wait := time.Duration(0)
+	for i := range throttles {
+		if d := throttles[i].take(n, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Check if any of the throttles limit anything.
+func throttled(throttles []*Throttle) bool {
+	for i := range throttles {
+		if throttles[i].GetRate() > 0 {
+			return true
+		}
+	}
+	return false
+}
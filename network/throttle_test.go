@@ -0,0 +1,76 @@
+// network/throttle_test.go
+// Testing for network/throttle.go.
+
+package network
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Test taking bytes from a throttle.
+func TestThrottleTake(t *testing.T) {
+	throttle := NewThrottle(1000)
+	now := throttle.last
+
+	// The bucket starts with one second of data.
+	if throttle.take(1000, now) != 0 {
+		t.Fail()
+	}
+
+	// Taking more should wait until the bytes are refilled.
+	if throttle.take(500, now) != 500*time.Millisecond {
+		t.Fail()
+	}
+	if throttle.take(0, now.Add(time.Second)) != 0 {
+		t.Fail()
+	}
+
+	// Lowering the rate caps the bucket.
+	throttle.SetRate(100)
+	if throttle.take(100, now.Add(time.Second)) != 0 || throttle.take(50, now.Add(time.Second)) != 500*time.Millisecond {
+		t.Fail()
+	}
+
+	// Nil and zero rate throttles do not limit anything.
+	var nilThrottle *Throttle
+	if nilThrottle.take(1000000, now) != 0 || nilThrottle.GetRate() != 0 {
+		t.Fail()
+	}
+	throttle.SetRate(0)
+	if throttle.take(1000000, now) != 0 {
+		t.Fail()
+	}
+}
+
+// Test reading and writing throttled chunks in pieces.
+func TestThrottledChunks(t *testing.T) {
+	data := make([]byte, ThrottlePieceSize*2+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	// Read the chunk.
+	ts := &TestStream{append(append([]byte{}, data...), []byte("END")...), []byte{}}
+	c := NewChunkHandler(DataStream(ts))
+	c.SetThrottles([]*Throttle{NewThrottle(0)}, []*Throttle{NewThrottle(0)})
+	read := make([]byte, len(data))
+	if err := c.GetChunk(&read, time.Duration(0)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(read, data) {
+		t.Fail()
+	}
+	if in, _ := c.BytesTransferred(); in != uint64(len(data)) {
+		t.Fail()
+	}
+
+	// Write the chunk.
+	if err := c.WriteChunk(&data, time.Duration(0)); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(ts.output, append(data, []byte("END")...)) {
+		t.Fail()
+	}
+}
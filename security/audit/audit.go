@@ -0,0 +1,324 @@
+// security/audit/audit.go
+// Audit logging for Lily servers.
+
+// Package audit provides an append-only audit log of the commands handled by
+// a Lily server. Records are written as JSON lines. If hash chaining is
+// enabled, each record includes the hash of the previous record, so changes
+// to the file can be detected.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrBrokenChain = errors.New("lily.security.audit: Audit log hash chain is broken")
+
+// The number of recent records kept in memory for queries.
+const DefaultRecentRecords = 1000
+
+// The maximum length of a single record in the audit log file.
+const MaxRecordLength = 1024 * 1024
+
+// The size of the blocks read from the end of the audit log file when it is
+// opened.
+const tailBlockSize = 64 * 1024
+
+// Audit record.
+type Record struct {
+	Time     time.Time     `json:"time"`
+	User     string        `json:"user"`
+	AuthType string        `json:"authType"`
+	IP       string        `json:"ip"`
+	Command  string        `json:"command"`
+	Drive    string        `json:"drive,omitempty"`
+	Paths    []string      `json:"paths,omitempty"`
+	Code     int           `json:"code"`
+	BytesIn  uint64        `json:"bytesIn"`
+	BytesOut uint64        `json:"bytesOut"`
+	Duration time.Duration `json:"duration"`
+
+	// The hash chain values, if enabled. The hash covers the previous hash
+	// and the record, without its own hash.
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Calculate the chained hash of a record.
+func (r Record) chainHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Audit log object.
+type Log struct {
+	lock     sync.Mutex
+	file     *os.File
+	chain    bool
+	lastHash string
+
+	// Recent records, oldest first.
+	recent    []Record
+	maxRecent int
+}
+
+// Open an audit log file, creating it if it does not exist. Only the end of
+// the file is read, to fill the recent records and continue the hash chain. A
+// torn record at the end of the file, left by a crash while it was written,
+// is removed.
+func Open(path string, chain bool, maxRecent int) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		lock:      sync.Mutex{},
+		file:      file,
+		chain:     chain,
+		recent:    []Record{},
+		maxRecent: maxRecent,
+	}
+
+	// Read the last records. At least one is read to continue the chain.
+	n := maxRecent
+	if n < 1 {
+		n = 1
+	}
+	records, err := readTail(file, n)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, r := range records {
+		l.lastHash = r.Hash
+		l.addRecent(r)
+	}
+
+	// Return.
+	return l, nil
+}
+
+// Read up to a number of records from the end of the audit log file, oldest
+// first. A torn record at the end of the file is truncated.
+func readTail(file *os.File, n int) ([]Record, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	// Read blocks from the end of the file until there are enough complete
+	// lines, or the start of the file is reached.
+	data := []byte{}
+	offset := size
+	for offset > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		if int64(len(data)) > int64(n+1)*MaxRecordLength {
+			return nil, bufio.ErrTooLong
+		}
+		length := int64(tailBlockSize)
+		if length > offset {
+			length = offset
+		}
+		offset -= length
+		block := make([]byte, length)
+		if _, err := file.ReadAt(block, offset); err != nil {
+			return nil, err
+		}
+		data = append(block, data...)
+	}
+
+	// Truncate a torn record.
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		end := bytes.LastIndexByte(data, '\n')
+		if end < 0 && offset > 0 {
+			return nil, bufio.ErrTooLong
+		}
+		if err := file.Truncate(offset + int64(end+1)); err != nil {
+			return nil, err
+		}
+		data = data[:end+1]
+	}
+
+	// Split the lines, skipping the partial line at the start.
+	if len(data) == 0 {
+		return []Record{}, nil
+	}
+	lines := bytes.Split(data[:len(data)-1], []byte{'\n'})
+	if offset > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	// Parse the records.
+	records := make([]Record, len(lines))
+	for i := range lines {
+		if err := json.Unmarshal(lines[i], &records[i]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Add a recent record. NOTE: This does not acquire the lock.
+func (l *Log) addRecent(r Record) {
+	if l.maxRecent <= 0 {
+		return
+	}
+	if len(l.recent) >= l.maxRecent {
+		l.recent = l.recent[1:]
+	}
+	l.recent = append(l.recent, r)
+}
+
+// Write a record. Writing to a nil log does nothing.
+func (l *Log) Write(r Record) error {
+	if l == nil {
+		return nil
+	}
+	r.Time = r.Time.UTC()
+
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Chain the record.
+	r.PrevHash, r.Hash = "", ""
+	if l.chain {
+		r.PrevHash = l.lastHash
+		hash, err := r.chainHash()
+		if err != nil {
+			return err
+		}
+		r.Hash = hash
+	}
+
+	// Write the record.
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.lastHash = r.Hash
+	l.addRecent(r)
+
+	// Return.
+	return nil
+}
+
+// Get the most recent records matching a filter, newest first. If the filter
+// is nil, all records match. If limit is zero, all matching records are
+// returned.
+func (l *Log) Recent(limit int, filter func(Record) bool) []Record {
+	if l == nil {
+		return []Record{}
+	}
+
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	records := []Record{}
+	for i := len(l.recent) - 1; i >= 0; i-- {
+		if limit > 0 && len(records) >= limit {
+			break
+		}
+		if filter == nil || filter(l.recent[i]) {
+			records = append(records, l.recent[i])
+		}
+	}
+	return records
+}
+
+// Verify the hash chain of the audit log file. Records are not written while
+// the file is being verified.
+func (l *Log) Verify() (int, error) {
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return VerifyFile(l.file.Name())
+}
+
+// Close the audit log.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.file.Close()
+}
+
+// Verify the hash chain of an audit log. The chain starts at the first
+// chained record, which has no previous hash, and records before it were
+// written before chaining was enabled, so they are skipped. Every record after
+// it must be chained. Returns the number of valid chained records, and
+// ErrBrokenChain if a record does not match the chain or no record is chained.
+func Verify(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), MaxRecordLength)
+	lastHash := ""
+	started := false
+	skipped := 0
+	n := 0
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return n, ErrBrokenChain
+		}
+		if !started && record.Hash == "" {
+			skipped++
+			continue
+		}
+		started = true
+		if record.Hash == "" || record.PrevHash != lastHash {
+			return n, ErrBrokenChain
+		}
+		hash, err := record.chainHash()
+		if err != nil {
+			return n, err
+		}
+		if hash != record.Hash {
+			return n, ErrBrokenChain
+		}
+		lastHash = record.Hash
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	if !started && skipped > 0 {
+		return 0, ErrBrokenChain
+	}
+	return n, nil
+}
+
+// Verify the hash chain of an audit log file.
+func VerifyFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return Verify(file)
+}
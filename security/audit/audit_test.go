@@ -0,0 +1,168 @@
+// security/audit/audit_test.go
+// Testing for security/audit/audit.go.
+
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test writing and reopening an audit log.
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, true, 2)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	for _, name := range []string{"login", "readfiles", "deletefiles"} {
+		if err := l.Write(Record{Time: time.Now(), User: "foo", Command: name}); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+
+	// Only the most recent records are kept in memory.
+	recent := l.Recent(0, nil)
+	if len(recent) != 2 || recent[0].Command != "deletefiles" || recent[1].Command != "readfiles" {
+		t.Fail()
+	}
+	recent = l.Recent(0, func(r Record) bool { return r.Command == "readfiles" })
+	if len(recent) != 1 || recent[0].Command != "readfiles" {
+		t.Fail()
+	}
+	l.Close()
+
+	// Reopen the log and continue the chain.
+	l, err = Open(path, true, 10)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(l.Recent(0, nil)) != 3 {
+		t.Fail()
+	}
+	if err := l.Write(Record{Time: time.Now(), User: "bar", Command: "logout"}); err != nil {
+		t.Error(err.Error())
+	}
+	l.Close()
+	if n, err := VerifyFile(path); err != nil || n != 4 {
+		t.Fail()
+	}
+
+	// Writing to a nil log does nothing.
+	var nilLog *Log
+	if nilLog.Write(Record{}) != nil || len(nilLog.Recent(0, nil)) != 0 {
+		t.Fail()
+	}
+}
+
+// Test detecting changes to a hash-chained audit log.
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, true, 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	for _, user := range []string{"foo", "bar", "baz"} {
+		if err := l.Write(Record{Time: time.Now(), User: user, Command: "deletefiles",
+			Drive: "main", Paths: []string{"a"}}); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+	l.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Change a record.
+	changed := bytes.Replace(data, []byte(`"user":"bar"`), []byte(`"user":"qux"`), 1)
+	if n, err := Verify(bytes.NewReader(changed)); err != ErrBrokenChain || n != 1 {
+		t.Fail()
+	}
+
+	// Remove a record.
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	removed := append(append([]byte{}, lines[0]...), lines[2]...)
+	if n, err := Verify(bytes.NewReader(removed)); err != ErrBrokenChain || n != 1 {
+		t.Fail()
+	}
+
+	// An unchained log does not verify.
+	path = filepath.Join(t.TempDir(), "unchained.log")
+	l, err = Open(path, false, 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	l.Write(Record{Time: time.Now(), User: "foo", Command: "ping"})
+	l.Close()
+	if _, err := VerifyFile(path); err != ErrBrokenChain {
+		t.Fail()
+	}
+}
+
+// Test reopening an audit log with a torn record, and enabling chaining over
+// an unchained log.
+func TestOpenTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, false, 0)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	long := string(bytes.Repeat([]byte("a"), 1000))
+	for i := 0; i < 200; i++ {
+		if err := l.Write(Record{Time: time.Now(), User: "foo", Command: "readfiles", Paths: []string{long}}); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+	l.Write(Record{Time: time.Now(), User: "bar", Command: "logout"})
+	l.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Write a torn record.
+	if err := os.WriteFile(path, append(append([]byte{}, data...), []byte(`{"time":"20`)...), 0600); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// The torn record is removed, and the last records are read.
+	l, err = Open(path, true, 2)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	recent := l.Recent(0, nil)
+	if len(recent) != 2 || recent[0].Command != "logout" || recent[1].Command != "readfiles" {
+		t.Fail()
+	}
+	if current, err := os.ReadFile(path); err != nil || !bytes.Equal(current, data) {
+		t.Fatal("torn record was not removed")
+	}
+
+	// The chain starts after the unchained records.
+	for _, name := range []string{"login", "deletefiles"} {
+		if err := l.Write(Record{Time: time.Now(), User: "foo", Command: name}); err != nil {
+			t.Error(err.Error())
+			return
+		}
+	}
+	if n, err := l.Verify(); err != nil || n != 2 {
+		t.Fail()
+	}
+	l.Close()
+}
@@ -0,0 +1,178 @@
+// security/auth/hash.go
+// Password hash algorithms for Lily servers.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidAlgorithm = errors.New("lily.security.auth: Invalid password hash algorithm")
+var ErrInvalidHashParams = errors.New("lily.security.auth: Invalid password hash parameters")
+
+// Password hash algorithm.
+type Algorithm int
+
+// Password hash algorithms. Argon2id hashes are stored in the PHC string
+// format, such as "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>".
+const (
+	Bcrypt Algorithm = iota
+	Argon2id
+)
+
+// Argon2id salt and key lengths.
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Get the name of a password hash algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case Argon2id:
+		return "argon2id"
+	default:
+		return "bcrypt"
+	}
+}
+
+// Parse a password hash algorithm name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "bcrypt":
+		return Bcrypt, nil
+	case "argon2id":
+		return Argon2id, nil
+	default:
+		return Bcrypt, ErrInvalidAlgorithm
+	}
+}
+
+// Password hash parameters. The Argon2id memory is in KiB.
+type HashParams struct {
+	Algorithm     Algorithm
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// Get the default password hash parameters.
+func DefaultHashParams() HashParams {
+	return HashParams{
+		Algorithm:     Bcrypt,
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    2,
+		Argon2Memory:  19 * 1024,
+		Argon2Threads: 1,
+	}
+}
+
+// Validate the hash parameters.
+func (p HashParams) Validate() error {
+	if p.Algorithm != Bcrypt && p.Algorithm != Argon2id {
+		return ErrInvalidAlgorithm
+	}
+	if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+		return ErrInvalidHashParams
+	}
+	if p.Argon2Time < 1 || p.Argon2Threads < 1 || p.Argon2Memory < 8*uint32(p.Argon2Threads) {
+		return ErrInvalidHashParams
+	}
+	return nil
+}
+
+// Create a new hash from a password with the given parameters.
+func NewPasswordHashWithParams(password string, params HashParams) (PasswordHash, error) {
+	if err := params.Validate(); err != nil {
+		return PasswordHash{}, err
+	}
+	if params.Algorithm == Bcrypt {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return PasswordHash{}, err
+		}
+		return PasswordHash(hashedPassword), nil
+	}
+
+	// Hash the password with Argon2id and a random salt.
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return PasswordHash{}, err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory,
+		params.Argon2Threads, argon2KeyLength)
+
+	// Return the encoded hash.
+	return PasswordHash(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		params.Argon2Memory, params.Argon2Time, params.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// Check if the hash should be replaced with a hash using the given parameters.
+func (h *PasswordHash) NeedsRehash(params HashParams) bool {
+	if strings.HasPrefix(string(*h), "$argon2id$") {
+		if params.Algorithm != Argon2id {
+			return true
+		}
+		hashParams, _, _, err := decodeArgon2id(*h)
+		return err != nil || hashParams.Argon2Time != params.Argon2Time ||
+			hashParams.Argon2Memory != params.Argon2Memory ||
+			hashParams.Argon2Threads != params.Argon2Threads
+	}
+	if params.Algorithm != Bcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(*h))
+	return err != nil || cost != params.BcryptCost
+}
+
+// Compare an Argon2id hash and a password.
+func compareArgon2id(h PasswordHash, password string) bool {
+	params, salt, key, err := decodeArgon2id(h)
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory,
+		params.Argon2Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// Decode an Argon2id hash. Returns the parameters, salt and key.
+func decodeArgon2id(h PasswordHash) (HashParams, []byte, []byte, error) {
+	parts := strings.Split(string(h), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+	params := HashParams{Algorithm: Argon2id}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory,
+		&params.Argon2Time, &params.Argon2Threads); err != nil {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+	if params.Argon2Time < 1 || params.Argon2Threads < 1 {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return HashParams{}, nil, nil, ErrInvalidHashParams
+	}
+
+	// Return.
+	return params, salt, key, nil
+}
@@ -1,15 +1,17 @@
 // security/auth/password.go
 // Password hashes for Lily servers.
 
-// User authentication in Lily is handled using bcrypt password hashes. These
-// are stored in the master user object in the Lily server struct. Session 
-// authentication is handled using UUID session keys which are compared at 
-// runtime. Both session and user authentication methods are valid for nearly 
-// commands.
+// User authentication in Lily is handled using bcrypt or Argon2id password
+// hashes. These are stored in the master user object in the Lily server
+// struct. Session authentication is handled using UUID session keys which are
+// compared at runtime. Both session and user authentication methods are valid
+// for nearly commands.
 
 package auth
 
 import (
+	"strings"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,21 +20,17 @@ import (
 type PasswordHash []byte
 
 
-// Create a new hash from a password.
+// Create a new hash from a password, using the default hash parameters.
 func NewPasswordHash(password string) (PasswordHash, error) {
-	// Use bcrypt to hash the password.
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 
-		bcrypt.MinCost)
-	if err != nil {
-		return PasswordHash{}, err
-	}
-
-	// Return the finished hash.
-	return PasswordHash(hashedPassword), nil
+	return NewPasswordHashWithParams(password, DefaultHashParams())
 }
 
 // Compare a password hash and a password.
 func (h *PasswordHash) Compare(password string) bool {
+	if strings.HasPrefix(string(*h), "$argon2id$") {
+		return compareArgon2id(*h, password)
+	}
+
 	// Use bcrypt and compare the password.
 	err := bcrypt.CompareHashAndPassword([]byte(*h), []byte(password))
 	if err != nil {
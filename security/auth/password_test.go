@@ -20,4 +20,52 @@ func TestComparePasswordHash(t *testing.T) {
 	if h.Compare("bar") != false {
 		t.Fail()
 	}
-}
\ No newline at end of file
+}
+
+// Test creating and comparing an Argon2id password hash.
+func TestCompareArgon2idPasswordHash(t *testing.T) {
+	params := DefaultHashParams()
+	params.Algorithm = Argon2id
+	params.Argon2Memory = 64
+	h, err := NewPasswordHashWithParams("foo", params)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if h.Compare("foo") != true {
+		t.Fail()
+	}
+	if h.Compare("bar") != false {
+		t.Fail()
+	}
+}
+
+// Test checking if password hashes need to be rehashed.
+func TestNeedsRehash(t *testing.T) {
+	bcryptParams := DefaultHashParams()
+	bcryptParams.BcryptCost = 4
+	argon2Params := DefaultHashParams()
+	argon2Params.Algorithm = Argon2id
+	argon2Params.Argon2Memory = 64
+	h, err := NewPasswordHashWithParams("foo", bcryptParams)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if h.NeedsRehash(bcryptParams) || !h.NeedsRehash(argon2Params) {
+		t.Fail()
+	}
+	bcryptParams.BcryptCost = 5
+	if !h.NeedsRehash(bcryptParams) {
+		t.Fail()
+	}
+	h, err = NewPasswordHashWithParams("foo", argon2Params)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if h.NeedsRehash(argon2Params) || !h.NeedsRehash(bcryptParams) {
+		t.Fail()
+	}
+	argon2Params.Argon2Time = 3
+	if !h.NeedsRehash(argon2Params) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,136 @@
+// security/auth/policy.go
+// Password policies for Lily servers.
+
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrPasswordTooShort = errors.New("lily.security.auth: Password is too short")
+var ErrPasswordTooWeak = errors.New("lily.security.auth: Password is missing a required character class")
+var ErrPasswordDenied = errors.New("lily.security.auth: Password is in the deny-list")
+var ErrInvalidPasswordPolicy = errors.New("lily.security.auth: Invalid password policy")
+
+// Password policy. Empty passwords are always rejected.
+type PasswordPolicy struct {
+	// The minimum password length, in characters.
+	MinLength int
+
+	// The required character classes. Symbols are any characters which are
+	// not letters or digits.
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// The path to the breached password deny-list file. If empty, no
+	// deny-list is used.
+	DenyListFile string
+}
+
+// Validate the policy.
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength < 0 {
+		return ErrInvalidPasswordPolicy
+	}
+	return nil
+}
+
+// Check a password against the policy and an optional deny-list.
+func (p PasswordPolicy) Check(password string, denyList *DenyList) error {
+	if password == "" || utf8.RuneCountInString(password) < p.MinLength {
+		return ErrPasswordTooShort
+	}
+
+	// Check the character classes.
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if (p.RequireUpper && !upper) || (p.RequireLower && !lower) ||
+		(p.RequireDigit && !digit) || (p.RequireSymbol && !symbol) {
+		return ErrPasswordTooWeak
+	}
+
+	// Check the deny-list.
+	if denyList.Contains(password) {
+		return ErrPasswordDenied
+	}
+
+	// Return.
+	return nil
+}
+
+// A breached password deny-list.
+type DenyList struct {
+	passwords map[string]struct{}
+	hashes    map[[sha1.Size]byte]struct{}
+}
+
+// Load a deny-list file. Each line is either a plaintext password, or a
+// hex-encoded SHA-1 password hash optionally followed by a colon and a count,
+// as in the Pwned Passwords downloads. Empty lines are ignored.
+func LoadDenyList(path string) (*DenyList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Read the lines.
+	d := &DenyList{
+		passwords: map[string]struct{}{},
+		hashes:    map[[sha1.Size]byte]struct{}{},
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		hash := strings.SplitN(line, ":", 2)[0]
+		if len(hash) == 2*sha1.Size {
+			var sum [sha1.Size]byte
+			if _, err := hex.Decode(sum[:], []byte(hash)); err == nil {
+				d.hashes[sum] = struct{}{}
+				continue
+			}
+		}
+		d.passwords[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Return.
+	return d, nil
+}
+
+// Check if a password is in the deny-list. A nil deny-list contains nothing.
+func (d *DenyList) Contains(password string) bool {
+	if d == nil {
+		return false
+	}
+	if _, ok := d.passwords[password]; ok {
+		return true
+	}
+	_, ok := d.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
@@ -0,0 +1,58 @@
+// security/auth/policy_test.go
+// Testing for security/auth/policy.go.
+
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test checking passwords against a policy.
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, RequireUpper: true, RequireDigit: true, RequireSymbol: true}
+	if (PasswordPolicy{}).Check("", nil) != ErrPasswordTooShort {
+		t.Fail()
+	}
+	if policy.Check("Fo0!", nil) != ErrPasswordTooShort {
+		t.Fail()
+	}
+	if policy.Check("foobarbaz0!", nil) != ErrPasswordTooWeak {
+		t.Fail()
+	}
+	if policy.Check("Foobarbaz!", nil) != ErrPasswordTooWeak {
+		t.Fail()
+	}
+	if policy.Check("Foobarbaz0", nil) != ErrPasswordTooWeak {
+		t.Fail()
+	}
+	if policy.Check("Foobarbaz0!", nil) != nil {
+		t.Fail()
+	}
+}
+
+// Test loading and checking a deny-list.
+func TestDenyList(t *testing.T) {
+	sum := sha1.Sum([]byte("Password1!"))
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	contents := "Foobarbaz0!\r\n\n" + strings.ToUpper(hex.EncodeToString(sum[:])) + ":123\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	d, err := LoadDenyList(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !d.Contains("Foobarbaz0!") || !d.Contains("Password1!") || d.Contains("foo") {
+		t.Fail()
+	}
+	if (PasswordPolicy{}).Check("Password1!", d) != ErrPasswordDenied {
+		t.Fail()
+	}
+}
@@ -0,0 +1,237 @@
+// security/certs/certs.go
+// Certificate generation for Lily servers.
+
+// Package certs creates a local certificate authority, and issues server and
+// client certificates from it, so servers can be set up without bringing
+// certificates from elsewhere.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidCertificate = errors.New("lily.security.certs: Invalid certificate or key")
+var ErrNotCA = errors.New("lily.security.certs: Certificate is not a certificate authority")
+var ErrInvalidValidity = errors.New("lily.security.certs: Invalid validity period")
+
+// Default validity periods.
+const (
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+	DefaultCertValidity = 365 * 24 * time.Hour
+)
+
+// A certificate and its private key.
+type KeyPair struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Create a new self-signed certificate authority.
+func NewCA(commonName string, validity time.Duration) (*KeyPair, error) {
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	return issue(template, nil)
+}
+
+// Issue a server certificate, valid for the given DNS names and IP addresses.
+func (ca *KeyPair) IssueServer(commonName string, sans []string, validity time.Duration) (*KeyPair, error) {
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for i := range sans {
+		if ip := net.ParseIP(sans[i]); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, sans[i])
+		}
+	}
+	return issue(template, ca)
+}
+
+// Issue a client certificate for TLS client authentication.
+func (ca *KeyPair) IssueClient(commonName string, validity time.Duration) (*KeyPair, error) {
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return issue(template, ca)
+}
+
+// Get the DNS names and IP addresses the certificate is valid for.
+func (k *KeyPair) SANs() []string {
+	sans := make([]string, 0, len(k.Cert.DNSNames)+len(k.Cert.IPAddresses))
+	sans = append(sans, k.Cert.DNSNames...)
+	for i := range k.Cert.IPAddresses {
+		sans = append(sans, k.Cert.IPAddresses[i].String())
+	}
+	return sans
+}
+
+// Save the certificate and key as PEM files. Each file is replaced in one
+// step, so a server reloading its certificates never reads a partial file.
+// The key file is only readable by its owner.
+func (k *KeyPair) Save(certFile, keyFile string) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return writeFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: k.Cert.Raw}), 0644)
+}
+
+// Load a certificate and key from PEM files.
+func Load(certFile, keyFile string) (*KeyPair, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, ErrInvalidCertificate
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(keyBlock)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return nil, ErrInvalidCertificate
+	}
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// Load a certificate authority from PEM files.
+func LoadCA(certFile, keyFile string) (*KeyPair, error) {
+	ca, err := Load(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if !ca.Cert.IsCA {
+		return nil, ErrNotCA
+	}
+	return ca, nil
+}
+
+// Parse a PKCS #8, EC or PKCS #1 private key.
+func parseKey(block *pem.Block) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, ErrInvalidCertificate
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidCertificate
+	}
+	return signer, nil
+}
+
+// Create a certificate template with a random serial number.
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	if validity <= 0 {
+		return nil, ErrInvalidValidity
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// Generate a key and sign the template with the certificate authority. If the
+// certificate authority is nil, the certificate is self-signed.
+func issue(template *x509.Certificate, ca *KeyPair) (*KeyPair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	parent, signer := template, crypto.Signer(key)
+	if ca != nil {
+		parent, signer = ca.Cert, ca.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Cert: cert, Key: key}, nil
+}
+
+// Write a file by writing a temporary file and renaming it.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
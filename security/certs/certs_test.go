@@ -0,0 +1,91 @@
+// security/certs/certs_test.go
+// Testing for security/certs/certs.go.
+
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test issuing certificates and using them for a TLS handshake.
+func TestIssue(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := NewCA("lily test CA", DefaultCAValidity)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, err := ca.IssueServer("lily", []string{"localhost", "127.0.0.1"}, DefaultCertValidity)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	client, err := ca.IssueClient("foo", DefaultCertValidity)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(server.SANs()) != 2 || server.SANs()[0] != "localhost" || server.SANs()[1] != "127.0.0.1" {
+		t.Error(server.SANs())
+	}
+
+	// Save and load the certificates.
+	for name, k := range map[string]*KeyPair{"ca": ca, "server": server, "client": client} {
+		if err := k.Save(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error(info.Mode())
+	}
+	if _, err := LoadCA(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")); err != ErrNotCA {
+		t.Error(err)
+	}
+	if _, err := Load(filepath.Join(dir, "server.pem"), filepath.Join(dir, "client.key")); err != ErrInvalidCertificate {
+		t.Error(err)
+	}
+	if ca, err = LoadCA(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The server and client should trust each other through the CA.
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{serverCert},
+			ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert})
+		done <- conn.Handshake()
+	}()
+	conn := tls.Client(clientConn, &tls.Config{Certificates: []tls.Certificate{clientCert},
+		RootCAs: pool, ServerName: "127.0.0.1"})
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Invalid validity periods are rejected.
+	if _, err := ca.IssueClient("foo", -time.Hour); err != ErrInvalidValidity {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,81 @@
+// security/ipfilter/ipfilter.go
+// IP address filtering for Lily servers.
+
+// Package ipfilter provides lists of CIDR ranges, used to allow and deny
+// client IP addresses and to trust load balancers.
+package ipfilter
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+var ErrInvalidCIDR = errors.New("lily.security.ipfilter: Invalid CIDR range or IP address")
+
+// A list of CIDR ranges.
+type List []*net.IPNet
+
+// Parse a list of CIDR ranges. Single IP addresses are also accepted.
+func ParseList(cidrs []string) (List, error) {
+	list := make(List, 0, len(cidrs))
+	for i := range cidrs {
+		cidr := strings.TrimSpace(cidrs[i])
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, ErrInvalidCIDR
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				list = append(list, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+// Check if the list contains an IP address.
+func (l List) Contains(ip net.IP) bool {
+	for i := range l {
+		if l[i].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// An IP address filter. Addresses in the deny list are always denied. If the
+// allow list is not empty, only addresses in it are allowed.
+type Filter struct {
+	Allow List
+	Deny  List
+}
+
+// Create a new filter from lists of CIDR ranges.
+func NewFilter(allow, deny []string) (*Filter, error) {
+	allowList, err := ParseList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyList, err := ParseList(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Allow: allowList, Deny: denyList}, nil
+}
+
+// Check if an IP address is allowed.
+func (f *Filter) Allowed(ip net.IP) bool {
+	if f.Deny.Contains(ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || f.Allow.Contains(ip)
+}
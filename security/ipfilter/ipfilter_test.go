@@ -0,0 +1,46 @@
+// security/ipfilter/ipfilter_test.go
+// Testing for security/ipfilter/ipfilter.go.
+
+package ipfilter
+
+import (
+	"net"
+	"testing"
+)
+
+// Test filtering IP addresses.
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.5", "10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"10.0.0.5":    false,
+		"10.1.2.3":    false,
+		"192.168.0.1": false,
+		"2001:db8::1": true,
+		"::1":         false,
+	} {
+		if f.Allowed(net.ParseIP(ip)) != allowed {
+			t.Error(ip)
+		}
+	}
+
+	// An empty allow list allows everything not denied.
+	f, err = NewFilter([]string{}, []string{"::1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !f.Allowed(net.ParseIP("192.168.0.1")) || f.Allowed(net.ParseIP("::1")) {
+		t.Fail()
+	}
+
+	// Invalid ranges are rejected.
+	if _, err := NewFilter([]string{"10.0.0.0/33"}, []string{}); err != ErrInvalidCIDR {
+		t.Fail()
+	}
+	if _, err := ParseList([]string{"foo"}); err != ErrInvalidCIDR {
+		t.Fail()
+	}
+}
@@ -0,0 +1,168 @@
+// security/ldapauth/ldapauth.go
+// LDAP bind authentication for Lily servers.
+
+// Package ldapauth provides an authenticator which verifies user passwords by
+// binding to an LDAP directory, and maps the user's directory groups to Lily
+// clearance levels.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cubeflix/lily/security/access"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var ErrInvalidCredentials = errors.New("lily.security.ldapauth: Invalid credentials")
+var ErrNoClearance = errors.New("lily.security.ldapauth: User is not a member of any mapped group")
+var ErrInvalidSettings = errors.New("lily.security.ldapauth: Invalid LDAP settings")
+
+// The timeout for connecting to and querying the directory.
+const Timeout = 10 * time.Second
+
+// LDAP authentication settings.
+type Settings struct {
+	// The directory URL, such as "ldaps://ldap.example.com:636".
+	URL string
+
+	// If the connection should be upgraded with StartTLS, and if the server
+	// certificate should not be verified.
+	StartTLS           bool
+	InsecureSkipVerify bool
+
+	// The user bind DN template. The escaped username replaces "%s", such as
+	// "uid=%s,ou=people,dc=example,dc=com".
+	BindDN string
+
+	// The group search base DN and filter. The escaped user DN replaces "%s"
+	// in the filter, such as "(member=%s)". If either is empty, groups are not
+	// searched.
+	GroupBaseDN string
+	GroupFilter string
+
+	// A map of group DNs or common names to clearance levels. Users receive
+	// the highest clearance of any group they are a member of.
+	GroupClearances map[string]int
+
+	// The clearance level for users who are not in any mapped group. If zero,
+	// these users cannot log in.
+	DefaultClearance int
+}
+
+// Validate the settings.
+func (s Settings) Validate() error {
+	if s.URL == "" || strings.Count(s.BindDN, "%s") != 1 {
+		return ErrInvalidSettings
+	}
+	if s.GroupFilter != "" && strings.Count(s.GroupFilter, "%s") != 1 {
+		return ErrInvalidSettings
+	}
+	if s.DefaultClearance < 0 || s.DefaultClearance > 5 {
+		return ErrInvalidSettings
+	}
+	for group := range s.GroupClearances {
+		if s.GroupClearances[group] < 1 || s.GroupClearances[group] > 5 {
+			return ErrInvalidSettings
+		}
+	}
+	return nil
+}
+
+// The LDAP authenticator.
+type Authenticator struct {
+	settings Settings
+}
+
+// Create a new LDAP authenticator.
+func NewAuthenticator(settings Settings) (*Authenticator, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	return &Authenticator{
+		settings: settings,
+	}, nil
+}
+
+// Verify a username and password by binding as the user, then search for the
+// user's groups. Returns the user's clearance level.
+func (a *Authenticator) VerifyPassword(username, password string) (access.Clearance, error) {
+	// An empty password would perform an unauthenticated bind, which most
+	// directories accept.
+	if username == "" || password == "" {
+		return 0, ErrInvalidCredentials
+	}
+
+	// Connect to the directory.
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.settings.InsecureSkipVerify}
+	conn, err := ldap.DialURL(a.settings.URL, ldap.DialWithDialer(&net.Dialer{Timeout: Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(Timeout)
+	if a.settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return 0, err
+		}
+	}
+
+	// Bind as the user.
+	userDN := fmt.Sprintf(a.settings.BindDN, EscapeDN(username))
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	// Search for the user's groups.
+	clearance := a.settings.DefaultClearance
+	if a.settings.GroupBaseDN != "" && a.settings.GroupFilter != "" {
+		result, err := conn.Search(ldap.NewSearchRequest(a.settings.GroupBaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(Timeout/time.Second), false,
+			fmt.Sprintf(a.settings.GroupFilter, ldap.EscapeFilter(userDN)), []string{"cn"}, nil))
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range result.Entries {
+			for _, name := range []string{entry.DN, entry.GetAttributeValue("cn")} {
+				if c, ok := a.settings.GroupClearances[name]; ok && c > clearance {
+					clearance = c
+				}
+			}
+		}
+	}
+	if clearance == 0 {
+		return 0, ErrNoClearance
+	}
+
+	// Return.
+	return access.Clearance(clearance), nil
+}
+
+// Escape a value for use in a DN attribute value, as described in RFC 4514.
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == ',' || c == '+' || c == '"' || c == '\\' || c == '<' || c == '>' || c == ';' || c == '=':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case (c == ' ' || c == '#') && i == 0, c == ' ' && i == len(value)-1:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,182 @@
+// security/ldapauth/ldapauth_test.go
+// Testing for security/ldapauth/ldapauth.go.
+
+package ldapauth
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cubeflix/lily/security/access"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// In-process LDAP directory, supporting simple binds and equality searches.
+type testDirectory struct {
+	users  map[string]string
+	groups map[string][]string
+}
+
+// Serve the directory on a listener.
+func (d *testDirectory) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+// Handle a directory connection.
+func (d *testDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			password, ok := d.users[op.Children[1].Data.String()]
+			if ok && password == op.Children[2].Data.String() {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(testMessage(id, testResult(ldap.ApplicationBindResponse, code)))
+		case ldap.ApplicationSearchRequest:
+			member := op.Children[6].Children[1].Data.String()
+			for dn, members := range d.groups {
+				for i := range members {
+					if members[i] != member {
+						continue
+					}
+					entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+					entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+					attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "cn", ""))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, strings.TrimPrefix(strings.SplitN(dn, ",", 2)[0], "cn="), ""))
+					attribute.AppendChild(values)
+					attributes.AppendChild(attribute)
+					entry.AppendChild(attributes)
+					conn.Write(testMessage(id, entry))
+				}
+			}
+			conn.Write(testMessage(id, testResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)))
+		default:
+			return
+		}
+	}
+}
+
+// Encode an LDAP message.
+func testMessage(id int64, op *ber.Packet) []byte {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet.Bytes()
+}
+
+// Encode an LDAP result.
+func testResult(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+// Test verifying passwords against the directory.
+func TestVerifyPassword(t *testing.T) {
+	// Start the directory.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer l.Close()
+	d := &testDirectory{
+		users: map[string]string{
+			"uid=alice,ou=people,dc=example": "foo",
+			"uid=bob,ou=people,dc=example":   "bar",
+			"uid=carol,ou=people,dc=example": "baz",
+		},
+		groups: map[string][]string{
+			"cn=admins,ou=groups,dc=example": {"uid=alice,ou=people,dc=example"},
+			"cn=staff,ou=groups,dc=example":  {"uid=alice,ou=people,dc=example", "uid=bob,ou=people,dc=example"},
+		},
+	}
+	go d.serve(l)
+
+	// Create the authenticator.
+	a, err := NewAuthenticator(Settings{
+		URL:         "ldap://" + l.Addr().String(),
+		BindDN:      "uid=%s,ou=people,dc=example",
+		GroupBaseDN: "ou=groups,dc=example",
+		GroupFilter: "(member=%s)",
+		GroupClearances: map[string]int{
+			"cn=admins,ou=groups,dc=example": 5,
+			"staff":                          2,
+		},
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Users receive the highest clearance of their groups.
+	if c, err := a.VerifyPassword("alice", "foo"); err != nil || c != access.ClearanceLevelFive {
+		t.Fail()
+	}
+	if c, err := a.VerifyPassword("bob", "bar"); err != nil || c != access.ClearanceLevelTwo {
+		t.Fail()
+	}
+
+	// Users in no mapped group cannot log in.
+	if _, err := a.VerifyPassword("carol", "baz"); err != ErrNoClearance {
+		t.Fail()
+	}
+
+	// Invalid and empty passwords.
+	if _, err := a.VerifyPassword("alice", "invalid"); err != ErrInvalidCredentials {
+		t.Fail()
+	}
+	if _, err := a.VerifyPassword("alice", ""); err != ErrInvalidCredentials {
+		t.Fail()
+	}
+	if _, err := a.VerifyPassword("alice,ou=people", "foo"); err != ErrInvalidCredentials {
+		t.Fail()
+	}
+}
+
+// Test validating settings.
+func TestSettingsValidate(t *testing.T) {
+	if (Settings{URL: "ldap://localhost", BindDN: "uid=%s"}).Validate() != nil {
+		t.Fail()
+	}
+	if (Settings{URL: "ldap://localhost", BindDN: "uid=foo"}).Validate() != ErrInvalidSettings {
+		t.Fail()
+	}
+	if (Settings{URL: "ldap://localhost", BindDN: "uid=%s", GroupClearances: map[string]int{"foo": 6}}).Validate() != ErrInvalidSettings {
+		t.Fail()
+	}
+}
+
+// Test escaping DN values.
+func TestEscapeDN(t *testing.T) {
+	if EscapeDN("foo") != "foo" {
+		t.Fail()
+	}
+	if EscapeDN("a,b=c") != "a\\,b\\=c" {
+		t.Fail()
+	}
+	if EscapeDN(" #a ") != "\\ #a\\ " {
+		t.Fail()
+	}
+}
@@ -0,0 +1,210 @@
+// security/lockout/lockout.go
+// Failed authentication tracking for Lily servers.
+
+// Package lockout provides a tracker for failed authentication attempts, which
+// temporarily locks out keys (such as usernames or IP addresses) after too
+// many failures. Each consecutive lockout doubles the lockout duration.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// The default maximum number of tracked keys.
+const DefaultMaxEntries = 10000
+
+// Lockout policy.
+type Policy struct {
+	// The number of consecutive failures before a key is locked out. If zero,
+	// keys are never locked out.
+	Threshold int
+
+	// The duration of the first lockout. Each following lockout doubles the
+	// duration, up to the maximum. Keys which have not failed for the maximum
+	// duration are forgotten.
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// A tracked key.
+type entry struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// The failed authentication tracker.
+type Tracker struct {
+	lock       sync.Mutex
+	policy     Policy
+	entries    map[string]*entry
+	maxEntries int
+}
+
+// Create a new tracker.
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		lock:       sync.Mutex{},
+		policy:     policy,
+		entries:    map[string]*entry{},
+		maxEntries: DefaultMaxEntries,
+	}
+}
+
+// Set the maximum number of tracked keys. Failures can be submitted for any
+// username or address, so the tracker evicts keys once it is full.
+func (t *Tracker) SetMaxEntries(maxEntries int) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.maxEntries = maxEntries
+}
+
+// Get the policy.
+func (t *Tracker) GetPolicy() Policy {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.policy
+}
+
+// Set the policy. Existing lockouts are kept.
+func (t *Tracker) SetPolicy(policy Policy) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.policy = policy
+}
+
+// Check if a key is locked out. Returns the time the lockout ends.
+func (t *Tracker) Locked(key string, now time.Time) (time.Time, bool) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || !now.Before(e.lockedUntil) {
+		return time.Time{}, false
+	}
+	return e.lockedUntil, true
+}
+
+// Record a failed attempt for a key. Returns the number of consecutive
+// failures, and the time the lockout ends if the key is now locked out.
+func (t *Tracker) Fail(key string, now time.Time) (int, time.Time, bool) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		if len(t.entries) >= t.maxEntries {
+			t.evict(now)
+		}
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures += 1
+	e.lastFailure = now
+	failures := e.failures
+	if t.policy.Threshold == 0 || e.failures < t.policy.Threshold {
+		return failures, time.Time{}, false
+	}
+
+	// Lock the key out, doubling the duration for each previous lockout.
+	duration := t.policy.Duration
+	for i := 0; i < e.lockouts && duration < t.policy.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > t.policy.MaxDuration {
+		duration = t.policy.MaxDuration
+	}
+	e.failures = 0
+	e.lockouts += 1
+	e.lockedUntil = now.Add(duration)
+
+	// Return.
+	return failures, e.lockedUntil, true
+}
+
+// Record a successful attempt for a key, resetting its failures.
+func (t *Tracker) Succeed(key string) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.entries, key)
+}
+
+// Unlock keys, resetting their failures. Returns false if a key is not locked.
+func (t *Tracker) Unlock(keys []string, now time.Time) bool {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ok := true
+	for i := range keys {
+		e, exists := t.entries[keys[i]]
+		if !exists || !now.Before(e.lockedUntil) {
+			ok = false
+			continue
+		}
+		delete(t.entries, keys[i])
+	}
+	return ok
+}
+
+// Get all locked out keys, along with the time each lockout ends.
+func (t *Tracker) GetLocked(now time.Time) map[string]time.Time {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	locked := map[string]time.Time{}
+	for key, e := range t.entries {
+		if now.Before(e.lockedUntil) {
+			locked[key] = e.lockedUntil
+		}
+	}
+	return locked
+}
+
+// Remove keys which are not locked out and have not failed for the maximum
+// lockout duration.
+func (t *Tracker) Prune(now time.Time) {
+	// Acquire the lock.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for key, e := range t.entries {
+		if !now.Before(e.lockedUntil) && now.Sub(e.lastFailure) >= t.policy.MaxDuration {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Evict a key to make room for a new one. Keys which are not locked out are
+// evicted first, oldest failure first, so that flooding the tracker with new
+// keys does not lift lockouts. If every key is locked out, the lockout which
+// ends first is evicted. The lock must be held.
+func (t *Tracker) evict(now time.Time) {
+	oldest, oldestLocked := "", ""
+	for key, e := range t.entries {
+		if now.Before(e.lockedUntil) {
+			if oldestLocked == "" || e.lockedUntil.Before(t.entries[oldestLocked].lockedUntil) {
+				oldestLocked = key
+			}
+		} else if oldest == "" || e.lastFailure.Before(t.entries[oldest].lastFailure) {
+			oldest = key
+		}
+	}
+	if oldest == "" {
+		oldest = oldestLocked
+	}
+	delete(t.entries, oldest)
+}
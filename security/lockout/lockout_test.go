@@ -0,0 +1,122 @@
+// security/lockout/lockout_test.go
+// Testing for security/lockout/lockout.go.
+
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// Test locking out keys with exponential backoff.
+func TestTrackerBackoff(t *testing.T) {
+	tracker := NewTracker(Policy{Threshold: 3, Duration: time.Minute, MaxDuration: 3 * time.Minute})
+	now := time.Now()
+
+	// The key should be locked out after three failures.
+	for i := 0; i < 2; i++ {
+		if _, _, locked := tracker.Fail("foo", now); locked {
+			t.Fail()
+		}
+	}
+	if _, until, locked := tracker.Fail("foo", now); !locked || until != now.Add(time.Minute) {
+		t.Fail()
+	}
+	if _, locked := tracker.Locked("foo", now); !locked {
+		t.Fail()
+	}
+	if _, locked := tracker.Locked("foo", now.Add(time.Minute)); locked {
+		t.Fail()
+	}
+
+	// The next lockout should double, and then be capped at the maximum.
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		tracker.Fail("foo", now)
+	}
+	if _, until, _ := tracker.Fail("foo", now); until != now.Add(2*time.Minute) {
+		t.Fail()
+	}
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		tracker.Fail("foo", now)
+	}
+	if _, until, _ := tracker.Fail("foo", now); until != now.Add(3*time.Minute) {
+		t.Fail()
+	}
+
+	// Other keys are unaffected.
+	if _, locked := tracker.Locked("bar", now); locked {
+		t.Fail()
+	}
+}
+
+// Test resetting and unlocking keys.
+func TestTrackerUnlock(t *testing.T) {
+	tracker := NewTracker(Policy{Threshold: 2, Duration: time.Minute, MaxDuration: time.Hour})
+	now := time.Now()
+
+	// A success resets the failure count.
+	tracker.Fail("foo", now)
+	tracker.Succeed("foo")
+	if _, _, locked := tracker.Fail("foo", now); locked {
+		t.Fail()
+	}
+
+	// Unlock a locked key.
+	tracker.Fail("foo", now)
+	if len(tracker.GetLocked(now)) != 1 {
+		t.Fail()
+	}
+	if !tracker.Unlock([]string{"foo"}, now) {
+		t.Fail()
+	}
+	if tracker.Unlock([]string{"foo"}, now) {
+		t.Fail()
+	}
+	if len(tracker.GetLocked(now)) != 0 {
+		t.Fail()
+	}
+
+	// Pruning forgets old failures.
+	tracker.Fail("bar", now)
+	tracker.Prune(now.Add(time.Hour))
+	if _, _, locked := tracker.Fail("bar", now.Add(time.Hour)); locked {
+		t.Fail()
+	}
+}
+
+// Test a disabled policy.
+func TestTrackerDisabled(t *testing.T) {
+	tracker := NewTracker(Policy{})
+	for i := 0; i < 100; i++ {
+		if _, _, locked := tracker.Fail("foo", time.Now()); locked {
+			t.Fail()
+		}
+	}
+}
+
+// Test that the number of tracked keys is capped, without lifting lockouts.
+func TestTrackerMaxEntries(t *testing.T) {
+	tracker := NewTracker(Policy{Threshold: 2, Duration: time.Minute, MaxDuration: time.Hour})
+	tracker.SetMaxEntries(3)
+	now := time.Now()
+
+	// Lock out a key, then fail many other keys.
+	tracker.Fail("locked", now)
+	tracker.Fail("locked", now)
+	for i := 0; i < 100; i++ {
+		tracker.Fail(string(rune('a'+i%26))+string(rune('a'+i/26)), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(tracker.entries) != 3 {
+		t.Fatal(len(tracker.entries))
+	}
+	if _, locked := tracker.Locked("locked", now); !locked {
+		t.Fail()
+	}
+
+	// The newest keys are kept.
+	if _, ok := tracker.entries["vd"]; !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,97 @@
+// security/totp/totp_test.go
+// Testing for security/totp/totp.go.
+
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test generating codes against the RFC 6238 SHA-1 test vectors.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, code := range vectors {
+		if Code(secret, time.Unix(unix, 0)) != code {
+			t.Errorf("invalid code at %d", unix)
+		}
+	}
+}
+
+// Test validating codes, including clock skew.
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	now := time.Now()
+
+	// The current and adjacent codes should be valid.
+	step, ok := Validate(secret, Code(secret, now), now)
+	if !ok || step != Step(now) {
+		t.Fail()
+	}
+	if _, ok := Validate(secret, Code(secret, now.Add(-Period)), now); !ok {
+		t.Fail()
+	}
+	if _, ok := Validate(secret, Code(secret, now.Add(Period)), now); !ok {
+		t.Fail()
+	}
+
+	// Codes outside the skew window should be invalid.
+	if _, ok := Validate(secret, Code(secret, now.Add(-3*Period)), now); ok {
+		t.Fail()
+	}
+	if _, ok := Validate(secret, "", now); ok {
+		t.Fail()
+	}
+}
+
+// Test encoding and decoding secrets.
+func TestEncodeSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	encoded := EncodeSecret(secret)
+	if strings.Contains(encoded, "=") {
+		t.Fail()
+	}
+	decoded, err := DecodeSecret(strings.ToLower(encoded))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if string(decoded) != string(secret) {
+		t.Fail()
+	}
+	if !strings.HasPrefix(URI("lily", "foo", secret), "otpauth://totp/lily:foo?") {
+		t.Fail()
+	}
+}
+
+// Test generating recovery codes.
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(codes) != NumRecoveryCodes {
+		t.Fail()
+	}
+	seen := map[string]bool{}
+	for i := range codes {
+		if len(codes[i]) != RecoveryCodeLength+1 || seen[codes[i]] {
+			t.Fail()
+		}
+		seen[codes[i]] = true
+	}
+}
@@ -0,0 +1,168 @@
+// security/userlimit/userlimit.go
+// Per-user request and bandwidth limits for Lily servers.
+
+// Package userlimit provides per-user request limits and bandwidth throttles.
+// Each user is limited to a number of requests per interval, and their chunk
+// data is throttled to an upload and download rate. The default limits can be
+// overridden for individual users.
+package userlimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cubeflix/lily/network"
+)
+
+var ErrInvalidLimits = errors.New("lily.security.userlimit: Invalid limits")
+
+// User limits.
+type Limits struct {
+	// The number of requests allowed in each interval. If zero, requests are
+	// not limited.
+	Requests int
+	Interval time.Duration
+
+	// The upload and download rates in bytes per second. If zero, transfers
+	// are not throttled.
+	UploadRate   int64
+	DownloadRate int64
+}
+
+// Check that the limits are valid.
+func (l Limits) Validate() error {
+	if l.Requests < 0 || l.UploadRate < 0 || l.DownloadRate < 0 {
+		return ErrInvalidLimits
+	}
+	if l.Requests > 0 && l.Interval <= 0 {
+		return ErrInvalidLimits
+	}
+	return nil
+}
+
+// A tracked user.
+type entry struct {
+	limits      Limits
+	requests    int
+	windowStart time.Time
+	lastUsed    time.Time
+	upload      *network.Throttle
+	download    *network.Throttle
+}
+
+// Set the entry's limits.
+func (e *entry) setLimits(limits Limits) {
+	e.limits = limits
+	e.upload.SetRate(limits.UploadRate)
+	e.download.SetRate(limits.DownloadRate)
+}
+
+// The per-user limiter.
+type Limiter struct {
+	lock      sync.Mutex
+	defaults  Limits
+	overrides map[string]Limits
+	entries   map[string]*entry
+}
+
+// Create a new limiter with the default limits and per-user overrides.
+func NewLimiter(defaults Limits, overrides map[string]Limits) *Limiter {
+	return &Limiter{
+		lock:      sync.Mutex{},
+		defaults:  defaults,
+		overrides: overrides,
+		entries:   map[string]*entry{},
+	}
+}
+
+// Get the limits for a user. NOTE: This does not acquire the lock.
+func (l *Limiter) limitsFor(username string) Limits {
+	if limits, ok := l.overrides[username]; ok {
+		return limits
+	}
+	return l.defaults
+}
+
+// Set the default limits and per-user overrides. Tracked users are updated to
+// the new limits.
+func (l *Limiter) SetLimits(defaults Limits, overrides map[string]Limits) {
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.defaults = defaults
+	l.overrides = overrides
+	for username, e := range l.entries {
+		e.setLimits(l.limitsFor(username))
+	}
+}
+
+// Get the entry for a user, creating it if needed. NOTE: This does not acquire
+// the lock.
+func (l *Limiter) getEntry(username string, now time.Time) *entry {
+	e, ok := l.entries[username]
+	if !ok {
+		limits := l.limitsFor(username)
+		e = &entry{
+			limits:      limits,
+			windowStart: now,
+			upload:      network.NewThrottle(limits.UploadRate),
+			download:    network.NewThrottle(limits.DownloadRate),
+		}
+		l.entries[username] = e
+	}
+	e.lastUsed = now
+	return e
+}
+
+// Take a request for a user. Returns false if the user has reached their
+// request limit for the current interval.
+func (l *Limiter) Take(username string, now time.Time) bool {
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e := l.getEntry(username, now)
+	if e.limits.Requests <= 0 {
+		return true
+	}
+	if now.Sub(e.windowStart) >= e.limits.Interval {
+		e.windowStart = now
+		e.requests = 0
+	}
+	if e.requests >= e.limits.Requests {
+		return false
+	}
+	e.requests++
+	return true
+}
+
+// Get the upload and download throttles for a user. The throttles are shared
+// by all of the user's connections.
+func (l *Limiter) Throttles(username string, now time.Time) (*network.Throttle, *network.Throttle) {
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e := l.getEntry(username, now)
+	return e.upload, e.download
+}
+
+// Forget users which have not made a request for the interval, or a minute if
+// their requests are not limited.
+func (l *Limiter) Prune(now time.Time) {
+	// Acquire the lock.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for username, e := range l.entries {
+		idle := e.limits.Interval
+		if idle < time.Minute {
+			idle = time.Minute
+		}
+		if now.Sub(e.lastUsed) >= idle {
+			delete(l.entries, username)
+		}
+	}
+}
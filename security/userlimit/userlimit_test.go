@@ -0,0 +1,76 @@
+// security/userlimit/userlimit_test.go
+// Testing for security/userlimit/userlimit.go.
+
+package userlimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Test limiting users' requests.
+func TestLimiterTake(t *testing.T) {
+	limiter := NewLimiter(Limits{Requests: 2, Interval: time.Minute}, map[string]Limits{
+		"bar": {Requests: 1, Interval: time.Minute},
+	})
+	now := time.Now()
+
+	// Each user has their own limit.
+	for i := 0; i < 2; i++ {
+		if !limiter.Take("foo", now) {
+			t.Fail()
+		}
+	}
+	if limiter.Take("foo", now) {
+		t.Fail()
+	}
+	if !limiter.Take("bar", now) || limiter.Take("bar", now) {
+		t.Fail()
+	}
+
+	// The limit resets after the interval.
+	if !limiter.Take("foo", now.Add(time.Minute)) {
+		t.Fail()
+	}
+
+	// Changing the limits applies to tracked users.
+	limiter.SetLimits(Limits{}, map[string]Limits{})
+	if !limiter.Take("bar", now) {
+		t.Fail()
+	}
+}
+
+// Test per-user throttles and pruning.
+func TestLimiterThrottles(t *testing.T) {
+	limiter := NewLimiter(Limits{UploadRate: 100, DownloadRate: 200}, map[string]Limits{})
+	now := time.Now()
+
+	// The throttles are shared by the user's connections.
+	upload, download := limiter.Throttles("foo", now)
+	if upload.GetRate() != 100 || download.GetRate() != 200 {
+		t.Fail()
+	}
+	if other, _ := limiter.Throttles("foo", now); other != upload {
+		t.Fail()
+	}
+	limiter.SetLimits(Limits{UploadRate: 50}, map[string]Limits{})
+	if upload.GetRate() != 50 || download.GetRate() != 0 {
+		t.Fail()
+	}
+
+	// Idle users are forgotten.
+	limiter.Prune(now.Add(time.Minute))
+	if other, _ := limiter.Throttles("foo", now); other == upload {
+		t.Fail()
+	}
+}
+
+// Test validating limits.
+func TestLimitsValidate(t *testing.T) {
+	if (Limits{Requests: 1}).Validate() != ErrInvalidLimits || (Limits{UploadRate: -1}).Validate() != ErrInvalidLimits {
+		t.Fail()
+	}
+	if (Limits{Requests: 1, Interval: time.Second}).Validate() != nil || (Limits{}).Validate() != nil {
+		t.Fail()
+	}
+}
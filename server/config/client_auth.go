@@ -0,0 +1,265 @@
+// server/config/client_auth.go
+// Client certificate authentication settings for Lily servers.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+var ErrInvalidClientAuthMode = errors.New("lily.server.config: Invalid client authentication mode")
+var ErrNoClientCA = errors.New("lily.server.config: Client authentication requires a client CA file")
+var ErrInvalidClientCA = errors.New("lily.server.config: Invalid client CA file")
+var ErrCertUserAlreadyExists = errors.New("lily.server.config: Certificate identity already exists")
+var ErrCertUserDoesNotExist = errors.New("lily.server.config: Certificate identity does not exist")
+var ErrInvalidCertIdentity = errors.New("lily.server.config: Invalid certificate identity")
+
+// Certificate identity types. An identity is written as its type and value,
+// such as "dns:foo.example.com", and only matches that part of a certificate.
+const (
+	CertIdentitySubject = "subject"
+	CertIdentityCN      = "cn"
+	CertIdentityDNS     = "dns"
+	CertIdentityEmail   = "email"
+	CertIdentityURI     = "uri"
+	CertIdentityIP      = "ip"
+)
+
+// Get the normalized form of a certificate identity. The type is lowercase,
+// and IP addresses are in their canonical form.
+func normalizeCertIdentity(identity string) (string, error) {
+	split := strings.SplitN(identity, ":", 2)
+	if len(split) != 2 || split[1] == "" {
+		return "", ErrInvalidCertIdentity
+	}
+	kind, value := strings.ToLower(split[0]), split[1]
+	switch kind {
+	case CertIdentitySubject, CertIdentityCN, CertIdentityDNS, CertIdentityEmail, CertIdentityURI:
+	case CertIdentityIP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", ErrInvalidCertIdentity
+		}
+		value = ip.String()
+	default:
+		return "", ErrInvalidCertIdentity
+	}
+	return kind + ":" + value, nil
+}
+
+// Client certificate authentication mode.
+type ClientAuthMode int
+
+// Client certificate authentication modes. In request mode, clients may
+// present a certificate, which is verified if given. In require mode, every
+// connection must present a valid client certificate.
+const (
+	ClientAuthNone ClientAuthMode = iota
+	ClientAuthRequest
+	ClientAuthRequire
+)
+
+// Get the name of a client authentication mode.
+func (m ClientAuthMode) String() string {
+	switch m {
+	case ClientAuthRequest:
+		return "request"
+	case ClientAuthRequire:
+		return "require"
+	default:
+		return "none"
+	}
+}
+
+// Parse a client authentication mode name.
+func ParseClientAuthMode(name string) (ClientAuthMode, error) {
+	switch name {
+	case "none":
+		return ClientAuthNone, nil
+	case "request":
+		return ClientAuthRequest, nil
+	case "require":
+		return ClientAuthRequire, nil
+	default:
+		return ClientAuthNone, ErrInvalidClientAuthMode
+	}
+}
+
+// Get the client certificate authentication settings.
+func (c *Config) GetClientAuth() (string, ClientAuthMode) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.clientCAFile, c.clientAuthMode
+}
+
+// Set the client certificate authentication settings. Note that this does not
+// update the server until the certificates are reloaded.
+func (c *Config) SetClientAuth(clientCAFile string, mode ClientAuthMode) error {
+	if mode < ClientAuthNone || mode > ClientAuthRequire {
+		return ErrInvalidClientAuthMode
+	}
+	if mode != ClientAuthNone && clientCAFile == "" {
+		return ErrNoClientCA
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clientCAFile = clientCAFile
+	c.clientAuthMode = mode
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Get the map of certificate identities to usernames.
+func (c *Config) GetCertUsers() map[string]string {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.certUsers
+}
+
+// Add certificate identities, mapping each to a username. An identity is a
+// type and a value: "subject:" with a certificate subject (such as
+// "subject:CN=foo,O=bar"), "cn:" with a subject common name, or "dns:",
+// "email:", "uri:" or "ip:" with a SAN.
+func (c *Config) AddCertUsers(users map[string]string) error {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check the identities.
+	normalized := map[string]string{}
+	for identity := range users {
+		key, err := normalizeCertIdentity(identity)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.certUsers[key]; ok {
+			return ErrCertUserAlreadyExists
+		}
+		if _, ok := normalized[key]; ok {
+			return ErrCertUserAlreadyExists
+		}
+		normalized[key] = users[identity]
+	}
+
+	// Add the identities.
+	for key := range normalized {
+		c.certUsers[key] = normalized[key]
+	}
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Remove certificate identities.
+func (c *Config) RemoveCertUsers(identities []string) error {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check the identities.
+	keys := make([]string, len(identities))
+	for i := range identities {
+		key, err := normalizeCertIdentity(identities[i])
+		if err != nil {
+			return err
+		}
+		if _, ok := c.certUsers[key]; !ok {
+			return ErrCertUserDoesNotExist
+		}
+		keys[i] = key
+	}
+
+	// Remove the identities.
+	for i := range keys {
+		delete(c.certUsers, keys[i])
+	}
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Get the username for a verified client certificate. The full subject is
+// checked first, followed by the common name and then each SAN. Each part of
+// the certificate only matches identities of its own type.
+func (c *Config) GetCertUsername(cert *x509.Certificate) (string, bool) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	identities := []string{CertIdentitySubject + ":" + cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, CertIdentityCN+":"+cert.Subject.CommonName)
+	}
+	for i := range cert.DNSNames {
+		identities = append(identities, CertIdentityDNS+":"+cert.DNSNames[i])
+	}
+	for i := range cert.EmailAddresses {
+		identities = append(identities, CertIdentityEmail+":"+cert.EmailAddresses[i])
+	}
+	for i := range cert.URIs {
+		identities = append(identities, CertIdentityURI+":"+cert.URIs[i].String())
+	}
+	for i := range cert.IPAddresses {
+		identities = append(identities, CertIdentityIP+":"+cert.IPAddresses[i].String())
+	}
+	for i := range identities {
+		if username, ok := c.certUsers[identities[i]]; ok {
+			return username, true
+		}
+	}
+	return "", false
+}
+
+// Load the client certificate authority into the TLS config. NOTE: This does
+// not acquire the lock.
+func (c *Config) loadClientCAs() error {
+	if c.clientAuthMode == ClientAuthNone {
+		c.tlsConfig.ClientCAs = nil
+		c.tlsConfig.ClientAuth = tls.NoClientCert
+		return nil
+	}
+	if c.clientCAFile == "" {
+		return ErrNoClientCA
+	}
+
+	// Load the CA bundle.
+	data, err := os.ReadFile(c.clientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return ErrInvalidClientCA
+	}
+	c.tlsConfig.ClientCAs = pool
+	if c.clientAuthMode == ClientAuthRequire {
+		c.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		c.tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	// Return.
+	return nil
+}
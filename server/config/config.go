@@ -9,6 +9,12 @@ import (
 	"os"
 	"sync"
 	"time"
+
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/ipfilter"
+	"github.com/cubeflix/lily/security/ldapauth"
+	"github.com/cubeflix/lily/security/lockout"
+	"github.com/cubeflix/lily/security/userlimit"
 )
 
 // The Lily server contains a configuration object which stores the settings
@@ -23,6 +29,14 @@ var ErrNumWorkersInvalid = errors.New("lily.server.config: Invalid number of wor
 var ErrNumBacklogInvalid = errors.New("lily.server.config: Invalid backlog length; must have at least one")
 var ErrTimeoutInvalid = errors.New("lily.server.config: Timeout interval invalid")
 var ErrInvalidLoggingLevel = errors.New("lily.server.config: Invalid logging level")
+var ErrInvalidClearance = errors.New("lily.server.config: Invalid clearance level")
+var ErrInvalidLockout = errors.New("lily.server.config: Invalid lockout settings")
+var ErrInvalidSessionTimeouts = errors.New("lily.server.config: Invalid session timeouts")
+var ErrInvalidShutdownDeadline = errors.New("lily.server.config: Invalid shutdown deadline")
+var ErrNoCertificates = errors.New("lily.server.config: No certificates loaded")
+
+// The default shutdown deadline.
+const DefaultShutdownDeadline = 30 * time.Second
 
 // Logging levels.
 const (
@@ -81,6 +95,10 @@ type Config struct {
 	// Network timeout duration.
 	netTimeout time.Duration
 
+	// The time in-flight commands are given to finish when the server shuts
+	// down, after which they are cancelled.
+	shutdownDeadline time.Duration
+
 	// Logging settings.
 	verbose   bool
 	logToFile bool
@@ -93,15 +111,108 @@ type Config struct {
 	allowChangeSessionExpiration bool
 	allowNonExpiringSessions     bool
 
+	// Session idle timeout and maximum lifetime. Sessions expire if they are
+	// unused for the idle timeout, or once they are older than the maximum
+	// lifetime, regardless of their expiration time. Zero disables either.
+	sessionIdleTimeout time.Duration
+	sessionMaxLifetime time.Duration
+
+	// Session binding settings. Bound sessions may only be used from the
+	// address, subnet or client certificate they were created with. The
+	// prefix lengths are used in subnet mode.
+	sessionBindingMode       SessionBindingMode
+	sessionBindingIPv4Prefix int
+	sessionBindingIPv6Prefix int
+
 	// Max sessions per user.
 	perUserSessionLimit int
 
+	// The path to the sessions file. If empty, sessions are not saved and
+	// are lost when the server restarts.
+	sessionFile string
+
+	// Audit log settings. If the audit file is empty, commands are not
+	// audited. If hash chaining is enabled, each record includes the hash of
+	// the previous record.
+	auditFile      string
+	auditHashChain bool
+
+	// The address of the metrics HTTP listener. If empty, metrics are not
+	// served.
+	metricsAddress string
+
+	// The clearance level at or above which users must enroll in two-factor
+	// authentication before they can log in. Zero disables the requirement.
+	totpRequiredClearance int
+
+	// Account lockout settings. Users and IP addresses are locked out after
+	// their threshold of consecutive failed logins, for a duration which
+	// doubles with each lockout up to the maximum. A threshold of zero
+	// disables the lockout.
+	userLockoutThreshold int
+	ipLockoutThreshold   int
+	lockoutDuration      time.Duration
+	lockoutMaxDuration   time.Duration
+
 	// Rate limiting settings.
 	limit          time.Duration
 	maxLimitEvents int
 
-	// TLS certificate paths.
+	// Per-user request and bandwidth limits, with overrides for individual
+	// users, and the server-wide upload and download rates in bytes per
+	// second. Zero values disable a limit.
+	userLimits         userlimit.Limits
+	userLimitOverrides map[string]userlimit.Limits
+	uploadRate         int64
+	downloadRate       int64
+
+	// Listener settings. Connections are checked against the IP allow and
+	// deny lists, and capped in total and per IP, where zero disables a cap.
+	// Connections from trusted proxies must begin with a PROXY protocol
+	// header, and the client address in the header is used instead.
+	ipAllowList         []string
+	ipDenyList          []string
+	ipFilter            *ipfilter.Filter
+	trustedProxies      []string
+	trustedProxyList    ipfilter.List
+	maxConnections      int
+	maxConnectionsPerIP int
+
+	// Additional TCP and Unix socket listeners.
+	listeners []Listener
+
+	// Transfer settings. Chunks larger than the max chunk size are rejected,
+	// where zero uses the default size. Transfers reserve their buffers from
+	// the memory budget, and are turned away once it is used up. Uploads
+	// larger than the max upload size are rejected. A budget or max upload
+	// size of zero disables it.
+	maxChunkSize  int
+	memoryBudget  int64
+	maxUploadSize int64
+
+	// TLS certificate paths, and the loaded certificates. The certificates
+	// are served through the TLS config's GetCertificate hook, so they can be
+	// reloaded without restarting the server.
 	certFiles []CertFilePair
+	certs     []tls.Certificate
+
+	// Client certificate authentication settings. The client CA file is a PEM
+	// bundle used to verify client certificates, and the cert users map
+	// certificate subjects or SANs to Lily usernames.
+	clientCAFile   string
+	clientAuthMode ClientAuthMode
+	certUsers      map[string]string
+
+	// LDAP authentication settings.
+	ldapMode          LDAPMode
+	ldapAutoProvision bool
+	ldapSettings      ldapauth.Settings
+
+	// Password policy and hash settings. The deny-list is loaded from the
+	// policy's deny-list file when the server is loaded.
+	passwordPolicy auth.PasswordPolicy
+	denyList       *auth.DenyList
+	hashParams     auth.HashParams
 
 	// TLS config.
 	tlsConfig *tls.Config
@@ -142,6 +253,7 @@ func NewConfig(file, name, host string, port int, driveFiles map[string]string,
 		mainCronInterval:             mainCronInterval,
 		sessionCronInterval:          sessionCronInterval,
 		netTimeout:                   netTimeout,
+		shutdownDeadline:             DefaultShutdownDeadline,
 		verbose:                      verbose,
 		logToFile:                    logToFile,
 		logJSON:                      logJSON,
@@ -151,9 +263,20 @@ func NewConfig(file, name, host string, port int, driveFiles map[string]string,
 		allowChangeSessionExpiration: allowChangeSessionExpiration,
 		allowNonExpiringSessions:     allowNonExpiringSessions,
 		perUserSessionLimit:          perUserSessionLimit,
+		sessionBindingIPv4Prefix:     DefaultSessionBindingIPv4Prefix,
+		sessionBindingIPv6Prefix:     DefaultSessionBindingIPv6Prefix,
 		limit:                        limit,
 		maxLimitEvents:               maxLimitEvents,
 		certFiles:                    certFiles,
+		certUsers:                    map[string]string{},
+		userLimitOverrides:           map[string]userlimit.Limits{},
+		ipAllowList:                  []string{},
+		ipDenyList:                   []string{},
+		ipFilter:                     &ipfilter.Filter{},
+		trustedProxies:               []string{},
+		listeners:                    []Listener{},
+		ldapSettings:                 ldapauth.Settings{GroupClearances: map[string]int{}},
+		hashParams:                   auth.DefaultHashParams(),
 		tlsConfig:                    tlsConfig,
 	}, nil
 }
@@ -416,6 +539,36 @@ func (c *Config) SetTimeout(netTimeout time.Duration) error {
 	return nil
 }
 
+// Get the shutdown deadline.
+func (c *Config) GetShutdownDeadline() time.Duration {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.shutdownDeadline
+}
+
+// Set the shutdown deadline. In-flight commands are cancelled if they have not
+// finished by the deadline. Note that this only applies from the next restart;
+// the shutdown command can set the deadline for a running server.
+func (c *Config) SetShutdownDeadline(deadline time.Duration) error {
+	if deadline < 0 {
+		return ErrInvalidShutdownDeadline
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.shutdownDeadline = deadline
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
 // Get the logging values. These values are thread-safe and thus do not need
 // locks.
 func (c *Config) GetLogging() (bool, bool, bool, string, string) {
@@ -459,6 +612,36 @@ func (c *Config) SetSessionExpirationSettings(defaultSessionExpiration time.Dura
 	c.SetDirty(true)
 }
 
+// Get the session idle timeout and maximum lifetime.
+func (c *Config) GetSessionTimeouts() (time.Duration, time.Duration) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.sessionIdleTimeout, c.sessionMaxLifetime
+}
+
+// Set the session idle timeout and maximum lifetime. Zero disables either.
+// Note that this only applies to new sessions.
+func (c *Config) SetSessionTimeouts(idleTimeout, maxLifetime time.Duration) error {
+	if idleTimeout < 0 || maxLifetime < 0 {
+		return ErrInvalidSessionTimeouts
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sessionIdleTimeout = idleTimeout
+	c.sessionMaxLifetime = maxLifetime
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
 // Get per-user session limit.
 func (c *Config) GetUserSessionLimit() int {
 	return c.perUserSessionLimit
@@ -472,6 +655,138 @@ func (c *Config) SetUserSessionLimit(limit int) {
 	c.SetDirty(true)
 }
 
+// Get the sessions file path.
+func (c *Config) GetSessionFile() string {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.sessionFile
+}
+
+// Set the sessions file path. If empty, sessions are not saved. Note that this
+// does not update the server until it is restarted.
+func (c *Config) SetSessionFile(path string) {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sessionFile = path
+
+	// Set the dirty value.
+	c.SetDirty(true)
+}
+
+// Get the audit log settings. Returns the audit file path and if hash
+// chaining is enabled.
+func (c *Config) GetAudit() (string, bool) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.auditFile, c.auditHashChain
+}
+
+// Set the audit log settings. If the path is empty, commands are not audited.
+// Note that this does not update the server until it is restarted.
+func (c *Config) SetAudit(path string, hashChain bool) {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.auditFile = path
+	c.auditHashChain = hashChain
+
+	// Set the dirty value.
+	c.SetDirty(true)
+}
+
+// Get the metrics listener address.
+func (c *Config) GetMetricsAddress() string {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metricsAddress
+}
+
+// Set the metrics listener address. If empty, metrics are not served. Note
+// that this does not update the server until it is restarted.
+func (c *Config) SetMetricsAddress(address string) {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.metricsAddress = address
+
+	// Set the dirty value.
+	c.SetDirty(true)
+}
+
+// Get the clearance level at or above which two-factor authentication is
+// required.
+func (c *Config) GetTOTPRequiredClearance() int {
+	return c.totpRequiredClearance
+}
+
+// Set the clearance level at or above which two-factor authentication is
+// required. Zero disables the requirement.
+func (c *Config) SetTOTPRequiredClearance(clearance int) error {
+	if clearance < 0 || clearance > 5 {
+		return ErrInvalidClearance
+	}
+
+	c.totpRequiredClearance = clearance
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Get the lockout settings. Returns the user threshold, IP threshold, lockout
+// duration and maximum lockout duration.
+func (c *Config) GetLockout() (int, int, time.Duration, time.Duration) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.userLockoutThreshold, c.ipLockoutThreshold, c.lockoutDuration, c.lockoutMaxDuration
+}
+
+// Get the lockout policies for users and IP addresses.
+func (c *Config) GetLockoutPolicies() (lockout.Policy, lockout.Policy) {
+	userThreshold, ipThreshold, duration, maxDuration := c.GetLockout()
+	return lockout.Policy{Threshold: userThreshold, Duration: duration, MaxDuration: maxDuration},
+		lockout.Policy{Threshold: ipThreshold, Duration: duration, MaxDuration: maxDuration}
+}
+
+// Set the lockout settings. Note that this does not update the server.
+func (c *Config) SetLockout(userThreshold, ipThreshold int, duration, maxDuration time.Duration) error {
+	if userThreshold < 0 || ipThreshold < 0 || duration < 0 || maxDuration < duration {
+		return ErrInvalidLockout
+	}
+	if (userThreshold != 0 || ipThreshold != 0) && duration == 0 {
+		return ErrInvalidLockout
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.userLockoutThreshold = userThreshold
+	c.ipLockoutThreshold = ipThreshold
+	c.lockoutDuration = duration
+	c.lockoutMaxDuration = maxDuration
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
 // Get the rate limit. These values are thread-safe and thus do not need
 // locks.
 func (c *Config) GetRateLimit() (time.Duration, int) {
@@ -504,8 +819,8 @@ func (c *Config) SetCertFilePairs(files []CertFilePair) {
 // Create the TLS config.
 func (c *Config) LoadCerts() error {
 	// Lock.
-	c.RLock()
-	defer c.RUnlock()
+	c.Lock()
+	defer c.Unlock()
 
 	// Load the certificates.
 	certs := make([]tls.Certificate, len(c.certFiles))
@@ -516,19 +831,52 @@ func (c *Config) LoadCerts() error {
 			return err
 		}
 	}
-	c.tlsConfig.Certificates = certs
+	c.certs = certs
+	c.tlsConfig.Certificates = nil
+	c.tlsConfig.GetCertificate = c.getCertificate
+
+	// Load the client certificate authority.
+	if err := c.loadClientCAs(); err != nil {
+		return err
+	}
 
 	// Return.
 	return nil
 }
 
+// Get the certificate for a TLS handshake. The first certificate supported
+// by the client is used, falling back to the first certificate.
+func (c *Config) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if len(c.certs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	for i := range c.certs {
+		if hello.SupportsCertificate(&c.certs[i]) == nil {
+			return &c.certs[i], nil
+		}
+	}
+	return &c.certs[0], nil
+}
+
 // Get TLS config.
 func (c *Config) GetTLSConfig() *tls.Config {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
 	return c.tlsConfig
 }
 
 // Set TLS config.
 func (c *Config) SetTLSConfig(config *tls.Config) {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.tlsConfig = config
 
 	// Set the dirty value.
@@ -0,0 +1,91 @@
+// server/config/ldap.go
+// LDAP authentication settings for Lily servers.
+
+package config
+
+import (
+	"errors"
+
+	"github.com/cubeflix/lily/security/ldapauth"
+)
+
+var ErrInvalidLDAPMode = errors.New("lily.server.config: Invalid LDAP authentication mode")
+
+// LDAP authentication mode.
+type LDAPMode int
+
+// LDAP authentication modes. In only mode, passwords are verified against the
+// directory instead of the local password hash. In fallback mode, the local
+// password hash is checked first, and the directory is used if it fails.
+const (
+	LDAPDisabled LDAPMode = iota
+	LDAPOnly
+	LDAPFallback
+)
+
+// Get the name of an LDAP authentication mode.
+func (m LDAPMode) String() string {
+	switch m {
+	case LDAPOnly:
+		return "only"
+	case LDAPFallback:
+		return "fallback"
+	default:
+		return "disabled"
+	}
+}
+
+// Parse an LDAP authentication mode name.
+func ParseLDAPMode(name string) (LDAPMode, error) {
+	switch name {
+	case "disabled":
+		return LDAPDisabled, nil
+	case "only":
+		return LDAPOnly, nil
+	case "fallback":
+		return LDAPFallback, nil
+	default:
+		return LDAPDisabled, ErrInvalidLDAPMode
+	}
+}
+
+// Get the LDAP authentication settings. Returns the mode, if users who only
+// exist in the directory should be created on login, and the directory
+// settings.
+func (c *Config) GetLDAP() (LDAPMode, bool, ldapauth.Settings) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.ldapMode, c.ldapAutoProvision, c.ldapSettings
+}
+
+// Set the LDAP authentication settings. The directory settings are only
+// validated if LDAP authentication is enabled.
+func (c *Config) SetLDAP(mode LDAPMode, autoProvision bool, settings ldapauth.Settings) error {
+	if mode < LDAPDisabled || mode > LDAPFallback {
+		return ErrInvalidLDAPMode
+	}
+	if mode != LDAPDisabled {
+		if err := settings.Validate(); err != nil {
+			return err
+		}
+	}
+	if settings.GroupClearances == nil {
+		settings.GroupClearances = map[string]int{}
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ldapMode = mode
+	c.ldapAutoProvision = autoProvision
+	c.ldapSettings = settings
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
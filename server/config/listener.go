@@ -0,0 +1,328 @@
+// server/config/listener.go
+// Listener, filtering and connection limit settings for Lily servers.
+
+package config
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cubeflix/lily/security/ipfilter"
+)
+
+var ErrInvalidConnectionLimits = errors.New("lily.server.config: Invalid connection limits")
+var ErrInvalidListener = errors.New("lily.server.config: Invalid listener")
+var ErrListenerDoesNotExist = errors.New("lily.server.config: Listener does not exist")
+
+// Listener networks.
+const (
+	ListenerTCP  = "tcp"
+	ListenerUnix = "unix"
+)
+
+// An additional listener, alongside the main listener on the host and port.
+// TCP listeners use TLS, while Unix socket listeners are not encrypted and
+// authenticate local users by their peer credentials.
+type Listener struct {
+	// The listener name, network and address. The address is a host and port
+	// for TCP listeners, or a socket path for Unix socket listeners.
+	Name    string
+	Network string
+	Address string
+
+	// The rate limit. If the maximum number of events is 0, the listener
+	// shares the server's rate limiter.
+	Limit          time.Duration
+	MaxLimitEvents int
+
+	// IP allow and deny lists for TCP listeners. The listener's allow list
+	// replaces the server's allow list if it is not empty, while addresses in
+	// either deny list are denied.
+	IPAllowList []string
+	IPDenyList  []string
+
+	// OS usernames or user IDs mapped to Lily usernames, for Unix socket
+	// listeners.
+	PeerUsers map[string]string
+
+	// The parsed allow and deny lists.
+	allow ipfilter.List
+	deny  ipfilter.List
+}
+
+// Check that the listener is valid, and parse its allow and deny lists.
+func (l *Listener) parse() error {
+	if l.Name == "" || l.Address == "" || l.MaxLimitEvents < 0 || (l.MaxLimitEvents > 0 && l.Limit <= 0) {
+		return ErrInvalidListener
+	}
+	switch l.Network {
+	case ListenerTCP:
+		if len(l.PeerUsers) != 0 {
+			return ErrInvalidListener
+		}
+	case ListenerUnix:
+		if len(l.IPAllowList) != 0 || len(l.IPDenyList) != 0 {
+			return ErrInvalidListener
+		}
+	default:
+		return ErrInvalidListener
+	}
+	var err error
+	if l.allow, err = ipfilter.ParseList(l.IPAllowList); err != nil {
+		return err
+	}
+	if l.deny, err = ipfilter.ParseList(l.IPDenyList); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Get the additional listeners.
+func (c *Config) GetListeners() []Listener {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	listeners := make([]Listener, len(c.listeners))
+	copy(listeners, c.listeners)
+	return listeners
+}
+
+// Get an additional listener by name.
+func (c *Config) GetListener(name string) (Listener, bool) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for i := range c.listeners {
+		if c.listeners[i].Name == name {
+			return c.listeners[i], true
+		}
+	}
+	return Listener{}, false
+}
+
+// Add a listener, or replace the listener with the same name. Note that new
+// listeners and changed addresses do not update the server until it is
+// restarted.
+func (c *Config) SetListener(l Listener) error {
+	if l.IPAllowList == nil {
+		l.IPAllowList = []string{}
+	}
+	if l.IPDenyList == nil {
+		l.IPDenyList = []string{}
+	}
+	if l.PeerUsers == nil {
+		l.PeerUsers = map[string]string{}
+	}
+	if err := l.parse(); err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	replaced := false
+	for i := range c.listeners {
+		if c.listeners[i].Name == l.Name {
+			c.listeners[i] = l
+			replaced = true
+		}
+	}
+	if !replaced {
+		c.listeners = append(c.listeners, l)
+	}
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Remove listeners by name. Note that this does not update the server until
+// it is restarted.
+func (c *Config) RemoveListeners(names []string) error {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check that the listeners exist.
+	remove := map[string]bool{}
+	for i := range names {
+		found := false
+		for j := range c.listeners {
+			found = found || c.listeners[j].Name == names[i]
+		}
+		if !found {
+			return ErrListenerDoesNotExist
+		}
+		remove[names[i]] = true
+	}
+	listeners := []Listener{}
+	for i := range c.listeners {
+		if !remove[c.listeners[i].Name] {
+			listeners = append(listeners, c.listeners[i])
+		}
+	}
+	c.listeners = listeners
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Check if an IP address is allowed to connect to an additional listener.
+// The server's IP filter applies if the listener does not exist.
+func (c *Config) ListenerIPAllowed(name string, ip net.IP) bool {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for i := range c.listeners {
+		if c.listeners[i].Name != name {
+			continue
+		}
+		filter := ipfilter.Filter{Allow: c.ipFilter.Allow, Deny: c.listeners[i].deny}
+		if len(c.listeners[i].allow) != 0 {
+			filter.Allow = c.listeners[i].allow
+		}
+		return !c.ipFilter.Deny.Contains(ip) && filter.Allowed(ip)
+	}
+	return c.ipFilter.Allowed(ip)
+}
+
+// Get the Lily username for a local OS user connected to a Unix socket
+// listener, by their OS username or user ID.
+func (c *Config) GetPeerUsername(name, osUsername, uid string) (string, bool) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for i := range c.listeners {
+		if c.listeners[i].Name != name {
+			continue
+		}
+		if username, ok := c.listeners[i].PeerUsers[osUsername]; ok && osUsername != "" {
+			return username, true
+		}
+		username, ok := c.listeners[i].PeerUsers[uid]
+		return username, ok
+	}
+	return "", false
+}
+
+// Get the IP allow and deny lists.
+func (c *Config) GetIPFilter() ([]string, []string) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.ipAllowList, c.ipDenyList
+}
+
+// Set the IP allow and deny lists, as CIDR ranges or IP addresses. If the
+// allow list is empty, all addresses which are not denied are allowed.
+func (c *Config) SetIPFilter(allow, deny []string) error {
+	filter, err := ipfilter.NewFilter(allow, deny)
+	if err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ipAllowList = allow
+	c.ipDenyList = deny
+	c.ipFilter = filter
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Check if an IP address is allowed to connect.
+func (c *Config) IPAllowed(ip net.IP) bool {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.ipFilter.Allowed(ip)
+}
+
+// Get the trusted proxies.
+func (c *Config) GetTrustedProxies() []string {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.trustedProxies
+}
+
+// Set the trusted proxies, as CIDR ranges or IP addresses. Connections from
+// trusted proxies must begin with a PROXY protocol header.
+func (c *Config) SetTrustedProxies(proxies []string) error {
+	list, err := ipfilter.ParseList(proxies)
+	if err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.trustedProxies = proxies
+	c.trustedProxyList = list
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Check if an IP address is a trusted proxy.
+func (c *Config) IsTrustedProxy(ip net.IP) bool {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.trustedProxyList.Contains(ip)
+}
+
+// Get the maximum number of concurrent connections in total and per IP.
+func (c *Config) GetConnectionLimits() (int, int) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.maxConnections, c.maxConnectionsPerIP
+}
+
+// Set the maximum number of concurrent connections in total and per IP. Zero
+// disables either limit.
+func (c *Config) SetConnectionLimits(maxConnections, maxConnectionsPerIP int) error {
+	if maxConnections < 0 || maxConnectionsPerIP < 0 {
+		return ErrInvalidConnectionLimits
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.maxConnections = maxConnections
+	c.maxConnectionsPerIP = maxConnectionsPerIP
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
@@ -0,0 +1,93 @@
+// server/config/password.go
+// Password policy and hash settings for Lily servers.
+
+package config
+
+import (
+	"github.com/cubeflix/lily/security/auth"
+)
+
+// Get the password policy.
+func (c *Config) GetPasswordPolicy() auth.PasswordPolicy {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.passwordPolicy
+}
+
+// Set the password policy. Note that this does not reload the deny-list.
+func (c *Config) SetPasswordPolicy(policy auth.PasswordPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.passwordPolicy = policy
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Load the password deny-list file from the password policy.
+func (c *Config) LoadPasswordDenyList() error {
+	path := c.GetPasswordPolicy().DenyListFile
+	var denyList *auth.DenyList
+	if path != "" {
+		var err error
+		denyList, err = auth.LoadDenyList(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.denyList = denyList
+	return nil
+}
+
+// Check a new password against the password policy and deny-list.
+func (c *Config) CheckPassword(password string) error {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.passwordPolicy.Check(password, c.denyList)
+}
+
+// Get the password hash parameters.
+func (c *Config) GetPasswordHashing() auth.HashParams {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.hashParams
+}
+
+// Set the password hash parameters. Note that this does not update the server.
+func (c *Config) SetPasswordHashing(params auth.HashParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.hashParams = params
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
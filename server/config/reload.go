@@ -0,0 +1,116 @@
+// server/config/reload.go
+// Reloading Lily server configs.
+
+package config
+
+import (
+	"errors"
+)
+
+var ErrRestartRequired = errors.New("lily.server.config: The host, port, drives, listeners and metrics address cannot be reloaded; restart the server")
+
+// Update the config with the settings from another config, such as a config
+// read again from the server file. The server file path is kept. The other
+// config's certificates must already be loaded, and its TLS config must not
+// be in use. The host, port, drives, listener addresses and metrics address
+// cannot be changed while the server is running, so they must match.
+func (c *Config) Update(other *Config) error {
+	// Acquire the read lock on the other config.
+	other.lock.RLock()
+	defer other.lock.RUnlock()
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check the settings which need a restart.
+	if c.host != other.host || c.port != other.port || c.metricsAddress != other.metricsAddress ||
+		len(c.driveFiles) != len(other.driveFiles) {
+		return ErrRestartRequired
+	}
+	for name := range c.driveFiles {
+		if path, ok := other.driveFiles[name]; !ok || path != c.driveFiles[name] {
+			return ErrRestartRequired
+		}
+	}
+	if len(c.listeners) != len(other.listeners) {
+		return ErrRestartRequired
+	}
+	for i := range c.listeners {
+		if c.listeners[i].Name != other.listeners[i].Name || c.listeners[i].Network != other.listeners[i].Network ||
+			c.listeners[i].Address != other.listeners[i].Address {
+			return ErrRestartRequired
+		}
+	}
+
+	// Update the settings.
+	c.name = other.name
+	c.numWorkers = other.numWorkers
+	c.backlog = other.backlog
+	c.mainCronInterval = other.mainCronInterval
+	c.sessionCronInterval = other.sessionCronInterval
+	c.netTimeout = other.netTimeout
+	c.shutdownDeadline = other.shutdownDeadline
+	c.verbose = other.verbose
+	c.logToFile = other.logToFile
+	c.logJSON = other.logJSON
+	c.logLevel = other.logLevel
+	c.logPath = other.logPath
+	c.defaultSessionExpiration = other.defaultSessionExpiration
+	c.allowChangeSessionExpiration = other.allowChangeSessionExpiration
+	c.allowNonExpiringSessions = other.allowNonExpiringSessions
+	c.sessionIdleTimeout = other.sessionIdleTimeout
+	c.sessionMaxLifetime = other.sessionMaxLifetime
+	c.sessionBindingMode = other.sessionBindingMode
+	c.sessionBindingIPv4Prefix = other.sessionBindingIPv4Prefix
+	c.sessionBindingIPv6Prefix = other.sessionBindingIPv6Prefix
+	c.perUserSessionLimit = other.perUserSessionLimit
+	c.sessionFile = other.sessionFile
+	c.auditFile = other.auditFile
+	c.auditHashChain = other.auditHashChain
+	c.totpRequiredClearance = other.totpRequiredClearance
+	c.userLockoutThreshold = other.userLockoutThreshold
+	c.ipLockoutThreshold = other.ipLockoutThreshold
+	c.lockoutDuration = other.lockoutDuration
+	c.lockoutMaxDuration = other.lockoutMaxDuration
+	c.limit = other.limit
+	c.maxLimitEvents = other.maxLimitEvents
+	c.userLimits = other.userLimits
+	c.userLimitOverrides = other.userLimitOverrides
+	c.uploadRate = other.uploadRate
+	c.downloadRate = other.downloadRate
+	c.ipAllowList = other.ipAllowList
+	c.ipDenyList = other.ipDenyList
+	c.ipFilter = other.ipFilter
+	c.trustedProxies = other.trustedProxies
+	c.trustedProxyList = other.trustedProxyList
+	c.maxConnections = other.maxConnections
+	c.maxConnectionsPerIP = other.maxConnectionsPerIP
+	c.listeners = other.listeners
+	c.maxChunkSize = other.maxChunkSize
+	c.memoryBudget = other.memoryBudget
+	c.maxUploadSize = other.maxUploadSize
+	c.certFiles = other.certFiles
+	c.certs = other.certs
+	c.clientCAFile = other.clientCAFile
+	c.clientAuthMode = other.clientAuthMode
+	c.certUsers = other.certUsers
+	c.ldapMode = other.ldapMode
+	c.ldapAutoProvision = other.ldapAutoProvision
+	c.ldapSettings = other.ldapSettings
+	c.passwordPolicy = other.passwordPolicy
+	c.denyList = other.denyList
+	c.hashParams = other.hashParams
+
+	// Use the new TLS config, serving certificates from this config. New
+	// connections use the new TLS config, while handshakes in progress keep
+	// the old one.
+	c.tlsConfig = other.tlsConfig
+	c.tlsConfig.GetCertificate = c.getCertificate
+
+	// The config now matches the server file.
+	c.SetDirty(false)
+
+	// Return.
+	return nil
+}
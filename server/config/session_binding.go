@@ -0,0 +1,139 @@
+// server/config/session_binding.go
+// Session binding settings for Lily servers.
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+)
+
+var ErrInvalidSessionBindingMode = errors.New("lily.server.config: Invalid session binding mode")
+var ErrInvalidSessionBindingPrefix = errors.New("lily.server.config: Invalid session binding prefix length")
+
+// Default subnet prefix lengths for session binding.
+const (
+	DefaultSessionBindingIPv4Prefix = 24
+	DefaultSessionBindingIPv6Prefix = 64
+)
+
+// Session binding mode.
+type SessionBindingMode int
+
+// Session binding modes. In IP mode, sessions may only be used from the IP
+// address they were created from. In subnet mode, sessions may be used from
+// any address in the same subnet. In certificate mode, sessions may only be
+// used with the client certificate they were created with.
+//
+// Each request is made over a new TLS connection, so sessions cannot be bound
+// to a single TLS channel. Certificate mode is the closest equivalent.
+const (
+	SessionBindingNone SessionBindingMode = iota
+	SessionBindingIP
+	SessionBindingSubnet
+	SessionBindingCert
+)
+
+// Get the name of a session binding mode.
+func (m SessionBindingMode) String() string {
+	switch m {
+	case SessionBindingIP:
+		return "ip"
+	case SessionBindingSubnet:
+		return "subnet"
+	case SessionBindingCert:
+		return "cert"
+	default:
+		return "none"
+	}
+}
+
+// Parse a session binding mode name.
+func ParseSessionBindingMode(name string) (SessionBindingMode, error) {
+	switch name {
+	case "none":
+		return SessionBindingNone, nil
+	case "ip":
+		return SessionBindingIP, nil
+	case "subnet":
+		return SessionBindingSubnet, nil
+	case "cert":
+		return SessionBindingCert, nil
+	default:
+		return SessionBindingNone, ErrInvalidSessionBindingMode
+	}
+}
+
+// Get the SHA-256 fingerprint of a client certificate, for binding sessions.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get the session binding settings. Returns the mode and the IPv4 and IPv6
+// subnet prefix lengths.
+func (c *Config) GetSessionBinding() (SessionBindingMode, int, int) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.sessionBindingMode, c.sessionBindingIPv4Prefix, c.sessionBindingIPv6Prefix
+}
+
+// Set the session binding settings. Note that sessions created before binding
+// was enabled are bound to the address they were created from.
+func (c *Config) SetSessionBinding(mode SessionBindingMode, ipv4Prefix, ipv6Prefix int) error {
+	if mode < SessionBindingNone || mode > SessionBindingCert {
+		return ErrInvalidSessionBindingMode
+	}
+	if ipv4Prefix < 0 || ipv4Prefix > 32 || ipv6Prefix < 0 || ipv6Prefix > 128 {
+		return ErrInvalidSessionBindingPrefix
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sessionBindingMode = mode
+	c.sessionBindingIPv4Prefix = ipv4Prefix
+	c.sessionBindingIPv6Prefix = ipv6Prefix
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Check if a session bound to an IP address and client certificate
+// fingerprint may be used from another IP address and certificate. Sessions
+// without a bound value never match.
+func (c *Config) CheckSessionBinding(boundIP, ip, boundCert, cert string) bool {
+	mode, ipv4Prefix, ipv6Prefix := c.GetSessionBinding()
+	switch mode {
+	case SessionBindingIP:
+		bound, addr := net.ParseIP(boundIP), net.ParseIP(ip)
+		return bound != nil && addr != nil && bound.Equal(addr)
+	case SessionBindingSubnet:
+		bound, addr := net.ParseIP(boundIP), net.ParseIP(ip)
+		if bound == nil || addr == nil {
+			return false
+		}
+		if bound.To4() != nil && addr.To4() != nil {
+			mask := net.CIDRMask(ipv4Prefix, 32)
+			return bound.To4().Mask(mask).Equal(addr.To4().Mask(mask))
+		}
+		if bound.To4() == nil && addr.To4() == nil {
+			mask := net.CIDRMask(ipv6Prefix, 128)
+			return bound.Mask(mask).Equal(addr.Mask(mask))
+		}
+		return false
+	case SessionBindingCert:
+		return boundCert != "" && boundCert == cert
+	default:
+		return true
+	}
+}
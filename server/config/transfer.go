@@ -0,0 +1,58 @@
+// server/config/transfer.go
+// Chunk size, memory and upload size limits for transfers on Lily servers.
+
+package config
+
+import (
+	"errors"
+
+	"github.com/cubeflix/lily/network"
+)
+
+var ErrInvalidTransferLimits = errors.New("lily.server.config: Invalid transfer limits")
+
+// Get the largest chunk the server receives, the memory budget for transfers
+// and the largest upload in bytes.
+func (c *Config) GetTransferLimits() (int, int64, int64) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.maxChunkSize, c.memoryBudget, c.maxUploadSize
+}
+
+// Get the largest chunk the server receives, using the default size if it is
+// not set.
+func (c *Config) GetMaxChunkSize() int {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.maxChunkSize == 0 {
+		return network.DefaultMaxChunkSize
+	}
+	return c.maxChunkSize
+}
+
+// Set the largest chunk the server receives, the memory budget for transfers
+// and the largest upload in bytes. A max chunk size of zero uses the default
+// size, and a memory budget or max upload size of zero disables it.
+func (c *Config) SetTransferLimits(maxChunkSize int, memoryBudget, maxUploadSize int64) error {
+	if maxChunkSize < 0 || memoryBudget < 0 || maxUploadSize < 0 {
+		return ErrInvalidTransferLimits
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.maxChunkSize = maxChunkSize
+	c.memoryBudget = memoryBudget
+	c.maxUploadSize = maxUploadSize
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
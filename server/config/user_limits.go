@@ -0,0 +1,126 @@
+// server/config/user_limits.go
+// Per-user limit and bandwidth settings for Lily servers.
+
+package config
+
+import (
+	"errors"
+
+	"github.com/cubeflix/lily/security/userlimit"
+)
+
+var ErrInvalidBandwidthLimits = errors.New("lily.server.config: Invalid bandwidth limits")
+var ErrUserLimitOverrideDoesNotExist = errors.New("lily.server.config: User limit override does not exist")
+
+// Get the default per-user limits.
+func (c *Config) GetUserLimits() userlimit.Limits {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.userLimits
+}
+
+// Set the default per-user limits.
+func (c *Config) SetUserLimits(limits userlimit.Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.userLimits = limits
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Get a copy of the per-user limit overrides.
+func (c *Config) GetUserLimitOverrides() map[string]userlimit.Limits {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	overrides := make(map[string]userlimit.Limits, len(c.userLimitOverrides))
+	for username := range c.userLimitOverrides {
+		overrides[username] = c.userLimitOverrides[username]
+	}
+	return overrides
+}
+
+// Set the limits for a user, overriding the default limits.
+func (c *Config) SetUserLimitOverride(username string, limits userlimit.Limits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.userLimitOverrides[username] = limits
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Remove per-user limit overrides, so the users use the default limits.
+func (c *Config) RemoveUserLimitOverrides(usernames []string) error {
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Check that the overrides exist.
+	for i := range usernames {
+		if _, ok := c.userLimitOverrides[usernames[i]]; !ok {
+			return ErrUserLimitOverrideDoesNotExist
+		}
+	}
+	for i := range usernames {
+		delete(c.userLimitOverrides, usernames[i])
+	}
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
+
+// Get the server-wide upload and download rates, in bytes per second.
+func (c *Config) GetBandwidthLimits() (int64, int64) {
+	// Acquire the read lock.
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.uploadRate, c.downloadRate
+}
+
+// Set the server-wide upload and download rates, in bytes per second. Zero
+// disables either limit.
+func (c *Config) SetBandwidthLimits(uploadRate, downloadRate int64) error {
+	if uploadRate < 0 || downloadRate < 0 {
+		return ErrInvalidBandwidthLimits
+	}
+
+	// Acquire the write lock.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.uploadRate = uploadRate
+	c.downloadRate = downloadRate
+
+	// Set the dirty value.
+	c.SetDirty(true)
+
+	// Return.
+	return nil
+}
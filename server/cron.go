@@ -5,7 +5,9 @@ package server
 
 import (
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/cubeflix/lily/marshal"
@@ -47,6 +49,13 @@ func (s *Server) SessionCronWorker() {
 					"error": err.Error(),
 				}).Error("error with expiring sessions")
 			}
+
+			// Forget old failed logins.
+			s.userLockout.Prune(time.Now())
+			s.ipLockout.Prune(time.Now())
+
+			// Forget idle users' request limits.
+			s.userLimiter.Prune(time.Now())
 		}
 	}
 }
@@ -64,6 +73,7 @@ func (s *Server) CronWorker() {
 		case <-time.After(interval):
 			// Don't stop, interval completed.
 			s.DriveHealth()
+			s.PruneUploads()
 			err := s.CronSave()
 			if err != nil {
 				// Error, log it.
@@ -75,8 +85,39 @@ func (s *Server) CronWorker() {
 	}
 }
 
+// Abort expired uploads on each drive.
+func (s *Server) PruneUploads() {
+	s.LockReadDrives()
+	defer s.UnlockReadDrives()
+
+	now := time.Now()
+	for _, d := range s.GetDrives() {
+		d.PruneUploads(now)
+	}
+}
+
+// Remove the copies of files left behind by uploads and patches on each drive.
+func (s *Server) RemoveTempFiles() {
+	s.LockReadDrives()
+	defer s.UnlockReadDrives()
+
+	for name, d := range s.GetDrives() {
+		if err := d.RemoveTempFiles(); err != nil {
+			log.WithFields(log.Fields{
+				"drive": name,
+				"error": err.Error(),
+			}).Error("failed to remove temporary files")
+		}
+	}
+}
+
 // Cron save.
 func (s *Server) CronSave() error {
+	start := time.Now()
+	defer func() {
+		s.metrics.CronSaveDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	// Loop over the server drives.
 	driveFiles := s.config.GetDriveFiles()
 	for drive := range driveFiles {
@@ -127,5 +168,47 @@ func (s *Server) CronSave() error {
 		log.Info("successfully saved server")
 	}
 
-	return nil
+	// Save the sessions. The session list is not tracked as dirty, so it is
+	// saved every time.
+	return s.SaveSessions()
+}
+
+// Save the sessions to the sessions file, if there is one. A failed save
+// leaves the previous sessions file intact.
+func (s *Server) SaveSessions() error {
+	path := s.config.GetSessionFile()
+	if path == "" {
+		return nil
+	}
+	return writeFileAtomic(path, 0600, func(w io.Writer) error {
+		return marshal.MarshalSessionList(s.sessions, w)
+	})
+}
+
+// Write a file by writing and syncing a temporary file in the same directory,
+// then renaming it over the file.
+func writeFileAtomic(path string, perm os.FileMode, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// Write and sync the temporary file.
+	if err := write(file); err != nil {
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Replace the file.
+	return os.Rename(file.Name(), path)
 }
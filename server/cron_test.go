@@ -0,0 +1,101 @@
+// server/cron_test.go
+// Testing for server/cron.go.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/marshal"
+	"github.com/cubeflix/lily/server/config"
+	"github.com/cubeflix/lily/session"
+	slist "github.com/cubeflix/lily/session/list"
+	ulist "github.com/cubeflix/lily/user/list"
+	"github.com/google/uuid"
+)
+
+// Test that a failed write leaves the previous file intact.
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sessions")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Fail partway through the write.
+	failed := errors.New("failed")
+	err := writeFileAtomic(path, 0600, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failed
+	})
+	if err != failed {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "old" {
+		t.Fatal(string(data), err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatal(entries, err)
+	}
+
+	// Replace the file.
+	err = writeFileAtomic(path, 0600, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, err = os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Fatal(string(data), err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal(info, err)
+	}
+	entries, err = os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatal(entries, err)
+	}
+}
+
+// Test saving the sessions file.
+func TestSaveSessions(t *testing.T) {
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1, time.Minute, time.Minute, time.Second,
+		false, false, false, config.LoggingLevelInfo, "", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	path := filepath.Join(t.TempDir(), "sessions")
+	cobj.SetSessionFile(path)
+	sessions := slist.NewSessionList(100, 100)
+	id, err := sessions.GenerateSessionID()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sessions.SetSessionsByID(map[uuid.UUID]*session.Session{id: session.NewSession(id, "foo", time.Hour)})
+	s := NewServer(sessions, ulist.NewUserList(), cobj)
+	if err := s.SaveSessions(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Load the sessions.
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer file.Close()
+	loaded, err := marshal.UnmarshalSessions(file)
+	if err != nil || len(loaded) != 1 {
+		t.Fatal(loaded, err)
+	}
+}
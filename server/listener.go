@@ -0,0 +1,356 @@
+// server/listener.go
+// Listening for, filtering and limiting connections for Lily servers.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	osuser "os/user"
+	"strconv"
+	"sync"
+
+	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/server/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// A listener. The main listener on the host and port has no name, and uses
+// the server's settings.
+type serverListener struct {
+	net.Listener
+	name    string
+	network string
+}
+
+// An accepted connection, and if it has reached the rate limit.
+type acceptedConn struct {
+	conn    net.Conn
+	limited bool
+}
+
+// A connection from a Unix socket listener, with the local user's ID and the
+// username mapped from it, if any.
+type localConn struct {
+	net.Conn
+	uid      int
+	username string
+}
+
+// Get the connection from the Unix socket.
+func (c *localConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Create the main listener and the additional listeners.
+func (s *Server) listen() error {
+	host, port := s.config.GetHostAndPort()
+	listener, err := net.Listen(config.ListenerTCP, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	s.listeners = []*serverListener{{Listener: listener, network: config.ListenerTCP}}
+	for _, l := range s.config.GetListeners() {
+		// Remove a socket file left behind by a previous server. Sockets which
+		// are still in use are left alone.
+		if l.Network == config.ListenerUnix {
+			removeStaleSocket(l.Address)
+		}
+		listener, err := net.Listen(l.Network, l.Address)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, &serverListener{Listener: listener, name: l.Name, network: l.Network})
+	}
+
+	// Return.
+	return nil
+}
+
+// Remove a Unix socket file if nothing is listening on it.
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial(config.ListenerUnix, path); err == nil {
+		conn.Close()
+		return
+	}
+	os.Remove(path)
+}
+
+// Close the listeners.
+func (s *Server) closeListeners() {
+	for i := range s.listeners {
+		s.listeners[i].Close()
+	}
+}
+
+// Accept connections from a listener until the server stops.
+func (s *Server) acceptLoop(l *serverListener) {
+	defer s.acceptGroup.Done()
+	log.WithFields(log.Fields{
+		"listener": l.name,
+		"network":  l.network,
+		"address":  l.Addr().String(),
+	}).Info("server is listening")
+	for s.Running() {
+		conn, err := l.Accept()
+		if err != nil {
+			if !s.Running() {
+				// If we are not running (i.e. shutting down), then ignore this
+				// and exit.
+				return
+			} else {
+				// Actual error, log and ignore.
+				log.WithFields(log.Fields{
+					"listener": l.name,
+					"error":    err.Error(),
+				}).Error("error with accepting connection")
+				continue
+			}
+		}
+
+		// Admit the connection on its own routine, so a slow client cannot
+		// block the listener.
+		s.acceptGroup.Add(1)
+		go s.admit(l, conn)
+	}
+}
+
+// Admit an accepted connection and send it to be queued.
+func (s *Server) admit(l *serverListener, conn net.Conn) {
+	defer s.acceptGroup.Done()
+
+	// Check the IP filter and connection limits, or the peer credentials.
+	var key string
+	var ok bool
+	if l.network == config.ListenerUnix {
+		conn, key, ok = s.admitLocalConnection(l, conn)
+	} else {
+		var addr *net.TCPAddr
+		conn, addr, ok = s.admitConnection(l, conn)
+		if ok {
+			key = addr.IP.String()
+		}
+	}
+	if !ok {
+		return
+	}
+
+	// Check the rate limit.
+	_, _, _, valid, err := s.rateLimiter(l.name).Take(context.Background(), key)
+	if err != nil {
+		// Weird error, log and ignore.
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("error with rate limiter")
+		conn.Close()
+		return
+	}
+	s.accepted <- acceptedConn{conn: conn, limited: !valid}
+}
+
+// Queue the accepted connections until the listeners stop.
+func (s *Server) dispatch() {
+	defer close(s.dispatched)
+	for a := range s.accepted {
+		jobs, limitReached := s.resizeQueues()
+		if a.limited {
+			// Rate limit reached.
+			s.metrics.RateLimitRejections.Inc()
+			limitReached <- a.conn
+			continue
+		}
+
+		// Handle the connection.
+		jobs <- a.conn
+	}
+}
+
+// Get a data stream for a queued connection, and the connection to close once
+// it is done. Connections from Unix socket listeners are not encrypted.
+func (s *Server) connStream(conn net.Conn) (network.DataStream, io.Closer) {
+	if _, ok := conn.(*localConn); ok {
+		return network.NewConnStream(conn), conn
+	}
+	tlsConn := tls.Server(conn, s.config.GetTLSConfig())
+	return network.NewTLSStream(tlsConn), tlsConn
+}
+
+// Get the log fields describing a connection.
+func connFields(conn net.Conn) log.Fields {
+	if local, ok := conn.(*localConn); ok {
+		return log.Fields{"uid": local.uid}
+	}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return log.Fields{"ip": addr.IP, "port": addr.Port}
+	}
+	return log.Fields{}
+}
+
+// A connection counted against the connection limits. The connection is
+// released when it is closed.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close the connection and release it.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// Get the tracked connection.
+func (c *trackedConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Get the number of open connections.
+func (s *Server) OpenConnections() int {
+	// Acquire the lock.
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	return s.numConns
+}
+
+// Count a connection from a client against the connection limits. The key is
+// the client's IP address, or the local user for Unix socket connections.
+// Returns false if a limit has been reached.
+func (s *Server) trackConnection(conn net.Conn, key string) (net.Conn, bool) {
+	maxConnections, maxConnectionsPerIP := s.config.GetConnectionLimits()
+
+	// Acquire the lock.
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	if (maxConnections > 0 && s.numConns >= maxConnections) ||
+		(maxConnectionsPerIP > 0 && s.ipConns[key] >= maxConnectionsPerIP) {
+		return nil, false
+	}
+	s.numConns++
+	s.ipConns[key]++
+	return &trackedConn{Conn: conn, release: func() {
+		// Acquire the lock.
+		s.connLock.Lock()
+		defer s.connLock.Unlock()
+
+		s.numConns--
+		s.ipConns[key]--
+		if s.ipConns[key] == 0 {
+			delete(s.ipConns, key)
+		}
+	}}, true
+}
+
+// Admit a connection accepted by a TCP listener. Connections from trusted
+// proxies must begin with a PROXY protocol header, whose client address is
+// used for the IP filter, connection limits and rate limiter. The header is
+// read within the network timeout. Returns the connection and the client
+// address, or false if the connection was rejected and closed.
+func (s *Server) admitConnection(l *serverListener, conn net.Conn) (net.Conn, *net.TCPAddr, bool) {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		// Weird error, ignore.
+		conn.Close()
+		return nil, nil, false
+	}
+
+	// Read the PROXY protocol header from trusted proxies.
+	if s.config.IsTrustedProxy(addr.IP) {
+		proxied, err := network.ReadProxyHeader(conn, s.config.GetTimeout())
+		if err != nil {
+			log.WithFields(log.Fields{
+				"ip":    addr.IP,
+				"error": err.Error(),
+			}).Warn("invalid PROXY protocol header")
+			s.metrics.ConnectionRejections.Inc("proxy")
+			conn.Close()
+			return nil, nil, false
+		}
+		conn = proxied
+		if clientAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			addr = clientAddr
+		}
+	}
+
+	// Check the IP filter.
+	if !s.config.ListenerIPAllowed(l.name, addr.IP) {
+		log.WithFields(log.Fields{
+			"ip": addr.IP,
+		}).Info("denied connection")
+		s.metrics.ConnectionRejections.Inc("denied")
+		conn.Close()
+		return nil, nil, false
+	}
+
+	// Check the connection limits.
+	tracked, ok := s.trackConnection(conn, addr.IP.String())
+	if !ok {
+		log.WithFields(log.Fields{
+			"ip": addr.IP,
+		}).Info("connection limit reached")
+		s.metrics.ConnectionRejections.Inc("limit")
+		conn.Close()
+		return nil, nil, false
+	}
+
+	// Return.
+	return tracked, addr, true
+}
+
+// Admit a connection accepted by a Unix socket listener. The local user is
+// identified by their peer credentials, and mapped to a username if the
+// listener maps their OS username or user ID, and counted against the
+// connection limits. Returns the connection and the rate limit key, or false if
+// the connection was rejected and closed.
+func (s *Server) admitLocalConnection(l *serverListener, conn net.Conn) (net.Conn, string, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		// Weird error, ignore.
+		conn.Close()
+		return nil, "", false
+	}
+	uid, err := network.PeerUID(unixConn)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"listener": l.name,
+			"error":    err.Error(),
+		}).Warn("failed to get peer credentials")
+		s.metrics.ConnectionRejections.Inc("peer")
+		conn.Close()
+		return nil, "", false
+	}
+
+	// Map the local user to a username.
+	osUsername := ""
+	if u, err := osuser.LookupId(strconv.Itoa(uid)); err == nil {
+		osUsername = u.Username
+	}
+	username, _ := s.config.GetPeerUsername(l.name, osUsername, strconv.Itoa(uid))
+
+	// Check the connection limits. Each local user is limited like an IP
+	// address.
+	key := "uid:" + strconv.Itoa(uid)
+	tracked, ok := s.trackConnection(conn, key)
+	if !ok {
+		log.WithFields(log.Fields{
+			"uid": uid,
+		}).Info("connection limit reached")
+		s.metrics.ConnectionRejections.Inc("limit")
+		conn.Close()
+		return nil, "", false
+	}
+
+	// Return.
+	return &localConn{Conn: tracked, uid: uid, username: username}, key, true
+}
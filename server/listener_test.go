@@ -0,0 +1,238 @@
+// server/listener_test.go
+// Testing for server/listener.go.
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/server/config"
+)
+
+// Accept a connection which sends the given data.
+func acceptTestConnection(t *testing.T, listener net.Listener, data string) (net.Conn, net.Conn) {
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := client.Write([]byte(data)); err != nil {
+		t.Fatal(err.Error())
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return conn, client
+}
+
+// Test filtering and limiting connections from a trusted proxy.
+func TestAdmitConnection(t *testing.T) {
+	s := newShutdownTestServer(t, time.Minute)
+	l := s.listeners[0]
+	defer l.Close()
+	if err := s.config.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.config.SetIPFilter([]string{}, []string{"192.0.2.2"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.config.SetConnectionLimits(0, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The client address from the PROXY header is used.
+	conn, client := acceptTestConnection(t, l, "PROXY TCP4 192.0.2.1 127.0.0.1 1234 42069\r\n")
+	defer client.Close()
+	tracked, addr, ok := s.admitConnection(l, conn)
+	if !ok {
+		t.Fatal("connection rejected")
+	}
+	if addr.String() != "192.0.2.1:1234" || tracked.RemoteAddr().String() != "192.0.2.1:1234" {
+		t.Error(addr.String())
+	}
+
+	// A second connection from the same client reaches the per-IP limit,
+	// until the first is closed.
+	conn, client = acceptTestConnection(t, l, "PROXY TCP4 192.0.2.1 127.0.0.1 1235 42069\r\n")
+	defer client.Close()
+	if _, _, ok := s.admitConnection(l, conn); ok {
+		t.Error("connection limit not enforced")
+	}
+	tracked.Close()
+	tracked.Close()
+	if s.OpenConnections() != 0 {
+		t.Error("connection not released")
+	}
+
+	// Denied clients and invalid headers are rejected.
+	conn, client = acceptTestConnection(t, l, "PROXY TCP4 192.0.2.2 127.0.0.1 1234 42069\r\n")
+	defer client.Close()
+	if _, _, ok := s.admitConnection(l, conn); ok {
+		t.Error("denied connection admitted")
+	}
+	conn, client = acceptTestConnection(t, l, "LILY0000000000000000")
+	defer client.Close()
+	if _, _, ok := s.admitConnection(l, conn); ok {
+		t.Error("connection without a PROXY header admitted")
+	}
+	if s.metrics.ConnectionRejections.Get("limit") != 1 || s.metrics.ConnectionRejections.Get("denied") != 1 ||
+		s.metrics.ConnectionRejections.Get("proxy") != 1 {
+		t.Fail()
+	}
+}
+
+// Test admitting a connection from a Unix socket with peer credentials.
+func TestAdmitLocalConnection(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+	s := newShutdownTestServer(t, time.Minute)
+	defer s.listeners[0].Close()
+	path := filepath.Join(t.TempDir(), "lily.sock")
+	if err := s.config.SetListener(config.Listener{Name: "local", Network: config.ListenerUnix, Address: path,
+		PeerUsers: map[string]string{strconv.Itoa(os.Getuid()): "foo"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	listener, err := net.Listen(config.ListenerUnix, path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+	l := &serverListener{Listener: listener, name: "local", network: config.ListenerUnix}
+
+	// The local user should be mapped by their user ID.
+	client, err := net.Dial(config.ListenerUnix, path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	admitted, key, ok := s.admitLocalConnection(l, conn)
+	if !ok {
+		t.Fatal("connection rejected")
+	}
+	local, ok := admitted.(*localConn)
+	if !ok || local.uid != os.Getuid() || local.username != "foo" || key != "uid:"+strconv.Itoa(os.Getuid()) {
+		t.Fail()
+	}
+
+	// A second connection from the same user reaches the per-client limit,
+	// until the first is closed.
+	if err := s.config.SetConnectionLimits(0, 1); err != nil {
+		t.Fatal(err.Error())
+	}
+	client, err = net.Dial(config.ListenerUnix, path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	conn, err = listener.Accept()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, _, ok := s.admitLocalConnection(l, conn); ok {
+		t.Error("connection limit not enforced")
+	}
+	admitted.Close()
+	if s.OpenConnections() != 0 || s.metrics.ConnectionRejections.Get("limit") != 1 {
+		t.Error("connection not released")
+	}
+}
+
+// Test removing socket files left behind by a previous server.
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// A socket which is still listening is kept.
+	path := filepath.Join(dir, "live.sock")
+	listener, err := net.Listen(config.ListenerUnix, path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer listener.Close()
+	removeStaleSocket(path)
+	if _, err := os.Lstat(path); err != nil {
+		t.Error("live socket removed")
+	}
+
+	// A socket with nothing listening is removed.
+	path = filepath.Join(dir, "stale.sock")
+	stale, err := net.Listen(config.ListenerUnix, path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	removeStaleSocket(path)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Error("stale socket not removed")
+	}
+
+	// Other files are kept.
+	path = filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("lily"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	removeStaleSocket(path)
+	if _, err := os.Lstat(path); err != nil {
+		t.Error("file removed")
+	}
+}
+
+// Test that a trusted proxy which stalls before sending its PROXY header does
+// not block other connections.
+func TestAcceptStalledProxy(t *testing.T) {
+	s := newShutdownTestServer(t, time.Minute)
+	l := s.listeners[0]
+	if err := s.config.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.config.SetTimeout(time.Minute); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := s.resetRateLimiter(); err != nil {
+		t.Fatal(err.Error())
+	}
+	s.setRunning()
+	s.accepted = make(chan acceptedConn, 1)
+	s.acceptGroup.Add(1)
+	go s.acceptLoop(l)
+
+	// Connect without sending a header, then connect with one.
+	stalled, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 1234 42069\r\n")); err != nil {
+		t.Fatal(err.Error())
+	}
+	select {
+	case a := <-s.accepted:
+		if a.conn.RemoteAddr().String() != "192.0.2.1:1234" {
+			t.Error(a.conn.RemoteAddr().String())
+		}
+		a.conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("connection blocked by a stalled proxy")
+	}
+
+	// Stop the listener.
+	s.stopRunning()
+	l.Close()
+	stalled.Close()
+	s.acceptGroup.Wait()
+}
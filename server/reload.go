@@ -0,0 +1,253 @@
+// server/reload.go
+// Reloading Lily servers.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/cubeflix/lily/marshal"
+	golimit "github.com/sethvargo/go-limiter"
+	"github.com/sethvargo/go-limiter/memorystore"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrServerNotRunning = errors.New("lily.server: Server is not running")
+
+// Reload the server. Unsaved changes are saved first, then the server file
+// and certificate files are read again. The new settings are applied to the
+// worker pool, connection queues, rate limiter, logging and audit log without
+// dropping active connections. If the server file cannot be loaded, the
+// server keeps its current settings.
+func (s *Server) Reload() error {
+	// Acquire the reload lock.
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("failed to reload server")
+		return err
+	}
+	log.Info("reloaded server")
+	return nil
+}
+
+// Reload the server. NOTE: This does not acquire the reload lock.
+func (s *Server) reload() error {
+	if s.State() != StateRunning {
+		return ErrServerNotRunning
+	}
+
+	// Save unsaved changes, so they are not lost.
+	if err := s.CronSave(); err != nil {
+		return err
+	}
+
+	// Read the server file. The user list is not reloaded.
+	file, err := os.OpenFile(s.config.GetServerFile(), os.O_RDONLY, 0644)
+	if err != nil {
+		return ErrServerFileInvalid
+	}
+	newConfig, err := marshal.UnmarshalConfig(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	// Load the certificates and password deny-list.
+	if err := newConfig.LoadCerts(); err != nil {
+		return err
+	}
+	if err := newConfig.LoadPasswordDenyList(); err != nil {
+		return err
+	}
+
+	// Update the config.
+	oldAuditFile, oldAuditHashChain := s.config.GetAudit()
+	if err := s.config.Update(newConfig); err != nil {
+		return err
+	}
+
+	// Apply the new settings.
+	if err := s.InitLogging(); err != nil {
+		return err
+	}
+	if auditFile, auditHashChain := s.config.GetAudit(); auditFile != oldAuditFile || auditHashChain != oldAuditHashChain {
+		if err := s.InitAudit(); err != nil {
+			return err
+		}
+	}
+	if err := s.resetRateLimiter(); err != nil {
+		return err
+	}
+	s.resizeWorkers(s.config.GetNumWorkers())
+	userPolicy, ipPolicy := s.config.GetLockoutPolicies()
+	s.userLockout.SetPolicy(userPolicy)
+	s.ipLockout.SetPolicy(ipPolicy)
+	s.userLimiter.SetLimits(s.config.GetUserLimits(), s.config.GetUserLimitOverrides())
+	uploadRate, downloadRate := s.config.GetBandwidthLimits()
+	s.uploadThrottle.SetRate(uploadRate)
+	s.downloadThrottle.SetRate(downloadRate)
+	_, memoryBudget, _ := s.config.GetTransferLimits()
+	s.memoryBudget.SetLimit(memoryBudget)
+	s.SetShutdownDeadline(s.config.GetShutdownDeadline())
+
+	// Return.
+	return nil
+}
+
+// Get the connection queues.
+func (s *Server) queues() (chan net.Conn, chan net.Conn) {
+	// Acquire the read lock.
+	s.runtimeLock.RLock()
+	defer s.runtimeLock.RUnlock()
+
+	return s.jobs, s.limitReached
+}
+
+// Get the job queue.
+func (s *Server) jobQueue() chan net.Conn {
+	jobs, _ := s.queues()
+	return jobs
+}
+
+// Get the rate limit queue.
+func (s *Server) limitQueue() chan net.Conn {
+	_, limitReached := s.queues()
+	return limitReached
+}
+
+// Replace the connection queues if the backlog has changed, and return them.
+// The old queues are closed, so workers waiting on them move to the new
+// queues, and their queued connections are moved to the new queues. NOTE:
+// This must only be called by the dispatch routine, which is the only routine
+// to send on the queues. The queues are swapped under the runtime lock, so
+// workers see either the old or the new queues.
+func (s *Server) resizeQueues() (chan net.Conn, chan net.Conn) {
+	backlog := s.config.GetBacklog()
+
+	// Acquire the lock.
+	s.runtimeLock.Lock()
+	defer s.runtimeLock.Unlock()
+
+	if cap(s.jobs) != backlog {
+		s.jobs = replaceQueue(s.jobs, backlog)
+	}
+	if cap(s.limitReached) != backlog {
+		s.limitReached = replaceQueue(s.limitReached, backlog)
+	}
+	return s.jobs, s.limitReached
+}
+
+// Replace a connection queue with a new queue. Connections waiting in the old
+// queue are moved to the new queue, or closed if it is full.
+func replaceQueue(old chan net.Conn, backlog int) chan net.Conn {
+	queue := make(chan net.Conn, backlog)
+	close(old)
+	for conn := range old {
+		select {
+		case queue <- conn:
+		default:
+			conn.Close()
+		}
+	}
+	return queue
+}
+
+// Get the rate limiter for a listener. Listeners without their own rate limit
+// share the server's rate limiter.
+func (s *Server) rateLimiter(listener string) golimit.Store {
+	// Acquire the read lock.
+	s.runtimeLock.RLock()
+	defer s.runtimeLock.RUnlock()
+
+	if limiter, ok := s.listenerLimiters[listener]; ok {
+		return limiter
+	}
+	return s.limiter
+}
+
+// Create new rate limiters with the current rate limit settings, replacing
+// the previous ones. Listeners with their own rate limit get their own rate
+// limiter.
+func (s *Server) resetRateLimiter() error {
+	interval, numTokens := s.config.GetRateLimit()
+	limiters := map[string]golimit.Store{}
+	for _, l := range s.config.GetListeners() {
+		if l.MaxLimitEvents == 0 {
+			continue
+		}
+		limiter, err := memorystore.New(&memorystore.Config{
+			Tokens:   uint64(l.MaxLimitEvents),
+			Interval: l.Limit,
+		})
+		if err != nil {
+			closeRateLimiters(limiters)
+			return err
+		}
+		limiters[l.Name] = limiter
+	}
+	limiter, err := memorystore.New(&memorystore.Config{
+		Tokens:   uint64(numTokens),
+		Interval: interval,
+	})
+	if err != nil {
+		closeRateLimiters(limiters)
+		return err
+	}
+
+	// Acquire the lock.
+	s.runtimeLock.Lock()
+	oldLimiters := s.listenerLimiters
+	if s.limiter != nil {
+		oldLimiters[""] = s.limiter
+	}
+	s.limiter = limiter
+	s.listenerLimiters = limiters
+	s.runtimeLock.Unlock()
+
+	// Close the previous rate limiters.
+	return closeRateLimiters(oldLimiters)
+}
+
+// Close rate limiters.
+func closeRateLimiters(limiters map[string]golimit.Store) error {
+	var err error
+	for name := range limiters {
+		if closeErr := limiters[name].Close(context.Background()); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Start or stop workers so the given number are running. Stopped workers
+// finish their current connection first.
+func (s *Server) resizeWorkers(numWorkers int) {
+	// Acquire the lock.
+	s.runtimeLock.Lock()
+	diff := numWorkers - s.numWorkers
+	s.numWorkers = numWorkers
+	s.runtimeLock.Unlock()
+
+	// Start the new workers.
+	for i := 0; i < diff; i++ {
+		go s.Worker()
+	}
+
+	// Send the stop signals. Workers only receive them between connections,
+	// so they are sent in the background.
+	if diff < 0 {
+		go func() {
+			for i := 0; i < -diff; i++ {
+				s.stop <- struct{}{}
+			}
+		}()
+	}
+}
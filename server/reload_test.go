@@ -0,0 +1,131 @@
+// server/reload_test.go
+// Testing for server/reload.go.
+
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/marshal"
+	"github.com/cubeflix/lily/server/config"
+	ulist "github.com/cubeflix/lily/user/list"
+)
+
+// Write a self-signed certificate and key for testing.
+func writeTestCert(t *testing.T, dir, name string) config.CertFilePair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	pair := config.CertFilePair{Cert: filepath.Join(dir, name+".crt"), Key: filepath.Join(dir, name+".key")}
+	if err := os.WriteFile(pair.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(pair.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	return pair
+}
+
+// Write a server file for testing.
+func writeTestServerFile(t *testing.T, path string, port, numWorkers int, cert config.CertFilePair) {
+	cobj, err := config.NewConfig(path, "lily", "127.0.0.1", port, map[string]string{}, numWorkers, 1, time.Minute,
+		time.Minute, time.Second, false, false, false, config.LoggingLevelInfo, "", time.Minute, false, false, 10,
+		time.Second, 10, []config.CertFilePair{cert}, &tls.Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer file.Close()
+	if err := marshal.MarshalConfig(cobj, file); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := marshal.MarshalUserList(ulist.NewUserList(), file); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// Test reloading the server file and certificates.
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server")
+	oldCert, newCert := writeTestCert(t, dir, "old"), writeTestCert(t, dir, "new")
+
+	// Load a running server.
+	writeTestServerFile(t, path, 42069, 1, oldCert)
+	s, err := LoadServerFromFile(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.jobs = make(chan net.Conn, s.config.GetBacklog())
+	s.limitReached = make(chan net.Conn, s.config.GetBacklog())
+	s.stop = make(chan struct{}, 1)
+	s.limitStop = make(chan struct{}, 1)
+	if err := s.resetRateLimiter(); err != nil {
+		t.Fatal(err.Error())
+	}
+	s.resizeWorkers(s.config.GetNumWorkers())
+	s.setRunning()
+	defer s.StopWorkers()
+	s.config.SetDirty(false)
+	s.users.SetDirty(false)
+
+	// Change the workers and certificate.
+	writeTestServerFile(t, path, 42069, 3, newCert)
+	if err := s.Reload(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if s.config.GetNumWorkers() != 3 || s.numWorkers != 3 {
+		t.Fail()
+	}
+	cert, err := s.config.GetTLSConfig().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	want, err := tls.LoadX509KeyPair(newCert.Cert, newCert.Key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(cert.Certificate[0], want.Certificate[0]) {
+		t.Error("certificate was not reloaded")
+	}
+
+	// The port cannot be reloaded.
+	writeTestServerFile(t, path, 42070, 1, oldCert)
+	if err := s.Reload(); err != config.ErrRestartRequired {
+		t.Fail()
+	}
+	if s.config.GetNumWorkers() != 3 {
+		t.Fail()
+	}
+}
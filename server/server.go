@@ -9,24 +9,27 @@
 package server
 
 import (
-	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/cubeflix/lily/connection"
 	"github.com/cubeflix/lily/drive"
 	"github.com/cubeflix/lily/marshal"
+	"github.com/cubeflix/lily/metrics"
 	"github.com/cubeflix/lily/network"
+	"github.com/cubeflix/lily/security/audit"
+	"github.com/cubeflix/lily/security/lockout"
+	"github.com/cubeflix/lily/security/userlimit"
 	"github.com/cubeflix/lily/server/config"
 	slist "github.com/cubeflix/lily/session/list"
 	ulist "github.com/cubeflix/lily/user/list"
 	golimit "github.com/sethvargo/go-limiter"
-	"github.com/sethvargo/go-limiter/memorystore"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -66,6 +69,11 @@ func LoadServerFromFile(path string) (*Server, error) {
 		return nil, err
 	}
 
+	// Load the password deny-list.
+	if err := config.LoadPasswordDenyList(); err != nil {
+		return nil, err
+	}
+
 	// Create the new server.
 	s := NewServer(slist.NewSessionList(10, config.GetUserSessionLimit()), users, config)
 
@@ -74,6 +82,11 @@ func LoadServerFromFile(path string) (*Server, error) {
 		return nil, err
 	}
 
+	// Restore the saved sessions.
+	if err := s.LoadSessions(); err != nil {
+		return nil, err
+	}
+
 	// Return.
 	return s, nil
 }
@@ -86,30 +99,212 @@ type Server struct {
 	users    *ulist.UserList
 	config   *config.Config
 
+	// Failed authentication trackers for users and IP addresses.
+	userLockout *lockout.Tracker
+	ipLockout   *lockout.Tracker
+
+	// Per-user request and bandwidth limits, and the server-wide bandwidth
+	// throttles.
+	userLimiter      *userlimit.Limiter
+	uploadThrottle   *network.Throttle
+	downloadThrottle *network.Throttle
+
+	// The memory budget for transfers.
+	memoryBudget *network.MemoryBudget
+
+	// The audit log, if enabled.
+	audit *audit.Log
+
+	// Metrics, and the metrics HTTP listener, if enabled.
+	metrics       *metrics.ServerMetrics
+	metricsServer *http.Server
+
+	// Shutdown state. The listeners accept connections while the server is
+	// running, and the connections being handled are tracked so they can be
+	// awaited or cancelled when the server shuts down.
+	stateLock        sync.Mutex
+	running          bool
+	state            State
+	conns            map[net.Conn]struct{}
+	drained          chan struct{}
+	shutdownDeadline time.Duration
+
+	// Open connections in total and per IP, counted against the connection
+	// limits from when they are accepted until they are closed.
+	connLock sync.Mutex
+	numConns int
+	ipConns  map[string]int
+
 	// Runtime values. limitReached is a channel of connections that need to
 	// be told they reached the rate limit. stop is a channel for sending a
-	// stop signal and will need to be propagated with one item for each
-	// worker and one extra for the limit worker.
-	jobs         chan net.Conn
-	limitReached chan net.Conn
-	limiter      golimit.Store
-	running      bool
-	stop         chan struct{}
-	cronStop     chan struct{}
-	listener     net.Listener
-	logFile      *os.File
+	// stop signal to a worker, and limitStop is for the limit worker. The
+	// queues, rate limiters, worker count, log file and audit log can be
+	// replaced when the server is reloaded, so they are protected by the
+	// runtime lock.
+	runtimeLock      sync.RWMutex
+	reloadLock       sync.Mutex
+	jobs             chan net.Conn
+	limitReached     chan net.Conn
+	limiter          golimit.Store
+	listenerLimiters map[string]golimit.Store
+	numWorkers       int
+	stop             chan struct{}
+	limitStop        chan struct{}
+	cronStop         chan struct{}
+	logFile          *os.File
+
+	// The listeners. Connections accepted by the listeners are sent to the
+	// accepted channel, and queued by a single routine, which closes
+	// dispatched once it stops.
+	listeners   []*serverListener
+	accepted    chan acceptedConn
+	dispatched  chan struct{}
+	acceptGroup sync.WaitGroup
 
 	PublicStop chan os.Signal
 }
 
 // Create a new server object.
 func NewServer(sessions *slist.SessionList, users *ulist.UserList, config *config.Config) *Server {
-	return &Server{
-		Lock:     sync.RWMutex{},
-		sessions: sessions,
-		users:    users,
-		config:   config,
+	userPolicy, ipPolicy := lockout.Policy{}, lockout.Policy{}
+	shutdownDeadline := time.Duration(0)
+	userLimits, userLimitOverrides := userlimit.Limits{}, map[string]userlimit.Limits{}
+	uploadRate, downloadRate := int64(0), int64(0)
+	memoryBudget := int64(0)
+	if config != nil {
+		userPolicy, ipPolicy = config.GetLockoutPolicies()
+		shutdownDeadline = config.GetShutdownDeadline()
+		userLimits, userLimitOverrides = config.GetUserLimits(), config.GetUserLimitOverrides()
+		uploadRate, downloadRate = config.GetBandwidthLimits()
+		_, memoryBudget, _ = config.GetTransferLimits()
+	}
+	s := &Server{
+		Lock:        sync.RWMutex{},
+		sessions:    sessions,
+		users:       users,
+		config:      config,
+		userLockout: lockout.NewTracker(userPolicy),
+		ipLockout:   lockout.NewTracker(ipPolicy),
+		metrics:     metrics.NewServerMetrics(),
+
+		userLimiter:      userlimit.NewLimiter(userLimits, userLimitOverrides),
+		uploadThrottle:   network.NewThrottle(uploadRate),
+		downloadThrottle: network.NewThrottle(downloadRate),
+		memoryBudget:     network.NewMemoryBudget(memoryBudget),
+
+		conns:            map[net.Conn]struct{}{},
+		shutdownDeadline: shutdownDeadline,
+
+		ipConns: map[string]int{},
+
+		listenerLimiters: map[string]golimit.Store{},
 	}
+	s.registerMetrics()
+	return s
+}
+
+// Register the metrics which read the server state.
+func (s *Server) registerMetrics() {
+	r := s.metrics.Registry
+	r.NewGaugeFunc("lily_jobs_queue_length", "Accepted connections waiting for a worker.", func() float64 {
+		jobs, _ := s.queues()
+		return float64(len(jobs))
+	})
+	r.NewGaugeFunc("lily_limit_queue_length", "Rate limited connections waiting for a response.", func() float64 {
+		_, limitReached := s.queues()
+		return float64(len(limitReached))
+	})
+	r.NewGaugeFunc("lily_open_connections", "Open connections, including queued connections.", func() float64 {
+		return float64(s.OpenConnections())
+	})
+	r.NewGaugeFunc("lily_sessions", "Sessions, including restored sessions which have not been used.", func() float64 {
+		return float64(s.sessions.Count())
+	})
+	r.NewGaugeFunc("lily_dirty_drives", "Drives with unsaved changes.", func() float64 {
+		s.LockReadDrives()
+		defer s.UnlockReadDrives()
+
+		dirty := 0
+		for name := range s.drives {
+			if s.drives[name].IsDirty() {
+				dirty++
+			}
+		}
+		return float64(dirty)
+	})
+	r.NewGaugeFunc("lily_memory_alloc_bytes", "Bytes of allocated heap objects.", func() float64 {
+		alloc, _, _ := s.GetMemUsage()
+		return float64(alloc)
+	})
+	r.NewGaugeFunc("lily_memory_sys_bytes", "Bytes of memory obtained from the OS.", func() float64 {
+		_, _, sys := s.GetMemUsage()
+		return float64(sys)
+	})
+}
+
+// Get the server metrics.
+func (s *Server) Metrics() *metrics.ServerMetrics {
+	return s.metrics
+}
+
+// Start the metrics HTTP listener, if enabled.
+func (s *Server) ServeMetrics() error {
+	address := s.config.GetMetricsAddress()
+	if address == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.Registry.Handler())
+	s.metricsServer = &http.Server{Handler: mux}
+	go func() {
+		log.WithFields(log.Fields{
+			"address": address,
+		}).Info("metrics are listening")
+		if err := s.metricsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("error with metrics listener")
+		}
+	}()
+	return nil
+}
+
+// Get the failed authentication tracker for users.
+func (s *Server) UserLockout() *lockout.Tracker {
+	return s.userLockout
+}
+
+// Get the failed authentication tracker for IP addresses.
+func (s *Server) IPLockout() *lockout.Tracker {
+	return s.ipLockout
+}
+
+// Get the per-user request and bandwidth limiter.
+func (s *Server) UserLimiter() *userlimit.Limiter {
+	return s.userLimiter
+}
+
+// Get the server-wide upload and download throttles.
+func (s *Server) BandwidthThrottles() (*network.Throttle, *network.Throttle) {
+	return s.uploadThrottle, s.downloadThrottle
+}
+
+// Get the memory budget for transfers.
+func (s *Server) MemoryBudget() *network.MemoryBudget {
+	return s.memoryBudget
+}
+
+// Get the audit log. Returns nil if auditing is disabled.
+func (s *Server) Audit() *audit.Log {
+	// Acquire the read lock.
+	s.runtimeLock.RLock()
+	defer s.runtimeLock.RUnlock()
+
+	return s.audit
 }
 
 // Set public stop channel.
@@ -202,34 +397,77 @@ func (s *Server) LoadDrives() error {
 	return nil
 }
 
+// Restore sessions from the sessions file, if there is one. Expired sessions
+// are dropped.
+func (s *Server) LoadSessions() error {
+	path := s.config.GetSessionFile()
+	if path == "" {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_RDONLY, 0600)
+	if os.IsNotExist(err) {
+		// No sessions have been saved yet.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	sessions, err := marshal.UnmarshalSessions(file)
+	if err != nil {
+		return err
+	}
+	s.sessions.RestoreSessions(sessions)
+	return nil
+}
+
 type nilWriter struct{}
 
 func (w *nilWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-// Initialize the logging.
+// Initialize the logging. This can be called again to apply new logging
+// settings, in which case the previous log file is closed.
 func (s *Server) InitLogging() error {
 	// Get logging settings.
 	verbose, logToFile, logJSON, level, path := s.config.GetLogging()
 
+	// Open the log file (if necessary).
+	var file *os.File
+	if verbose && logToFile {
+		var err error
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the previous log file.
+	s.runtimeLock.Lock()
+	oldFile := s.logFile
+	s.logFile = file
+	s.runtimeLock.Unlock()
+	defer func() {
+		if oldFile != nil {
+			oldFile.Close()
+		}
+	}()
+
 	// If not verbose, don't initialize logging.
 	if !verbose {
 		log.SetOutput(&nilWriter{})
 		return nil
 	}
 
-	// Set the JSON formatter (if necessary).
+	// Set the formatter.
 	if logJSON {
 		log.SetFormatter(&log.JSONFormatter{})
+	} else {
+		log.SetFormatter(&log.TextFormatter{})
 	}
 
 	// Set the output.
-	if logToFile {
-		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
-		if err != nil {
-			return err
-		}
+	if file != nil {
 		log.SetOutput(file)
 	} else {
 		log.SetOutput(os.Stdout)
@@ -252,16 +490,52 @@ func (s *Server) InitLogging() error {
 	return nil
 }
 
+// Open the audit log, if enabled. This can be called again to apply new audit
+// settings, in which case the previous audit log is closed.
+func (s *Server) InitAudit() error {
+	path, hashChain := s.config.GetAudit()
+	var l *audit.Log
+	if path != "" {
+		var err error
+		l, err = audit.Open(path, hashChain, audit.DefaultRecentRecords)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the previous audit log.
+	s.runtimeLock.Lock()
+	oldLog := s.audit
+	s.audit = l
+	s.runtimeLock.Unlock()
+	return oldLog.Close()
+}
+
 // Finish logging.
 func (s *Server) FinishLogging() {
+	// Acquire the lock.
+	s.runtimeLock.Lock()
+	defer s.runtimeLock.Unlock()
+
 	// Close the log file, if it exists.
 	if s.logFile != nil {
 		s.logFile.Close()
 	}
+
+	// Close the audit log.
+	if err := s.audit.Close(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("failed to close audit log")
+	}
 }
 
 // Check if the server is running.
 func (s *Server) Running() bool {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
 	return s.running
 }
 
@@ -271,8 +545,13 @@ func (s *Server) Serve() error {
 	if err := s.InitLogging(); err != nil {
 		return err
 	}
+	if err := s.InitAudit(); err != nil {
+		return err
+	}
 
-	// Perform a health check.
+	// Remove the copies left behind by uploads and patches which were in
+	// progress when the server last stopped, and perform a health check.
+	s.RemoveTempFiles()
 	s.DriveHealth()
 
 	log.WithFields(log.Fields{
@@ -282,128 +561,100 @@ func (s *Server) Serve() error {
 	// Create the channels and rate limiter.
 	s.jobs = make(chan net.Conn, s.config.GetBacklog())
 	s.limitReached = make(chan net.Conn, s.config.GetBacklog())
-	s.stop = make(chan struct{}, s.config.GetNumWorkers()+1)
-	interval, numTokens := s.config.GetRateLimit()
-	limiter, err := memorystore.New(&memorystore.Config{
-		Tokens:   uint64(numTokens),
-		Interval: interval,
-	})
-	if err != nil {
+	s.stop = make(chan struct{}, s.config.GetNumWorkers())
+	s.limitStop = make(chan struct{}, 1)
+	if err := s.resetRateLimiter(); err != nil {
 		return err
 	}
-	s.limiter = limiter
 
-	// Create the listener.
-	host, port := s.config.GetHostAndPort()
-	s.listener, err = tls.Listen("tcp", fmt.Sprintf("%s:%d", host, port), s.config.GetTLSConfig())
-	if err != nil {
+	// Create the listeners. The TLS handshake is done by the workers, so the
+	// underlying connections can be closed to cancel them on shutdown.
+	if err := s.listen(); err != nil {
 		return err
 	}
-	s.running = true
+	if err := s.ServeMetrics(); err != nil {
+		s.closeListeners()
+		return err
+	}
+	s.setRunning()
 
 	// Start the workers. Workers are started after everything else is ready
 	// but before the listener begins.
-	for i := 0; i < s.config.GetNumWorkers(); i++ {
-		go s.Worker()
-	}
+	s.resizeWorkers(s.config.GetNumWorkers())
 
 	// Start a worker to respond to connections that have reached the rate
 	// limit.
 	go s.LimitResponseWorker()
 
-	// Start listening.
+	// Start listening. Each listener accepts connections on its own routine,
+	// and a single routine queues the accepted connections.
+	s.accepted = make(chan acceptedConn)
+	s.dispatched = make(chan struct{})
+	go s.dispatch()
+	s.acceptGroup.Add(len(s.listeners))
+	for i := range s.listeners {
+		go s.acceptLoop(s.listeners[i])
+	}
 	go func() {
-		log.WithFields(log.Fields{
-			"host": host,
-			"port": port,
-		}).Info("server is listening")
-		for s.running {
-			conn, err := s.listener.Accept()
-			if err != nil {
-				if !s.running {
-					// If we are not running (i.e. shutting down), then ignore this
-					// and exit.
-					return
-				} else {
-					// Actual error, log and ignore.
-					log.WithFields(log.Fields{
-						"error": err.Error(),
-					}).Error("error with accepting connection")
-					continue
-				}
-			}
-
-			// Check the rate limit.
-			addr, ok := conn.RemoteAddr().(*net.TCPAddr)
-			if !ok {
-				// Weird error, ignore.
-				conn.Close()
-				continue
-			}
-			_, _, _, valid, err := s.limiter.Take(context.Background(), addr.IP.String())
-			if err != nil {
-				// Weird error, log and ignore.
-				log.WithFields(log.Fields{
-					"error": err.Error(),
-				}).Error("error with rate limiter")
-				conn.Close()
-				continue
-			}
-			if !valid {
-				// Rate limit reached.
-				s.limitReached <- conn
-				continue
-			}
-
-			// Handle the connection.
-			s.jobs <- conn
-		}
+		s.acceptGroup.Wait()
+		close(s.accepted)
 	}()
 
 	// Return.
 	return nil
 }
 
-// Stop the main server routine.
+// Stop the main server routine. Returns once no more connections will be
+// queued.
 func (s *Server) StopServerRoutine() {
-	s.running = false
-	s.listener.Close()
+	s.stopRunning()
+	s.closeListeners()
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	if s.dispatched != nil {
+		<-s.dispatched
+	}
 }
 
 // Stop the workers.
 func (s *Server) StopWorkers() {
 	// Send all the stop signals.
-	for i := 0; i < (s.config.GetNumWorkers() + 1); i++ {
-		s.stop <- struct{}{}
-	}
+	s.resizeWorkers(0)
+	s.limitStop <- struct{}{}
 }
 
 // Worker routine.
 func (s *Server) Worker() {
-	// Continually handle new connections.
-	for s.running {
+	// Continually handle new connections. Workers keep running while the
+	// server drains, so queued connections are rejected.
+	for {
 		select {
 		case <-s.stop:
-			// Stop signal. NOTE: Never put any code here since we can't be
-			// sure we'll ever get the stop signal, we may just exit the loop.
+			// Stop signal.
 			return
-		case conn := <-s.jobs:
-			addr := conn.RemoteAddr().(*net.TCPAddr)
-			log.WithFields(log.Fields{
-				"ip":   addr.IP,
-				"port": addr.Port,
-			}).Info("accepted connection")
-			// Got a new connection.
-			tlsConn, ok := conn.(*tls.Conn)
+		case conn, ok := <-s.jobQueue():
 			if !ok {
-				// Weird error, ignore.
+				// The queue was replaced after a reload.
 				continue
 			}
-			connection.HandleConnection(tlsConn, s.config.GetTimeout(), s)
-			log.WithFields(log.Fields{
-				"ip":   addr.IP,
-				"port": addr.Port,
-			}).Info("handled connection")
+			fields := connFields(conn)
+			log.WithFields(fields).Info("accepted connection")
+			// Got a new connection. If we are shutting down, reject it.
+			if !s.beginConnection(conn) {
+				s.rejectConnection(conn)
+				continue
+			}
+			s.metrics.ActiveConnections.Add(1)
+			if local, ok := conn.(*localConn); ok {
+				connection.HandleLocalConnection(local, local.username, s.config.GetTimeout(), s)
+			} else {
+				tlsConn := tls.Server(conn, s.config.GetTLSConfig())
+				connection.HandleConnection(tlsConn, s.config.GetTimeout(), s)
+			}
+			s.metrics.ActiveConnections.Add(-1)
+			s.endConnection(conn)
+			log.WithFields(fields).Info("handled connection")
 		}
 	}
 }
@@ -411,31 +662,34 @@ func (s *Server) Worker() {
 // Limit response worker routine.
 func (s *Server) LimitResponseWorker() {
 	// Continually handle new connections.
-	for s.running {
+	for {
 		select {
-		case <-s.stop:
-			// Stop signal. NOTE: Never put any code here since we can't be
-			// sure we'll ever get the stop signal, we may just exit the loop.
+		case <-s.limitStop:
+			// Stop signal.
 			return
-		case conn := <-s.limitReached:
-			// Got a new connection.
-			tlsConn, ok := conn.(*tls.Conn)
+		case conn, ok := <-s.limitQueue():
 			if !ok {
-				// Weird error, ignore.
-				conn.Close()
+				// The queue was replaced after a reload.
 				continue
 			}
-
-			stream := network.DataStream(network.NewTLSStream(tlsConn))
+			// Got a new connection.
+			stream, closer := s.connStream(conn)
 			connection.ConnectionError(stream, s.config.GetTimeout(), 7, "Rate limit reached. Please try again later.", nil)
+			closer.Close()
 		}
 	}
 }
 
-// Fully close the server.
+// Fully close the server. The server is drained, then the drives, server file
+// and sessions are saved.
 func (s *Server) FullyClose() {
-	s.StopServerRoutine()
+	// Wait for the in-flight commands before saving. Reloads which have
+	// already started are allowed to finish.
+	s.Drain()
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
 	s.StopWorkers()
+	s.rejectQueued()
 	s.StopCronRoutines()
 	s.DriveHealth()
 	err := s.CronSave()
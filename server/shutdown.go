@@ -0,0 +1,198 @@
+// server/shutdown.go
+// Graceful shutdown for Lily servers.
+
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/cubeflix/lily/connection"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server state.
+type State int
+
+// Server states. A running server accepts and handles connections. A draining
+// server no longer accepts connections and rejects queued connections, while
+// waiting for in-flight commands to finish. A stopped server has finished
+// all of its connections.
+const (
+	StateStopped State = iota
+	StateRunning
+	StateDraining
+)
+
+// Get the name of a server state.
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	default:
+		return "stopped"
+	}
+}
+
+// Get the server state.
+func (s *Server) State() State {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.state
+}
+
+// Set the server state to running.
+func (s *Server) setRunning() {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.running = true
+	s.state = StateRunning
+	s.conns = map[net.Conn]struct{}{}
+}
+
+// Stop accepting connections. Connections which have already been accepted
+// are still handled.
+func (s *Server) stopRunning() {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.running = false
+}
+
+// Get the shutdown deadline. In-flight commands are cancelled if they have not
+// finished by the deadline.
+func (s *Server) GetShutdownDeadline() time.Duration {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.shutdownDeadline
+}
+
+// Set the shutdown deadline for the next shutdown.
+func (s *Server) SetShutdownDeadline(deadline time.Duration) {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.shutdownDeadline = deadline
+}
+
+// Begin handling a connection. Returns false if the server is not running, in
+// which case the connection should be rejected.
+func (s *Server) beginConnection(conn net.Conn) bool {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.state != StateRunning {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+// Finish handling a connection.
+func (s *Server) endConnection(conn net.Conn) {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	delete(s.conns, conn)
+	if s.state == StateDraining && len(s.conns) == 0 {
+		s.state = StateStopped
+		close(s.drained)
+	}
+}
+
+// Begin draining. Returns a channel which is closed once all in-flight
+// connections have finished.
+func (s *Server) beginDraining() chan struct{} {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.drained = make(chan struct{})
+	if len(s.conns) == 0 {
+		s.state = StateStopped
+		close(s.drained)
+	} else {
+		s.state = StateDraining
+	}
+	return s.drained
+}
+
+// Cancel all in-flight connections by closing them. Commands fail on their
+// next read or write. Returns the number of cancelled connections.
+func (s *Server) cancelConnections() int {
+	// Acquire the lock.
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return len(s.conns)
+}
+
+// Reject a connection because the server is shutting down.
+func (s *Server) rejectConnection(conn net.Conn) {
+	stream, closer := s.connStream(conn)
+	defer closer.Close()
+	connection.ConnectionError(stream, s.config.GetTimeout(), 36, "Server is shutting down.", nil)
+}
+
+// Drain the server. Stops accepting connections, rejects queued connections
+// and waits for in-flight commands until the shutdown deadline, after which
+// they are cancelled.
+func (s *Server) Drain() {
+	deadline := s.GetShutdownDeadline()
+	log.WithFields(log.Fields{
+		"deadline": deadline,
+	}).Info("draining server")
+
+	// Stop accepting connections. Workers reject any queued connections from
+	// here on.
+	drained := s.beginDraining()
+	s.StopServerRoutine()
+
+	// Wait for the in-flight commands.
+	select {
+	case <-drained:
+		return
+	case <-time.After(deadline):
+	}
+
+	// Cancel the remaining commands, and give them until the network timeout
+	// to fail.
+	log.WithFields(log.Fields{
+		"connections": s.cancelConnections(),
+	}).Warn("shutdown deadline reached, cancelling in-flight commands")
+	select {
+	case <-drained:
+	case <-time.After(s.config.GetTimeout()):
+		log.Error("in-flight commands did not stop after being cancelled")
+	}
+}
+
+// Reject the connections left in the queues once the workers have stopped.
+func (s *Server) rejectQueued() {
+	jobs, limitReached := s.queues()
+	for {
+		select {
+		case conn := <-jobs:
+			s.rejectConnection(conn)
+		case conn := <-limitReached:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
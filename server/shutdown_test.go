@@ -0,0 +1,86 @@
+// server/shutdown_test.go
+// Testing for server/shutdown.go.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/server/config"
+	slist "github.com/cubeflix/lily/session/list"
+	ulist "github.com/cubeflix/lily/user/list"
+)
+
+// Create a running server for testing shutdown.
+func newShutdownTestServer(t *testing.T, deadline time.Duration) *Server {
+	cobj, err := config.NewConfig("", "", "", 0, map[string]string{}, 1, 1, time.Minute, time.Minute, time.Second,
+		false, false, false, config.LoggingLevelInfo, "", time.Minute, false, false, 0, time.Second, 10, nil, &tls.Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cobj.SetShutdownDeadline(deadline); err != nil {
+		t.Fatal(err.Error())
+	}
+	s := NewServer(slist.NewSessionList(0, 100), ulist.NewUserList(), cobj)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	s.listeners = []*serverListener{{Listener: listener, network: config.ListenerTCP}}
+	s.setRunning()
+	return s
+}
+
+// Test draining a server with an in-flight connection which finishes before
+// the deadline.
+func TestDrain(t *testing.T) {
+	s := newShutdownTestServer(t, time.Minute)
+	conn, other := net.Pipe()
+	defer other.Close()
+	if !s.beginConnection(conn) {
+		t.Fatal("connection rejected while running")
+	}
+
+	// Finish the connection while draining.
+	done := make(chan struct{})
+	go func() {
+		s.Drain()
+		close(done)
+	}()
+	for s.State() != StateDraining {
+		time.Sleep(time.Millisecond)
+	}
+	if s.beginConnection(other) {
+		t.Error("connection accepted while draining")
+	}
+	s.endConnection(conn)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish")
+	}
+	if s.State() != StateStopped {
+		t.Fail()
+	}
+}
+
+// Test cancelling an in-flight connection once the deadline is reached.
+func TestDrainDeadline(t *testing.T) {
+	s := newShutdownTestServer(t, 10*time.Millisecond)
+	conn, other := net.Pipe()
+	defer other.Close()
+	s.beginConnection(conn)
+
+	// The connection finishes once it is closed by the server.
+	go func() {
+		conn.Read(make([]byte, 1))
+		s.endConnection(conn)
+	}()
+	s.Drain()
+	if s.State() != StateStopped {
+		t.Fail()
+	}
+}
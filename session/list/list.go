@@ -25,6 +25,10 @@ type SessionList struct {
 	sessions     map[uuid.UUID]*session.Session
 	ids          []uuid.UUID
 	perUserLimit int
+
+	// Sessions restored from disk, by ID hash. A restored session is moved to
+	// the main map when a client first presents its ID.
+	restored map[session.IDHash]*session.Session
 }
 
 // Create the session list.
@@ -36,6 +40,7 @@ func NewSessionList(genLimit, perUserLimit int) *SessionList {
 		sessions:     map[uuid.UUID]*session.Session{},
 		ids:          []uuid.UUID{},
 		perUserLimit: perUserLimit,
+		restored:     map[session.IDHash]*session.Session{},
 	}
 }
 
@@ -48,13 +53,30 @@ func (u *SessionList) CheckList(id uuid.UUID) bool {
 	// Check for the session.
 	_, ok := u.sessions[id]
 	if !ok {
-		return false
-	} else {
-		return true
+		_, ok = u.restored[session.HashID(id)]
 	}
+	return ok
 }
 
-// Get the list of IDs.
+// Move a restored session to the main map, if one exists for the ID. The
+// write lock must be held.
+func (u *SessionList) promote(id uuid.UUID) {
+	if _, ok := u.sessions[id]; ok {
+		return
+	}
+	hash := session.HashID(id)
+	sessionObj, ok := u.restored[hash]
+	if !ok {
+		return
+	}
+	delete(u.restored, hash)
+	sessionObj.SetID(id)
+	u.sessions[id] = sessionObj
+	u.ids = append(u.ids, id)
+}
+
+// Get the list of IDs. Restored sessions are not included until they are
+// used, since their IDs are unknown.
 func (u *SessionList) GetList() []uuid.UUID {
 	// Acquire the read lock.
 	u.lock.RLock()
@@ -64,14 +86,24 @@ func (u *SessionList) GetList() []uuid.UUID {
 	return u.ids
 }
 
-// Get sessions by ID.
-func (u *SessionList) GetSessionsByID(ids []uuid.UUID) ([]*session.Session, error) {
+// Get the number of sessions, including restored sessions.
+func (u *SessionList) Count() int {
 	// Acquire the read lock.
 	u.lock.RLock()
 	defer u.lock.RUnlock()
 
+	return len(u.sessions) + len(u.restored)
+}
+
+// Get sessions by ID.
+func (u *SessionList) GetSessionsByID(ids []uuid.UUID) ([]*session.Session, error) {
+	// Acquire the write lock, since restored sessions may be moved.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
 	output := []*session.Session{}
 	for i := range ids {
+		u.promote(ids[i])
 		// Get the sessions.
 		sessionObj, ok := u.sessions[ids[i]]
 		if !ok {
@@ -130,6 +162,11 @@ func (u *SessionList) AllUserSessions(user string, useLock bool) []*session.Sess
 			sessions = append(sessions, u.sessions[id])
 		}
 	}
+	for hash := range u.restored {
+		if u.restored[hash].GetUsername() == user {
+			sessions = append(sessions, u.restored[hash])
+		}
+	}
 
 	// Return the list of sessions.
 	return sessions
@@ -145,6 +182,7 @@ func (u *SessionList) RemoveSessionsByID(ids []uuid.UUID, useLock bool) error {
 
 	for i := range ids {
 		// Check that the session exists.
+		u.promote(ids[i])
 		_, ok := u.sessions[ids[i]]
 		if !ok {
 			return ErrSessionNotFound
@@ -209,7 +247,123 @@ func (u *SessionList) ExpireSessions() error {
 	if err := u.RemoveSessionsByID(toExpire, false); err != nil {
 		return err
 	}
+	for hash := range u.restored {
+		if u.restored[hash].ShouldExpire() {
+			delete(u.restored, hash)
+		}
+	}
 
 	// Return.
 	return nil
 }
+
+// Get all sessions by ID hash, for saving.
+func (u *SessionList) GetSessionHashes() map[session.IDHash]*session.Session {
+	// Acquire the read lock.
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	sessions := map[session.IDHash]*session.Session{}
+	for id := range u.sessions {
+		sessions[session.HashID(id)] = u.sessions[id]
+	}
+	for hash := range u.restored {
+		sessions[hash] = u.restored[hash]
+	}
+	return sessions
+}
+
+// Restore saved sessions by ID hash. Expired sessions are dropped.
+func (u *SessionList) RestoreSessions(sessions map[session.IDHash]*session.Session) {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	for hash := range sessions {
+		if sessions[hash].ShouldExpire() {
+			continue
+		}
+		u.restored[hash] = sessions[hash]
+	}
+}
+
+// Get all the sessions for a user by ID hash.
+func (u *SessionList) GetUserSessionHashes(user string) map[session.IDHash]*session.Session {
+	// Acquire the read lock.
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	sessions := map[session.IDHash]*session.Session{}
+	for id := range u.sessions {
+		if u.sessions[id].GetUsername() == user {
+			sessions[session.HashID(id)] = u.sessions[id]
+		}
+	}
+	for hash := range u.restored {
+		if u.restored[hash].GetUsername() == user {
+			sessions[hash] = u.restored[hash]
+		}
+	}
+	return sessions
+}
+
+// Remove sessions by ID hash.
+func (u *SessionList) RemoveSessionsByHash(hashes []session.IDHash) error {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	// Find the IDs of the sessions.
+	ids := []uuid.UUID{}
+	for i := range hashes {
+		if _, ok := u.restored[hashes[i]]; ok {
+			continue
+		}
+		found := false
+		for id := range u.sessions {
+			if session.HashID(id) == hashes[i] {
+				ids = append(ids, id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrSessionNotFound
+		}
+	}
+
+	// Remove the sessions.
+	for i := range hashes {
+		delete(u.restored, hashes[i])
+	}
+	return u.RemoveSessionsByID(ids, false)
+}
+
+// Remove all the sessions for a user, except the sessions with the given ID
+// hashes.
+func (u *SessionList) RemoveUserSessions(user string, keep []session.IDHash) {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	kept := func(hash session.IDHash) bool {
+		for i := range keep {
+			if keep[i] == hash {
+				return true
+			}
+		}
+		return false
+	}
+	ids := []uuid.UUID{}
+	for id := range u.sessions {
+		if u.sessions[id].GetUsername() == user && !kept(session.HashID(id)) {
+			ids = append(ids, id)
+		}
+	}
+	for hash := range u.restored {
+		if u.restored[hash].GetUsername() == user && !kept(hash) {
+			delete(u.restored, hash)
+		}
+	}
+	u.RemoveSessionsByID(ids, false)
+}
@@ -70,3 +70,78 @@ func TestUserList(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+// Test restoring saved sessions.
+func TestRestoreSessions(t *testing.T) {
+	// Create a session list and a session.
+	list := NewSessionList(1, 100)
+	uuid1 := uuid.New()
+	session1 := session.NewSession(uuid1, "foo", time.Hour)
+	list.SetSessionsByID(map[uuid.UUID]*session.Session{uuid1: session1})
+
+	// Restore the sessions into a new list, along with an expired session.
+	hashes := list.GetSessionHashes()
+	if len(hashes) != 1 || hashes[session.HashID(uuid1)] != session1 {
+		t.Fail()
+	}
+	expired := session.RestoreSession("foo", time.Hour, time.Now().Add(-time.Minute), 0, 0,
+		time.Now().Add(-time.Hour), time.Now().Add(-time.Hour), "", "", "")
+	hashes[session.HashID(uuid.New())] = expired
+	newList := NewSessionList(1, 100)
+	newList.RestoreSessions(hashes)
+	if len(newList.AllUserSessions("foo", true)) != 1 || len(newList.GetList()) != 0 || newList.Count() != 1 {
+		t.Fail()
+	}
+
+	// The restored session is found by its ID.
+	if !newList.CheckList(uuid1) {
+		t.Fail()
+	}
+	sessions, err := newList.GetSessionsByID([]uuid.UUID{uuid1})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if sessions[0].GetID() != uuid1 || sessions[0].GetUsername() != "foo" || len(newList.GetList()) != 1 {
+		t.Fail()
+	}
+	if len(newList.GetSessionHashes()) != 1 || newList.Count() != 1 {
+		t.Fail()
+	}
+}
+
+// Test getting and removing sessions by ID hash.
+func TestRemoveSessionsByHash(t *testing.T) {
+	// Create a session list with two sessions.
+	list := NewSessionList(1, 100)
+	uuid1, uuid2 := uuid.New(), uuid.New()
+	list.SetSessionsByID(map[uuid.UUID]*session.Session{
+		uuid1: session.NewSession(uuid1, "foo", time.Hour),
+		uuid2: session.NewSession(uuid2, "bar", time.Hour),
+	})
+	if len(list.GetUserSessionHashes("foo")) != 1 {
+		t.Fail()
+	}
+
+	// Remove a session.
+	if list.RemoveSessionsByHash([]session.IDHash{session.HashID(uuid.New())}) != ErrSessionNotFound {
+		t.Fail()
+	}
+	if err := list.RemoveSessionsByHash([]session.IDHash{session.HashID(uuid1)}); err != nil {
+		t.Error(err.Error())
+	}
+	if list.CheckList(uuid1) || !list.CheckList(uuid2) || len(list.GetList()) != 1 {
+		t.Fail()
+	}
+
+	// Remove all of a user's sessions except one.
+	uuid3, uuid4 := uuid.New(), uuid.New()
+	list.SetSessionsByID(map[uuid.UUID]*session.Session{
+		uuid3: session.NewSession(uuid3, "bar", time.Hour),
+		uuid4: session.NewSession(uuid4, "bar", time.Hour),
+	})
+	list.RemoveUserSessions("bar", []session.IDHash{session.HashID(uuid3)})
+	if !list.CheckList(uuid3) || list.CheckList(uuid2) || list.CheckList(uuid4) {
+		t.Fail()
+	}
+}
@@ -4,6 +4,7 @@
 package session
 
 import (
+	"crypto/sha256"
 	"errors"
 	"sync"
 	"time"
@@ -25,30 +26,61 @@ type Session struct {
 	id       uuid.UUID
 	username string
 
-	// Expiration settings.
+	// Expiration settings. The idle timeout and maximum lifetime are
+	// enforced in addition to the expiration time, and are disabled if zero.
 	expireAfter time.Duration
 	expireAt    time.Time
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+
+	// Session metadata. The IP address is the address the session was
+	// created from, and the label is an optional name given by the client.
+	// The certificate fingerprint is the SHA-256 fingerprint of the client
+	// certificate the session was created with, if any.
+	createdAt       time.Time
+	lastUsed        time.Time
+	ip              string
+	label           string
+	certFingerprint string
 }
 
-// Session info type.
+// Session info type. Times are Unix timestamps.
 type SessionInfo struct {
 	ID          []byte
 	Username    string
 	ExpireAfter time.Duration
 	ExpireAt    int64
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
+	CreatedAt   int64
+	LastUsed    int64
+	IP          string
+	Label       string
 }
 
 var ErrSessionExpired = errors.New("lily.session: Session expired")
 
+// Session ID hash type. Session IDs are only stored on disk as hashes, so the
+// saved sessions cannot be used to authenticate.
+type IDHash [sha256.Size]byte
+
+// Hash a session ID.
+func HashID(id uuid.UUID) IDHash {
+	return IDHash(sha256.Sum256(id[:]))
+}
+
 // Create a new session object. Note that UUID generation is handled by session
 // lists, to ensure no session are repeated.
 func NewSession(id uuid.UUID, username string, expireAfter time.Duration) *Session {
 	// Create the session object.
+	now := time.Now()
 	session := &Session{
 		lock:        sync.RWMutex{},
 		id:          id,
 		username:    username,
 		expireAfter: expireAfter,
+		createdAt:   now,
+		lastUsed:    now,
 	}
 
 	// Calculate the next expiration time.
@@ -58,6 +90,26 @@ func NewSession(id uuid.UUID, username string, expireAfter time.Duration) *Sessi
 	return session
 }
 
+// Restore a saved session. The session ID is unknown until the client
+// presents it, so it is set by the session list.
+func RestoreSession(username string, expireAfter time.Duration, expireAt time.Time,
+	idleTimeout, maxLifetime time.Duration, createdAt, lastUsed time.Time, ip, label,
+	certFingerprint string) *Session {
+	return &Session{
+		lock:            sync.RWMutex{},
+		username:        username,
+		expireAfter:     expireAfter,
+		expireAt:        expireAt,
+		idleTimeout:     idleTimeout,
+		maxLifetime:     maxLifetime,
+		createdAt:       createdAt,
+		lastUsed:        lastUsed,
+		ip:              ip,
+		label:           label,
+		certFingerprint: certFingerprint,
+	}
+}
+
 // Calculate the next expiration time.
 func (s *Session) UpdateExpiration() {
 	// Acquire the write lock.
@@ -73,18 +125,46 @@ func (s *Session) ShouldExpire() bool {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
+	// Check the idle timeout and maximum lifetime.
+	now := time.Now()
+	if s.idleTimeout != 0 && now.Sub(s.lastUsed) > s.idleTimeout {
+		return true
+	}
+	if s.maxLifetime != 0 && now.Sub(s.createdAt) > s.maxLifetime {
+		return true
+	}
+
 	if s.expireAfter == 0 {
 		// Should not expire.
 		return false
 	}
 
-	if s.expireAt.Before(time.Now()) {
+	if s.expireAt.Before(now) {
 		return true
 	} else {
 		return false
 	}
 }
 
+// Get the idle timeout and maximum lifetime.
+func (s *Session) GetTimeouts() (time.Duration, time.Duration) {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.idleTimeout, s.maxLifetime
+}
+
+// Set the idle timeout and maximum lifetime. Zero disables either.
+func (s *Session) SetTimeouts(idleTimeout, maxLifetime time.Duration) {
+	// Acquire the write lock.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.idleTimeout = idleTimeout
+	s.maxLifetime = maxLifetime
+}
+
 // Get the ID.
 func (s *Session) GetID() uuid.UUID {
 	// Acquire the read lock.
@@ -148,12 +228,109 @@ func (s *Session) GetExpireAt() time.Time {
 	return s.expireAt
 }
 
-// Authenticate.
+// Get the creation time.
+func (s *Session) GetCreatedAt() time.Time {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.createdAt
+}
+
+// Get the last used time.
+func (s *Session) GetLastUsed() time.Time {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.lastUsed
+}
+
+// Get the client IP address.
+func (s *Session) GetIP() string {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.ip
+}
+
+// Set the client IP address.
+func (s *Session) SetIP(ip string) {
+	// Acquire the write lock.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ip = ip
+}
+
+// Get the client label.
+func (s *Session) GetLabel() string {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.label
+}
+
+// Set the client label.
+func (s *Session) SetLabel(label string) {
+	// Acquire the write lock.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.label = label
+}
+
+// Get the client certificate fingerprint.
+func (s *Session) GetCertFingerprint() string {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.certFingerprint
+}
+
+// Set the client certificate fingerprint.
+func (s *Session) SetCertFingerprint(fingerprint string) {
+	// Acquire the write lock.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.certFingerprint = fingerprint
+}
+
+// Get the session info, with the given ID bytes.
+func (s *Session) GetInfo(id []byte) SessionInfo {
+	// Acquire the read lock.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return SessionInfo{
+		ID:          id,
+		Username:    s.username,
+		ExpireAfter: s.expireAfter,
+		ExpireAt:    s.expireAt.Unix(),
+		IdleTimeout: s.idleTimeout,
+		MaxLifetime: s.maxLifetime,
+		CreatedAt:   s.createdAt.Unix(),
+		LastUsed:    s.lastUsed.Unix(),
+		IP:          s.ip,
+		Label:       s.label,
+	}
+}
+
+// Authenticate. Updates the last used time.
 func (s *Session) Authenticate() error {
 	if s.ShouldExpire() {
 		return ErrSessionExpired
 	}
 
+	// Acquire the write lock.
+	s.lock.Lock()
+	s.lastUsed = time.Now()
+	s.lock.Unlock()
+
 	// Return.
 	return nil
 }
@@ -72,3 +72,33 @@ func TestSessionFuncs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test the idle timeout and maximum lifetime.
+func TestSessionTimeouts(t *testing.T) {
+	// An idle session expires, but a used one does not.
+	s := NewSession(uuid.New(), "foo", 0)
+	s.SetTimeouts(20*time.Millisecond, 0)
+	time.Sleep(10 * time.Millisecond)
+	if s.Authenticate() != nil {
+		t.Fail()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if s.Authenticate() != nil {
+		t.Fail()
+	}
+	time.Sleep(30 * time.Millisecond)
+	if s.Authenticate() != ErrSessionExpired {
+		t.Fail()
+	}
+
+	// An active session expires after its maximum lifetime.
+	s = NewSession(uuid.New(), "foo", 0)
+	s.SetTimeouts(time.Hour, 20*time.Millisecond)
+	if s.Authenticate() != nil {
+		t.Fail()
+	}
+	time.Sleep(30 * time.Millisecond)
+	if s.Authenticate() != ErrSessionExpired {
+		t.Fail()
+	}
+}
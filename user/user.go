@@ -4,7 +4,8 @@
 // Package user provides definitions and functions for user-related objects.
 
 // Users in Lily are identified by a User object, containing a string for the
-// username and a password hash (bcrypt), along with its security clearance.
+// username and a password hash (bcrypt or Argon2id), along with its security
+// clearance.
 
 package user
 
@@ -28,6 +29,15 @@ type User struct {
 
 	// Security clearance.
 	clearance access.Clearance
+
+	// Two-factor authentication settings. The secret is set on enrollment, but
+	// is only required on login once enabled. Recovery codes are stored as
+	// password hashes. The last used time step is kept in memory to prevent
+	// codes from being replayed.
+	totpSecret    []byte
+	totpEnabled   bool
+	recoveryCodes []auth.PasswordHash
+	totpLastStep  int64
 }
 
 // User info type.
@@ -35,12 +45,18 @@ type UserInfo struct {
 	Username     string
 	Clearance    int
 	PasswordHash []byte
+	TOTPEnabled  bool
 }
 
-// Create a new user object.
+// Create a new user object, using the default password hash parameters.
 func NewUser(username, password string, clearance access.Clearance) (*User, error) {
+	return NewUserWithParams(username, password, clearance, auth.DefaultHashParams())
+}
+
+// Create a new user object, hashing the password with the given parameters.
+func NewUserWithParams(username, password string, clearance access.Clearance, params auth.HashParams) (*User, error) {
 	// Hash the password.
-	passwordHash, err := auth.NewPasswordHash(password)
+	passwordHash, err := auth.NewPasswordHashWithParams(password, params)
 	if err != nil {
 		return &User{}, err
 	}
@@ -53,6 +69,16 @@ func NewUser(username, password string, clearance access.Clearance) (*User, erro
 	}, nil
 }
 
+// Create a user object from an existing password hash.
+func NewUserFromHash(username string, hash auth.PasswordHash, clearance access.Clearance) *User {
+	return &User{
+		lock:      &sync.RWMutex{},
+		username:  username,
+		password:  hash,
+		clearance: clearance,
+	}
+}
+
 // Get the username.
 func (u *User) GetUsername() string {
 	// Acquire the read lock.
@@ -98,14 +124,14 @@ func (u *User) ComparePassword(password string) bool {
 	return u.password.Compare(password)
 }
 
-// Set the password.
-func (u *User) SetPassword(password string) error {
+// Set the password, hashing it with the given parameters.
+func (u *User) SetPassword(password string, params auth.HashParams) error {
 	// Acquire the write lock.
 	u.lock.Lock()
 	defer u.lock.Unlock()
 
 	// Hash the new password.
-	hash, err := auth.NewPasswordHash(password)
+	hash, err := auth.NewPasswordHashWithParams(password, params)
 	if err != nil {
 		return err
 	}
@@ -115,6 +141,27 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
+// Rehash the password if the stored hash does not use the given parameters.
+// The password is only rehashed if it matches the stored hash. Returns true if
+// the hash was replaced.
+func (u *User) RehashPassword(password string, params auth.HashParams) bool {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if !u.password.NeedsRehash(params) || !u.password.Compare(password) {
+		return false
+	}
+	hash, err := auth.NewPasswordHashWithParams(password, params)
+	if err != nil {
+		return false
+	}
+
+	// Set the hash and return.
+	u.password = hash
+	return true
+}
+
 // Get the clearance level.
 func (u *User) GetClearance() access.Clearance {
 	// Acquire the read lock.
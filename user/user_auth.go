@@ -3,15 +3,39 @@
 
 package user
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/cubeflix/lily/security/access"
+)
 
 var ErrInvalidPassword = errors.New("lily.user: Invalid password")
 
+// An external password verifier, such as a directory service. Returns the
+// user's clearance level.
+type PasswordVerifier interface {
+	VerifyPassword(username, password string) (access.Clearance, error)
+}
+
+// Called after an external verifier accepts a user, with the clearance level
+// from the verifier. Returns the user object, creating it if necessary.
+type ExternalSyncFunc func(username string, clearance access.Clearance) (*User, error)
+
 // User authentication object.
 type UserAuth struct {
 	username string
 	password string
 	user     *User
+
+	// External password verification settings. If the verifier is nil, the
+	// password is compared with the user's password hash.
+	verifier PasswordVerifier
+	useLocal bool
+	sync     ExternalSyncFunc
+
+	// The result of authenticating, so the password is only verified once.
+	authenticated bool
+	authErr       error
 }
 
 // Create a user authentication object.
@@ -23,12 +47,57 @@ func NewUserAuth(username, password string, user *User) *UserAuth {
 	}
 }
 
-// Authenticate.
+// Create a user authentication object which verifies the password with an
+// external verifier. If useLocal is true, the user's password hash is checked
+// first. The user object may be nil if the user does not exist yet, in which
+// case the sync function must create it.
+func NewExternalUserAuth(username, password string, user *User, verifier PasswordVerifier,
+	useLocal bool, sync ExternalSyncFunc) *UserAuth {
+	return &UserAuth{
+		username: username,
+		password: password,
+		user:     user,
+		verifier: verifier,
+		useLocal: useLocal,
+		sync:     sync,
+	}
+}
+
+// Authenticate. The result is kept, so later calls do not verify the password
+// again.
 func (u *UserAuth) Authenticate() error {
-	// Compare password.
-	if !u.user.ComparePassword(u.password) {
-		return ErrInvalidPassword
+	if !u.authenticated {
+		u.authErr = u.authenticate()
+		u.authenticated = true
 	}
+	return u.authErr
+}
+
+// Verify the password.
+func (u *UserAuth) authenticate() error {
+	if u.verifier == nil {
+		// Compare password.
+		if !u.user.ComparePassword(u.password) {
+			return ErrInvalidPassword
+		}
+		return nil
+	}
+
+	// Compare the local password, if allowed.
+	if u.useLocal && u.user != nil && u.user.ComparePassword(u.password) {
+		return nil
+	}
+
+	// Verify the password externally and update the user.
+	clearance, err := u.verifier.VerifyPassword(u.username, u.password)
+	if err != nil {
+		return err
+	}
+	user, err := u.sync(u.username, clearance)
+	if err != nil {
+		return err
+	}
+	u.user = user
 	return nil
 }
 
@@ -40,3 +109,65 @@ func (n *UserAuth) Type() string {
 func (u *UserAuth) GetInfo() (string, string, *User) {
 	return u.username, u.password, u.user
 }
+
+// Certificate authentication object. The client certificate is verified during
+// the TLS handshake, and the username is resolved from the certificate by the
+// server before the object is created.
+type CertAuth struct {
+	username string
+	user     *User
+}
+
+// Create a certificate authentication object.
+func NewCertAuth(username string, user *User) *CertAuth {
+	return &CertAuth{
+		username: username,
+		user:     user,
+	}
+}
+
+// Authenticate.
+func (u *CertAuth) Authenticate() error {
+	// The certificate has already been verified.
+	return nil
+}
+
+func (n *CertAuth) Type() string {
+	return "cert"
+}
+
+// Get the user information.
+func (u *CertAuth) GetInfo() (string, *User) {
+	return u.username, u.user
+}
+
+// Peer authentication object. The local OS user connected to a Unix socket is
+// identified by their peer credentials, and mapped to a username by the server
+// before the object is created.
+type PeerAuth struct {
+	username string
+	user     *User
+}
+
+// Create a peer authentication object.
+func NewPeerAuth(username string, user *User) *PeerAuth {
+	return &PeerAuth{
+		username: username,
+		user:     user,
+	}
+}
+
+// Authenticate.
+func (u *PeerAuth) Authenticate() error {
+	// The peer credentials have already been verified.
+	return nil
+}
+
+func (n *PeerAuth) Type() string {
+	return "peer"
+}
+
+// Get the user information.
+func (u *PeerAuth) GetInfo() (string, *User) {
+	return u.username, u.user
+}
@@ -29,3 +29,57 @@ func TestUserAuth(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Testing password verifier.
+type testVerifier struct {
+	password string
+}
+
+func (v *testVerifier) VerifyPassword(username, password string) (access.Clearance, error) {
+	if password != v.password {
+		return 0, ErrInvalidPassword
+	}
+	return access.ClearanceLevelThree, nil
+}
+
+// Test external authentication.
+func TestExternalUserAuth(t *testing.T) {
+	// Create a user object.
+	user, err := NewUser("foo", "bar", access.ClearanceLevelOne)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	sync := func(username string, clearance access.Clearance) (*User, error) {
+		user.SetClearance(clearance)
+		return user, nil
+	}
+	verifier := &testVerifier{"baz"}
+
+	// Without the local password, only the external password is accepted.
+	if NewExternalUserAuth("foo", "bar", user, verifier, false, sync).Authenticate() == nil {
+		t.Fail()
+	}
+	if NewExternalUserAuth("foo", "baz", user, verifier, false, sync).Authenticate() != nil {
+		t.Fail()
+	}
+	if user.GetClearance() != access.ClearanceLevelThree {
+		t.Fail()
+	}
+
+	// With the local password, both are accepted.
+	if NewExternalUserAuth("foo", "bar", user, verifier, true, sync).Authenticate() != nil {
+		t.Fail()
+	}
+	if NewExternalUserAuth("foo", "invalid", user, verifier, true, sync).Authenticate() == nil {
+		t.Fail()
+	}
+
+	// Users which do not exist yet are created by the sync function.
+	auth := NewExternalUserAuth("foo", "baz", nil, verifier, false, sync)
+	if auth.Authenticate() != nil {
+		t.Fail()
+	}
+	if _, _, u := auth.GetInfo(); u != user {
+		t.Fail()
+	}
+}
@@ -5,6 +5,7 @@ package user
 
 import (
 	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/auth"
 
 	"testing"
 )
@@ -40,7 +41,7 @@ func TestUserPassword(t *testing.T) {
 	if u.ComparePassword("bar") == false {
 		t.Fail()
 	}
-	u.SetPassword("lily")
+	u.SetPassword("lily", auth.DefaultHashParams())
 	if u.ComparePassword("lily") == false {
 		t.Fail()
 	}
@@ -129,4 +130,31 @@ func TestUserAccess(t *testing.T) {
 	if u2.CanModify(a) != false {
 		t.Fail()
 	}
-}
\ No newline at end of file
+}
+
+// Test rehashing a password with new hash parameters.
+func TestRehashPassword(t *testing.T) {
+	params := auth.DefaultHashParams()
+	params.BcryptCost = 4
+	u, err := NewUserWithParams("foo", "bar", access.ClearanceLevelOne, params)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if u.RehashPassword("bar", params) {
+		t.Fail()
+	}
+
+	// Switch to Argon2id.
+	params.Algorithm = auth.Argon2id
+	params.Argon2Memory = 64
+	if u.RehashPassword("baz", params) {
+		t.Fail()
+	}
+	if !u.RehashPassword("bar", params) || u.RehashPassword("bar", params) {
+		t.Fail()
+	}
+	if !u.ComparePassword("bar") {
+		t.Fail()
+	}
+}
@@ -0,0 +1,129 @@
+// user/user_totp.go
+// Two-factor authentication for Lily users.
+
+package user
+
+import (
+	"time"
+
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/totp"
+)
+
+// Get the TOTP secret and if two-factor authentication is enabled.
+func (u *User) GetTOTP() ([]byte, bool) {
+	// Acquire the read lock.
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.totpSecret, u.totpEnabled
+}
+
+// Set the TOTP secret and if two-factor authentication is enabled. Enabling
+// keeps the last used step, so the code which confirmed enrollment cannot be
+// used again to log in.
+func (u *User) SetTOTP(secret []byte, enabled bool) {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.totpSecret = secret
+	u.totpEnabled = enabled
+	if !enabled {
+		u.totpLastStep = 0
+	}
+}
+
+// Check if two-factor authentication is enabled.
+func (u *User) IsTOTPEnabled() bool {
+	// Acquire the read lock.
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.totpEnabled
+}
+
+// Get the recovery code hashes.
+func (u *User) GetRecoveryCodes() []auth.PasswordHash {
+	// Acquire the read lock.
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return u.recoveryCodes
+}
+
+// Set the recovery code hashes.
+func (u *User) SetRecoveryCodes(codes []auth.PasswordHash) {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.recoveryCodes = codes
+}
+
+// Set new recovery codes, hashing each code with the given parameters.
+func (u *User) SetRecoveryCodesFromStrings(codes []string, params auth.HashParams) error {
+	// Hash the codes.
+	hashes := make([]auth.PasswordHash, len(codes))
+	for i := range codes {
+		hash, err := auth.NewPasswordHashWithParams(codes[i], params)
+		if err != nil {
+			return err
+		}
+		hashes[i] = hash
+	}
+
+	// Set the hashes.
+	u.SetRecoveryCodes(hashes)
+
+	// Return.
+	return nil
+}
+
+// Reset two-factor authentication, removing the secret and recovery codes.
+func (u *User) ResetTOTP() {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.totpSecret = nil
+	u.totpEnabled = false
+	u.recoveryCodes = nil
+	u.totpLastStep = 0
+}
+
+// Verify a TOTP code against the user's secret. The secret does not need to be
+// enabled, so this can be used to confirm enrollment. A code cannot be used
+// twice.
+func (u *User) VerifyTOTP(code string) bool {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	step, ok := totp.Validate(u.totpSecret, code, time.Now())
+	if !ok || step <= u.totpLastStep {
+		return false
+	}
+	u.totpLastStep = step
+	return true
+}
+
+// Use a recovery code. If the code is valid, it is removed from the list of
+// recovery codes and the function returns true.
+func (u *User) UseRecoveryCode(code string) bool {
+	// Acquire the write lock.
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	for i := range u.recoveryCodes {
+		if u.recoveryCodes[i].Compare(code) {
+			// Remove the code.
+			codes := append([]auth.PasswordHash{}, u.recoveryCodes[:i]...)
+			u.recoveryCodes = append(codes, u.recoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	// Return.
+	return false
+}
@@ -0,0 +1,105 @@
+// user/user_totp_test.go
+// Testing for user/user_totp.go.
+
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cubeflix/lily/security/access"
+	"github.com/cubeflix/lily/security/auth"
+	"github.com/cubeflix/lily/security/totp"
+)
+
+// Test verifying TOTP codes.
+func TestUserVerifyTOTP(t *testing.T) {
+	// Create the user object.
+	u, err := NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if u.IsTOTPEnabled() {
+		t.Fail()
+	}
+
+	// Enroll the user.
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	u.SetTOTP(secret, true)
+	if !u.IsTOTPEnabled() {
+		t.Fail()
+	}
+
+	// Verify a code. The same code should not be accepted twice.
+	code := totp.Code(secret, time.Now())
+	if !u.VerifyTOTP(code) {
+		t.Fail()
+	}
+	if u.VerifyTOTP(code) {
+		t.Fail()
+	}
+
+	// Reset.
+	u.ResetTOTP()
+	if u.IsTOTPEnabled() {
+		t.Fail()
+	}
+}
+
+// Test that the code which confirms enrollment cannot be used to log in.
+func TestUserTOTPEnrollmentReplay(t *testing.T) {
+	// Create the user object.
+	u, err := NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Confirm enrollment with a code, then enable, as the enable TOTP command
+	// does.
+	u.SetTOTP(secret, false)
+	code := totp.Code(secret, time.Now())
+	if !u.VerifyTOTP(code) {
+		t.Fail()
+	}
+	u.SetTOTP(secret, true)
+
+	// Logging in verifies the code again, which should be rejected.
+	if u.VerifyTOTP(code) {
+		t.Fail()
+	}
+}
+
+// Test using recovery codes.
+func TestUserRecoveryCodes(t *testing.T) {
+	// Create the user object.
+	u, err := NewUser("foo", "bar", access.ClearanceLevelFive)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Set the codes.
+	if err := u.SetRecoveryCodesFromStrings([]string{"aaaaa-bbbbb", "ccccc-ddddd"}, auth.DefaultHashParams()); err != nil {
+		t.Error(err.Error())
+	}
+
+	// Each code should only be usable once.
+	if !u.UseRecoveryCode("ccccc-ddddd") {
+		t.Fail()
+	}
+	if u.UseRecoveryCode("ccccc-ddddd") {
+		t.Fail()
+	}
+	if u.UseRecoveryCode("invalid") {
+		t.Fail()
+	}
+	if len(u.GetRecoveryCodes()) != 1 {
+		t.Fail()
+	}
+}